@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ARQConfig is a retransmit-on-timeout scheme with a playout deadline
+type ARQConfig struct {
+	TimeoutMs  float64 // retransmission timeout
+	DeadlineMs float64 // data is useless after this long from the first send
+}
+
+// ParseARQ parses "timeout:deadline" in milliseconds, e.g. "50:200"
+func ParseARQ(s string) (ARQConfig, error) {
+	timeout, deadline, ok := strings.Cut(s, ":")
+	if !ok {
+		return ARQConfig{}, fmt.Errorf("invalid ARQ config %q, expected timeout:deadline", s)
+	}
+	t, errT := strconv.ParseFloat(timeout, 64)
+	d, errD := strconv.ParseFloat(deadline, 64)
+	if errT != nil || errD != nil || t <= 0 || d <= 0 {
+		return ARQConfig{}, fmt.Errorf("invalid ARQ config %q, timeout and deadline must be positive", s)
+	}
+	return ARQConfig{TimeoutMs: t, DeadlineMs: d}, nil
+}
+
+// ARQResult is the outcome of replaying the trace with retransmissions
+type ARQResult struct {
+	Packets         int
+	OnTimeRaw       int // answered within the deadline without retransmission
+	OnTime          int // answered within the deadline with retransmission
+	Retransmissions int
+}
+
+// SimulateARQ replays the trace as if every unanswered packet had been
+// retransmitted after the timeout until its deadline passed. The fate of a
+// retransmission sent at time t is taken from the measured packet sent
+// closest to t, so retries see the path conditions of that moment,
+// including correlated loss bursts.
+func SimulateARQ(records []*PacketRecord, cfg ARQConfig) ARQResult {
+	sorted := make([]*PacketRecord, len(records))
+	copy(sorted, records)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].SentTime < sorted[j].SentTime })
+
+	// sampleAt returns the packet sent closest to sentNs
+	sampleAt := func(sentNs int64) *PacketRecord {
+		i := sort.Search(len(sorted), func(i int) bool { return sorted[i].SentTime >= sentNs })
+		if i == len(sorted) {
+			return sorted[len(sorted)-1]
+		}
+		if i > 0 && sentNs-sorted[i-1].SentTime < sorted[i].SentTime-sentNs {
+			return sorted[i-1]
+		}
+		return sorted[i]
+	}
+	answeredWithin := func(r *PacketRecord, limitMs float64) bool {
+		return !r.Lost && r.RecvTime > 0 && r.LatencyMs <= limitMs
+	}
+
+	res := ARQResult{Packets: len(sorted)}
+	for _, r := range sorted {
+		if answeredWithin(r, min(cfg.TimeoutMs, cfg.DeadlineMs)) {
+			res.OnTimeRaw++
+			res.OnTime++
+			continue
+		}
+		// Without ARQ a slow reply still counts if it beats the deadline
+		if answeredWithin(r, cfg.DeadlineMs) {
+			res.OnTimeRaw++
+		}
+
+		delivered := answeredWithin(r, cfg.DeadlineMs)
+		for offset := cfg.TimeoutMs; offset < cfg.DeadlineMs; offset += cfg.TimeoutMs {
+			res.Retransmissions++
+			retry := sampleAt(r.SentTime + int64(offset*1e6))
+			if answeredWithin(retry, cfg.DeadlineMs-offset) {
+				delivered = true
+			}
+			if delivered || answeredWithin(retry, cfg.TimeoutMs) {
+				break
+			}
+		}
+		if delivered {
+			res.OnTime++
+		}
+	}
+	return res
+}
+
+// PrintARQ prints on-time delivery with and without retransmissions
+func PrintARQ(records []*PacketRecord, cfg ARQConfig) {
+	res := SimulateARQ(records, cfg)
+	if res.Packets == 0 {
+		return
+	}
+
+	fmt.Printf("\n--- ARQ Simulation (timeout %.0fms, deadline %.0fms) ---\n", cfg.TimeoutMs, cfg.DeadlineMs)
+	fmt.Printf("On time: %.2f%% with retransmission, %.2f%% without, %d retransmissions (%.1f%% overhead)\n",
+		float64(res.OnTime)/float64(res.Packets)*100,
+		float64(res.OnTimeRaw)/float64(res.Packets)*100,
+		res.Retransmissions,
+		float64(res.Retransmissions)/float64(res.Packets)*100)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// A server started with --key lets only clients that hold the same key ask
+// for more reply bytes than they send, get downlink streams and send
+// background load past --rate-limit; with --truncate anyone else also gets
+// single replies cut to the header and no receive reports, so a spoofed
+// source can't turn the server into an amplifier. A client proves it has
+// the key with a token derived from the key and its session ID, carried in
+// every packet. The token is only as secret as the traffic is: it keeps off-path
+// spoofers out, not an eavesdropper.
+
+// authContext is mixed into tokens so they can't be mistaken for other
+// uses of the same key
+const authContext = "packet-test session"
+
+// Session is what a run's packets carry to identify it to the server
+type Session struct {
+	ID    uint32
+	Token uint64 // proves the client holds the server's key, 0 = no key
+}
+
+// newSession returns the session for a run, authenticated with key if
+// one is given
+func newSession(runID, key string) Session {
+	id := sessionID(runID)
+	return Session{ID: id, Token: authToken(key, id)}
+}
+
+// authToken returns the token for a session under key, 0 without a key
+func authToken(key string, id uint32) uint64 {
+	if key == "" {
+		return 0
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(authContext))
+	mac.Write(binary.BigEndian.AppendUint32(nil, id))
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// stamp marks p as belonging to the session
+func (s Session) stamp(p *Packet) *Packet {
+	p.Session, p.Auth = s.ID, s.Token
+	return p
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseBitrate parses a bitrate such as 10M, 1.5G, 500k or 2000000 into
+// bits per second. Suffixes are decimal, as in link speeds, and a trailing
+// "bps" or "bit/s" is ignored.
+func ParseBitrate(s string) (float64, error) {
+	v := strings.TrimSpace(s)
+	for _, unit := range []string{"bit/s", "bps"} {
+		v = strings.TrimSuffix(v, unit)
+	}
+	mult := 1.0
+	if v != "" {
+		switch v[len(v)-1] {
+		case 'k', 'K':
+			mult = 1e3
+		case 'm', 'M':
+			mult = 1e6
+		case 'g', 'G':
+			mult = 1e9
+		}
+		if mult != 1 {
+			v = v[:len(v)-1]
+		}
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil || n <= 0 || math.IsInf(n*mult, 0) {
+		return 0, fmt.Errorf("invalid bitrate %q, expected e.g. 10M, 1.5G or 500k", s)
+	}
+	return n * mult, nil
+}
+
+// parseMbps parses a bitrate where a bare number means Mbps
+func parseMbps(s string) (float64, error) {
+	if n, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+		s = strconv.FormatFloat(n, 'f', -1, 64) + "M"
+	}
+	return ParseBitrate(s)
+}
+
+// RateForBitrate returns the packets per second that carry bps in UDP
+// payloads of size bytes, at least 1
+func RateForBitrate(bps float64, size int) int {
+	return max(1, int(math.Round(bps/float64(size*8))))
+}
+
+// checkBitrate returns an error unless a pacer can send bps in packets of
+// size bytes. It compares before converting, as a huge bitrate overflows
+// the int rate.
+func checkBitrate(name string, bps float64, size int) error {
+	if bps/float64(size*8) > float64(maxRate) {
+		return fmt.Errorf("%s %s at %d byte packets needs more than %d pps", name, FormatBitrate(bps), size, maxRate)
+	}
+	return nil
+}
+
+// FormatBitrate formats bits per second with a decimal unit, e.g. "9.98 Mbps"
+func FormatBitrate(bps float64) string {
+	switch {
+	case bps >= 1e9:
+		return fmt.Sprintf("%.2f Gbps", bps/1e9)
+	case bps >= 1e6:
+		return fmt.Sprintf("%.2f Mbps", bps/1e6)
+	case bps >= 1e3:
+		return fmt.Sprintf("%.1f kbps", bps/1e3)
+	}
+	return fmt.Sprintf("%.0f bps", bps)
+}
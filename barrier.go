@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Barrier start lets several clients begin sending at the same moment.
+// Each client sends TypeBarrier joins carrying the group size in
+// ReplyCount until the server has heard from that many clients. The
+// server then picks a start time a little in the future and sends it to
+// every member. Each join is also answered directly with the server's
+// clock, so a client can estimate its clock offset from the fastest
+// exchange and convert the start time to its own clock.
+
+const (
+	barrierLead     = 500 * time.Millisecond // start time ahead of the release, covers delivery to all members
+	barrierInterval = 200 * time.Millisecond // join resend interval while waiting
+	barrierTimeout  = 5 * time.Minute        // how long a client waits for the rest of the group
+	barrierLinger   = 5 * time.Second        // released members can re-ask for the start time this long
+)
+
+// Barrier payload layout (after the packet header)
+const (
+	barrierServerNowOffset = 0  // server wall clock when the packet was sent
+	barrierStartOffset     = 8  // start time on the server clock, 0 while waiting
+	barrierJoinedOffset    = 16 // members joined so far
+	barrierPayloadSize     = 18
+)
+
+// StartSync records how a synchronized start went, so stagger between
+// clients can be checked afterwards
+type StartSync struct {
+	Mode          string    `json:"mode"`              // "barrier" or "time"
+	Clients       int       `json:"clients,omitempty"` // barrier group size
+	TargetStart   time.Time `json:"target_start"`      // on the local clock
+	ActualStart   time.Time `json:"actual_start"`
+	StaggerMs     float64   `json:"stagger_ms"`                // actual minus target start
+	ClockOffsetMs float64   `json:"clock_offset_ms,omitempty"` // server clock minus local clock
+	SyncRTTMs     float64   `json:"sync_rtt_ms,omitempty"`     // RTT of the exchange the offset came from
+}
+
+// String formats the sync for console output and reports
+func (s *StartSync) String() string {
+	if s.Mode == "barrier" {
+		return fmt.Sprintf("barrier of %d clients, started %+.2fms from target (clock offset to server %+.2fms, ±%.2fms)",
+			s.Clients, s.StaggerMs, s.ClockOffsetMs, s.SyncRTTMs/2)
+	}
+	return fmt.Sprintf("at %s, started %+.2fms from target", s.TargetStart.Format("15:04:05.000"), s.StaggerMs)
+}
+
+func encodeBarrier(echoNs int64, size uint16, serverNow, start time.Time, joined int) []byte {
+	buf := (&Packet{Type: TypeBarrier, Timestamp: echoNs, ReplyCount: size}).Encode(HeaderSize + barrierPayloadSize)
+	payload := buf[HeaderSize:]
+	binary.BigEndian.PutUint64(payload[barrierServerNowOffset:], uint64(serverNow.UnixNano()))
+	if !start.IsZero() {
+		binary.BigEndian.PutUint64(payload[barrierStartOffset:], uint64(start.UnixNano()))
+	}
+	binary.BigEndian.PutUint16(payload[barrierJoinedOffset:], uint16(joined))
+	return buf
+}
+
+// JoinBarrier waits until size clients have joined the server's barrier
+// and returns the agreed start time on the local clock. The receiver
+// goroutines must not be running yet since replies are read from conn.
+func JoinBarrier(ctx context.Context, conn net.Conn, session Session, size int) (start time.Time, sync *StartSync, err error) {
+	ctx, cancel := context.WithTimeout(ctx, barrierTimeout)
+	defer cancel()
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+	defer conn.SetReadDeadline(time.Time{})
+
+	sync = &StartSync{Mode: "barrier", Clients: size}
+	bestRTT := time.Duration(-1)
+	lastJoined := -1
+	buf := make([]byte, 65535)
+
+	for ctx.Err() == nil {
+		t0 := time.Now()
+		join := session.stamp(&Packet{Type: TypeBarrier, Timestamp: t0.UnixNano(), ReplyCount: uint16(size)}).Encode(HeaderSize + barrierPayloadSize)
+		if _, err := conn.Write(join); err != nil {
+			return time.Time{}, nil, fmt.Errorf("failed to join barrier: %w", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(barrierInterval))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break // resend on timeout
+			}
+			t1 := time.Now()
+			if err := versionError(buf[:n]); err != nil {
+				return time.Time{}, nil, err
+			}
+			pkt := DecodePacket(buf[:n])
+			if pkt == nil || pkt.Type != TypeBarrier || len(pkt.Payload) < barrierPayloadSize {
+				continue
+			}
+			serverNow := int64(binary.BigEndian.Uint64(pkt.Payload[barrierServerNowOffset:]))
+			startNs := int64(binary.BigEndian.Uint64(pkt.Payload[barrierStartOffset:]))
+			joined := int(binary.BigEndian.Uint16(pkt.Payload[barrierJoinedOffset:]))
+
+			// Direct answers echo our send time; the fastest one gives
+			// the tightest offset estimate
+			if pkt.Timestamp != 0 {
+				rtt := t1.Sub(time.Unix(0, pkt.Timestamp))
+				if bestRTT < 0 || rtt < bestRTT {
+					bestRTT = rtt
+					mid := pkt.Timestamp + rtt.Nanoseconds()/2
+					sync.ClockOffsetMs = float64(serverNow-mid) / 1e6
+					sync.SyncRTTMs = float64(rtt.Nanoseconds()) / 1e6
+				}
+			}
+			if startNs != 0 {
+				offsetNs := int64(sync.ClockOffsetMs * 1e6)
+				start = time.Unix(0, startNs-offsetNs)
+				sync.TargetStart = start
+				return start, sync, nil
+			}
+			if joined != lastJoined {
+				fmt.Printf("Barrier: %d of %d clients joined\n", joined, size)
+				lastJoined = joined
+			}
+		}
+	}
+	return time.Time{}, nil, fmt.Errorf("barrier not released: %w", context.Cause(ctx))
+}
+
+// ParseStartAt parses a --start-at time: RFC 3339, a time of day today
+// (15:04:05, optionally with fractional seconds) or Unix seconds
+func ParseStartAt(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("15:04:05.999999999", s, time.Local); err == nil {
+		now := time.Now()
+		return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local), nil
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(secs*1e9)), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid start time %q (want RFC 3339, HH:MM:SS or Unix seconds)", s)
+}
+
+// WaitUntil sleeps until start and records how late sending really began
+func WaitUntil(ctx context.Context, start time.Time, sync *StartSync) error {
+	select {
+	case <-time.After(time.Until(start)):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	sync.ActualStart = time.Now()
+	sync.StaggerMs = float64(sync.ActualStart.Sub(start).Nanoseconds()) / 1e6
+	return nil
+}
+
+// barrier is the server's side of one synchronized start
+type barrier struct {
+	size    int
+	members map[string]bool
+	order   []barrierMember
+	start   time.Time // zero until released
+}
+
+// barrierMember is a joined client and its session key
+type barrierMember struct {
+	key  string
+	addr net.Addr
+}
+
+// handle answers a join from addr, releasing the group once it's complete
+func (b *barrier) handle(conn net.PacketConn, pkt *Packet, addr net.Addr, clients map[string]*ReceiveLog) {
+	size := int(pkt.ReplyCount)
+	key := sessionKey(addr.String(), pkt.Session)
+	now := time.Now()
+
+	// A finished or resized barrier starts a new round, except for members
+	// of the released group re-asking for their start time
+	released := !b.start.IsZero()
+	if size != b.size || (released && (!b.members[key] || now.After(b.start.Add(barrierLinger)))) {
+		*b = barrier{size: size, members: make(map[string]bool)}
+		released = false
+	}
+	if !b.members[key] {
+		b.members[key] = true
+		b.order = append(b.order, barrierMember{key, addr})
+		fmt.Printf("Barrier: %s joined (%d of %d)\n", key, len(b.order), size)
+	}
+
+	if !released && len(b.order) >= size {
+		b.start = now.Add(barrierLead)
+		released = true
+		fmt.Printf("Barrier released: %d clients start at %s\n", size, b.start.Format("15:04:05.000"))
+		for _, member := range b.order {
+			if c := clients[member.key]; c != nil {
+				c.SyncStart = b.start
+			}
+			if member.key == key {
+				continue // answered directly below
+			}
+			if _, err := conn.WriteTo(encodeBarrier(0, uint16(size), time.Now(), b.start, len(b.order)), member.addr); err != nil {
+				fmt.Printf("Write error to %s: %v\n", member.addr, err)
+			}
+		}
+	}
+	var start time.Time
+	if released {
+		start = b.start
+	}
+	if _, err := conn.WriteTo(encodeBarrier(pkt.Timestamp, uint16(size), time.Now(), start, len(b.order)), addr); err != nil {
+		fmt.Printf("Write error to %s: %v\n", key, err)
+	}
+}
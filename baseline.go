@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrRegression is returned by a run that did worse than its baseline by
+// more than the tolerance; the client exits with status 3 for it
+var ErrRegression = errors.New("regression against baseline")
+
+// baselineFloorMs is the smallest rise in a latency or jitter figure that
+// counts as a regression, so a percentage of a loopback-sized baseline
+// isn't tripped by timer noise
+const baselineFloorMs = 0.1
+
+// Baseline saves a run's summary as a baseline, or compares the run
+// against one saved earlier
+type Baseline struct {
+	File          string       // the baseline, in the format of --summary-json
+	Against       *JSONSummary // the loaded baseline to compare against, nil = save the run to File
+	TolerancePct  float64      // percent latency and jitter may rise over the baseline
+	LossTolerance float64      // percentage points loss may rise over the baseline
+}
+
+// LoadBaseline reads a summary saved with --baseline or --summary-json
+func LoadBaseline(path string) (*JSONSummary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sum JSONSummary
+	if err := json.Unmarshal(data, &sum); err != nil {
+		return nil, fmt.Errorf("%s is not a JSON summary: %w", path, err)
+	}
+	if sum.Sent == 0 {
+		return nil, fmt.Errorf("%s has no probes to compare against", path)
+	}
+	return &sum, nil
+}
+
+// CompareBaseline prints each headline number against the baseline and
+// returns the names of the ones that regressed
+func CompareBaseline(cfg Baseline, sum *JSONSummary) []string {
+	base := cfg.Against
+	var baseRTT, curRTT LatencyStats
+	if base.RTT != nil {
+		baseRTT = *base.RTT
+	}
+	if sum.RTT != nil {
+		curRTT = *sum.RTT
+	}
+	checks := []struct {
+		name      string
+		cur, base float64
+	}{
+		{"Avg RTT", curRTT.Avg, baseRTT.Avg},
+		{"p50 RTT", curRTT.P50, baseRTT.P50},
+		{"p99 RTT", curRTT.P99, baseRTT.P99},
+		{"Jitter", sum.JitterMs, base.JitterMs},
+	}
+
+	fmt.Printf("\n--- Baseline (%s, run %s) ---\n", cfg.File, base.StartTime.Format("2006-01-02 15:04"))
+	var regressed []string
+	result := "OK   "
+	if sum.LossPercent > base.LossPercent+cfg.LossTolerance {
+		result = "WORSE"
+		regressed = append(regressed, "Loss")
+	}
+	fmt.Printf("%s %-8s %.2f%% vs %.2f%% (%+.2f points, tolerance %.2f)\n", result, "Loss:",
+		sum.LossPercent, base.LossPercent, sum.LossPercent-base.LossPercent, cfg.LossTolerance)
+	for _, c := range checks {
+		if sum.Received == 0 || base.Received == 0 {
+			break
+		}
+		result, change := "OK   ", ""
+		if c.base > 0 {
+			change = fmt.Sprintf(", %+.1f%%", (c.cur-c.base)/c.base*100)
+		}
+		if c.cur > c.base*(1+cfg.TolerancePct/100) && c.cur-c.base >= baselineFloorMs {
+			result = "WORSE"
+			regressed = append(regressed, c.name)
+		}
+		fmt.Printf("%s %-8s %.2fms vs %.2fms (%+.2fms%s, tolerance %.0f%%)\n", result, c.name+":",
+			c.cur, c.base, c.cur-c.base, change, cfg.TolerancePct)
+	}
+	if len(regressed) == 0 {
+		fmt.Println("No regression against the baseline")
+	}
+	return regressed
+}
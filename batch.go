@@ -0,0 +1,32 @@
+package main
+
+import "net"
+
+// At high packet rates a syscall per datagram costs more than the
+// datagram itself, and the socket buffer overflows while the loop catches
+// up. Where the system has them, reads and the server's replies move many
+// datagrams per syscall (recvmmsg and sendmmsg on Linux); elsewhere a
+// batch is one datagram at a time, so callers don't need to care.
+
+// batchSize is the most datagrams one batched syscall moves
+const batchSize = 64
+
+// message is one datagram of a batch. For reads Buf and OOB are filled to
+// N and OOBN and Addr is the source; for writes Buf and OOB are sent as
+// they are to Addr, or to the connected peer if Addr is nil.
+type message struct {
+	Buf, OOB []byte
+	N, OOBN  int
+	Addr     *net.UDPAddr
+}
+
+// newMessages allocates a batch of messages with buffers of the given
+// sizes
+func newMessages(bufSize, oobSize int) []message {
+	msgs := make([]message, batchSize)
+	for i := range msgs {
+		msgs[i].Buf = make([]byte, bufSize)
+		msgs[i].OOB = make([]byte, oobSize)
+	}
+	return msgs
+}
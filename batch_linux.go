@@ -0,0 +1,191 @@
+package main
+
+import (
+	"net"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// mmsghdr is struct mmsghdr, a msghdr and the length the kernel moved
+type mmsghdr struct {
+	Hdr syscall.Msghdr
+	Len uint32
+}
+
+// batchConn reads and writes batches on a UDP socket. It keeps the
+// syscall arguments between calls, so one goroutine may use it at a time.
+type batchConn struct {
+	uc    *net.UDPConn
+	raw   syscall.RawConn
+	ipv6  bool // an IPv6 socket, which reaches IPv4 peers by mapped addresses
+	hdrs  []mmsghdr
+	iovs  []syscall.Iovec
+	names []syscall.RawSockaddrAny
+}
+
+func newBatchConn(uc *net.UDPConn) (*batchConn, error) {
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	local, _ := uc.LocalAddr().(*net.UDPAddr)
+	return &batchConn{
+		uc:    uc,
+		raw:   raw,
+		ipv6:  local != nil && local.IP.To4() == nil,
+		hdrs:  make([]mmsghdr, batchSize),
+		iovs:  make([]syscall.Iovec, batchSize),
+		names: make([]syscall.RawSockaddrAny, batchSize),
+	}, nil
+}
+
+// prepare points the headers at the messages' buffers
+func (b *batchConn) prepare(msgs []message, write bool) {
+	for i := range msgs {
+		m, h := &msgs[i], &b.hdrs[i]
+		*h = mmsghdr{}
+		buf := m.Buf
+		if write {
+			buf = m.Buf[:m.N]
+		}
+		b.iovs[i] = syscall.Iovec{}
+		if len(buf) > 0 {
+			b.iovs[i].Base = &buf[0]
+			b.iovs[i].SetLen(len(buf))
+		}
+		h.Hdr.Iov = &b.iovs[i]
+		h.Hdr.Iovlen = 1
+		if len(m.OOB) > 0 {
+			h.Hdr.Control = &m.OOB[0]
+			h.Hdr.SetControllen(len(m.OOB))
+		}
+		if !write {
+			h.Hdr.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+			h.Hdr.Namelen = syscall.SizeofSockaddrAny
+		} else if m.Addr != nil {
+			h.Hdr.Name = (*byte)(unsafe.Pointer(&b.names[i]))
+			h.Hdr.Namelen = b.putSockaddr(&b.names[i], m.Addr)
+		}
+	}
+}
+
+// ReadBatch blocks until at least one datagram arrives and returns how
+// many of msgs it filled
+func (b *batchConn) ReadBatch(msgs []message) (int, error) {
+	msgs = msgs[:min(len(msgs), len(b.hdrs))]
+	b.prepare(msgs, false)
+	var n int
+	var serr error
+	err := b.raw.Read(func(fd uintptr) bool {
+		r, _, e := syscall.Syscall6(syscall.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&b.hdrs[0])),
+			uintptr(len(msgs)), syscall.MSG_WAITFORONE, 0, 0)
+		if e == syscall.EAGAIN {
+			return false
+		}
+		if e != 0 {
+			serr = e
+			return true
+		}
+		n = int(r)
+		return true
+	})
+	runtime.KeepAlive(msgs)
+	if err == nil {
+		err = serr
+	}
+	if err != nil {
+		return 0, err
+	}
+	for i := range n {
+		m, h := &msgs[i], &b.hdrs[i]
+		m.N, m.OOBN = int(h.Len), int(h.Hdr.Controllen)
+		m.Addr = udpAddrOf(&b.names[i])
+	}
+	return n, nil
+}
+
+// WriteBatch sends msgs and returns how many went out; when it stops
+// early the error is that of the first one that didn't
+func (b *batchConn) WriteBatch(msgs []message) (int, error) {
+	msgs = msgs[:min(len(msgs), len(b.hdrs))]
+	b.prepare(msgs, true)
+	var n int
+	var serr error
+	err := b.raw.Write(func(fd uintptr) bool {
+		r, _, e := syscall.Syscall6(sysSendmmsg, fd, uintptr(unsafe.Pointer(&b.hdrs[0])),
+			uintptr(len(msgs)), 0, 0, 0)
+		if e == syscall.EAGAIN {
+			return false
+		}
+		if e != 0 {
+			serr = e
+			return true
+		}
+		n = int(r)
+		return true
+	})
+	runtime.KeepAlive(msgs)
+	if err == nil {
+		err = serr
+	}
+	return n, err
+}
+
+// putSockaddr writes addr as a sockaddr of the socket's family and
+// returns its length
+func (b *batchConn) putSockaddr(sa *syscall.RawSockaddrAny, addr *net.UDPAddr) uint32 {
+	if ip4 := addr.IP.To4(); ip4 != nil && !b.ipv6 {
+		sa4 := (*syscall.RawSockaddrInet4)(unsafe.Pointer(sa))
+		*sa4 = syscall.RawSockaddrInet4{Family: syscall.AF_INET}
+		putPort(&sa4.Port, addr.Port)
+		copy(sa4.Addr[:], ip4)
+		return syscall.SizeofSockaddrInet4
+	}
+	sa6 := (*syscall.RawSockaddrInet6)(unsafe.Pointer(sa))
+	*sa6 = syscall.RawSockaddrInet6{Family: syscall.AF_INET6}
+	putPort(&sa6.Port, addr.Port)
+	copy(sa6.Addr[:], addr.IP.To16())
+	if addr.Zone != "" {
+		if ifi, err := net.InterfaceByName(addr.Zone); err == nil {
+			sa6.Scope_id = uint32(ifi.Index)
+		} else if id, err := strconv.Atoi(addr.Zone); err == nil {
+			sa6.Scope_id = uint32(id)
+		}
+	}
+	return syscall.SizeofSockaddrInet6
+}
+
+// udpAddrOf converts a received sockaddr
+func udpAddrOf(sa *syscall.RawSockaddrAny) *net.UDPAddr {
+	switch sa.Addr.Family {
+	case syscall.AF_INET:
+		sa4 := (*syscall.RawSockaddrInet4)(unsafe.Pointer(sa))
+		return &net.UDPAddr{IP: net.IPv4(sa4.Addr[0], sa4.Addr[1], sa4.Addr[2], sa4.Addr[3]), Port: getPort(&sa4.Port)}
+	case syscall.AF_INET6:
+		sa6 := (*syscall.RawSockaddrInet6)(unsafe.Pointer(sa))
+		addr := &net.UDPAddr{IP: make(net.IP, net.IPv6len), Port: getPort(&sa6.Port)}
+		copy(addr.IP, sa6.Addr[:])
+		if sa6.Scope_id != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa6.Scope_id)); err == nil {
+				addr.Zone = ifi.Name
+			} else {
+				addr.Zone = strconv.Itoa(int(sa6.Scope_id))
+			}
+		}
+		return addr
+	}
+	return nil
+}
+
+// Ports in a sockaddr are in network byte order
+func putPort(p *uint16, port int) {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	b[0], b[1] = byte(port>>8), byte(port)
+}
+
+func getPort(p *uint16) int {
+	b := (*[2]byte)(unsafe.Pointer(p))
+	return int(b[0])<<8 | int(b[1])
+}
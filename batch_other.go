@@ -0,0 +1,37 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// batchConn moves one datagram per syscall where batched syscalls aren't
+// implemented
+type batchConn struct {
+	uc *net.UDPConn
+}
+
+func newBatchConn(uc *net.UDPConn) (*batchConn, error) {
+	return &batchConn{uc: uc}, nil
+}
+
+// ReadBatch reads a single datagram into msgs[0]
+func (b *batchConn) ReadBatch(msgs []message) (int, error) {
+	m := &msgs[0]
+	var err error
+	m.N, m.OOBN, _, m.Addr, err = b.uc.ReadMsgUDP(m.Buf, m.OOB)
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// WriteBatch sends msgs one by one, stopping at the first error
+func (b *batchConn) WriteBatch(msgs []message) (int, error) {
+	for i := range msgs {
+		m := &msgs[i]
+		if _, _, err := b.uc.WriteMsgUDP(m.Buf[:m.N], m.OOB, m.Addr); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
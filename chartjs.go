@@ -0,0 +1,39 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Reports load Chart.js from a CDN unless a copy can be inlined, which
+// lab networks without internet access need. A copy vendored into
+// chartjs/ is embedded at build time; --chartjs names one at run time.
+
+//go:embed chartjs
+var chartJSFiles embed.FS
+
+const (
+	chartJSBundle = "chartjs/chart.umd.min.js"
+	chartJSCDN    = `<script src="https://cdn.jsdelivr.net/npm/chart.js"></script>`
+)
+
+// chartJSTag returns the script element that loads Chart.js: the file at
+// path if given, else the embedded copy, else the CDN
+func chartJSTag(path string) (string, error) {
+	var bundle []byte
+	if path != "" {
+		var err error
+		if bundle, err = os.ReadFile(path); err != nil {
+			return "", fmt.Errorf("failed to read Chart.js: %w", err)
+		}
+	} else if embedded, err := chartJSFiles.ReadFile(chartJSBundle); err == nil {
+		bundle = embedded
+	} else {
+		return chartJSCDN, nil
+	}
+	// The bundle must not end the script element early
+	js := strings.ReplaceAll(string(bundle), "</script", `<\/script`)
+	return "<script>" + js + "</script>", nil
+}
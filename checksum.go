@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Probe payloads normally go out as zeros, which survive most corruption
+// unnoticed. With payload verification they carry a pattern derived from
+// the sequence number, and every reply is checked against it end to end,
+// so mangling that slips past (or is hidden by) checksum offload shows up.
+
+// fillPattern writes the check pattern for seq into a probe's payload
+func fillPattern(payload []byte, seq uint64) {
+	x := seq | 1
+	for i := range payload {
+		if i%8 == 0 {
+			x = xorshift(x)
+		}
+		payload[i] = byte(x >> (8 * (i % 8)))
+	}
+}
+
+// verifyPattern checks a reply's payload. The server echoes the request's
+// bytes and zero-pads replies larger than the request, so only the first
+// sentSize-HeaderSize bytes carry the pattern.
+func verifyPattern(payload []byte, seq uint64, sentSize int) bool {
+	x := seq | 1
+	for i, b := range payload {
+		if i >= sentSize-HeaderSize {
+			if b != 0 {
+				return false
+			}
+			continue
+		}
+		if i%8 == 0 {
+			x = xorshift(x)
+		}
+		if b != byte(x>>(8*(i%8))) {
+			return false
+		}
+	}
+	return true
+}
+
+func xorshift(x uint64) uint64 {
+	x ^= x << 13
+	x ^= x >> 7
+	x ^= x << 17
+	return x
+}
+
+// udpChecksumErrors returns the host's count of UDP datagrams dropped for a
+// bad checksum, IPv4 and IPv6 combined. It's only available on Linux.
+func udpChecksumErrors() (uint64, bool) {
+	v4, ok4 := snmpCounter("/proc/net/snmp", "Udp:", "InCsumErrors")
+	v6, ok6 := snmpCounter("/proc/net/snmp6", "", "Udp6InCsumErrors")
+	return v4 + v6, ok4 || ok6
+}
+
+// snmpCounter reads one counter from a /proc/net/snmp style file. With a
+// prefix the file is in header/value line pairs (snmp), without one it is
+// name/value lines (snmp6).
+func snmpCounter(path, prefix, name string) (uint64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if prefix == "" {
+			if len(fields) == 2 && fields[0] == name {
+				v, err := strconv.ParseUint(fields[1], 10, 64)
+				return v, err == nil
+			}
+			continue
+		}
+		if len(fields) == 0 || fields[0] != prefix || i+1 >= len(lines) {
+			continue
+		}
+		values := strings.Fields(lines[i+1])
+		for j, f := range fields {
+			if f == name && j < len(values) {
+				v, err := strconv.ParseUint(values[j], 10, 64)
+				return v, err == nil
+			}
+		}
+		return 0, false
+	}
+	return 0, false
+}
+
+// PrintChecksumCheck prints the outcome of payload verification and the
+// kernel's checksum error count over the run
+func PrintChecksumCheck(corrupt uint64, csumErrors uint64, haveCsum bool) {
+	fmt.Println("\n--- Checksum ---")
+	fmt.Printf("Payload check: %d replies corrupted (counted as lost)\n", corrupt)
+	if haveCsum {
+		fmt.Printf("Kernel UDP checksum errors during the run: %d (host-wide)\n", csumErrors)
+	}
+	if corrupt > 0 && haveCsum && csumErrors == 0 {
+		fmt.Println("Corruption passed the UDP checksum: suspect checksum offload or a middlebox rewriting packets")
+	}
+}
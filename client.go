@@ -1,14 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,153 +21,942 @@ import (
 type ClientConfig struct {
 	Host          string
 	Port          int
+	Family        string // "IPv4" or "IPv6" to use only that family, "" = either
+	Label         string // name of the target in a target list, "" = none
 	PacketSize    int
 	Rate          int
 	Duration      int
+	Count         uint64 // probes to send before stopping, 0 = run for Duration
 	OutputFile    string
 	Burst         bool
 	BurstSize     int
 	NoPlot        bool
-	LateThreshold float64 // milliseconds
+	NoOpen        bool          // generate the HTML report without opening it
+	LateThreshold float64       // milliseconds
+	Timeout       time.Duration // fixed loss timeout for the interval stats, 0 = adapt to the RTT
+	NoLookup      bool
+	Traceroute    bool
+	DownRate      int // replies per second, 0 = same as Rate
+	DownSize      int // reply size in bytes, 0 = same as PacketSize
+	CountOnly     bool
+	NoCatchUp     bool     // record missed send slots instead of catching up
+	Pattern       string   // gaps between send slots, PatternFixed, PatternPoisson or PatternJittered
+	Profile       *Profile // traffic to replay instead of a fixed rate and size, nil = none
+	JitterBuffer  float64  // simulated playout buffer in milliseconds, 0 = off
+	GameTick      int      // game tick rate in Hz, 0 = off
+	FEC           []FECScheme
+	ARQ           *ARQConfig
+	RotatePorts   int           // source ports to rotate through, 0 or 1 = off
+	Flows         int           // parallel flows each sending at Rate, 0 or 1 = one
+	Bandwidth     float64       // target bits per second Rate was derived from, 0 = none
+	Load          *LoadConfig   // bulk traffic for a latency-under-load test, nil = none
+	RotatePeriod  time.Duration // time spent on each source port
+	ResultsDir    string        // per-run subdirectories and index.html, "" = current directory
+	Plot          PlotOptions
+	Irtt          bool // print irtt-style metrics and save them as <name>.irtt.json
+	ZeroChecksum  bool // send with a zero UDP checksum, IPv4 only
+	DontFragment  bool // set DF on probes
+	TTL           int  // TTL or hop limit of probes, 0 = system default
+	DSCP          int  // DiffServ code point of probes, 0 = best effort
+	ECN           bool // send probes ECN-capable and count CE marks on replies
+	RecvBuffer    int  // socket receive buffer in bytes, 0 = system default
+	SendBuffer    int  // socket send buffer in bytes, 0 = system default
+	VerifyPayload bool // fill probes with a check pattern and verify every reply
+	Notify        NotifyConfig
+	SLA           *SLA          // limits checked against the summary, nil = none
+	Baseline      *Baseline     // summary saved as or compared against a baseline, nil = none
+	Barrier       int           // clients to wait for at the server's barrier before sending, 0 = off
+	StartAt       time.Time     // wall-clock start time shared with other clients, zero = now
+	Inject        *Injector     // synthetic loss and delay applied to replies, nil = none
+	Strict        bool          // reject replies that don't match an outstanding probe
+	Key           string        // shared secret the server trusts for full-size replies, "" = none
+	DrainCap      time.Duration // longest wait for replies after sending stops
+	Drain         time.Duration // fixed wait for replies after sending stops, 0 = adapt to the RTT
+	ICMPCompare   bool          // ping the target alongside the test
+	SummaryJSON   string        // write the final summary as JSON here, "-" = stdout, "" = off
+	MetricsPort   int           // serve Prometheus metrics on this port during the run, 0 = off
+	SelfProfile   bool          // save the run's CPU profile as <name>.cpu.pprof
+	Spill         bool          // stream records to the CSV as they become final instead of keeping them
+	JSONL         io.Writer     // stream each record here as a JSON line once final, nil = off
+	Influx        *InfluxConfig // export records and interval windows as InfluxDB line protocol, nil = off
+	Statsd        *StatsdConfig // send interval windows to a StatsD agent, nil = off
+	TUI           bool          // redraw a live terminal view every second instead of printing interval lines
 }
 
-// RunClient runs the UDP test client
-func RunClient(cfg ClientConfig) error {
+// RunClient runs the UDP test client and returns the run's metadata.
+// Cancelling ctx ends the test early; results gathered so far are still
+// summarized and saved.
+func RunClient(ctx context.Context, cfg ClientConfig) (*RunMetadata, error) {
 	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
-	conn, err := net.Dial("udp", addr)
+	choice, err := SelectAddress(ctx, cfg.Host, cfg.Port, cfg.Family)
 	if err != nil {
-		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+		return nil, err
+	}
+	if choice.Tried != "" {
+		fmt.Printf("Using %s\n", choice)
+	}
+	if cfg.ZeroChecksum && choice.Family == "IPv6" {
+		fmt.Println("Warning: IPv6 requires UDP checksums, sending with checksums")
+		cfg.ZeroChecksum = false
+	}
+	// Side probes (traceroute, ping) go to the address chosen here, so
+	// they take the same family as the test
+	targetIP, _, _ := net.SplitHostPort(choice.Addr)
+	network := udpNetwork(choice.Family)
+	sockOpts := socketOptions{ZeroChecksum: cfg.ZeroChecksum, DontFragment: cfg.DontFragment, TTL: cfg.TTL, DSCP: cfg.DSCP, ECN: cfg.ECN,
+		RecvBuffer: cfg.RecvBuffer, SendBuffer: cfg.SendBuffer}
+	conn, err := dialRebindable(network, choice.Addr, sockOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
 	}
 	defer conn.Close()
 
-	if cfg.Burst {
+	// Path sampling sends from several source ports in turn and parallel
+	// flows from all of them at once; conns[0] is the main socket
+	flows := max(cfg.Flows, 1)
+	conns := []*rebindConn{conn}
+	for len(conns) < max(cfg.RotatePorts, flows) {
+		extra, err := dialRebindable(network, choice.Addr, sockOpts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+		}
+		defer extra.Close()
+		conns = append(conns, extra)
+	}
+
+	if cfg.Profile != nil {
+		fmt.Printf("Replaying %s, looped, to %s\n\n", cfg.Profile, addr)
+		if cfg.Profile.Raised > 0 {
+			fmt.Printf("Warning: %d packets smaller than the %d byte probe header are sent at that size\n\n", cfg.Profile.Raised, HeaderSize)
+		}
+		if cfg.Profile.Clipped > 0 {
+			fmt.Printf("Warning: %d packets bigger than %d bytes are sent at that size\n\n", cfg.Profile.Clipped, MaxPacketSize)
+		}
+	} else if cfg.Burst {
 		fmt.Printf("Sending %d pps in bursts of %d, %d byte packets to %s\n\n",
 			cfg.Rate, cfg.BurstSize, cfg.PacketSize, addr)
+	} else if cfg.Pattern != PatternFixed && cfg.Pattern != "" {
+		fmt.Printf("Sending %d pps with %s gaps, %d byte packets to %s\n\n",
+			cfg.Rate, cfg.Pattern, cfg.PacketSize, addr)
 	} else {
 		fmt.Printf("Sending %d pps, %d byte packets to %s\n\n",
 			cfg.Rate, cfg.PacketSize, addr)
 	}
+	if cfg.Count > 0 {
+		fmt.Printf("Stopping after %d packets\n\n", cfg.Count)
+	}
+	if flows > 1 {
+		fmt.Printf("Running %d parallel flows at that rate each, %d pps in total\n\n", flows, cfg.Rate*flows)
+	}
+	downRate := cfg.Rate
+	if cfg.DownRate > 0 {
+		downRate = cfg.DownRate
+	}
+	downSize := cfg.PacketSize
+	if cfg.DownSize > 0 {
+		downSize = cfg.DownSize
+	}
+	if cfg.CountOnly {
+		fmt.Printf("Count-only mode: server won't echo, loss is read from its report\n\n")
+	} else if downRate != cfg.Rate || downSize != cfg.PacketSize {
+		fmt.Printf("Downstream: %d pps, %d byte replies\n\n", downRate, downSize)
+	}
+	if cfg.ZeroChecksum {
+		fmt.Printf("Sending with zero UDP checksum\n\n")
+	}
+	if cfg.DontFragment {
+		fmt.Printf("Sending with DF set: probes too big for the path are lost, not fragmented\n\n")
+	}
+	if cfg.TTL > 0 {
+		fmt.Printf("Sending with TTL %d\n\n", cfg.TTL)
+	}
+	if cfg.DSCP > 0 {
+		fmt.Printf("Marking probes DSCP %s\n\n", dscpName(cfg.DSCP))
+	}
+	if cfg.ECN {
+		fmt.Printf("Sending probes ECN-capable (ECT(0)), counting CE marks on replies\n\n")
+	}
+	if cfg.RecvBuffer > 0 || cfg.SendBuffer > 0 {
+		printSocketBuffers(conn.current(), cfg.RecvBuffer, cfg.SendBuffer)
+		fmt.Println()
+	}
+	// Payloads carry the check pattern only when replies echo them
+	runID := newRunID()
+	session := newSession(runID, cfg.Key)
+	check := replyCheck{Strict: cfg.Strict, ReplySize: downSize, Session: session.ID}
+	if cfg.VerifyPayload || cfg.Strict {
+		if cfg.CountOnly {
+			fmt.Printf("Warning: payload verification needs echoed replies, skipped in count-only mode\n\n")
+		} else {
+			check.PatternSize = cfg.PacketSize
+		}
+	}
+	if cfg.Inject != nil {
+		fmt.Printf("Injecting %s: results are synthetic\n\n", cfg.Inject)
+	}
+	if cfg.Load != nil && cfg.Load.Background {
+		fmt.Printf("Background load: %s upload from a second socket, unmeasured\n\n", FormatBitrate(cfg.Load.UpBps))
+	} else if cfg.Load != nil {
+		fmt.Printf("Latency under load: %s idle, then", cfg.Load.Delay)
+		if cfg.Load.UpBps > 0 {
+			fmt.Printf(" %s upload", FormatBitrate(cfg.Load.UpBps))
+		}
+		if cfg.Load.DownBps > 0 {
+			fmt.Printf(" %s download", FormatBitrate(cfg.Load.DownBps))
+		}
+		fmt.Printf(" load until the end\n\n")
+	}
+	if cfg.RotatePorts > 1 {
+		fmt.Printf("Rotating through %d source ports every %s\n\n", len(conns), cfg.RotatePeriod)
+	}
+
+	meta := &RunMetadata{
+		RunID:     runID,
+		Label:     cfg.Label,
+		Target:    addr,
+		Address:   choice,
+		StartTime: time.Now(),
+		Injected:  cfg.Inject,
+	}
+
+	// Resolve target context in the background so it doesn't delay the test
+	var targetInfo chan *TargetInfo
+	if !cfg.NoLookup {
+		targetInfo = make(chan *TargetInfo, 1)
+		go func() {
+			targetInfo <- LookupTargetInfo(ctx, conn.RemoteAddr().(*net.UDPAddr).IP)
+		}()
+	}
+
+	if cfg.Traceroute {
+		fmt.Printf("Tracing path to %s...\n", cfg.Host)
+		meta.TracerouteStart = RunTraceroute(ctx, targetIP)
+		fmt.Printf("Path: %s\n\n", meta.TracerouteStart)
+	}
+
+	meta.Client = CaptureEnvironment(conn.LocalAddr().(*net.UDPAddr).IP)
+	fmt.Printf("Run ID: %s\n", meta.RunID)
+	fmt.Printf("Client: %s\n\n", meta.Client)
+	for _, c := range conns {
+		if err := announceRun(c, session, meta.RunID); err != nil {
+			fmt.Printf("Warning: failed to announce run ID: %v\n", err)
+		}
+	}
+
+	// The server clock offset turns the stamps in replies into one-way
+	// latency; it is measured before any synchronized start so it can't
+	// delay it
+	if !cfg.CountOnly {
+		if sample, err := MeasureClockOffset(ctx, conn, session); errors.Is(err, ErrVersionMismatch) {
+			return nil, err
+		} else if err != nil {
+			fmt.Printf("Warning: one-way latency unavailable: %v\n\n", err)
+		} else {
+			meta.Clock = &ClockSync{Start: sample}
+			fmt.Printf("Clock: %s\n\n", meta.Clock)
+		}
+	}
+
+	// Synchronized start with other clients, through the server's barrier
+	// or a start time agreed beforehand
+	if cfg.Barrier > 0 {
+		fmt.Printf("Waiting for %d clients at the barrier...\n", cfg.Barrier)
+		start, sync, err := JoinBarrier(ctx, conn, session, cfg.Barrier)
+		if err != nil {
+			return nil, err
+		}
+		meta.StartSync = sync
+		fmt.Printf("Barrier released, starting at %s\n", start.Format("15:04:05.000"))
+	} else if !cfg.StartAt.IsZero() {
+		meta.StartSync = &StartSync{Mode: "time", TargetStart: cfg.StartAt}
+		fmt.Printf("Waiting to start at %s\n", cfg.StartAt.Format("15:04:05.000"))
+	}
+	if meta.StartSync != nil {
+		if err := WaitUntil(ctx, meta.StartSync.TargetStart, meta.StartSync); err != nil {
+			return nil, fmt.Errorf("interrupted before the start: %w", err)
+		}
+		fmt.Printf("Synchronized start: %s\n\n", meta.StartSync)
+	}
+
+	var pinger *Pinger
+	if cfg.ICMPCompare {
+		if pinger, err = StartPing(ctx, targetIP, choice.Family == "IPv6"); err != nil {
+			fmt.Printf("Warning: ICMP comparison unavailable: %v\n\n", err)
+		}
+	}
 
 	stats := NewStats(cfg.LateThreshold)
+	if cfg.Timeout > 0 {
+		stats.SetTimeout(cfg.Timeout)
+	}
+	stats.SetECN(cfg.ECN)
+	var view *tui
+	if cfg.TUI {
+		view = newTUI(fmt.Sprintf("packet-test → %s, %d pps, %d byte packets", addr, cfg.Rate*flows, cfg.PacketSize))
+		stats.SetQuiet(true)
+	}
+	var spill *spillFile
+	if cfg.Spill {
+		if spill, err = newSpillFile(spillDir(cfg), meta.Clock); err != nil {
+			return nil, fmt.Errorf("failed to create spill file: %w", err)
+		}
+		defer spill.Abort()
+		stats.SetSpill(spill)
+	}
+	var stream *jsonlStream
+	if cfg.JSONL != nil {
+		stream = newJSONLStream(cfg.JSONL, meta.RunID, cfg.Label, meta.Clock)
+		stats.AddStream(stream)
+	}
+	var influx *influxStream
+	if cfg.Influx != nil {
+		influx = newInfluxStream(*cfg.Influx, addr, meta.RunID, cfg.Label, meta.Clock)
+		stats.AddStream(influx)
+	}
+	var statsd *statsdClient
+	if cfg.Statsd != nil {
+		if statsd, err = newStatsdClient(*cfg.Statsd, addr, meta.RunID, cfg.Label); err != nil {
+			return nil, fmt.Errorf("failed to connect to StatsD at %s: %w", cfg.Statsd.Addr, err)
+		}
+		defer statsd.Close()
+	}
+	csumBefore, haveCsum := udpChecksumErrors()
+	notify := newNotifier(cfg.Notify)
 
-	// Start receiver goroutine
-	done := make(chan struct{})
-	go receivePackets(conn, stats, done)
+	var profile *cpuProfile
+	if cfg.SelfProfile {
+		if profile, err = startCPUProfile(); err != nil {
+			return nil, err
+		}
+		defer profile.Stop()
+	}
 
-	endTime := time.Now().Add(time.Duration(cfg.Duration) * time.Second)
+	if cfg.MetricsPort > 0 {
+		metricsCtx, stopMetrics := context.WithCancel(context.Background())
+		defer stopMetrics()
+		labels := metricLabels("target", addr, "run_id", meta.RunID)
+		if cfg.Label != "" {
+			labels = metricLabels("target", addr, "label", cfg.Label, "run_id", meta.RunID)
+		}
+		err := ServeMetrics(metricsCtx, cfg.MetricsPort, func(w io.Writer) { stats.WriteMetrics(w, labels) })
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("Serving Prometheus metrics on :%d/metrics\n\n", cfg.MetricsPort)
+	}
+
+	// Start receiver goroutine. It runs on its own context so it can keep
+	// collecting replies after sending stops.
+	recvCtx, stopRecv := context.WithCancel(context.Background())
+	defer stopRecv()
+	var recvWg sync.WaitGroup
+	for _, c := range conns {
+		recvWg.Add(1)
+		go func() {
+			defer recvWg.Done()
+			receivePackets(recvCtx, c, stats, check, cfg.Inject)
+		}()
+	}
+
+	// With --count sending stops at the last probe instead of on a timer
+	var sendCtx context.Context
+	var stopSend context.CancelFunc
+	if cfg.Count > 0 {
+		sendCtx, stopSend = context.WithCancel(ctx)
+	} else {
+		sendCtx, stopSend = context.WithTimeout(ctx, time.Duration(cfg.Duration)*time.Second)
+	}
+	defer stopSend()
+	clockSteps := watchClockSteps(recvCtx, 100*time.Millisecond, stats.LastSeq)
 	var seqNum uint64 = 1
+	countReached := func() bool { return cfg.Count > 0 && seqNum > cfg.Count }
+
+	// replyCount spreads the downstream rate over the upstream probes, so
+	// e.g. a 1:20 ratio asks for 20 replies per probe and 20:1 for one reply
+	// every 20th probe
+	replyCount := func(seq uint64) uint16 {
+		up, down := uint64(cfg.Rate), uint64(downRate)
+		return uint16(seq*down/up - (seq-1)*down/up)
+	}
+
+	// pathAt returns the index of the socket whose rotation period covers t
+	testStart := time.Now()
+	pathAt := func(t time.Time) int {
+		if cfg.RotatePorts <= 1 {
+			return 0
+		}
+		return int(t.Sub(testStart)/cfg.RotatePeriod) % len(conns)
+	}
+
+	// sendProbe sends the next packet of a flow for the given tick and
+	// records it
+	sendProbe := func(tick time.Time, flow, size int) error {
+		path := pathAt(tick) + flow
+		conn := conns[path]
+		sendTime := monoUnixNano(time.Now())
+		pkt := session.stamp(NewPacket(seqNum, size, sendTime))
+		pkt.ReplySize = uint16(cfg.DownSize)
+		pkt.ReplyCount = replyCount(seqNum)
+		if cfg.CountOnly {
+			// Keep one record per probe, resolved from the server's report
+			pkt.ReplyCount = 0
+			stats.RecordSent(seqNum, sendTime, 1, path)
+		} else {
+			stats.RecordSent(seqNum, sendTime, int(pkt.ReplyCount), path)
+		}
+		data := pkt.Encode(size)
+		if check.PatternSize > 0 {
+			fillPattern(data[HeaderSize:], seqNum)
+		}
+
+		_, err := conn.Write(data)
+		stats.RecordSendDone(seqNum, monoUnixNano(tick), monoUnixNano(time.Now()))
+		for _, st := range conn.TxTimestamps() {
+			stats.RecordTxTime(st.Seq, st.Ns)
+		}
+		if from, to, ok := conn.Check(err); ok {
+			fmt.Printf("Local address changed from %s to %s, socket rebound at seq %d\n", from, to, seqNum)
+			meta.Events = append(meta.Events, RunEvent{
+				Kind:     EventRebind,
+				Time:     time.Now(),
+				StartSeq: seqNum,
+				EndSeq:   seqNum,
+				Detail:   from + " -> " + to,
+			})
+		}
+		seqNum++
+		return err
+	}
+
+	var load *loadGen
+	if cfg.Load != nil {
+		if load, err = StartLoad(sendCtx, network, choice.Addr, session, *cfg.Load); err != nil {
+			return nil, err
+		}
+	}
 
 	// Stats printing ticker
 	statsTicker := time.NewTicker(100 * time.Millisecond)
 	defer statsTicker.Stop()
+	statsTick := func() {
+		if !cfg.CountOnly {
+			w := stats.PrintInterval()
+			notify.CheckWindow(w)
+			if influx != nil && w != nil {
+				influx.Interval(w)
+			}
+			if statsd != nil && w != nil {
+				statsd.Interval(w)
+			}
+		}
+		stats.Spill(time.Now())
+		if view != nil {
+			view.Update(stats, time.Now())
+		}
+	}
 
+	var pace *pacer
 	if cfg.Burst {
 		// Burst mode: send BurstSize packets quickly, then pause
 		// Calculate bursts per second to maintain overall rate
 		burstsPerSecond := float64(cfg.Rate) / float64(cfg.BurstSize)
 		burstInterval := time.Duration(float64(time.Second) / burstsPerSecond)
-		burstTicker := time.NewTicker(burstInterval)
-		defer burstTicker.Stop()
+		pace = newPacer(burstInterval, !cfg.NoCatchUp)
+		burstTicks, stopTicks := pace.ticks(cfg.Pattern)
 
-		for time.Now().Before(endTime) {
+	burstLoop:
+		for {
 			select {
-			case <-burstTicker.C:
+			case <-sendCtx.Done():
+				break burstLoop
+
+			case tick := <-burstTicks:
 				// Send burst of packets as fast as possible
 				for i := 0; i < cfg.BurstSize; i++ {
-					sendTime := time.Now().UnixNano()
-					pkt := NewPacket(seqNum, cfg.PacketSize, sendTime)
-					data := pkt.Encode(cfg.PacketSize)
-					stats.RecordSent(seqNum, sendTime)
-					conn.Write(data)
-					seqNum++
+					for flow := range flows {
+						sendProbe(tick, flow, cfg.PacketSize)
+						if countReached() {
+							break burstLoop
+						}
+					}
+				}
+
+			case <-statsTicker.C:
+				statsTick()
+			}
+		}
+		stopTicks()
+	} else if cfg.Profile != nil {
+		// Replay mode: send at the profile's offsets with its sizes
+		pace = newPacer(time.Second/time.Duration(cfg.Rate), false)
+		pace.pattern = "replayed"
+		slots, stopReplay := cfg.Profile.replay()
+
+	replayLoop:
+		for {
+			select {
+			case <-sendCtx.Done():
+				break replayLoop
+
+			case slot := <-slots:
+				for flow := range flows {
+					if err := sendProbe(slot.Time, flow, slot.Size); err != nil {
+						fmt.Printf("Send error: %v\n", err)
+					}
+					if countReached() {
+						break replayLoop
+					}
 				}
 
 			case <-statsTicker.C:
-				stats.PrintInterval()
+				statsTick()
 			}
 		}
+		stopReplay()
 	} else {
-		// Steady mode: send packets at fixed interval
+		// Steady mode: send packets at the interval, or with gaps
+		// around it in a random pattern
 		interval := time.Second / time.Duration(cfg.Rate)
-		ticker := time.NewTicker(interval)
-		defer ticker.Stop()
+		pace = newPacer(interval, !cfg.NoCatchUp)
+		ticks, stopTicks := pace.ticks(cfg.Pattern)
 
-		for time.Now().Before(endTime) {
+	steadyLoop:
+		for {
 			select {
-			case <-ticker.C:
-				sendTime := time.Now().UnixNano()
-				pkt := NewPacket(seqNum, cfg.PacketSize, sendTime)
-				data := pkt.Encode(cfg.PacketSize)
+			case <-sendCtx.Done():
+				break steadyLoop
 
-				stats.RecordSent(seqNum, sendTime)
-
-				_, err := conn.Write(data)
-				if err != nil {
-					fmt.Printf("Send error: %v\n", err)
+			case tick := <-ticks:
+				for flow := range flows {
+					if err := sendProbe(tick, flow, cfg.PacketSize); err != nil {
+						fmt.Printf("Send error: %v\n", err)
+					}
+					if countReached() {
+						break steadyLoop
+					}
 				}
-				seqNum++
 
 			case <-statsTicker.C:
-				stats.PrintInterval()
+				statsTick()
 			}
 		}
+		stopTicks()
 	}
 
-	// Wait a bit for final responses
-	time.Sleep(500 * time.Millisecond)
-	close(done)
+	sendEnd := time.Now()
+
+	drainReplies(ctx, stats, cfg.CountOnly, cfg.Drain, cfg.DrainCap)
+	if ctx.Err() != nil {
+		fmt.Println("\nTest interrupted")
+	}
+	stopRecv()
+	recvWg.Wait()
+	meta.Events = append(meta.Events, clockSteps.Events()...)
+	var pings []ICMPSample
+	if pinger != nil {
+		pings = pinger.Stop()
+	}
+	csumAfter, _ := udpChecksumErrors()
+	if meta.Clock != nil && ctx.Err() == nil {
+		if sample, err := MeasureClockOffset(ctx, conn, session); err == nil {
+			meta.Clock.End = sample
+		}
+	}
+
+	if cfg.CountOnly {
+		// The report is the run's only result, so it is fetched even
+		// when the run was cancelled
+		report, err := FetchReport(context.WithoutCancel(ctx), conn, session, seqNum-1)
+		if err != nil {
+			fmt.Printf("Warning: failed to fetch server report: %v\n", err)
+		} else {
+			stats.ApplyServerReport(report)
+			fmt.Printf("\nServer received %d of %d probes (%d bytes)\n", report.Received, seqNum-1, report.Bytes)
+		}
+	}
+
+	// The server's receive report tells which way each loss happened;
+	// spilled records are already written, so it's skipped then
+	if !cfg.CountOnly && !cfg.Spill && ctx.Err() == nil && stats.Lost() > 0 {
+		reports := make([]*ReceiveLog, len(conns))
+		for i, c := range conns {
+			if reports[i], err = FetchReport(ctx, c, session, seqNum-1); err != nil {
+				fmt.Printf("Warning: loss direction unavailable for source port %d: %v\n", c.LocalAddr().(*net.UDPAddr).Port, err)
+			}
+		}
+		stats.AttributeLoss(reports)
+	}
+
+	if cfg.Traceroute && ctx.Err() == nil {
+		fmt.Printf("\nTracing path to %s...\n", cfg.Host)
+		meta.TracerouteEnd = RunTraceroute(ctx, targetIP)
+		meta.PathChanged = PathChanged(meta.TracerouteStart, meta.TracerouteEnd)
+		fmt.Printf("Path: %s\n", meta.TracerouteEnd)
+		if meta.PathChanged {
+			fmt.Println("WARNING: path changed between start and end of the run")
+		}
+	}
+
+	if targetInfo != nil {
+		meta.TargetInfo = <-targetInfo
+		fmt.Printf("\nTarget: %s\n", meta.TargetInfo)
+	}
 
 	stats.PrintSummary()
+	if !cfg.CountOnly {
+		stats.PrintBDP(cfg.Rate*flows, cfg.PacketSize)
+	}
+	// Analyses over every record are left out when most were spilled
+	perPacket := !cfg.Spill
+	if meta.Clock != nil {
+		stats.ApplyClockOffset(meta.Clock)
+		if perPacket {
+			PrintOneWay(stats.GetRecords(), meta.Clock)
+		}
+	}
+	if load != nil && perPacket {
+		meta.Load = load.Result(stats.GetRecords())
+		PrintLoad(meta.Load)
+	}
+	if perPacket {
+		lossMetrics := ComputeLossMetrics(stats.GetRecords())
+		meta.LossMetrics = &lossMetrics
+		PrintLossMetrics(lossMetrics)
+	} else {
+		fmt.Println("Records were spilled to the CSV during the run, per-packet analyses skipped")
+	}
+	pace.PrintSummary(seqNum-1, cfg.Rate*flows, sendEnd, stats.ScheduleError())
+	upBps, downBps := stats.Throughput(cfg.PacketSize, downSize)
+	if cfg.Bandwidth > 0 {
+		fmt.Printf("Bandwidth: %s sent of %s target, %s received\n",
+			FormatBitrate(upBps), FormatBitrate(cfg.Bandwidth), FormatBitrate(downBps))
+	}
+	if cfg.JitterBuffer > 0 && perPacket {
+		PrintJitterBuffer(stats.GetRecords(), cfg.JitterBuffer)
+	}
+	if cfg.GameTick > 0 && perPacket {
+		PrintGameTicks(stats.GetRecords(), cfg.GameTick)
+	}
+	if len(cfg.FEC) > 0 && perPacket {
+		PrintFEC(stats.GetRecords(), cfg.FEC)
+	}
+	if cfg.ARQ != nil && perPacket {
+		PrintARQ(stats.GetRecords(), *cfg.ARQ)
+	}
+	if check.PatternSize > 0 {
+		PrintChecksumCheck(stats.Corrupt(), csumAfter-csumBefore, haveCsum)
+	}
+	if check.Strict {
+		PrintRejected(stats.Rejected(), stats.Corrupt())
+	}
+	if !cfg.CountOnly && perPacket {
+		roams := DetectRoams(stats.GetRecords())
+		PrintRoams(roams)
+		meta.Events = append(meta.Events, roams...)
+		meta.Events = append(meta.Events, stats.TTLChanges()...)
+	}
+	var irtt *IrttResult
+	if cfg.Irtt {
+		irtt = BuildIrttResult(stats.GetRecords(), cfg.PacketSize, testStart, sendEnd.Sub(testStart))
+		irtt.RunID = meta.RunID
+		PrintIrtt(irtt)
+	}
+	if len(conns) > 1 && perPacket {
+		ports := make([]string, len(conns))
+		for i, c := range conns {
+			ports[i] = strconv.Itoa(c.LocalAddr().(*net.UDPAddr).Port)
+		}
+		title := "Path Samples"
+		if flows > 1 {
+			title = "Flows"
+		}
+		PrintPaths(title, stats.GetRecords(), ports)
+	}
+	if downRate != cfg.Rate || downSize != cfg.PacketSize {
+		stats.PrintDirections(cfg.PacketSize, downSize)
+	}
 
 	// Generate output filename if not specified
+	timestamp := time.Now().Format("2006-01-02_15-04-05")
 	outputFile := cfg.OutputFile
 	if outputFile == "" {
-		timestamp := time.Now().Format("2006-01-02_15-04-05")
 		outputFile = fmt.Sprintf("packet-test_%s.csv", timestamp)
 	}
+	if cfg.ResultsDir != "" {
+		runDir, err := newRunDir(cfg.ResultsDir, timestamp, cfg.Host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create run directory: %w", err)
+		}
+		outputFile = filepath.Join(runDir, filepath.Base(outputFile))
+	}
+	meta.Summary = stats.Headline()
+	meta.Summary.UpBps, meta.Summary.DownBps = upBps, downBps
+	meta.TargetRate = cfg.Rate * flows
+	if cfg.Pattern != PatternFixed {
+		meta.SendPattern = cfg.Pattern
+	}
+	if cfg.Profile != nil {
+		meta.Replay = cfg.Profile.Source
+	}
+	meta.TargetBps = cfg.Bandwidth
+	meta.PacketSize, meta.ReplySize = cfg.PacketSize, downSize
+	meta.DontFragment, meta.TTL, meta.ECN = cfg.DontFragment, cfg.TTL, cfg.ECN
+	if rcv, snd, ok := socketBuffers(conn.current()); ok {
+		meta.RecvBuffer, meta.SendBuffer = rcv, snd
+	}
+	if cfg.DSCP > 0 {
+		meta.DSCP = dscpName(cfg.DSCP)
+	}
+	meta.SendRate = stats.SendRate()
+	meta.CSVSchema = CSVSchemaVersion
+	if !cfg.CountOnly && meta.Summary.Received > 0 {
+		meta.Voice = EstimateVoiceQuality(meta.Summary)
+		fmt.Printf("VoIP quality: %s\n", meta.Voice)
+	}
+	if pinger != nil {
+		meta.ICMP = ICMPSummary(pings)
+		PrintICMPCompare(meta.Summary, meta.ICMP)
+	}
+	var slaFailed []string
+	if cfg.SLA != nil {
+		slaFailed = CheckSLA(*cfg.SLA, meta.Summary)
+	}
+	// The JSON summary also serves as the baseline, saved or compared
+	var sum *JSONSummary
+	if cfg.SummaryJSON != "" || cfg.Baseline != nil {
+		sum = stats.JSONSummary()
+		sum.RunID, sum.Label, sum.Target, sum.StartTime = meta.RunID, cfg.Label, addr, meta.StartTime
+		sum.DurationSec = sendEnd.Sub(testStart).Seconds()
+		sum.CSVFile = outputFile
+		sum.PacketSize, sum.ReplySize = cfg.PacketSize, downSize
+		sum.UpBps, sum.DownBps = upBps, downBps
+		sum.Voice = meta.Voice
+		if cfg.SLA != nil {
+			pass := len(slaFailed) == 0
+			sum.SLAPass, sum.SLAFailed = &pass, slaFailed
+		}
+	}
+	var regressed []string
+	if cfg.Baseline != nil && cfg.Baseline.Against != nil {
+		regressed = CompareBaseline(*cfg.Baseline, sum)
+	}
 
 	// Always save CSV
-	if err := saveCSV(outputFile, stats); err != nil {
-		return fmt.Errorf("failed to save CSV: %w", err)
+	stats.FlushSpill()
+	if stream != nil {
+		if err := stream.Flush(); err != nil {
+			return nil, fmt.Errorf("failed to write JSONL: %w", err)
+		}
+	}
+	if influx != nil {
+		if err := influx.Close(); err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+	if spill != nil {
+		err = spill.Close(outputFile)
+	} else {
+		err = saveCSV(outputFile, stats)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save CSV: %w", err)
+	}
+	if err := saveMetadata(outputFile, meta); err != nil {
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+	if pinger != nil {
+		if err := saveICMPCSV(outputFile, pings); err != nil {
+			return nil, fmt.Errorf("failed to save ICMP results: %w", err)
+		}
 	}
 	fmt.Printf("\nResults saved to %s\n", outputFile)
+	if irtt != nil {
+		irttFile, err := SaveIrttJSON(outputFile, irtt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save irtt JSON: %w", err)
+		}
+		fmt.Printf("irtt-compatible results saved to %s\n", irttFile)
+	}
+	if profile != nil {
+		profFile, err := profile.Save(outputFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save CPU profile: %w", err)
+		}
+		fmt.Printf("CPU profile saved to %s (go tool pprof %s)\n", profFile, profFile)
+	}
+	if cfg.SummaryJSON != "" {
+		if err := saveJSONSummary(cfg.SummaryJSON, sum); err != nil {
+			return nil, fmt.Errorf("failed to save JSON summary: %w", err)
+		}
+		if cfg.SummaryJSON != "-" {
+			fmt.Printf("JSON summary saved to %s\n", cfg.SummaryJSON)
+		}
+	}
+	if cfg.Baseline != nil && cfg.Baseline.Against == nil {
+		if err := saveJSONSummary(cfg.Baseline.File, sum); err != nil {
+			return nil, fmt.Errorf("failed to save baseline: %w", err)
+		}
+		fmt.Printf("Baseline saved to %s\n", cfg.Baseline.File)
+	}
+	notify.Finished(addr, meta.Summary)
 
 	// Generate HTML plot and open in browser
 	if !cfg.NoPlot {
-		if err := GeneratePlot(outputFile); err != nil {
-			return fmt.Errorf("failed to generate plot: %w", err)
+		if err := GeneratePlot(outputFile, cfg.Plot); err != nil {
+			return nil, fmt.Errorf("failed to generate plot: %w", err)
 		}
 
-		htmlFile := strings.TrimSuffix(outputFile, ".csv") + ".html"
-		openBrowser(htmlFile)
+		if !cfg.NoOpen {
+			htmlFile := strings.TrimSuffix(outputFile, ".csv") + ".html"
+			openBrowser(htmlFile)
+		}
 	}
 
-	return nil
+	if cfg.ResultsDir != "" {
+		if err := UpdateIndex(cfg.ResultsDir); err != nil {
+			return nil, fmt.Errorf("failed to update results index: %w", err)
+		}
+	}
+
+	if len(slaFailed) > 0 {
+		return meta, fmt.Errorf("%w: %s", ErrSLAFailed, strings.Join(slaFailed, ", "))
+	}
+	if len(regressed) > 0 {
+		return meta, fmt.Errorf("%w: %s", ErrRegression, strings.Join(regressed, ", "))
+	}
+	return meta, nil
 }
 
-func receivePackets(conn net.Conn, stats *Stats, done chan struct{}) {
-	buf := make([]byte, 65535)
+// drainReplies waits for replies still in flight after sending stops. It
+// returns once every probe is answered or the drain timeout has passed,
+// and reports how many probes were still unanswered then. The timeout is
+// fixed if given, otherwise scaled to the RTT measured so far and capped
+// at limit. Count-only runs have nothing to wait for beyond the last
+// probes reaching the server.
+func drainReplies(ctx context.Context, stats *Stats, countOnly bool, fixed, limit time.Duration) {
+	timeout, p99 := stats.DrainTimeout(limit)
+	if fixed > 0 {
+		timeout = fixed
+	}
+	start := time.Now()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	poll := time.NewTicker(20 * time.Millisecond)
+	defer poll.Stop()
 
-	// Set read deadline to allow checking done channel
+wait:
 	for {
 		select {
-		case <-done:
-			return
-		default:
-			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
-			n, err := conn.Read(buf)
-			if err != nil {
-				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		case <-deadline.C:
+			break wait
+		case <-ctx.Done():
+			break wait
+		case <-poll.C:
+			if !countOnly && stats.Outstanding() == 0 {
+				break wait
+			}
+		}
+	}
+	if countOnly {
+		return
+	}
+	// Probes sent less than a timeout before the drain began never got
+	// the full wait; earlier unanswered ones were already lost
+	cutoff := stats.UnansweredSince(monoUnixNano(start.Add(-timeout)))
+	switch {
+	case cutoff == 0:
+	case fixed > 0:
+		fmt.Printf("Drain: %d replies still outstanding at cutoff after %s (--drain, p99 RTT %.1fms), counted as lost\n",
+			cutoff, time.Since(start).Round(time.Millisecond), p99)
+	default:
+		fmt.Printf("Drain: %d replies still outstanding at cutoff after %s (%dx p99 RTT of %.1fms, cap %s), counted as lost\n",
+			cutoff, time.Since(start).Round(time.Millisecond), lossTimeoutRTTs, p99, limit)
+	}
+}
+
+// recvControl is the ancillary data received with a packet
+type recvControl struct {
+	TTL      int    // TTL or hop limit, 0 if unknown
+	ECN      int    // ECN field, ecnNotECT if unknown
+	KernelNs int64  // when the kernel received the packet, wall clock Unix nanoseconds, 0 if unknown
+	Drops    uint32 // packets the socket has dropped so far on a full receive buffer
+	HasDrops bool
+}
+
+// receivePackets records replies until ctx is done, vetting them as check
+// says. inject, if set, perturbs replies before they are recorded.
+func receivePackets(ctx context.Context, conn *rebindConn, stats *Stats, check replyCheck, inject *Injector) {
+	msgs := newMessages(65535, 256)
+	var lastDrops uint32
+	versionWarned := false
+
+	// Unblock the pending Read once the context is done
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	for {
+		count, err := conn.ReadBatch(msgs)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		now := time.Now()
+		for i := range msgs[:count] {
+			m := &msgs[i]
+			buf, n, from := m.Buf, m.N, m.Addr
+			recvTime := monoUnixNano(now)
+			rc := parseRecvControl(m.OOB[:m.OOBN])
+			// The kernel's timestamp leaves out the time until this
+			// goroutine ran, and tells apart the replies of a batch;
+			// it is on the wall clock, so only its age is taken
+			if age := now.UnixNano() - rc.KernelNs; rc.KernelNs > 0 && age >= 0 && age < int64(time.Second) {
+				recvTime -= age
+			}
+			if rc.HasDrops && rc.Drops != lastDrops {
+				// The counter restarts at zero when the socket is rebound
+				if rc.Drops > lastDrops {
+					stats.RecordLocalDrops(uint64(rc.Drops - lastDrops))
+				} else {
+					stats.RecordLocalDrops(uint64(rc.Drops))
+				}
+				lastDrops = rc.Drops
+			}
+			pkt := DecodePacket(buf[:n])
+			if pkt != nil && pkt.Type == TypeHello {
+				continue // echo of the run announcement
+			}
+			if pkt == nil && !versionWarned {
+				if err := versionError(buf[:n]); err != nil {
+					fmt.Printf("Warning: %v\n", err)
+					versionWarned = true
+				}
+			}
+			if check.Strict {
+				server, _ := conn.RemoteAddr().(*net.UDPAddr)
+				if reason := check.validate(stats, pkt, n, from, server); reason >= 0 {
+					stats.RecordRejected(reason)
 					continue
 				}
+			}
+			if pkt == nil || pkt.Type != TypeProbe || pkt.Session != check.Session {
 				continue
 			}
-
-			recvTime := time.Now().UnixNano()
-			pkt := DecodePacket(buf[:n])
-			if pkt != nil {
-				stats.RecordReceived(pkt.SeqNum, recvTime, pkt.ServerProcNs)
+			if check.PatternSize > 0 && !verifyPattern(pkt.Payload, pkt.SeqNum, check.PatternSize) {
+				stats.RecordCorrupt()
+				continue
+			}
+			if inject != nil {
+				if inject.Drop(pkt.SeqNum, pkt.ReplyCount) {
+					continue
+				}
+				recvTime += inject.Delay.Nanoseconds()
 			}
+			stats.RecordReceived(pkt.SeqNum, pkt.ReplyCount, recvTime, pkt.ServerProcNs, pkt.ServerRecvNs, rc)
 		}
 	}
 }
@@ -186,6 +980,11 @@ func openBrowser(path string) {
 }
 
 func saveCSV(filename string, stats *Stats) error {
+	return writeRecordsCSV(filename, stats.GetRecords())
+}
+
+// writeRecordsCSV writes packet records in the current CSV schema
+func writeRecordsCSV(filename string, records []*PacketRecord) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
@@ -196,22 +995,41 @@ func saveCSV(filename string, stats *Stats) error {
 	defer writer.Flush()
 
 	// Write header
-	writer.Write([]string{"seq", "sent_time", "recv_time", "latency_ms", "server_proc_ms", "net_latency_ms", "lost", "late"})
+	writer.Write(csvColumns)
 
 	// Write records
-	records := stats.GetRecords()
 	for _, r := range records {
-		writer.Write([]string{
-			strconv.FormatUint(r.SeqNum, 10),
-			strconv.FormatInt(r.SentTime/1000000, 10), // Convert to milliseconds
-			strconv.FormatInt(r.RecvTime/1000000, 10),
-			fmt.Sprintf("%.2f", r.LatencyMs),
-			fmt.Sprintf("%.2f", r.ServerProcMs),
-			fmt.Sprintf("%.2f", r.NetLatencyMs),
-			strconv.FormatBool(r.Lost),
-			strconv.FormatBool(r.Late),
-		})
+		writer.Write(csvRow(r))
 	}
 
 	return nil
 }
+
+// csvRow formats a record as a row of csvColumns
+func csvRow(r *PacketRecord) []string {
+	up, down := "", ""
+	if r.OneWay {
+		up, down = fmt.Sprintf("%.2f", r.UpMs), fmt.Sprintf("%.2f", r.DownMs)
+	}
+	return []string{
+		strconv.FormatUint(r.SeqNum, 10),
+		strconv.FormatInt(r.SentTime/1000000, 10), // Convert to milliseconds
+		strconv.FormatInt(r.RecvTime/1000000, 10),
+		fmt.Sprintf("%.2f", r.LatencyMs),
+		fmt.Sprintf("%.2f", r.ServerProcMs),
+		fmt.Sprintf("%.3f", r.ClientProcMs),
+		fmt.Sprintf("%.1f", r.SchedErrorUs),
+		fmt.Sprintf("%.2f", r.NetLatencyMs),
+		up,
+		down,
+		strconv.Itoa(r.RecvTTL),
+		strconv.Itoa(r.ECN),
+		strconv.Itoa(r.Path),
+		strconv.FormatBool(r.Lost),
+		r.LossDir,
+		strconv.FormatBool(r.Late),
+		strconv.FormatBool(r.Reordered),
+		strconv.Itoa(r.Duplicates),
+		fmt.Sprintf("%.3f", r.RFCJitterMs),
+	}
+}
@@ -0,0 +1,754 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// clientCommand runs a test against a server: a single run, or one of
+// the modes built on it such as --monitor, --targets or --ramp
+func clientCommand(args []string) error {
+	return runClientCommand(newFlagSet("client", "[flags]",
+		"Test the path to a server: send probes at --rate for --duration, measure loss, latency and jitter\nof the replies and save them to a CSV and HTML report."), args)
+}
+
+// sweepCommand runs the client over a matrix of packet sizes and rates
+func sweepCommand(args []string) error {
+	return runClientCommand(newFlagSet("sweep", "[flags]",
+		"Run the client once for each packet size in --sizes at each rate in --rates, and save a combined\nCSV and a heatmap report. The other flags are the client's and apply to every run."), args)
+}
+
+// runClientCommand parses the flags of the client, and of sweep its
+// matrix too, and runs the selected mode
+func runClientCommand(fs *flag.FlagSet, args []string) error {
+	sweep := fs.Name() == "sweep"
+
+	// Connection flags
+	host := fs.String("host", "localhost", "Server address, a name or IPv4/IPv6 literal, brackets optional")
+	port := fs.Int("port", 9999, "UDP port")
+	ipv4, ipv6 := addFamilyFlags(fs, "Use IPv4 only", "Use IPv6 only")
+
+	// Test flags
+	packetSize := fs.Int("packet-size", 128, "Packet payload size in bytes")
+	rate := fs.Int("rate", 64, "Packets per second")
+	bandwidth := fs.String("bandwidth", "", "Target UDP payload bitrate, e.g. 10M or 500k; sets --rate from it and --packet-size (split across --flows)")
+	duration := fs.Int("duration", 30, "Test duration in seconds")
+	count := fs.Uint64("count", 0, "Send exactly this many packets and stop, instead of running for --duration (0 = off)")
+	output := fs.String("output", "", "CSV filename (auto-generated if empty)")
+	key := fs.String("key", "", "Shared secret the server trusts: one running with --truncate gives full-size replies only to clients that have it")
+	metricsPort := fs.Int("metrics-port", 0, "Serve Prometheus metrics of the live test on this TCP port at /metrics (0 = off)")
+	pprofAddr := fs.String("pprof", "", "Serve Go runtime profiles (net/http/pprof) at this address, e.g. :6060, to see whether latency spikes come from the tool itself")
+	selfProfile := fs.Bool("self-profile", false, "Record the client's CPU profile during the run and save it next to the CSV as <name>.cpu.pprof")
+	summaryJSON := fs.String("summary-json", "", "Also write the final summary (loss, late, RTT/net/server percentiles, jitter) as JSON to this file, - for stdout")
+	spill := fs.Bool("spill", false, "Write per-packet records to the CSV as they become final instead of keeping them all in memory, for long high-rate runs; per-packet analyses are skipped")
+	format := fs.String("format", "csv", "Output format: csv saves the records at the end of the run; jsonl also streams each record to stdout as a JSON line once final (10s after it was sent, losses at the end), and influx streams records and interval windows as InfluxDB line protocol, with the rest of the output on stderr")
+	influxURL := fs.String("influx-url", "", "Post records and interval windows in line protocol to this InfluxDB write URL, e.g. http://localhost:8086/api/v2/write?org=o&bucket=b")
+	influxToken := fs.String("influx-token", "", "InfluxDB API token for --influx-url")
+	statsdAddr := fs.String("statsd", "", "Send each 5s window's sent, received, lost and late counts and RTT and jitter timings to the StatsD or DogStatsD agent at this host:port")
+	statsdPrefix := fs.String("statsd-prefix", "packet_test", "Metric name prefix for --statsd")
+	statsdTags := fs.String("statsd-tags", "", "Comma-separated tags to add to --statsd metrics, e.g. env:prod,team:net (target, test_id and label are always sent)")
+	resultsDir := fs.String("results-dir", "", "Save each run in its own subdirectory here and keep an index.html of all runs")
+	burst := fs.Bool("burst", false, "Send packets in bursts (exposes WiFi buffering)")
+	burstSize := fs.Int("burst-size", 10, "Packets per burst (with --burst)")
+	downRate := fs.Int("down-rate", 0, "Reply packets per second from the server (0 = same as --rate); more reply bytes than probe bytes, or more than 4 replies a probe, need --key set as on the server")
+	downSize := fs.Int("down-size", 0, "Reply size in bytes (0 = same as --packet-size); replies bigger than the probes need --key set as on the server")
+	fs.IntVar(downSize, "reply-size", 0, "Same as --down-size: have the server reply with this many bytes, e.g. 1400 byte replies to 64 byte probes; replies bigger than the probes are refused unless --key is set as on the server")
+	pattern := fs.String("pattern", PatternFixed, "Gaps between sends: fixed, poisson (exponential around the interval) or jittered (uniform within half an interval), so probes don't alias with periodic behaviour such as WiFi power save")
+	noCatchUp := fs.Bool("no-catchup", false, "Skip send slots missed under load instead of catching up (still reported)")
+	jitterBuffer := fs.Float64("jitter-buffer", 0, "Simulate fixed and adaptive jitter buffers of this size in ms (0 = off)")
+	replay := fs.String("replay", "", "Replay the timing and sizes of real traffic from a schedule file of \"offset-seconds size\" lines or a pcap of its UDP packets, looped, instead of a fixed rate")
+	gameTick := fs.Int("game-tick", 0, "Send one probe per game tick at this rate in Hz and report missed frames (0 = off)")
+	fec := fs.String("fec", "", "Simulate FEC schemes on the loss trace, e.g. 4:1,8:2 (k data : n parity)")
+	arq := fs.String("arq", "", "Simulate retransmission on the trace as timeout:deadline in ms, e.g. 50:200")
+	rotatePorts := fs.Int("rotate-ports", 0, "Rotate through this many source ports to sample load-balanced paths (0 = off)")
+	rotatePeriod := fs.Float64("rotate-period", 5, "Seconds spent on each source port (with --rotate-ports)")
+	flows := fs.Int("flows", 1, "Send --rate from each of this many sockets (distinct source ports) at once and report each flow")
+	countOnly := fs.Bool("count-only", false, "Server counts probes instead of echoing; loss is read from its report")
+	irtt := fs.Bool("irtt", false, "Print irtt-style metrics (IPDV, send call, timer error) and save irtt-compatible JSON")
+	dontFragment := fs.Bool("df", false, "Send probes with the Don't Fragment bit set, so probes too big for the path are lost instead of fragmented (Linux only)")
+	ttl := fs.Int("ttl", 0, "TTL (IPv4) or hop limit (IPv6) of probes, e.g. to expire them a given number of hops away (0 = system default, Linux only)")
+	ecn := fs.Bool("ecn", false, "Send probes ECN-capable and count congestion-experienced marks on replies, to see whether the bottleneck marks rather than drops (Linux only)")
+	rcvbuf := fs.Int("rcvbuf", 0, "Socket receive buffer in bytes, e.g. 8388608 for high rates, so replies aren't lost in the host's own buffer (0 = system default)")
+	sndbuf := fs.Int("sndbuf", 0, "Socket send buffer in bytes (0 = system default)")
+	dscp := fs.String("dscp", "", "Mark probes with this DSCP, by name (EF, AF41, CS5, ...) or number 0-63, to check QoS queueing (Linux only)")
+	zeroChecksum := fs.Bool("zero-checksum", false, "Send with a zero UDP checksum (IPv4 on Linux only) to test checksum offload and middleboxes")
+	verifyPayload := fs.Bool("verify-payload", false, "Fill probes with a check pattern, verify every reply and report corruption and kernel checksum errors")
+	notify := fs.Bool("notify", false, "Show a desktop notification when the run finishes")
+	notifyLoss := fs.Float64("notify-loss", 0, "Show a desktop notification when a 5s window's loss exceeds this percent (0 = off)")
+	notifyRTT := fs.Float64("notify-rtt", 0, "Show a desktop notification when a 5s window's average RTT exceeds this many ms (0 = off)")
+	bell := fs.Bool("bell", false, "Ring the terminal bell with each notification")
+	maxLoss := fs.Float64("max-loss", 0, "SLA: fail the run (exit status 2) if loss exceeds this percent (0 = off, overrides --preset)")
+	maxP99 := fs.Float64("max-p99", 0, "SLA: fail the run (exit status 2) if p99 RTT exceeds this many ms (0 = off, overrides --preset)")
+	maxJitter := fs.Float64("max-jitter", 0, "SLA: fail the run (exit status 2) if jitter exceeds this many ms (0 = off, overrides --preset)")
+	baselineFile := fs.String("baseline", "", "Save the run's summary to this JSON file as a baseline, or compare the run against it with --compare-baseline")
+	compareBaseline := fs.Bool("compare-baseline", false, "Compare the run against the --baseline file and exit with status 3 if loss, RTT or jitter regressed beyond the tolerance")
+	baselineTolerance := fs.Float64("baseline-tolerance", 20, "Percent the average, p50 and p99 RTT and jitter may rise over the baseline before it counts as a regression")
+	baselineLoss := fs.Float64("baseline-loss", 0.5, "Percentage points loss may rise over the baseline before it counts as a regression")
+	preset := fs.String("preset", "", "Use a traffic preset that sets rate, size, bursts, late threshold and SLA checks: "+PresetNames())
+	barrier := fs.Int("barrier", 0, "Wait until this many clients have joined the server's barrier, then all start together (0 = off)")
+	startAt := fs.String("start-at", "", "Start sending at this time (RFC 3339, HH:MM:SS or Unix seconds), to line up clients with synced clocks")
+	tuiMode := fs.Bool("tui", false, "Show a live terminal view (RTT sparkline, loss gauge, percentiles) redrawn every second instead of interval lines")
+	monitor := fs.Bool("monitor", false, "Run indefinitely (default 10 pps), starting a new CSV every hour and printing a rolling 24h summary")
+	targetsFile := fs.String("targets", "", "Test each target of this YAML list in turn (label, host, port, rate, size per target; flags fill in the rest) and save summaries keyed by label")
+	schedule := fs.String("schedule", "", "Stay resident and run a test at the times of this cron expression, e.g. \"*/15 * * * *\", appending each run to a per-day summary CSV and HTML report")
+	selfTest := fs.Bool("selftest", false, fmt.Sprintf("Run a server and client in this process over loopback (default %ds) and report the tool's own latency and jitter floor on this host", selfTestDuration))
+	monitorDaily := fs.Bool("monitor-daily", false, "Rotate at midnight instead of every hour (with --monitor)")
+	stress := fs.Int("stress", 0, "Stress-test the server: ramp up to this many virtual clients (doubling each step) at --rate each and report where it saturates")
+	stressStep := fs.Int("stress-step", 5, "Seconds per step (with --stress)")
+	ramp := fs.String("ramp", "", "Find the highest sustainable rate: sweep the send rate as start:end:step pps, e.g. 100:5000:100")
+	rampInterval := fs.Int("ramp-interval", 5, "Seconds per rate step (with --ramp)")
+	mtuSweep := fs.Bool("mtu-sweep", false, "Find the path MTU: send DF-marked probes of increasing size and report the largest that gets through, with loss per size (Linux only)")
+	mtuRange := fs.String("mtu-range", "1200:1500:4", "IP packet sizes to sweep as min:max:step bytes (with --mtu-sweep)")
+	rampMaxLoss := fs.Float64("ramp-max-loss", 1, "Loss percent a rate may have and still count as sustainable (with --ramp)")
+	injectLoss := fs.String("inject-loss", "", "Drop this share of replies before they're recorded, e.g. 5% (for validating reports)")
+	injectDelay := fs.Duration("inject-delay", 0, "Add this delay to every reply's receive time, e.g. 20ms (for validating reports)")
+	seed := fs.Int64("seed", 1, "Seed for --inject-loss, so runs are reproducible")
+	strict := fs.Bool("strict", false, "Reject replies that don't match an outstanding probe (source, size, timestamp, payload) and count them separately")
+	drain := fs.Duration("drain", 0, "Wait this long for outstanding replies after sending stops, e.g. 2s for a satellite link (0 = adapt to 3x the p99 RTT, up to --drain-cap)")
+	drainCap := fs.Duration("drain-cap", 10*time.Second, "Longest wait for outstanding replies after sending stops (the wait adapts to 3x the p99 RTT)")
+	load := fs.String("load", "", "Send unmeasured bulk UDP upstream at this many Mbps (or a bitrate like 500k) from a second socket for the whole run, to measure the probes on a loaded link (a server with --rate-limit counts it against the limit unless --key matches)")
+	loadUp := fs.String("load-up", "", "Latency under load: send bulk UDP upstream at this bitrate, e.g. 50M, after an idle baseline (a server with --rate-limit counts it against the limit unless --key matches)")
+	loadDown := fs.String("load-down", "", "Latency under load: have the server stream bulk UDP down at this bitrate, e.g. 200M (needs the server's --key)")
+	loadDelay := fs.Duration("load-delay", 5*time.Second, "Idle baseline before the load starts (with --load-up/--load-down)")
+	icmpCompare := fs.Bool("icmp-compare", false, "Ping the target alongside the test and compare ICMP with UDP latency and loss")
+	downlink := fs.Bool("downlink", false, "Have the server push a stream at --rate and --packet-size for --duration and measure one-way loss and jitter on the way down (needs the server's --key)")
+	noPlot := fs.Bool("no-plot", false, "Don't generate HTML plot or open browser")
+	timeout := fs.Float64("timeout", 0, "Count a probe as lost in the interval stats once it goes this many ms without a reply (0 = adapt to the RTT)")
+	lateThreshold := fs.Float64("late-threshold", 100, "Packets above this latency (ms) are counted as late")
+	noLookup := fs.Bool("no-lookup", false, "Don't resolve reverse DNS/AS/country of the target (the location is only the country, from Team Cymru registry data, with no city or region)")
+	traceroute := fs.Bool("traceroute", false, "Trace the path to the target at start and end of the run")
+	plot := addPlotFlags(fs)
+
+	var sweepSizes, sweepRates *string
+	if sweep {
+		sweepSizes = fs.String("sizes", "64,512,1400", "Packet sizes in bytes to sweep, comma-separated")
+		sweepRates = fs.String("rates", "50,200,1000", "Rates in pps to sweep at each size, comma-separated")
+	}
+	fs.Parse(args)
+
+	plotOpts, err := plot.options()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	family := parseFamily(*ipv4, *ipv6)
+	// Accept IPv6 literals in URL style, e.g. [2001:db8::1]
+	if strings.HasPrefix(*host, "[") && strings.HasSuffix(*host, "]") {
+		*host = (*host)[1 : len(*host)-1]
+	}
+
+	var sla *SLA
+	if *preset != "" {
+		p, err := ApplyPreset(fs, *preset)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		sla = &p.SLA
+	}
+	if *maxLoss < 0 || *maxP99 < 0 || *maxJitter < 0 {
+		fmt.Fprintln(os.Stderr, "Error: max-loss, max-p99 and max-jitter can't be negative")
+		os.Exit(1)
+	}
+	if *maxLoss > 0 || *maxP99 > 0 || *maxJitter > 0 {
+		if sla == nil {
+			sla = &SLA{}
+		}
+		if *maxLoss > 0 {
+			sla.MaxLoss = *maxLoss
+		}
+		if *maxP99 > 0 {
+			sla.MaxP99 = *maxP99
+		}
+		if *maxJitter > 0 {
+			sla.MaxJitter = *maxJitter
+		}
+	}
+
+	var baseline *Baseline
+	if *compareBaseline && *baselineFile == "" {
+		fmt.Fprintln(os.Stderr, "Error: --compare-baseline needs the --baseline file to compare against")
+		os.Exit(1)
+	}
+	if *baselineFile != "" {
+		if sweep || *monitor || *selfTest || *targetsFile != "" || *schedule != "" {
+			fmt.Fprintln(os.Stderr, "Error: --baseline is for single runs and can't be combined with sweep, --monitor, --selftest, --targets or --schedule")
+			os.Exit(1)
+		}
+		if *baselineTolerance < 0 || *baselineLoss < 0 {
+			fmt.Fprintln(os.Stderr, "Error: baseline-tolerance and baseline-loss can't be negative")
+			os.Exit(1)
+		}
+		baseline = &Baseline{File: *baselineFile, TolerancePct: *baselineTolerance, LossTolerance: *baselineLoss}
+		if *compareBaseline {
+			if baseline.Against, err = LoadBaseline(*baselineFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to load baseline: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Validate packet size
+	if *packetSize < HeaderSize {
+		fmt.Fprintf(os.Stderr, "Error: packet-size must be at least %d bytes\n", HeaderSize)
+		os.Exit(1)
+	}
+
+	if *packetSize > MaxPacketSize {
+		fmt.Fprintf(os.Stderr, "Error: packet-size must be at most %d bytes\n", MaxPacketSize)
+		os.Exit(1)
+	}
+
+	if _, err := ParsePattern(*pattern); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var dscpValue int
+	if *dscp != "" {
+		if dscpValue, err = ParseDSCP(*dscp); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *rcvbuf < 0 || *sndbuf < 0 {
+		fmt.Fprintln(os.Stderr, "Error: rcvbuf and sndbuf can't be negative")
+		os.Exit(1)
+	}
+	if *ttl < 0 || *ttl > 255 {
+		fmt.Fprintln(os.Stderr, "Error: ttl must be between 1 and 255 (0 = system default)")
+		os.Exit(1)
+	}
+
+	if *downSize != 0 && (*downSize < HeaderSize || *downSize > MaxPacketSize) {
+		fmt.Fprintf(os.Stderr, "Error: down-size must be between %d and %d bytes\n", HeaderSize, MaxPacketSize)
+		os.Exit(1)
+	}
+
+	var targetBps float64
+	if *bandwidth != "" {
+		if flagSet(fs, "rate") {
+			fmt.Fprintln(os.Stderr, "Error: --bandwidth can't be combined with --rate")
+			os.Exit(1)
+		}
+		if targetBps, err = ParseBitrate(*bandwidth); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := checkBitrate("bandwidth", targetBps/float64(max(*flows, 1)), *packetSize); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		*rate = RateForBitrate(targetBps/float64(max(*flows, 1)), *packetSize)
+		fmt.Printf("Bandwidth %s at %d byte packets: %d pps\n", FormatBitrate(targetBps), *packetSize, *rate*max(*flows, 1))
+	}
+
+	var targets []Target
+	if *targetsFile != "" {
+		if sweep || *monitor || *selfTest {
+			fmt.Fprintln(os.Stderr, "Error: --targets can't be combined with sweep, --monitor or --selftest")
+			os.Exit(1)
+		}
+		if targets, err = LoadTargets(*targetsFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var cron *cronSchedule
+	if *schedule != "" {
+		if sweep || *monitor || *selfTest || *targetsFile != "" || *resultsDir != "" {
+			fmt.Fprintln(os.Stderr, "Error: --schedule can't be combined with sweep, --monitor, --selftest, --targets or --results-dir")
+			os.Exit(1)
+		}
+		if cron, err = ParseCron(*schedule); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var sizes, rates []int
+	if sweep {
+		if *monitor || *selfTest {
+			fmt.Fprintln(os.Stderr, "Error: sweep can't be combined with --monitor or --selftest")
+			os.Exit(1)
+		}
+		if sizes, err = ParseSweepList("sizes", *sweepSizes); err == nil {
+			rates, err = ParseSweepList("rates", *sweepRates)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, size := range sizes {
+			if size < HeaderSize || size > MaxPacketSize {
+				fmt.Fprintf(os.Stderr, "Error: sweep sizes must be %d to %d bytes\n", HeaderSize, MaxPacketSize)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if *selfTest {
+		if *monitor {
+			fmt.Fprintln(os.Stderr, "Error: --selftest can't be combined with --monitor")
+			os.Exit(1)
+		}
+		if !flagSet(fs, "duration") {
+			*duration = selfTestDuration
+		}
+	}
+
+	if *monitor {
+		if flagSet(fs, "duration") || *count > 0 {
+			fmt.Fprintln(os.Stderr, "Error: --monitor runs until stopped and can't be combined with --duration or --count")
+			os.Exit(1)
+		}
+		if !flagSet(fs, "rate") && *bandwidth == "" {
+			*rate = monitorRate
+		}
+	}
+
+	// A replayed profile brings its own timing and sizes; the rate and
+	// packet size become its averages for the stats
+	var profile *Profile
+	if *replay != "" {
+		if flagSet(fs, "rate") || flagSet(fs, "packet-size") || *bandwidth != "" || *burst || *gameTick > 0 || *pattern != PatternFixed {
+			fmt.Fprintln(os.Stderr, "Error: --replay can't be combined with --rate, --packet-size, --bandwidth, --burst, --game-tick or --pattern")
+			os.Exit(1)
+		}
+		if *strict || *verifyPayload {
+			fmt.Fprintln(os.Stderr, "Error: --replay can't be combined with --strict or --verify-payload, as probe sizes vary")
+			os.Exit(1)
+		}
+		if profile, err = LoadProfile(*replay); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		*rate, *packetSize = profile.Rate(), profile.AvgSize()
+	}
+
+	if err := checkRate("rate", *rate); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *downRate < 0 || *downRate > *rate*math.MaxUint16 {
+		fmt.Fprintf(os.Stderr, "Error: down-rate must be between 0 and %d\n", *rate*math.MaxUint16)
+		os.Exit(1)
+	}
+
+	if *countOnly && (*downRate != 0 || *downSize != 0) {
+		fmt.Fprintln(os.Stderr, "Error: --count-only can't be combined with --down-rate or --down-size")
+		os.Exit(1)
+	}
+
+	// Servers give a client without their key no more reply bytes than it
+	// sent, in at most maxUnkeyedReplies replies a probe
+	if *key == "" && (*downRate != 0 || *downSize != 0) {
+		down, size := *rate, *packetSize
+		if *downRate != 0 {
+			down = *downRate
+		}
+		if *downSize != 0 {
+			size = *downSize
+		}
+		if perProbe := (down + *rate - 1) / *rate; perProbe > maxUnkeyedReplies || perProbe*size > *packetSize {
+			flags := "--down-rate and --down-size ask"
+			if flagSet(fs, "reply-size") {
+				flags = "--reply-size asks"
+			}
+			fmt.Fprintf(os.Stderr, "Error: %s for more reply bytes than probe bytes, which servers only send to clients with their key; set --key to the same secret here and on the server\n", flags)
+			os.Exit(1)
+		}
+	}
+
+	if *rotatePorts > 1 {
+		if *countOnly {
+			fmt.Fprintln(os.Stderr, "Error: --rotate-ports can't be combined with --count-only")
+			os.Exit(1)
+		}
+		if *rotatePeriod <= 0 {
+			fmt.Fprintln(os.Stderr, "Error: rotate-period must be positive")
+			os.Exit(1)
+		}
+	}
+
+	if *flows < 1 {
+		fmt.Fprintln(os.Stderr, "Error: flows must be at least 1")
+		os.Exit(1)
+	}
+	if *flows > 1 && *rotatePorts > 1 {
+		fmt.Fprintln(os.Stderr, "Error: --flows can't be combined with --rotate-ports")
+		os.Exit(1)
+	}
+
+	// Game mode aligns probes to the tick rate
+	if *gameTick > 0 {
+		if *burst {
+			fmt.Fprintln(os.Stderr, "Error: --game-tick can't be combined with --burst")
+			os.Exit(1)
+		}
+		if err := checkRate("game-tick", *gameTick); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		*rate = *gameTick
+	}
+
+	// testLength is how long the client will send for
+	testLength := time.Duration(*duration) * time.Second
+	if *count > 0 {
+		if flagSet(fs, "duration") {
+			fmt.Fprintln(os.Stderr, "Error: --count can't be combined with --duration")
+			os.Exit(1)
+		}
+		// Divided first and clamped, so a huge count can't overflow
+		secs := float64(*count) / float64(*rate**flows)
+		testLength = time.Duration(min(secs, float64(math.MaxInt64/time.Second)) * float64(time.Second))
+	}
+
+	var startTime time.Time
+	if *startAt != "" {
+		if *barrier > 0 {
+			fmt.Fprintln(os.Stderr, "Error: --start-at can't be combined with --barrier")
+			os.Exit(1)
+		}
+		if startTime, err = ParseStartAt(*startAt); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if time.Until(startTime) < 0 {
+			fmt.Fprintf(os.Stderr, "Error: start time %s has already passed\n", startTime.Format(time.RFC3339))
+			os.Exit(1)
+		}
+	}
+	if *barrier < 0 || *barrier > math.MaxUint16 {
+		fmt.Fprintf(os.Stderr, "Error: barrier must be between 0 and %d\n", math.MaxUint16)
+		os.Exit(1)
+	}
+	// The server streams only to clients that prove they have its key
+	if (*downlink || *loadDown != "") && *key == "" {
+		fmt.Fprintln(os.Stderr, "Error: --downlink and --load-down need --key, set to the same secret as the server's --key")
+		os.Exit(1)
+	}
+
+	var inject *Injector
+	if *injectLoss != "" || *injectDelay != 0 {
+		inject = &Injector{Delay: *injectDelay, Seed: *seed}
+		if *injectLoss != "" {
+			if inject.LossPercent, err = ParsePercent(*injectLoss); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: inject-loss: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if *injectDelay < 0 {
+			fmt.Fprintln(os.Stderr, "Error: inject-delay can't be negative")
+			os.Exit(1)
+		}
+		if *countOnly {
+			fmt.Fprintln(os.Stderr, "Error: --inject-loss and --inject-delay act on replies, which --count-only doesn't get")
+			os.Exit(1)
+		}
+	}
+
+	if *timeout < 0 {
+		fmt.Fprintln(os.Stderr, "Error: timeout can't be negative")
+		os.Exit(1)
+	}
+
+	if *drainCap <= 0 {
+		fmt.Fprintln(os.Stderr, "Error: drain-cap must be positive")
+		os.Exit(1)
+	}
+	if *drain < 0 {
+		fmt.Fprintln(os.Stderr, "Error: drain can't be negative")
+		os.Exit(1)
+	}
+	if *drain > 0 && flagSet(fs, "drain-cap") {
+		fmt.Fprintln(os.Stderr, "Error: --drain sets a fixed wait and can't be combined with --drain-cap")
+		os.Exit(1)
+	}
+
+	if *strict && *countOnly {
+		fmt.Fprintln(os.Stderr, "Error: --strict validates replies, which --count-only doesn't get")
+		os.Exit(1)
+	}
+
+	if *tuiMode && *countOnly {
+		fmt.Fprintln(os.Stderr, "Error: --tui shows replies, which --count-only doesn't get")
+		os.Exit(1)
+	}
+
+	if *spill && (*countOnly || *irtt) {
+		fmt.Fprintln(os.Stderr, "Error: --spill can't be combined with --count-only or --irtt, which need every record at the end")
+		os.Exit(1)
+	}
+
+	// A JSONL or Influx stream takes stdout; everything else printed goes
+	// to stderr
+	var jsonl io.Writer
+	var influx *InfluxConfig
+	if *influxURL != "" {
+		if err := checkInfluxURL(*influxURL); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		influx = &InfluxConfig{URL: *influxURL, Token: *influxToken}
+	}
+	var statsd *StatsdConfig
+	if *statsdAddr != "" {
+		if _, _, err := net.SplitHostPort(*statsdAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid StatsD address %q, expected host:port\n", *statsdAddr)
+			os.Exit(1)
+		}
+		tags, err := ParseStatsdTags(*statsdTags)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		statsd = &StatsdConfig{Addr: *statsdAddr, Prefix: *statsdPrefix, Tags: tags}
+	}
+	switch *format {
+	case "csv":
+	case "jsonl", "influx":
+		if *tuiMode || *countOnly || *summaryJSON == "-" {
+			fmt.Fprintf(os.Stderr, "Error: --format %s can't be combined with --tui, --count-only or --summary-json -\n", *format)
+			os.Exit(1)
+		}
+		if *format == "jsonl" {
+			jsonl = os.Stdout
+		} else if influx != nil {
+			influx.Out = os.Stdout
+		} else {
+			influx = &InfluxConfig{Out: os.Stdout}
+		}
+		os.Stdout = os.Stderr
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid format %q (want csv, jsonl or influx)\n", *format)
+		os.Exit(1)
+	}
+
+	var fecSchemes []FECScheme
+	if *fec != "" {
+		var err error
+		if fecSchemes, err = ParseFECSchemes(*fec); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var arqConfig *ARQConfig
+	if *arq != "" {
+		cfg, err := ParseARQ(*arq)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		arqConfig = &cfg
+	}
+
+	var loadCfg *LoadConfig
+	if *load != "" {
+		if *loadUp != "" || *loadDown != "" || flagSet(fs, "load-delay") {
+			fmt.Fprintln(os.Stderr, "Error: --load can't be combined with --load-up, --load-down or --load-delay")
+			os.Exit(1)
+		}
+		loadCfg = &LoadConfig{Background: true}
+		if loadCfg.UpBps, err = parseMbps(*load); err == nil {
+			err = checkBitrate("load", loadCfg.UpBps, loadPacketSize)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else if *loadUp != "" || *loadDown != "" {
+		loadCfg = &LoadConfig{Delay: *loadDelay}
+		if *loadUp != "" {
+			if loadCfg.UpBps, err = ParseBitrate(*loadUp); err == nil {
+				err = checkBitrate("load-up", loadCfg.UpBps, loadPacketSize)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		if *loadDown != "" {
+			if loadCfg.DownBps, err = ParseBitrate(*loadDown); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			// The server won't stream faster than streamMaxRate
+			if maxBps := float64(streamMaxRate * loadPacketSize * 8); loadCfg.DownBps > maxBps {
+				fmt.Printf("Load down %s is more than a server stream carries: using %s\n", FormatBitrate(loadCfg.DownBps), FormatBitrate(maxBps))
+				loadCfg.DownBps = maxBps
+			}
+		}
+		if *loadDelay < 0 || *loadDelay+loadSettle >= testLength {
+			fmt.Fprintln(os.Stderr, "Error: load-delay must leave time for the loaded phase within --duration or --count")
+			os.Exit(1)
+		}
+	}
+
+	// Ctrl+C ends the test early
+	ctx, stop := commandContext(*pprofAddr)
+	defer stop()
+
+	// Run selected mode
+	if *downlink {
+		err = RunDownlink(ctx, DownlinkConfig{
+			Host:       *host,
+			Port:       *port,
+			Family:     family,
+			PacketSize: *packetSize,
+			Rate:       *rate,
+			Duration:   *duration,
+			OutputFile: *output,
+			NoPlot:     *noPlot,
+			Plot:       plotOpts,
+			Key:        *key,
+		})
+	} else if *mtuSweep {
+		lo, hi, step, rangeErr := ParseMTURange(*mtuRange)
+		if rangeErr != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", rangeErr)
+			os.Exit(1)
+		}
+		err = RunMTUSweep(ctx, MTUConfig{
+			Host:       *host,
+			Port:       *port,
+			Family:     family,
+			Min:        lo,
+			Max:        hi,
+			Step:       step,
+			OutputFile: *output,
+			Key:        *key,
+		})
+	} else if *ramp != "" {
+		var start, end, step int
+		if start, end, step, err = ParseRamp(*ramp); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if *rampInterval <= 0 {
+			fmt.Fprintln(os.Stderr, "Error: ramp-interval must be positive")
+			os.Exit(1)
+		}
+		err = RunRamp(ctx, RampConfig{
+			Host:        *host,
+			Port:        *port,
+			Family:      family,
+			PacketSize:  *packetSize,
+			Start:       start,
+			End:         end,
+			Step:        step,
+			StepSeconds: *rampInterval,
+			MaxLoss:     *rampMaxLoss,
+			OutputFile:  *output,
+		})
+	} else if *stress > 0 {
+		if *stressStep <= 0 {
+			fmt.Fprintln(os.Stderr, "Error: stress-step must be positive")
+			os.Exit(1)
+		}
+		err = RunStress(ctx, StressConfig{
+			Host:        *host,
+			Port:        *port,
+			Family:      family,
+			PacketSize:  *packetSize,
+			Rate:        *rate,
+			MaxClients:  *stress,
+			StepSeconds: *stressStep,
+			OutputFile:  *output,
+		})
+	} else {
+		cfg := ClientConfig{
+			Host:          *host,
+			Port:          *port,
+			Family:        family,
+			PacketSize:    *packetSize,
+			Rate:          *rate,
+			Duration:      *duration,
+			Count:         *count,
+			OutputFile:    *output,
+			Burst:         *burst,
+			BurstSize:     *burstSize,
+			NoPlot:        *noPlot,
+			LateThreshold: *lateThreshold,
+			Timeout:       time.Duration(*timeout * float64(time.Millisecond)),
+			NoLookup:      *noLookup,
+			Traceroute:    *traceroute,
+			DownRate:      *downRate,
+			DownSize:      *downSize,
+			CountOnly:     *countOnly,
+			NoCatchUp:     *noCatchUp,
+			Pattern:       *pattern,
+			Profile:       profile,
+			JitterBuffer:  *jitterBuffer,
+			GameTick:      *gameTick,
+			FEC:           fecSchemes,
+			ARQ:           arqConfig,
+			ResultsDir:    *resultsDir,
+			Plot:          plotOpts,
+			Irtt:          *irtt,
+			ZeroChecksum:  *zeroChecksum,
+			DontFragment:  *dontFragment,
+			TTL:           *ttl,
+			DSCP:          dscpValue,
+			ECN:           *ecn,
+			RecvBuffer:    *rcvbuf,
+			SendBuffer:    *sndbuf,
+			VerifyPayload: *verifyPayload,
+			RotatePorts:   *rotatePorts,
+			RotatePeriod:  time.Duration(*rotatePeriod * float64(time.Second)),
+			ICMPCompare:   *icmpCompare,
+			Flows:         *flows,
+			Bandwidth:     targetBps,
+			Load:          loadCfg,
+			SummaryJSON:   *summaryJSON,
+			MetricsPort:   *metricsPort,
+			SelfProfile:   *selfProfile,
+			Spill:         *spill,
+			JSONL:         jsonl,
+			Influx:        influx,
+			Statsd:        statsd,
+			TUI:           *tuiMode,
+			Notify: NotifyConfig{
+				OnFinish: *notify,
+				Bell:     *bell,
+				MaxLoss:  *notifyLoss,
+				MaxRTT:   *notifyRTT,
+			},
+			SLA:      sla,
+			Baseline: baseline,
+			Barrier:  *barrier,
+			StartAt:  startTime,
+			Inject:   inject,
+			Strict:   *strict,
+			Key:      *key,
+			DrainCap: *drainCap,
+			Drain:    *drain,
+		}
+		if *monitor {
+			err = RunMonitor(ctx, MonitorConfig{Client: cfg, Daily: *monitorDaily})
+		} else if *selfTest {
+			err = RunSelfTest(ctx, cfg)
+		} else if cron != nil {
+			err = RunCron(ctx, CronConfig{Client: cfg, Schedule: cron, Expr: *schedule})
+		} else if sweep {
+			err = RunSweep(ctx, SweepConfig{Client: cfg, Sizes: sizes, Rates: rates})
+		} else if targets != nil {
+			err = RunTargets(ctx, TargetsConfig{Client: cfg, Targets: targets})
+		} else {
+			_, err = RunClient(ctx, cfg)
+		}
+	}
+	return err
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+)
+
+// One-way latency needs the server's clock. Before and after the test the
+// client sends TypeClockSync requests stamped with its send time T1; the
+// server stamps its receive time T2 and the time it took to reply, giving
+// its transmit time T3, and the client notes the arrival time T4. As in
+// NTP the server clock is ((T2-T1)+(T3-T4))/2 ahead, exact when both
+// directions take equally long and off by at most half the network RTT
+// otherwise, so the fastest exchange is kept. Probe replies carry the same
+// stamps, and the offset is interpolated between the two estimates to
+// follow clock drift over the run.
+
+const (
+	clockSyncExchanges = 20
+	clockSyncInterval  = 20 * time.Millisecond
+	clockSyncTimeout   = 500 * time.Millisecond // wait for each answer
+)
+
+// ClockSample is one estimate of the server's clock offset
+type ClockSample struct {
+	Time     time.Time `json:"time"`      // local time of the exchange
+	OffsetMs float64   `json:"offset_ms"` // server clock minus local clock
+	ErrorMs  float64   `json:"error_ms"`  // half the network RTT of the exchange, bounds the offset error
+}
+
+// ClockSync is the server clock offset over a run
+type ClockSync struct {
+	Start *ClockSample `json:"start"`
+	End   *ClockSample `json:"end,omitempty"` // nil if the run was interrupted or the server stopped answering
+}
+
+// OffsetAt returns the server clock offset in nanoseconds at local time t
+// (Unix nanoseconds), interpolated between the start and end estimates
+func (c *ClockSync) OffsetAt(t int64) int64 {
+	start := c.Start.OffsetMs * 1e6
+	if c.End == nil {
+		return int64(start)
+	}
+	span := float64(c.End.Time.UnixNano() - c.Start.Time.UnixNano())
+	if span <= 0 {
+		return int64(start)
+	}
+	frac := float64(t-c.Start.Time.UnixNano()) / span
+	return int64(start + (c.End.OffsetMs*1e6-start)*frac)
+}
+
+// ErrorMs returns the larger error bound of the two estimates
+func (c *ClockSync) ErrorMs() float64 {
+	if c.End == nil {
+		return c.Start.ErrorMs
+	}
+	return max(c.Start.ErrorMs, c.End.ErrorMs)
+}
+
+// String formats the offset for console output and reports,
+// e.g. "server clock +3.21ms ±0.45ms, drift +1.2ppm"
+func (c *ClockSync) String() string {
+	s := fmt.Sprintf("server clock %+.2fms ±%.2fms", c.Start.OffsetMs, c.ErrorMs())
+	if c.End != nil {
+		if span := c.End.Time.Sub(c.Start.Time).Seconds(); span > 0 {
+			s += fmt.Sprintf(", drift %+.1fppm", (c.End.OffsetMs-c.Start.OffsetMs)*1e3/span)
+		}
+	}
+	return s
+}
+
+// MeasureClockOffset runs the clock sync exchanges and returns the
+// estimate from the fastest one. The receiver goroutines must not be
+// running since answers are read from conn.
+func MeasureClockOffset(ctx context.Context, conn net.Conn, session Session) (*ClockSample, error) {
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+	defer conn.SetReadDeadline(time.Time{})
+
+	var best *ClockSample
+	bestRTT := int64(-1)
+	buf := make([]byte, 65535)
+
+	for i := uint64(1); i <= clockSyncExchanges && ctx.Err() == nil; i++ {
+		t1 := monoUnixNano(time.Now())
+		req := session.stamp(&Packet{SeqNum: i, Type: TypeClockSync, Timestamp: t1}).Encode(HeaderSize)
+		if _, err := conn.Write(req); err != nil {
+			return nil, fmt.Errorf("failed to send clock sync: %w", err)
+		}
+
+		conn.SetReadDeadline(time.Now().Add(clockSyncTimeout))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break // unanswered, move on
+			}
+			t4 := monoUnixNano(time.Now())
+			if err := versionError(buf[:n]); err != nil {
+				return nil, err
+			}
+			pkt := DecodePacket(buf[:n])
+			if pkt == nil || pkt.Type != TypeClockSync || pkt.SeqNum != i || pkt.ServerRecvNs == 0 {
+				continue // a late answer or a stray probe reply
+			}
+			t2 := pkt.ServerRecvNs
+			t3 := t2 + pkt.ServerProcNs
+			rtt := (t4 - t1) - (t3 - t2)
+			if bestRTT < 0 || rtt < bestRTT {
+				bestRTT = rtt
+				best = &ClockSample{
+					Time:     time.Unix(0, t1+(t4-t1)/2),
+					OffsetMs: float64((t2-t1)+(t3-t4)) / 2 / 1e6,
+					ErrorMs:  float64(rtt) / 2 / 1e6,
+				}
+			}
+			break
+		}
+
+		select {
+		case <-time.After(clockSyncInterval):
+		case <-ctx.Done():
+		}
+	}
+	if best == nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.New("server didn't answer clock sync")
+	}
+	return best, nil
+}
+
+// PrintOneWay prints the upstream and downstream latency distribution
+func PrintOneWay(records []*PacketRecord, clock *ClockSync) {
+	var up, down []float64
+	for _, r := range records {
+		if r.OneWay {
+			up = append(up, r.UpMs)
+			down = append(down, r.DownMs)
+		}
+	}
+	if len(up) == 0 {
+		return
+	}
+	sort.Float64s(up)
+	sort.Float64s(down)
+	avg := func(v []float64) float64 {
+		sum := 0.0
+		for _, x := range v {
+			sum += x
+		}
+		return sum / float64(len(v))
+	}
+
+	fmt.Println("\n--- One-way latency ---")
+	fmt.Printf("Clock offset: %s\n", clock)
+	fmt.Printf("Upstream:   min %.2fms, avg %.2fms, p50 %.2fms, p99 %.2fms, max %.2fms\n",
+		up[0], avg(up), percentile(up, 50), percentile(up, 99), up[len(up)-1])
+	fmt.Printf("Downstream: min %.2fms, avg %.2fms, p50 %.2fms, p99 %.2fms, max %.2fms\n",
+		down[0], avg(down), percentile(down, 50), percentile(down, 99), down[len(down)-1])
+	fmt.Printf("Offset error of up to ±%.2fms moves latency from one direction to the other\n", clock.ErrorMs())
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+)
+
+// significance is the p-value below which a difference is reported as real
+const significance = 0.05
+
+// minCompareSamples is the fewest answered packets per run for the
+// latency tests to mean anything
+const minCompareSamples = 20
+
+// CompareRuns loads two result CSVs and tests whether their latency
+// distributions and loss rates differ significantly. Packets within a run
+// aren't independent (congestion comes in episodes), so the p-values are
+// optimistic; a difference that isn't significant here certainly isn't.
+func CompareRuns(fileA, fileB string) error {
+	a, err := LoadRecords(fileA)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", fileA, err)
+	}
+	b, err := LoadRecords(fileB)
+	if err != nil {
+		return fmt.Errorf("failed to load %s: %w", fileB, err)
+	}
+	latA, lostA := answeredLatencies(a)
+	latB, lostB := answeredLatencies(b)
+
+	fmt.Println("--- Comparison ---")
+	fmt.Printf("%-12s %16s %16s\n", "", "A", "B")
+	fmt.Printf("%-12s %16s %16s\n", "File", filepath.Base(fileA), filepath.Base(fileB))
+	// The usual reason to compare is a change in marking, e.g. EF vs best effort
+	if dscpA, dscpB := runDSCP(fileA), runDSCP(fileB); dscpA != dscpB {
+		fmt.Printf("%-12s %16s %16s\n", "DSCP", dscpA, dscpB)
+	}
+	fmt.Printf("%-12s %16d %16d\n", "Packets", len(a), len(b))
+	fmt.Printf("%-12s %15.2f%% %15.2f%%\n", "Loss", lossPercent(lostA, len(a)), lossPercent(lostB, len(b)))
+	fmt.Printf("%-12s %14.2fms %14.2fms\n", "RTT mean", avg(latA), avg(latB))
+	for _, p := range []float64{50, 90, 99} {
+		fmt.Printf("%-12s %14.2fms %14.2fms\n", fmt.Sprintf("RTT p%.0f", p), percentile(latA, p), percentile(latB, p))
+	}
+
+	fmt.Println("\nLatency:")
+	if len(latA) < minCompareSamples || len(latB) < minCompareSamples {
+		fmt.Printf("  Not enough answered packets to compare (need %d per run)\n", minCompareSamples)
+	} else {
+		u, z, p := mannWhitney(latA, latB)
+		shift := "B lower"
+		if z > 0 {
+			shift = "B higher"
+		}
+		fmt.Printf("  Mann-Whitney U:     U=%.0f z=%+.2f p=%.4f  %s\n", u, z, p, verdict(p, shift))
+		d, p := kolmogorovSmirnov(latA, latB)
+		fmt.Printf("  Kolmogorov-Smirnov: D=%.3f p=%.4f  %s\n", d, p, verdict(p, "distributions differ"))
+	}
+
+	fmt.Println("\nLoss:")
+	z, p := twoProportions(lostA, len(a), lostB, len(b))
+	shift := "B lower"
+	if z > 0 {
+		shift = "B higher"
+	}
+	fmt.Printf("  Two-proportion z:   z=%+.2f p=%.4f  %s\n", z, p, verdict(p, shift))
+	fmt.Println("\nPackets within a run are correlated, so treat p-values near the cutoff as optimistic")
+	return nil
+}
+
+// runDSCP returns the probe marking recorded in a run's metadata
+func runDSCP(csvFile string) string {
+	meta, _ := loadMetadata(csvFile)
+	if meta == nil || meta.DSCP == "" {
+		return "BE (0)"
+	}
+	return meta.DSCP
+}
+
+// answeredLatencies returns the sorted RTTs of the answered records and
+// the number of lost ones
+func answeredLatencies(records []*PacketRecord) ([]float64, int) {
+	var lats []float64
+	lost := 0
+	for _, r := range records {
+		if r.Lost {
+			lost++
+		} else if r.RecvTime > 0 {
+			lats = append(lats, r.LatencyMs)
+		}
+	}
+	sort.Float64s(lats)
+	return lats, lost
+}
+
+func lossPercent(lost, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(lost) / float64(total) * 100
+}
+
+func verdict(p float64, direction string) string {
+	if p < significance {
+		return "significant (" + direction + ")"
+	}
+	return "not significant"
+}
+
+// mannWhitney runs a two-sided Mann-Whitney U test with the normal
+// approximation, corrected for ties. z is positive when b tends to be
+// larger than a. Both inputs must be sorted.
+func mannWhitney(a, b []float64) (u, z, p float64) {
+	n1, n2 := float64(len(a)), float64(len(b))
+	n := n1 + n2
+
+	// Merge and rank, giving ties their average rank
+	var rankSumA, tieTerm float64
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		v := math.Inf(1)
+		if i < len(a) {
+			v = a[i]
+		}
+		if j < len(b) && b[j] < v {
+			v = b[j]
+		}
+		start := float64(i + j)
+		inA := 0
+		for i < len(a) && a[i] == v {
+			i++
+			inA++
+		}
+		for j < len(b) && b[j] == v {
+			j++
+		}
+		t := float64(i+j) - start
+		rankSumA += float64(inA) * (start + (t+1)/2)
+		tieTerm += t*t*t - t
+	}
+
+	u = rankSumA - n1*(n1+1)/2 // pairs where a is larger
+	mean := n1 * n2 / 2
+	sigma := math.Sqrt(n1 * n2 / 12 * ((n + 1) - tieTerm/(n*(n-1))))
+	if sigma == 0 {
+		return u, 0, 1
+	}
+	diff := mean - u
+	// Continuity correction toward the mean
+	diff -= math.Copysign(math.Min(0.5, math.Abs(diff)), diff)
+	z = diff / sigma
+	return u, z, math.Erfc(math.Abs(z) / math.Sqrt2)
+}
+
+// kolmogorovSmirnov runs a two-sample Kolmogorov-Smirnov test and returns
+// the largest gap between the two CDFs with its asymptotic p-value. Both
+// inputs must be sorted.
+func kolmogorovSmirnov(a, b []float64) (d, p float64) {
+	n1, n2 := float64(len(a)), float64(len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		v := math.Min(a[i], b[j])
+		for i < len(a) && a[i] == v {
+			i++
+		}
+		for j < len(b) && b[j] == v {
+			j++
+		}
+		d = math.Max(d, math.Abs(float64(i)/n1-float64(j)/n2))
+	}
+
+	ne := math.Sqrt(n1 * n2 / (n1 + n2))
+	lambda := (ne + 0.12 + 0.11/ne) * d
+	// Kolmogorov distribution tail: 2 * sum (-1)^(k-1) exp(-2 k^2 lambda^2)
+	sign := 1.0
+	for k := 1.0; k <= 100; k++ {
+		term := 2 * sign * math.Exp(-2*k*k*lambda*lambda)
+		p += term
+		if math.Abs(term) < 1e-10 {
+			break
+		}
+		sign = -sign
+	}
+	if lambda < 0.3 {
+		p = 1 // the series converges badly here and the answer is ~1
+	}
+	return d, math.Max(0, math.Min(1, p))
+}
+
+// twoProportions tests whether two loss rates differ with a pooled
+// two-proportion z test. z is positive when b's rate is higher.
+func twoProportions(lostA, totalA, lostB, totalB int) (z, p float64) {
+	if totalA == 0 || totalB == 0 {
+		return 0, 1
+	}
+	p1 := float64(lostA) / float64(totalA)
+	p2 := float64(lostB) / float64(totalB)
+	pooled := float64(lostA+lostB) / float64(totalA+totalB)
+	se := math.Sqrt(pooled * (1 - pooled) * (1/float64(totalA) + 1/float64(totalB)))
+	if se == 0 {
+		return 0, 1
+	}
+	z = (p2 - p1) / se
+	return z, math.Erfc(math.Abs(z) / math.Sqrt2)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A comparison report overlays several runs on a shared time axis, from
+// each run's own start, e.g. before and after a firmware update. Series are
+// aggregated per second so runs of any length overlay cleanly.
+
+const compareTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Packet Loss Test Comparison</title>
+    {{CHARTJS}}
+` + reportStyle + `    <style>
+        table.compare { width: 100%; border-collapse: collapse; }
+        table.compare th, table.compare td { padding: 6px 10px; text-align: right; border-bottom: 1px solid var(--bg); }
+        table.compare th:first-child, table.compare td:first-child { text-align: left; color: var(--muted); }
+        table.compare th { color: var(--accent); }
+    </style>
+</head>
+<body>
+    <h1>UDP Packet Loss Test Comparison</h1>
+
+    <div class="chart-container">
+        <table class="compare">
+{{SUMMARY_TABLE}}
+        </table>
+    </div>
+
+    <div class="chart-container">
+        <canvas id="rttChart"></canvas>
+    </div>
+
+    <div class="chart-container">
+        <canvas id="lossChart"></canvas>
+    </div>
+
+    <div class="chart-container">
+        <canvas id="cdfChart"></canvas>
+    </div>
+
+    <script>
+        const runs = {{RUNS_JSON}};
+        const themes = {
+            dark: { text: '#eee', muted: '#888', grid: '#333' },
+            light: { text: '#222', muted: '#666', grid: '#ddd' }
+        };
+        const params = new URLSearchParams(location.search);
+        const themeName = themes[params.get('theme')] ? params.get('theme') : '{{THEME}}';
+        const theme = themes[themeName];
+        document.body.classList.add('theme-' + themeName);
+
+        const colors = ['#00d9ff', '#feca57', '#ff6b6b', '#1dd1a1', '#a29bfe', '#ff9ff3', '#54a0ff', '#c8d6e5'];
+        const color = i => colors[i % colors.length];
+
+        const linear = (title, unit) => ({
+            type: 'linear',
+            title: { display: true, text: title + (unit ? ' (' + unit + ')' : ''), color: theme.muted },
+            ticks: { color: theme.muted },
+            grid: { color: theme.grid }
+        });
+        const options = (title, x, y, extra) => Object.assign({
+            responsive: true,
+            parsing: false,
+            spanGaps: false,
+            elements: { point: { radius: 0 } },
+            plugins: {
+                title: { display: true, text: title, color: theme.text },
+                legend: { labels: { color: theme.text } }
+            },
+            scales: { x: x, y: y }
+        }, extra || {});
+
+        new Chart(document.getElementById('rttChart'), {
+            type: 'line',
+            data: {
+                datasets: runs.map((r, i) => ({
+                    label: r.label,
+                    data: r.seconds.map(s => ({ x: s.t, y: s.avg })),
+                    borderColor: color(i),
+                    borderWidth: 1
+                }))
+            },
+            options: options('Average RTT per Second', linear('Seconds from start'), linear('RTT', 'ms'))
+        });
+
+        new Chart(document.getElementById('lossChart'), {
+            type: 'line',
+            data: {
+                datasets: runs.map((r, i) => ({
+                    label: r.label,
+                    data: r.seconds.map(s => ({ x: s.t, y: s.loss })),
+                    borderColor: color(i),
+                    borderWidth: 1
+                }))
+            },
+            options: options('Loss per Second', linear('Seconds from start'), Object.assign(linear('Loss', '%'), { min: 0 }))
+        });
+
+        new Chart(document.getElementById('cdfChart'), {
+            type: 'line',
+            data: {
+                datasets: runs.map((r, i) => ({
+                    label: r.label,
+                    data: r.cdf.map(p => ({ x: p[0], y: p[1] })),
+                    borderColor: color(i),
+                    borderWidth: 1.5,
+                    stepped: true
+                }))
+            },
+            options: options('RTT Distribution', linear('RTT', 'ms'), Object.assign(linear('Packets at or below', '%'), { min: 0, max: 100 }))
+        });
+    </script>
+</body>
+</html>
+`
+
+// compareRun is one run's series in a comparison report
+type compareRun struct {
+	Label   string         `json:"label"`
+	Seconds []comparePoint `json:"seconds"`
+	CDF     [][2]float64   `json:"cdf"` // RTT in ms, percent of answered packets at or below it
+	stats   compareRunSummary
+}
+
+// comparePoint is one second of a run, counted from its first probe
+type comparePoint struct {
+	T    int64    `json:"t"`
+	Avg  *float64 `json:"avg"` // null when nothing was answered that second
+	Loss float64  `json:"loss"`
+}
+
+// compareRunSummary is a run's column in the summary table
+type compareRunSummary struct {
+	Started                 time.Time
+	Target                  string
+	DSCP                    string
+	Packets, Lost           int
+	Avg, P50, P90, P99, Max float64
+	Jitter                  float64
+	Answered                int
+}
+
+// ComparePlots generates one report overlaying the given runs, named after
+// the first file with a _compare suffix
+func ComparePlots(csvFiles []string, opts PlotOptions) error {
+	runs := make([]*compareRun, 0, len(csvFiles))
+	for _, f := range csvFiles {
+		run, err := loadCompareRun(f)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, run)
+	}
+
+	runsJSON, err := json.Marshal(runs)
+	if err != nil {
+		return err
+	}
+	chartJS, err := chartJSTag(opts.ChartJS)
+	if err != nil {
+		return err
+	}
+	theme := opts.Theme
+	if theme == "" {
+		theme = "dark"
+	}
+
+	page := compareTemplate
+	page = strings.Replace(page, "{{SUMMARY_TABLE}}", strings.TrimSuffix(renderCompareTable(runs), "\n"), 1)
+	page = strings.Replace(page, "{{RUNS_JSON}}", string(runsJSON), 1)
+	page = strings.Replace(page, "{{THEME}}", theme, 1)
+	page = strings.Replace(page, "{{CHARTJS}}", chartJS, 1)
+
+	outputFile := strings.TrimSuffix(csvFiles[0], ".csv") + "_compare.html"
+	if err := os.WriteFile(outputFile, []byte(page), 0644); err != nil {
+		return fmt.Errorf("failed to write HTML: %w", err)
+	}
+	fmt.Printf("Generated %s\n", outputFile)
+	return nil
+}
+
+// loadCompareRun reads a CSV and reduces it to per-second series, its RTT
+// distribution and summary stats
+func loadCompareRun(csvFile string) (*compareRun, error) {
+	records, err := LoadRecords(csvFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", csvFile, err)
+	}
+	meta, err := loadMetadata(csvFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+
+	run := &compareRun{Label: filepath.Base(strings.TrimSuffix(csvFile, ".csv"))}
+	if meta != nil {
+		run.stats.Started, run.stats.Target = meta.StartTime, meta.Target
+	}
+	run.stats.DSCP = runDSCP(csvFile)
+
+	buckets := make(map[int64]*secondBucket)
+	for _, r := range records {
+		sec := r.SentTime / int64(time.Second)
+		b, ok := buckets[sec]
+		if !ok {
+			b = &secondBucket{T: sec}
+			buckets[sec] = b
+		}
+		b.add(r.Lost, r.LatencyMs, !r.Lost && r.RecvTime > 0)
+	}
+	seconds := aggregateSeconds(buckets)
+	for _, b := range seconds {
+		p := comparePoint{T: b.T - seconds[0].T, Avg: b.Avg}
+		p.Loss = lossPercent(b.Lost, b.Sent)
+		run.Seconds = append(run.Seconds, p)
+	}
+
+	lats, lost := answeredLatencies(records)
+	run.CDF = cdfPoints(lats, cdfMaxPoints)
+
+	s := &run.stats
+	s.Packets, s.Lost, s.Answered = len(records), lost, len(lats)
+	if len(lats) > 0 {
+		_, s.Avg, s.Max, s.Jitter = calcStats(lats)
+		s.P50, s.P90, s.P99 = percentile(lats, 50), percentile(lats, 90), percentile(lats, 99)
+	}
+	return run, nil
+}
+
+// renderCompareTable renders the summary stats with one column per run
+func renderCompareTable(runs []*compareRun) string {
+	var b strings.Builder
+	cell := func(tag, v string) { fmt.Fprintf(&b, "<%s>%s</%s>", tag, v, tag) }
+	row := func(label string, value func(s *compareRunSummary) string) {
+		b.WriteString("            <tr>")
+		cell("td", label)
+		for _, r := range runs {
+			cell("td", value(&r.stats))
+		}
+		b.WriteString("</tr>\n")
+	}
+	ms := func(v func(s *compareRunSummary) float64) func(s *compareRunSummary) string {
+		return func(s *compareRunSummary) string {
+			if s.Answered == 0 {
+				return "-"
+			}
+			return fmt.Sprintf("%.2f ms", v(s))
+		}
+	}
+
+	b.WriteString("            <tr>")
+	cell("th", "")
+	for _, r := range runs {
+		cell("th", html.EscapeString(r.Label))
+	}
+	b.WriteString("</tr>\n")
+	row("Started", func(s *compareRunSummary) string {
+		if s.Started.IsZero() {
+			return "-"
+		}
+		return s.Started.Format("2006-01-02 15:04")
+	})
+	row("Target", func(s *compareRunSummary) string {
+		if s.Target == "" {
+			return "-"
+		}
+		return html.EscapeString(s.Target)
+	})
+	row("DSCP", func(s *compareRunSummary) string { return s.DSCP })
+	row("Packets", func(s *compareRunSummary) string { return fmt.Sprint(s.Packets) })
+	row("Loss", func(s *compareRunSummary) string {
+		return fmt.Sprintf("%.2f%% (%d)", lossPercent(s.Lost, s.Packets), s.Lost)
+	})
+	row("RTT avg", ms(func(s *compareRunSummary) float64 { return s.Avg }))
+	row("RTT p50", ms(func(s *compareRunSummary) float64 { return s.P50 }))
+	row("RTT p90", ms(func(s *compareRunSummary) float64 { return s.P90 }))
+	row("RTT p99", ms(func(s *compareRunSummary) float64 { return s.P99 }))
+	row("RTT max", ms(func(s *compareRunSummary) float64 { return s.Max }))
+	row("Jitter", ms(func(s *compareRunSummary) float64 { return s.Jitter }))
+	return b.String()
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Scheduled mode stays resident and runs a test at the times of a cron
+// expression. Each run keeps its own CSV; a row per run is appended to the
+// day's summary CSV and the day's runs are merged into one HTML report
+// that grows with each run, so runs can be compared without an external
+// cron job losing track of them.
+
+// cronSchedule holds the allowed values of each field as bitmasks
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool // the field was *, so only the other day field restricts
+}
+
+// ParseCron parses a standard five-field cron expression: minute, hour,
+// day of month, month and day of week (0 or 7 = Sunday). Fields take
+// numbers, ranges a-b, lists a,b, * and steps */n or a-b/n. As in cron,
+// when both day fields are restricted a day matching either one counts.
+func ParseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, expected 5 fields: minute hour day-of-month month day-of-week", expr)
+	}
+	var s cronSchedule
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	masks := [5]*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, f := range fields {
+		mask, err := parseCronField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", expr, err)
+		}
+		*masks[i] = mask
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny, s.dowAny = fields[2] == "*", fields[4] == "*"
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q: it never matches a date", expr)
+	}
+	return &s, nil
+}
+
+func parseCronField(f string, lo, hi int) (uint64, error) {
+	var mask uint64
+	for part := range strings.SplitSeq(f, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		start, end := lo, hi
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var errA, errB error
+			start, errA = strconv.Atoi(a)
+			end = start
+			if isRange {
+				end, errB = strconv.Atoi(b)
+			} else if hasStep {
+				end = hi // n/step runs from n to the end, as in cron
+			}
+			if errA != nil || errB != nil || start < lo || end > hi || start > end {
+				return 0, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+			}
+		}
+		for v := start; v <= end; v += step {
+			mask |= 1 << v
+		}
+	}
+	return mask, nil
+}
+
+// matchesDay reports whether the schedule runs on t's date
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first scheduled minute after t in t's location, or
+// the zero time if there is none within five years
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// CronConfig configures scheduled testing
+type CronConfig struct {
+	Client   ClientConfig // settings for each run; OutputFile is set per run
+	Schedule *cronSchedule
+	Expr     string // the schedule as given, for messages
+}
+
+// RunCron runs the client at each scheduled time until ctx is cancelled.
+// A run still going at a scheduled time makes it skip that one.
+func RunCron(ctx context.Context, cfg CronConfig) error {
+	prefix := strings.TrimSuffix(cfg.Client.OutputFile, ".csv")
+	if prefix == "" {
+		prefix = "packet-test-scheduled"
+	}
+	fmt.Printf("Testing %s on schedule %q, daily summaries and reports named %s_<date>\n\n",
+		net.JoinHostPort(cfg.Client.Host, strconv.Itoa(cfg.Client.Port)), cfg.Expr, prefix)
+
+	for {
+		next := cfg.Schedule.Next(time.Now())
+		fmt.Printf("Next run at %s\n", next.Format("Jan 02 15:04"))
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+			return nil
+		}
+
+		start := time.Now()
+		day := start.Format("2006-01-02")
+		run := cfg.Client
+		run.OutputFile = fmt.Sprintf("%s_%s.csv", prefix, start.Format("2006-01-02_15-04-05"))
+		run.NoPlot = true // the day's report covers it
+
+		meta, err := RunClient(ctx, run)
+		if err != nil && !errors.Is(err, ErrSLAFailed) {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Printf("Warning: scheduled run failed: %v\n\n", err)
+			continue
+		}
+
+		seg := monitorSegment{Start: start, End: time.Now(), File: run.OutputFile, Summary: meta.Summary}
+		summaryFile := fmt.Sprintf("%s_%s_summary.csv", prefix, day)
+		if err := appendMonitorSummary(summaryFile, seg); err != nil {
+			fmt.Printf("Warning: failed to update %s: %v\n", summaryFile, err)
+		}
+		s := seg.Summary
+		fmt.Printf("\n=== Run %s: loss %.2f%% (%d of %d), avg RTT %.2fms, p99 %.2fms, jitter %.2fms ===\n",
+			start.Format("Jan 02 15:04"), s.LossPercent, s.Sent-s.Received, s.Sent, s.AvgRTTMs, s.P99RTTMs, s.JitterMs)
+
+		if !cfg.Client.NoPlot {
+			// The day's runs are found by name, so a restart picks them up
+			runs, _ := filepath.Glob(fmt.Sprintf("%s_%s_??-??-??.csv", prefix, day))
+			report := fmt.Sprintf("%s_%s.html", prefix, day)
+			if err := generatePlot(runs, report, cfg.Client.Plot); err != nil {
+				fmt.Printf("Warning: failed to update %s: %v\n", report, err)
+			} else {
+				fmt.Printf("Day report: %s (%d runs)\n", report, len(runs))
+			}
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSVSchemaVersion is the version of the per-packet CSV written by this
+// build. It is recorded in the run metadata; files without metadata have
+// their version inferred from the header.
+//
+//	1: seq, sent_time, recv_time, latency_ms, lost, late
+//	2: adds server_proc_ms and net_latency_ms (net still includes client time)
+//	3: adds client_proc_ms, net_latency_ms excludes it
+//	4: adds recv_ttl and path
+//	5: adds loss_dir
+//	6: adds up_ms and down_ms, empty when the server clock offset is unknown
+//	7: adds reordered and duplicate (the number of duplicate replies)
+//	8: adds jitter_rfc3550_ms, the running RFC 3550 jitter
+//	9: adds ecn, the ECN field of the reply (3 = CE)
+//	10: adds sched_error_us, how late the probe was sent after its slot
+const CSVSchemaVersion = 10
+
+// csvColumns is the header written for CSVSchemaVersion
+var csvColumns = []string{"seq", "sent_time", "recv_time", "latency_ms", "server_proc_ms", "client_proc_ms", "sched_error_us", "net_latency_ms", "up_ms", "down_ms", "recv_ttl", "ecn", "path", "lost", "loss_dir", "late", "reordered", "duplicate", "jitter_rfc3550_ms"}
+
+// csvRequired are the columns every schema version has
+var csvRequired = []string{"seq", "sent_time", "recv_time", "latency_ms", "lost"}
+
+// csvAddedIn maps columns introduced after version 1 to their version
+var csvAddedIn = map[string]int{
+	"server_proc_ms":    2,
+	"net_latency_ms":    2,
+	"client_proc_ms":    3,
+	"recv_ttl":          4,
+	"path":              4,
+	"loss_dir":          5,
+	"up_ms":             6,
+	"down_ms":           6,
+	"reordered":         7,
+	"duplicate":         7,
+	"jitter_rfc3550_ms": 8,
+	"ecn":               9,
+	"sched_error_us":    10,
+}
+
+// csvLayout locates columns in a CSV of any schema version
+type csvLayout struct {
+	Version int
+	index   map[string]int
+}
+
+// parseCSVHeader maps a header row to column positions. Column order is
+// never assumed, so files with extra or reordered columns still parse. A
+// version of 0 means unknown, and is inferred from the columns present.
+func parseCSVHeader(header []string, version int) (*csvLayout, error) {
+	layout := &csvLayout{Version: version, index: make(map[string]int, len(header))}
+	for i, col := range header {
+		layout.index[strings.TrimSpace(col)] = i
+	}
+	for _, col := range csvRequired {
+		if !layout.Has(col) {
+			return nil, fmt.Errorf("CSV missing required column: %s", col)
+		}
+	}
+	if version > CSVSchemaVersion {
+		return nil, fmt.Errorf("CSV schema version %d is newer than this build supports (%d)", version, CSVSchemaVersion)
+	}
+
+	if layout.Version == 0 {
+		layout.Version = 1
+		for col, v := range csvAddedIn {
+			if layout.Has(col) {
+				layout.Version = max(layout.Version, v)
+			}
+		}
+	}
+	return layout, nil
+}
+
+// Has reports whether the file has a column
+func (l *csvLayout) Has(col string) bool {
+	_, ok := l.index[col]
+	return ok
+}
+
+// Get returns a column's value in a row, or "" if the column or cell is missing
+func (l *csvLayout) Get(row []string, col string) string {
+	i, ok := l.index[col]
+	if !ok || i >= len(row) {
+		return ""
+	}
+	return row[i]
+}
+
+// Complete reports whether a row has all required columns
+func (l *csvLayout) Complete(row []string) bool {
+	for _, col := range csvRequired {
+		if l.index[col] >= len(row) {
+			return false
+		}
+	}
+	return true
+}
+
+// NetIncludesClient reports whether net_latency_ms still contains client
+// processing time, as it did before client_proc_ms was split out
+func (l *csvLayout) NetIncludesClient() bool {
+	return l.Version < csvAddedIn["client_proc_ms"]
+}
+
+// LoadRecords reads a results CSV of any schema version back into packet
+// records for reanalysis. Columns a version doesn't have are left zero.
+func LoadRecords(csvFile string) ([]*PacketRecord, error) {
+	rows, err := readCSV(csvFile)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := loadMetadata(csvFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	version := 0
+	if meta != nil {
+		version = meta.CSVSchema
+	}
+	layout, err := parseCSVHeader(rows[0], version)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", csvFile, err)
+	}
+
+	msToNs := func(s string) int64 {
+		ms, _ := strconv.ParseInt(s, 10, 64)
+		return ms * int64(time.Millisecond)
+	}
+	float := func(row []string, col string) float64 {
+		v, _ := strconv.ParseFloat(layout.Get(row, col), 64)
+		return v
+	}
+
+	records := make([]*PacketRecord, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		if !layout.Complete(row) {
+			continue
+		}
+		seq, err := strconv.ParseUint(layout.Get(row, "seq"), 10, 64)
+		if err != nil {
+			continue
+		}
+		r := &PacketRecord{
+			SeqNum:       seq,
+			SentTime:     msToNs(layout.Get(row, "sent_time")),
+			RecvTime:     msToNs(layout.Get(row, "recv_time")),
+			LatencyMs:    float(row, "latency_ms"),
+			ServerProcMs: float(row, "server_proc_ms"),
+			ClientProcMs: float(row, "client_proc_ms"),
+			SchedErrorUs: float(row, "sched_error_us"),
+			NetLatencyMs: float(row, "net_latency_ms"),
+			Lost:         layout.Get(row, "lost") == "true",
+			LossDir:      layout.Get(row, "loss_dir"),
+			Late:         layout.Get(row, "late") == "true",
+			Reordered:    layout.Get(row, "reordered") == "true",
+			RFCJitterMs:  float(row, "jitter_rfc3550_ms"),
+		}
+		r.Duplicates, _ = strconv.Atoi(layout.Get(row, "duplicate"))
+		r.RecvTTL, _ = strconv.Atoi(layout.Get(row, "recv_ttl"))
+		r.ECN, _ = strconv.Atoi(layout.Get(row, "ecn"))
+		r.Path, _ = strconv.Atoi(layout.Get(row, "path"))
+		if layout.Get(row, "up_ms") != "" {
+			r.UpMs = float(row, "up_ms")
+			r.DownMs = float(row, "down_ms")
+			r.OneWay = true
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+)
+
+// digest summarizes a stream of non-negative samples in fixed-size
+// buckets instead of keeping every sample, so long high-rate runs don't
+// grow memory with their length. Buckets are log-linear as in HDR
+// Histogram: values are counted in nanoseconds, exactly below 256ns and
+// to within 1/128 above, so a percentile is off by under 0.8%. Min, max
+// and mean are exact.
+
+const (
+	digestSubBits = 8 // 256 linear buckets, then 128 per power of two
+	digestSub     = 1 << digestSubBits
+	digestHalf    = digestSub / 2
+)
+
+type digest struct {
+	counts   []uint64
+	n        uint64
+	sum      float64
+	min, max float64
+}
+
+// digestIndex returns the bucket of a value in nanoseconds
+func digestIndex(v uint64) int {
+	if v < digestSub {
+		return int(v)
+	}
+	shift := bits.Len64(v) - digestSubBits
+	return digestSub + (shift-1)*digestHalf + int(v>>shift) - digestHalf
+}
+
+// digestValue returns the midpoint of a bucket in nanoseconds
+func digestValue(i int) float64 {
+	if i < digestSub {
+		return float64(i)
+	}
+	shift := (i-digestSub)/digestHalf + 1
+	lower := uint64((i-digestSub)%digestHalf+digestHalf) << shift
+	return float64(lower) + float64(uint64(1)<<shift)/2
+}
+
+// Add records a sample in milliseconds
+func (d *digest) Add(ms float64) {
+	ns := max(ms*1e6, 0)
+	i := digestIndex(uint64(math.Min(ns, math.MaxInt64)))
+	if i >= len(d.counts) {
+		d.counts = append(d.counts, make([]uint64, i+1-len(d.counts))...)
+	}
+	d.counts[i]++
+	if d.n == 0 || ms < d.min {
+		d.min = ms
+	}
+	if d.n == 0 || ms > d.max {
+		d.max = ms
+	}
+	d.n++
+	d.sum += ms
+}
+
+// Count returns the number of samples
+func (d *digest) Count() uint64 {
+	return d.n
+}
+
+// Min, Max and Avg return exact statistics in milliseconds, 0 if empty
+func (d *digest) Min() float64 { return d.min }
+func (d *digest) Max() float64 { return d.max }
+func (d *digest) Avg() float64 {
+	if d.n == 0 {
+		return 0
+	}
+	return d.sum / float64(d.n)
+}
+
+// Percentile returns the p-th percentile in milliseconds, 0 if empty
+func (d *digest) Percentile(p float64) float64 {
+	if d.n == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return d.min
+	}
+	if p >= 100 {
+		return d.max
+	}
+	rank := uint64(math.Round(p / 100 * float64(d.n-1)))
+	var seen uint64
+	for i, c := range d.counts {
+		seen += c
+		if seen > rank {
+			return min(max(digestValue(i)/1e6, d.min), d.max)
+		}
+	}
+	return d.max
+}
+
+// MeanDeviation returns the mean absolute deviation from the average in
+// milliseconds, the "jitter" of the summary, from the bucket midpoints
+func (d *digest) MeanDeviation() float64 {
+	if d.n == 0 {
+		return 0
+	}
+	avg := d.Avg()
+	var sum float64
+	for i, c := range d.counts {
+		if c > 0 {
+			sum += math.Abs(digestValue(i)/1e6-avg) * float64(c)
+		}
+	}
+	return sum / float64(d.n)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDigestPercentiles(t *testing.T) {
+	var d digest
+	for i := 1; i <= 1000; i++ {
+		d.Add(float64(i)) // 1 to 1000 ms
+	}
+	tests := []struct {
+		p, want float64
+	}{
+		{0, 1},
+		{1, 11},
+		{50, 501},
+		{90, 900},
+		{99, 990},
+		{100, 1000},
+	}
+	for _, tt := range tests {
+		// Buckets are within 1/128 of the value
+		if got := d.Percentile(tt.p); math.Abs(got-tt.want) > tt.want/128 {
+			t.Errorf("p%g = %.3f, want %.3f within 1/128", tt.p, got, tt.want)
+		}
+	}
+	if d.Count() != 1000 || d.Min() != 1 || d.Max() != 1000 || d.Avg() != 500.5 {
+		t.Errorf("count %d, min %g, max %g, avg %g, want 1000, 1, 1000, 500.5", d.Count(), d.Min(), d.Max(), d.Avg())
+	}
+}
+
+func TestDigestSmallValues(t *testing.T) {
+	var d digest
+	for _, ms := range []float64{0.0001, 0.0001, 0.0002} { // 100 and 200 ns
+		d.Add(ms)
+	}
+	// Values under 256ns are counted exactly
+	if got := d.Percentile(50); got != 0.0001 {
+		t.Errorf("p50 = %g, want 0.0001", got)
+	}
+	if got := d.Percentile(99); got != 0.0002 {
+		t.Errorf("p99 = %g, want 0.0002", got)
+	}
+}
+
+func TestDigestEmpty(t *testing.T) {
+	var d digest
+	if d.Percentile(50) != 0 || d.Avg() != 0 || d.MeanDeviation() != 0 {
+		t.Error("an empty digest should report zeros")
+	}
+}
+
+func TestDigestIndexRoundTrip(t *testing.T) {
+	for _, ns := range []uint64{0, 1, 255, 256, 257, 1000, 123456, 1 << 40} {
+		v := digestValue(digestIndex(ns))
+		if math.Abs(v-float64(ns)) > float64(ns)/128+0.5 {
+			t.Errorf("bucket of %dns is centred at %.1f", ns, v)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Downlink mode has the server push a stream at the client instead of
+// echoing, so the download direction can be measured on its own. The
+// client sends TypeStreamRequest packets carrying the rate, size and
+// duration; the first starts the stream and the rest are keepalives. The
+// server stops a stream whose keepalives stop arriving. A stream sends far
+// more than its requests, and keepalives can be spoofed as easily as the
+// first request, so the server only streams to clients that prove they
+// have its --key, and to at most streamMaxActive of them at once.
+
+const (
+	streamKeepalive  = time.Second     // client request resend interval
+	streamStale      = 3 * time.Second // server stops a stream without keepalives this long
+	streamStartWait  = 5 * time.Second // client gives up if nothing arrives
+	streamDrain      = time.Second     // client waits this long past the stream's end
+	streamMaxRate    = 100000
+	streamMaxSeconds = 24 * 60 * 60
+	streamMaxActive  = 16 // server refuses new streams beyond this many
+)
+
+// Stream request payload layout (after the packet header); the packet size
+// travels in ReplySize
+const (
+	streamRateOffset     = 0 // packets per second, 0 = stop
+	streamDurationOffset = 4 // seconds
+	streamRequestSize    = 8
+)
+
+// DownlinkConfig configures a server-push test
+type DownlinkConfig struct {
+	Host       string
+	Port       int
+	Family     string // "IPv4" or "IPv6" to use only that family, "" = either
+	PacketSize int
+	Rate       int
+	Duration   int
+	OutputFile string
+	NoPlot     bool
+	Plot       PlotOptions
+	Key        string // shared secret the server trusts for streams, "" = none
+}
+
+func encodeStreamRequest(rate, seconds, size int, session Session) []byte {
+	buf := session.stamp(&Packet{Type: TypeStreamRequest, ReplySize: uint16(size)}).Encode(HeaderSize + streamRequestSize)
+	binary.BigEndian.PutUint32(buf[HeaderSize+streamRateOffset:], uint32(rate))
+	binary.BigEndian.PutUint32(buf[HeaderSize+streamDurationOffset:], uint32(seconds))
+	return buf
+}
+
+// RunDownlink requests a stream from the server and measures one-way loss,
+// delay variation and inter-arrival jitter on the way down
+func RunDownlink(ctx context.Context, cfg DownlinkConfig) error {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	conn, err := net.Dial(udpNetwork(cfg.Family), addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	fmt.Printf("Requesting a %d pps, %d byte downlink stream for %ds from %s\n\n",
+		cfg.Rate, cfg.PacketSize, cfg.Duration, addr)
+	meta := &RunMetadata{
+		RunID:     newRunID(),
+		Mode:      ModeDownlink,
+		Target:    addr,
+		StartTime: time.Now(),
+	}
+
+	// Keepalives run until the stream is over or the test is interrupted
+	keepCtx, stopKeep := context.WithCancel(ctx)
+	defer stopKeep()
+	session := newSession(meta.RunID, cfg.Key)
+	request := encodeStreamRequest(cfg.Rate, cfg.Duration, cfg.PacketSize, session)
+	go func() {
+		ticker := time.NewTicker(streamKeepalive)
+		defer ticker.Stop()
+		for {
+			conn.Write(request)
+			select {
+			case <-keepCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	defer conn.Write(encodeStreamRequest(0, 0, cfg.PacketSize, session))
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	// Arrival order is kept for jitter; records are keyed by sequence
+	type arrival struct {
+		seq          uint64
+		sent, recvNs int64
+	}
+	var arrivals []arrival
+	seen := make(map[uint64]bool)
+	var duplicates uint64
+	expected := uint64(cfg.Rate) * uint64(cfg.Duration)
+	deadline := time.Now().Add(streamStartWait)
+	buf := make([]byte, 65535)
+	lastPrint := time.Now()
+
+	for ctx.Err() == nil {
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil || time.Now().After(deadline) {
+				break
+			}
+			continue
+		}
+		recvNs := time.Now().UnixNano()
+		if err := versionError(buf[:n]); err != nil {
+			return err
+		}
+		pkt := DecodePacket(buf[:n])
+		if pkt == nil || pkt.Type != TypeStream {
+			continue
+		}
+		if len(arrivals) == 0 && duplicates == 0 {
+			// The stream's end is known once it starts
+			deadline = time.Now().Add(time.Duration(cfg.Duration)*time.Second + streamDrain)
+		} else if late := time.Now().Add(streamDrain); late.After(deadline) {
+			// A server running behind schedule sends past the nominal end
+			deadline = late
+		}
+		if seen[pkt.SeqNum] {
+			duplicates++
+			continue
+		}
+		seen[pkt.SeqNum] = true
+		arrivals = append(arrivals, arrival{pkt.SeqNum, pkt.Timestamp, recvNs})
+		if pkt.SeqNum >= expected && uint64(len(arrivals)) >= expected {
+			break
+		}
+		if time.Since(lastPrint) >= 5*time.Second {
+			lastPrint = time.Now()
+			fmt.Printf("[%ds] %d packets received\n", int(time.Since(meta.StartTime).Seconds()), len(arrivals))
+		}
+	}
+	stopKeep()
+	if ctx.Err() != nil {
+		fmt.Println("\nTest interrupted")
+		expected = 0
+		for seq := range seen {
+			expected = max(expected, seq)
+		}
+	}
+	if len(arrivals) == 0 {
+		return fmt.Errorf("no stream received from %s (is the server running this version, with the same --key?)", addr)
+	}
+
+	// Relative one-way delay: clocks aren't synchronized, so delay is
+	// reported above the smallest seen, which is the queueing delay
+	minOWD := int64(math.MaxInt64)
+	for _, a := range arrivals {
+		minOWD = min(minOWD, a.recvNs-a.sent)
+	}
+	records := make([]*PacketRecord, 0, expected)
+	bySeq := make(map[uint64]*PacketRecord, len(arrivals))
+	var jitter float64
+	var gaps []float64
+	reordered := 0
+	for i, a := range arrivals {
+		r := &PacketRecord{
+			SeqNum:       a.seq,
+			SentTime:     a.sent,
+			RecvTime:     a.recvNs,
+			LatencyMs:    float64(a.recvNs-a.sent-minOWD) / 1e6,
+			NetLatencyMs: float64(a.recvNs-a.sent-minOWD) / 1e6,
+		}
+		bySeq[a.seq] = r
+		if i > 0 {
+			prev := arrivals[i-1]
+			// RFC 3550 interarrival jitter on the transit time difference
+			d := float64((a.recvNs-a.sent)-(prev.recvNs-prev.sent)) / 1e6
+			jitter += (math.Abs(d) - jitter) / 16
+			gaps = append(gaps, float64(a.recvNs-prev.recvNs)/1e6)
+			if a.seq < prev.seq {
+				r.Reordered = true
+				reordered++
+			}
+		}
+	}
+	for seq := uint64(1); seq <= expected; seq++ {
+		if r, ok := bySeq[seq]; ok {
+			records = append(records, r)
+		} else {
+			records = append(records, &PacketRecord{SeqNum: seq, Lost: true, LossDir: LossDown})
+		}
+	}
+
+	received := uint64(len(arrivals))
+	lost := expected - min(received, expected)
+	lossPercent := 0.0
+	if expected > 0 {
+		lossPercent = float64(lost) / float64(expected) * 100
+	}
+	delays := make([]float64, 0, len(arrivals))
+	for _, a := range arrivals {
+		delays = append(delays, float64(a.recvNs-a.sent-minOWD)/1e6)
+	}
+	sort.Float64s(delays)
+	sort.Float64s(gaps)
+	nominal := 1000 / float64(cfg.Rate)
+
+	fmt.Println("\n--- Downlink ---")
+	fmt.Printf("Packets: %d sent, %d received, %d lost (%.2f%%), %d duplicated, %d reordered\n",
+		expected, received, lost, lossPercent, duplicates, reordered)
+	fmt.Printf("One-way delay above minimum: p50=%.2fms p90=%.2fms p99=%.2fms max=%.2fms\n",
+		percentile(delays, 50), percentile(delays, 90), percentile(delays, 99), percentile(delays, 100))
+	fmt.Printf("Interarrival jitter (RFC 3550): %.2fms\n", jitter)
+	fmt.Printf("Inter-arrival gap: p50=%.2fms p99=%.2fms max=%.2fms (nominal %.2fms)\n",
+		percentile(gaps, 50), percentile(gaps, 99), percentile(gaps, 100), nominal)
+
+	meta.Summary = &RunSummary{
+		Sent:        expected,
+		Received:    received,
+		LossPercent: lossPercent,
+		AvgRTTMs:    avg(delays),
+		P99RTTMs:    percentile(delays, 99),
+		JitterMs:    jitter,
+	}
+	meta.TargetRate = cfg.Rate
+	meta.CSVSchema = CSVSchemaVersion
+
+	outputFile := cfg.OutputFile
+	if outputFile == "" {
+		outputFile = fmt.Sprintf("packet-test-downlink_%s.csv", time.Now().Format("2006-01-02_15-04-05"))
+	}
+	if err := writeRecordsCSV(outputFile, records); err != nil {
+		return fmt.Errorf("failed to save CSV: %w", err)
+	}
+	if err := saveMetadata(outputFile, meta); err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+	fmt.Printf("\nResults saved to %s\n", outputFile)
+
+	if !cfg.NoPlot {
+		if err := GeneratePlot(outputFile, cfg.Plot); err != nil {
+			return fmt.Errorf("failed to generate plot: %w", err)
+		}
+		openBrowser(strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) + ".html")
+	}
+	return nil
+}
+
+// streamer runs the server's downlink streams, one per client address
+type streamer struct {
+	mu      sync.Mutex
+	streams map[string]*stream
+	full    bool // a request was refused since a stream last ended
+}
+
+type stream struct {
+	lastSeen time.Time
+	cancel   context.CancelFunc
+}
+
+// handle starts, refreshes or stops the stream a request asks for
+func (s *streamer) handle(ctx context.Context, conn net.PacketConn, pkt *Packet, addr net.Addr) {
+	if len(pkt.Payload) < streamRequestSize {
+		return
+	}
+	rate := int(binary.BigEndian.Uint32(pkt.Payload[streamRateOffset:]))
+	seconds := int(binary.BigEndian.Uint32(pkt.Payload[streamDurationOffset:]))
+	size := max(HeaderSize, min(int(pkt.ReplySize), MaxPacketSize))
+	key := addr.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.streams == nil {
+		s.streams = make(map[string]*stream)
+	}
+	if st, ok := s.streams[key]; ok {
+		if rate == 0 {
+			st.cancel()
+		} else {
+			st.lastSeen = time.Now()
+		}
+		return
+	}
+	if rate <= 0 || rate > streamMaxRate || seconds <= 0 || seconds > streamMaxSeconds {
+		return
+	}
+	if len(s.streams) >= streamMaxActive {
+		if !s.full {
+			s.full = true
+			fmt.Printf("Stream to %s refused: %d streams already running\n", key, streamMaxActive)
+		}
+		return
+	}
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	st := &stream{lastSeen: time.Now(), cancel: cancel}
+	s.streams[key] = st
+	fmt.Printf("Streaming %d pps, %d byte packets to %s for %ds\n", rate, size, key, seconds)
+
+	go func() {
+		defer func() {
+			cancel()
+			s.mu.Lock()
+			delete(s.streams, key)
+			s.full = false
+			s.mu.Unlock()
+		}()
+		// Every packet is sent even if the server falls behind, so a
+		// missing sequence number always means loss on the way down
+		interval := time.Second / time.Duration(rate)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		pace := newPacer(interval, true)
+		data := make([]byte, size)
+		total := uint64(rate) * uint64(seconds)
+		for seq := uint64(1); seq <= total; {
+			var tick time.Time
+			select {
+			case <-streamCtx.Done():
+				return
+			case tick = <-ticker.C:
+			}
+			s.mu.Lock()
+			stale := time.Since(st.lastSeen) > streamStale
+			s.mu.Unlock()
+			if stale {
+				fmt.Printf("Stream to %s stopped: no keepalive for %s\n", key, streamStale)
+				return
+			}
+			for range pace.due(tick) {
+				if seq > total {
+					break
+				}
+				pkt := &Packet{SeqNum: seq, Timestamp: time.Now().UnixNano(), Type: TypeStream}
+				copy(data, pkt.Encode(HeaderSize))
+				if _, err := conn.WriteTo(data, addr); err != nil {
+					fmt.Printf("Write error to %s: %v\n", key, err)
+				}
+				seq++
+			}
+		}
+		fmt.Printf("Stream to %s finished: %d packets\n", key, total)
+	}()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dscpNames are the standard per-hop behaviour code points (RFC 2474,
+// 2597, 3246, 5865)
+var dscpNames = map[string]int{
+	"BE": 0, "CS0": 0, "CS1": 8, "CS2": 16, "CS3": 24, "CS4": 32, "CS5": 40, "CS6": 48, "CS7": 56,
+	"AF11": 10, "AF12": 12, "AF13": 14,
+	"AF21": 18, "AF22": 20, "AF23": 22,
+	"AF31": 26, "AF32": 28, "AF33": 30,
+	"AF41": 34, "AF42": 36, "AF43": 38,
+	"VOICE-ADMIT": 44, "EF": 46,
+	"LE": 1,
+}
+
+// ECN codepoints, the low two bits of the IPv4 TOS byte or IPv6 traffic
+// class (RFC 3168)
+const (
+	ecnNotECT = 0
+	ecnECT1   = 1
+	ecnECT0   = 2
+	ecnCE     = 3 // congestion experienced: an AQM marked the packet instead of dropping it
+)
+
+// ParseDSCP parses a DSCP given by name (EF, AF41, CS5, ...) or as a
+// number from 0 to 63
+func ParseDSCP(s string) (int, error) {
+	if v, ok := dscpNames[strings.ToUpper(strings.TrimSpace(s))]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || v < 0 || v > 63 {
+		return 0, fmt.Errorf("invalid DSCP %q, expected a name like EF or AF41 or a number from 0 to 63", s)
+	}
+	return v, nil
+}
+
+// dscpName formats a DSCP with its name if it has one, e.g. "EF (46)"
+func dscpName(v int) string {
+	best := ""
+	for name, code := range dscpNames {
+		// CS0 and BE share 0; prefer the shorter, then alphabetical
+		if code == v && (best == "" || len(name) < len(best) || len(name) == len(best) && name < best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return strconv.Itoa(v)
+	}
+	return fmt.Sprintf("%s (%d)", best, v)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Environment describes the client machine and the interface the test
+// ran over, so results from different machines or months can be told
+// apart. Details the platform doesn't expose are left empty.
+type Environment struct {
+	OS        string            `json:"os"`
+	Kernel    string            `json:"kernel,omitempty"`
+	Arch      string            `json:"arch"`
+	Hostname  string            `json:"hostname,omitempty"`
+	Interface string            `json:"interface,omitempty"`
+	Link      string            `json:"link,omitempty"` // "wifi", "wired", "loopback" or "" if unknown
+	MTU       int               `json:"mtu,omitempty"`
+	Driver    string            `json:"driver,omitempty"`
+	Device    string            `json:"device,omitempty"` // bus IDs, e.g. "pci 8086:15f3"
+	Sysctls   map[string]string `json:"sysctls,omitempty"`
+}
+
+// Sysctls that shape UDP buffering and competing traffic
+var envSysctls = []string{
+	"net.core.rmem_default",
+	"net.core.rmem_max",
+	"net.core.wmem_default",
+	"net.core.wmem_max",
+	"net.core.netdev_max_backlog",
+	"net.core.default_qdisc",
+	"net.ipv4.udp_mem",
+	"net.ipv4.tcp_congestion_control",
+}
+
+// String formats the environment for console output and reports, e.g.
+// "Debian GNU/Linux 12, kernel 6.1.0, amd64; eth0 wired, MTU 1500, driver e1000e"
+func (e *Environment) String() string {
+	s := e.OS
+	if e.Kernel != "" {
+		s += ", kernel " + e.Kernel
+	}
+	s += ", " + e.Arch
+	if e.Interface != "" {
+		s += "; " + e.Interface
+		if e.Link != "" {
+			s += " " + e.Link
+		}
+		if e.MTU > 0 {
+			s += fmt.Sprintf(", MTU %d", e.MTU)
+		}
+		if e.Driver != "" {
+			s += ", driver " + e.Driver
+		}
+	}
+	return s
+}
+
+// CaptureEnvironment collects the environment of a test sending from
+// localIP
+func CaptureEnvironment(localIP net.IP) *Environment {
+	env := &Environment{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	env.Hostname, _ = os.Hostname()
+
+	if ifi := interfaceFor(localIP); ifi != nil {
+		env.Interface = ifi.Name
+		env.MTU = ifi.MTU
+		if ifi.Flags&net.FlagLoopback != 0 {
+			env.Link = "loopback"
+		}
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		captureLinux(env)
+	case "darwin":
+		if out, err := exec.Command("sw_vers", "-productVersion").Output(); err == nil {
+			env.OS = "macOS " + strings.TrimSpace(string(out))
+		}
+		if out, err := exec.Command("uname", "-r").Output(); err == nil {
+			env.Kernel = strings.TrimSpace(string(out))
+		}
+	}
+	return env
+}
+
+// interfaceFor returns the interface that owns ip, or nil
+func interfaceFor(ip net.IP) *net.Interface {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for i := range ifaces {
+		addrs, err := ifaces[i].Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return &ifaces[i]
+			}
+		}
+	}
+	return nil
+}
+
+func captureLinux(env *Environment) {
+	readTrimmed := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(data))
+	}
+
+	if data, err := os.ReadFile("/etc/os-release"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if v, ok := strings.CutPrefix(line, "PRETTY_NAME="); ok {
+				env.OS = strings.Trim(v, `"`)
+			}
+		}
+	}
+	env.Kernel = readTrimmed("/proc/sys/kernel/osrelease")
+
+	if env.Interface != "" && env.Link != "loopback" {
+		dev := filepath.Join("/sys/class/net", env.Interface)
+		if _, err := os.Stat(filepath.Join(dev, "wireless")); err == nil {
+			env.Link = "wifi"
+		} else if _, err := os.Stat(filepath.Join(dev, "device")); err == nil {
+			env.Link = "wired"
+		}
+		if driver, err := os.Readlink(filepath.Join(dev, "device", "driver")); err == nil {
+			env.Driver = filepath.Base(driver)
+		}
+		vendor := readTrimmed(filepath.Join(dev, "device", "vendor"))
+		device := readTrimmed(filepath.Join(dev, "device", "device"))
+		if vendor != "" && device != "" {
+			subsystem, _ := os.Readlink(filepath.Join(dev, "device", "subsystem"))
+			env.Device = strings.TrimSpace(filepath.Base(subsystem) + " " +
+				strings.TrimPrefix(vendor, "0x") + ":" + strings.TrimPrefix(device, "0x"))
+		}
+	}
+
+	env.Sysctls = make(map[string]string)
+	for _, name := range envSysctls {
+		path := "/proc/sys/" + strings.ReplaceAll(name, ".", "/")
+		if v := readTrimmed(path); v != "" {
+			env.Sysctls[name] = strings.Join(strings.Fields(v), " ")
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Roam detection thresholds. An AP handover shows up as a short blackout
+// followed by a shift in base latency, since the new AP sits on a
+// different radio link (and often a different switch port).
+const (
+	roamMinLostPackets = 3    // consecutive losses that make a blackout
+	roamMinGapMs       = 50.0 // shortest blackout worth calling a roam
+	roamMaxGapMs       = 5000 // longer outages are disconnects, not roams
+	roamWindow         = 50   // answered packets compared on each side
+	roamMinStepMs      = 3.0  // smallest latency step that counts
+	roamMinStepRatio   = 0.2  // or this fraction of the latency before
+)
+
+// Event kinds
+const (
+	EventRoam      = "roam"
+	EventRebind    = "rebind"     // local address changed and the socket was rebound
+	EventTTL       = "ttl"        // reply TTL changed, so the return path did
+	EventClockStep = "clock_step" // the client's wall clock was stepped, see monoclock.go
+)
+
+// RunEvent is a notable disruption during the run, kept in the metadata so
+// reports can annotate it
+type RunEvent struct {
+	Kind     string    `json:"kind"`
+	Time     time.Time `json:"time"`
+	StartSeq uint64    `json:"start_seq"`
+	EndSeq   uint64    `json:"end_seq"`
+	Lost     int       `json:"lost"`
+	GapMs    float64   `json:"gap_ms"`
+	StepMs   float64   `json:"step_ms,omitempty"` // median RTT after minus before
+	Detail   string    `json:"detail,omitempty"`
+}
+
+// String formats the event for console output and reports,
+// e.g. "roam at 12:00:05: 40 lost over 620ms, latency +8.2ms"
+func (e RunEvent) String() string {
+	if e.Kind == EventRoam {
+		return fmt.Sprintf("%s at %s: %d lost over %.0fms, latency %+.1fms",
+			e.Kind, e.Time.Format("15:04:05"), e.Lost, e.GapMs, e.StepMs)
+	}
+	return fmt.Sprintf("%s at %s (seq %d): %s", e.Kind, e.Time.Format("15:04:05"), e.StartSeq, e.Detail)
+}
+
+// DetectRoams finds WiFi roaming signatures in the trace: a run of
+// consecutive losses bracketed by a step in median RTT. Loss bursts with
+// the same latency on both sides are congestion or interference and are
+// left alone.
+func DetectRoams(records []*PacketRecord) []RunEvent {
+	sorted := sortBySeq(records)
+	answered := func(r *PacketRecord) bool { return !r.Lost && r.RecvTime > 0 }
+
+	var events []RunEvent
+	for i := 0; i < len(sorted); {
+		if answered(sorted[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(sorted) && !answered(sorted[i]) {
+			i++
+		}
+		// A burst needs answered packets on both sides to measure the step
+		if start == 0 || i == len(sorted) || i-start < roamMinLostPackets {
+			continue
+		}
+
+		first, last := sorted[start], sorted[i-1]
+		gapMs := float64(sorted[i].SentTime-sorted[start-1].SentTime) / 1e6
+		if gapMs < roamMinGapMs || gapMs > roamMaxGapMs {
+			continue
+		}
+
+		before := medianLatency(sorted[max(start-roamWindow, 0):start])
+		after := medianLatency(sorted[i:min(i+roamWindow, len(sorted))])
+		step := after - before
+		if math.Abs(step) < math.Max(roamMinStepMs, before*roamMinStepRatio) {
+			continue
+		}
+
+		events = append(events, RunEvent{
+			Kind:     EventRoam,
+			Time:     time.Unix(0, first.SentTime),
+			StartSeq: first.SeqNum,
+			EndSeq:   last.SeqNum,
+			Lost:     i - start,
+			GapMs:    gapMs,
+			StepMs:   step,
+		})
+	}
+	return events
+}
+
+// medianLatency returns the median RTT of the answered records
+func medianLatency(records []*PacketRecord) float64 {
+	var lats []float64
+	for _, r := range records {
+		if !r.Lost && r.RecvTime > 0 {
+			lats = append(lats, r.LatencyMs)
+		}
+	}
+	sort.Float64s(lats)
+	return percentile(lats, 50)
+}
+
+// PrintRoams prints the detected roaming events, if any
+func PrintRoams(events []RunEvent) {
+	if len(events) == 0 {
+		return
+	}
+	lost := 0
+	for _, e := range events {
+		lost += e.Lost
+	}
+
+	fmt.Println("\n--- Roaming ---")
+	fmt.Printf("Roamed %d times, losing %.0f packets per roam on average\n",
+		len(events), float64(lost)/float64(len(events)))
+	for _, e := range events {
+		fmt.Println(e)
+	}
+}
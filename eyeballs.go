@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	eyeballsHeadStart = 50 * time.Millisecond // IPv6 goes first, as in RFC 8305
+	eyeballsInterval  = 200 * time.Millisecond
+	eyeballsTimeout   = 1500 * time.Millisecond
+)
+
+// AddressChoice records which of the target's addresses the test used
+type AddressChoice struct {
+	Addr     string `json:"addr"`
+	Family   string `json:"family"`
+	Answered bool   `json:"answered"`           // the server answered the hello
+	Fallback bool   `json:"fallback,omitempty"` // the preferred family didn't answer
+	Tried    string `json:"tried,omitempty"`    // addresses raced, if more than one
+}
+
+// String formats the choice for console output and reports,
+// e.g. "192.0.2.7:9999 (IPv4, preferred family didn't answer)"
+func (a *AddressChoice) String() string {
+	s := fmt.Sprintf("%s (%s", a.Addr, a.Family)
+	if a.Fallback {
+		s += ", preferred family didn't answer"
+	}
+	if a.Tried != "" && !a.Answered {
+		s += ", no hello reply"
+	}
+	return s + ")"
+}
+
+// SelectAddress resolves host and, when it has both IPv4 and IPv6
+// addresses, races a hello to one of each in happy-eyeballs style and picks
+// whichever the server answers first. IPv6 gets a short head start. If
+// neither answers the preferred address is used anyway, which is what a
+// plain dial would have done. A family of "IPv4" or "IPv6" only considers
+// addresses of that family.
+func SelectAddress(ctx context.Context, host string, port int, family string) (*AddressChoice, error) {
+	resolved, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	var ips []net.IPAddr
+	for _, ip := range resolved {
+		if family == "" || ipFamily(ip.IP) == family {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		if family != "" {
+			return nil, fmt.Errorf("no %s addresses for %s", family, host)
+		}
+		return nil, fmt.Errorf("no addresses for %s", host)
+	}
+
+	var v4, v6 *net.IPAddr
+	for i, ip := range ips {
+		if ip.IP.To4() != nil {
+			if v4 == nil {
+				v4 = &ips[i]
+			}
+		} else if v6 == nil {
+			v6 = &ips[i]
+		}
+	}
+
+	// IPAddr keeps the zone of link-local IPv6 addresses
+	choice := func(ip *net.IPAddr) *AddressChoice {
+		return &AddressChoice{
+			Addr:   net.JoinHostPort(ip.String(), strconv.Itoa(port)),
+			Family: ipFamily(ip.IP),
+		}
+	}
+	if v4 == nil || v6 == nil {
+		return choice(&ips[0]), nil
+	}
+
+	preferred, other := choice(v6), choice(v4)
+	tried := preferred.Addr + ", " + other.Addr
+	raceCtx, cancel := context.WithTimeout(ctx, eyeballsTimeout)
+	defer cancel()
+
+	answers := make(chan *AddressChoice, 2)
+	go func() {
+		if sendHello(raceCtx, preferred.Addr) {
+			answers <- preferred
+		}
+	}()
+	go func() {
+		select {
+		case <-time.After(eyeballsHeadStart):
+		case <-raceCtx.Done():
+			return
+		}
+		if sendHello(raceCtx, other.Addr) {
+			answers <- other
+		}
+	}()
+
+	select {
+	case winner := <-answers:
+		winner.Answered = true
+		winner.Fallback = winner == other
+		winner.Tried = tried
+		return winner, nil
+	case <-raceCtx.Done():
+		preferred.Tried = tried
+		return preferred, nil
+	}
+}
+
+// sendHello sends hello packets to addr until one is echoed or ctx is done
+func sendHello(ctx context.Context, addr string) bool {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	hello := (&Packet{Type: TypeHello}).Encode(HeaderSize)
+	buf := make([]byte, 65535)
+	for ctx.Err() == nil {
+		if _, err := conn.Write(hello); err != nil {
+			return false
+		}
+		conn.SetReadDeadline(time.Now().Add(eyeballsInterval))
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				break // resend on timeout
+			}
+			if pkt := DecodePacket(buf[:n]); pkt != nil && pkt.Type == TypeHello {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// udpNetwork returns the network to dial or listen on for a family,
+// "udp" (either, or both when listening) if it is ""
+func udpNetwork(family string) string {
+	switch family {
+	case "IPv4":
+		return "udp4"
+	case "IPv6":
+		return "udp6"
+	}
+	return "udp"
+}
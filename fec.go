@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// FECScheme is a block code with K data and N parity packets per block
+type FECScheme struct {
+	K, N int
+}
+
+func (f FECScheme) String() string {
+	return fmt.Sprintf("%d+%d", f.K, f.N)
+}
+
+// ParseFECSchemes parses a comma-separated list like "4:1,8:2"
+func ParseFECSchemes(s string) ([]FECScheme, error) {
+	var schemes []FECScheme
+	for _, part := range strings.Split(s, ",") {
+		k, n, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid FEC scheme %q, expected k:n", part)
+		}
+		kVal, errK := strconv.Atoi(k)
+		nVal, errN := strconv.Atoi(n)
+		if errK != nil || errN != nil || kVal < 1 || nVal < 1 {
+			return nil, fmt.Errorf("invalid FEC scheme %q, k and n must be positive integers", part)
+		}
+		schemes = append(schemes, FECScheme{K: kVal, N: nVal})
+	}
+	return schemes, nil
+}
+
+// FECResult is the outcome of replaying the loss trace through a scheme
+type FECResult struct {
+	Scheme       FECScheme
+	DataPackets  int
+	DataLost     int // data packets still missing after recovery
+	Blocks       int
+	FailedBlocks int
+}
+
+// SimulateFEC replays the recorded loss pattern as if the packet stream
+// had been sent as FEC blocks: every K+N consecutive packets form a block
+// whose first K carry data and last N carry parity. A block recovers fully
+// when at most N of its packets were lost, so bursty loss is penalized
+// exactly as it would be on the wire.
+func SimulateFEC(records []*PacketRecord, scheme FECScheme) FECResult {
+	sorted := sortBySeq(records)
+	res := FECResult{Scheme: scheme}
+	blockSize := scheme.K + scheme.N
+
+	for start := 0; start+blockSize <= len(sorted); start += blockSize {
+		block := sorted[start : start+blockSize]
+		lost, dataLost := 0, 0
+		for i, r := range block {
+			if r.Lost {
+				lost++
+				if i < scheme.K {
+					dataLost++
+				}
+			}
+		}
+
+		res.Blocks++
+		res.DataPackets += scheme.K
+		if lost > scheme.N {
+			res.FailedBlocks++
+			res.DataLost += dataLost
+		}
+	}
+	return res
+}
+
+// PrintFEC prints residual loss for each scheme next to the raw loss
+func PrintFEC(records []*PacketRecord, schemes []FECScheme) {
+	lost := 0
+	for _, r := range records {
+		if r.Lost {
+			lost++
+		}
+	}
+	rawLoss := float64(0)
+	if len(records) > 0 {
+		rawLoss = float64(lost) / float64(len(records)) * 100
+	}
+
+	fmt.Printf("\n--- FEC Simulation (raw loss %.2f%%) ---\n", rawLoss)
+	for _, scheme := range schemes {
+		res := SimulateFEC(records, scheme)
+		residual := float64(0)
+		if res.DataPackets > 0 {
+			residual = float64(res.DataLost) / float64(res.DataPackets) * 100
+		}
+		overhead := float64(scheme.N) / float64(scheme.K) * 100
+		fmt.Printf("FEC %s: residual loss %.2f%%, %d of %d blocks unrecoverable, %.0f%% overhead\n",
+			scheme, residual, res.FailedBlocks, res.Blocks, overhead)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+)
+
+// GameResult summarizes probes treated as game ticks
+type GameResult struct {
+	TickRate   int
+	DeadlineMs float64
+	Frames     int
+	Dropped    int // lost, or replied after the tick deadline
+	Stutters   int // runs of two or more consecutive dropped frames
+	MaxRun     int
+}
+
+// SimulateGameTicks treats each probe as one game tick and counts the
+// frames whose reply missed its deadline. Base ping is absorbed by client
+// prediction, so the deadline is one tick past the fastest observed RTT:
+// anything later would be displayed a frame late.
+func SimulateGameTicks(records []*PacketRecord, tickRate int) GameResult {
+	sorted := sortBySeq(records)
+
+	interval := 1000 / float64(tickRate)
+	minLat := minLatency(sorted)
+
+	res := GameResult{TickRate: tickRate, Frames: len(sorted)}
+	res.DeadlineMs = minLat + interval
+	gaps := &gapTracker{interval: interval}
+	for _, r := range sorted {
+		if r.Lost || r.RecvTime == 0 || r.LatencyMs > res.DeadlineMs {
+			res.Dropped++
+			gaps.miss()
+		} else {
+			gaps.hit()
+		}
+	}
+	gaps.hit()
+
+	for _, run := range gaps.runs {
+		if run >= 2 {
+			res.Stutters++
+		}
+		res.MaxRun = max(res.MaxRun, run)
+	}
+	return res
+}
+
+// PrintGameTicks prints the tick-deadline view of the run
+func PrintGameTicks(records []*PacketRecord, tickRate int) {
+	res := SimulateGameTicks(records, tickRate)
+
+	frameLoss := float64(0)
+	if res.Frames > 0 {
+		frameLoss = float64(res.Dropped) / float64(res.Frames) * 100
+	}
+	interval := 1000 / float64(tickRate)
+
+	fmt.Printf("\n--- Game Ticks (%d Hz) ---\n", res.TickRate)
+	fmt.Printf("Frames: %d, dropped %d (%.2f%%) with deadline %.1fms\n",
+		res.Frames, res.Dropped, frameLoss, res.DeadlineMs)
+	fmt.Printf("Stutters: %d runs of 2+ dropped frames, longest %d frames (%.0fms)\n",
+		res.Stutters, res.MaxRun, float64(res.MaxRun)*interval)
+}
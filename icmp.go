@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ICMP comparison runs the system ping next to the UDP test, so a path
+// that treats UDP differently (policing, shaping, separate queues) stands
+// out against one that is simply slow or lossy for everything.
+
+// icmpInterval is the ping interval, the shortest unprivileged ping allows
+// on most systems. Windows ping is fixed at one per second.
+const icmpInterval = 200 * time.Millisecond
+
+var (
+	pingReply    = regexp.MustCompile(`(?:icmp_seq=(\d+).*)?time[=<]\s*([\d.]+)\s*ms`)
+	pingTimeout  = regexp.MustCompile(`(?i)timed out|unreachable`)
+	pingSentLine = regexp.MustCompile(`(\d+) packets transmitted`)
+)
+
+// ICMPSample is one ping
+type ICMPSample struct {
+	Seq       uint64
+	SentTime  int64 // Unix nanoseconds, derived from the reply time less the RTT
+	LatencyMs float64
+	Lost      bool
+}
+
+// Pinger runs the system ping against a host until stopped
+type Pinger struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+
+	mu      sync.Mutex
+	samples []ICMPSample
+	sent    uint64 // from ping's closing statistics, 0 if it printed none
+}
+
+// StartPing starts pinging host (an IP address) in the background
+func StartPing(ctx context.Context, host string, ipv6 bool) (*Pinger, error) {
+	var cmd *exec.Cmd
+	interval := strconv.FormatFloat(icmpInterval.Seconds(), 'f', -1, 64)
+	switch {
+	case runtime.GOOS == "windows":
+		cmd = exec.CommandContext(ctx, "ping", "-t", host)
+	case runtime.GOOS == "darwin" && ipv6:
+		cmd = exec.CommandContext(ctx, "ping6", "-n", "-i", interval, host)
+	default:
+		cmd = exec.CommandContext(ctx, "ping", "-n", "-i", interval, host)
+	}
+	// Interrupt rather than kill, so ping prints how many it sent
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = time.Second
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ping: %w", err)
+	}
+
+	p := &Pinger{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			p.parseLine(scanner.Text(), time.Now())
+		}
+		cmd.Wait()
+	}()
+	return p, nil
+}
+
+// parseLine records a reply, timeout or closing statistics line. Lines
+// are read as ping prints them, so the arrival time stands in for the
+// reply's receive time.
+func (p *Pinger) parseLine(line string, at time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	next := uint64(1)
+	if len(p.samples) > 0 {
+		next = p.samples[len(p.samples)-1].Seq + 1
+	}
+	if m := pingSentLine.FindStringSubmatch(line); m != nil {
+		p.sent, _ = strconv.ParseUint(m[1], 10, 64)
+		return
+	}
+	if pingTimeout.MatchString(line) {
+		p.samples = append(p.samples, ICMPSample{Seq: next, SentTime: at.UnixNano(), Lost: true})
+		return
+	}
+	m := pingReply.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	rtt, err := strconv.ParseFloat(m[2], 64)
+	if err != nil {
+		return
+	}
+	seq := next
+	if m[1] != "" {
+		n, _ := strconv.ParseUint(m[1], 10, 64)
+		seq = n + 1 - min(pingFirstSeq(), n)
+	}
+	if seq < next {
+		return // duplicate or late reply
+	}
+	sent := at.UnixNano() - int64(rtt*float64(time.Millisecond))
+
+	// Gaps in the sequence are pings that got no reply
+	for missing := next; missing < seq; missing++ {
+		back := int64(seq-missing) * icmpInterval.Nanoseconds()
+		p.samples = append(p.samples, ICMPSample{Seq: missing, SentTime: sent - back, Lost: true})
+	}
+	p.samples = append(p.samples, ICMPSample{Seq: seq, SentTime: sent, LatencyMs: rtt})
+}
+
+// pingFirstSeq is the icmp_seq of the first ping: iputils counts from 1,
+// BSD ping from 0
+func pingFirstSeq() uint64 {
+	if runtime.GOOS == "linux" {
+		return 1
+	}
+	return 0
+}
+
+// Stop ends the ping and returns the samples, with pings sent after the
+// last reply counted as lost
+func (p *Pinger) Stop() []ICMPSample {
+	if p.cmd.Process != nil {
+		p.cmd.Cancel()
+	}
+	<-p.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	last := ICMPSample{SentTime: time.Now().UnixNano()}
+	if len(p.samples) > 0 {
+		last = p.samples[len(p.samples)-1]
+	}
+	for seq := last.Seq + 1; seq <= p.sent; seq++ {
+		p.samples = append(p.samples, ICMPSample{
+			Seq:      seq,
+			SentTime: last.SentTime + int64(seq-last.Seq)*icmpInterval.Nanoseconds(),
+			Lost:     true,
+		})
+	}
+	return p.samples
+}
+
+// ICMPSummary condenses the pings into the same headline numbers as a run
+func ICMPSummary(samples []ICMPSample) *RunSummary {
+	sum := &RunSummary{Sent: uint64(len(samples))}
+	var lats []float64
+	for _, s := range samples {
+		if !s.Lost {
+			lats = append(lats, s.LatencyMs)
+		}
+	}
+	sum.Received = uint64(len(lats))
+	if sum.Sent > 0 {
+		sum.LossPercent = float64(sum.Sent-sum.Received) / float64(sum.Sent) * 100
+	}
+	if len(lats) > 0 {
+		_, sum.AvgRTTMs, _, sum.JitterMs = calcStats(lats)
+		_, _, sum.P99RTTMs = percentiles(lats, 50, 90, 99)
+	}
+	return sum
+}
+
+// icmpFile returns the ping series path that belongs to a CSV file
+func icmpFile(csvFile string) string {
+	return strings.TrimSuffix(csvFile, ".csv") + ".icmp.csv"
+}
+
+// saveICMPCSV writes the ping series next to the run's CSV, in the
+// columns of the first CSV schema so it reads like any other trace
+func saveICMPCSV(csvFile string, samples []ICMPSample) error {
+	file, err := os.Create(icmpFile(csvFile))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+	writer.Write([]string{"seq", "sent_time", "recv_time", "latency_ms", "lost"})
+	for _, s := range samples {
+		recv := int64(0)
+		if !s.Lost {
+			recv = s.SentTime + int64(s.LatencyMs*float64(time.Millisecond))
+		}
+		writer.Write([]string{
+			strconv.FormatUint(s.Seq, 10),
+			strconv.FormatInt(s.SentTime/1000000, 10),
+			strconv.FormatInt(recv/1000000, 10),
+			fmt.Sprintf("%.2f", s.LatencyMs),
+			strconv.FormatBool(s.Lost),
+		})
+	}
+	return writer.Error()
+}
+
+// PrintICMPCompare prints UDP against ICMP and what the difference suggests
+func PrintICMPCompare(udp, icmp *RunSummary) {
+	fmt.Println("\n--- UDP vs ICMP ---")
+	fmt.Printf("UDP:  %d sent, %.2f%% loss, avg %.2fms, p99 %.2fms\n", udp.Sent, udp.LossPercent, udp.AvgRTTMs, udp.P99RTTMs)
+	fmt.Printf("ICMP: %d sent, %.2f%% loss, avg %.2fms, p99 %.2fms\n", icmp.Sent, icmp.LossPercent, icmp.AvgRTTMs, icmp.P99RTTMs)
+	if icmp.Received == 0 {
+		fmt.Println("No ping replies: ICMP is blocked on the path or by the target")
+		return
+	}
+
+	var findings []string
+	lossGap := udp.LossPercent - icmp.LossPercent
+	switch {
+	case lossGap > 1 && udp.LossPercent > 2*icmp.LossPercent:
+		findings = append(findings, "UDP loses more than ICMP: UDP is likely policed or rate limited")
+	case lossGap < -1 && icmp.LossPercent > 2*udp.LossPercent:
+		findings = append(findings, "ICMP loses more than UDP: a hop or the target likely rate limits ICMP, which is common")
+	}
+	latGap := udp.AvgRTTMs - icmp.AvgRTTMs
+	if math.Abs(latGap) > max(2, icmp.AvgRTTMs*0.2) {
+		if latGap > 0 {
+			findings = append(findings, fmt.Sprintf("UDP is %.1fms slower than ICMP: UDP may be shaped or queued separately", latGap))
+		} else {
+			findings = append(findings, fmt.Sprintf("ICMP is %.1fms slower than UDP: ICMP is likely deprioritized", -latGap))
+		}
+	}
+	if len(findings) == 0 {
+		findings = append(findings, "UDP and ICMP see the same path")
+	}
+	for _, f := range findings {
+		fmt.Println(f)
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Influx stream writes a run in InfluxDB line protocol: a
+// packet_test_packet point per probe once it is final, as the JSONL stream
+// writes records, timestamped when it was sent, and a packet_test_interval
+// point per interval window.
+// Every point is tagged with the client's host name, the target and the
+// run's ID as test_id, and the label of a target list. Points go to
+// stdout, to an InfluxDB write URL, or both.
+
+const (
+	influxQueue   = 64 // batches waiting to be posted before new ones are dropped
+	influxBatch   = time.Second
+	influxTimeout = 10 * time.Second
+)
+
+// InfluxConfig says where line protocol goes
+type InfluxConfig struct {
+	Out   io.Writer // nil = not written out
+	URL   string    // write endpoint with precision ns, e.g. http://localhost:8086/api/v2/write?org=o&bucket=b; "" = not posted
+	Token string    // API token sent with posts, "" = none
+}
+
+// influxStream formats a run's points and sends them in batches
+type influxStream struct {
+	cfg   InfluxConfig
+	tags  string
+	clock *ClockSync // splits RTTs into one-way latency, nil if unknown
+
+	mu       sync.Mutex
+	buf      bytes.Buffer // points not yet written out
+	batch    bytes.Buffer // points not yet posted
+	lastPost time.Time
+
+	posts   chan []byte
+	done    chan struct{}
+	dropped int // batches the queue had no room for
+	failed  int // batches InfluxDB didn't take
+	lastErr error
+}
+
+func newInfluxStream(cfg InfluxConfig, target, runID, label string, clock *ClockSync) *influxStream {
+	host, _ := os.Hostname()
+	tags := ",host=" + influxEscape(host) + ",target=" + influxEscape(target) + ",test_id=" + influxEscape(runID)
+	if label != "" {
+		tags += ",label=" + influxEscape(label)
+	}
+	f := &influxStream{cfg: cfg, tags: tags, clock: clock}
+	if cfg.URL != "" {
+		f.posts = make(chan []byte, influxQueue)
+		f.done = make(chan struct{})
+		go f.post()
+	}
+	return f
+}
+
+// influxEscape escapes a tag key or value
+func influxEscape(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `).Replace(s)
+}
+
+func (f *influxStream) write(r *PacketRecord) {
+	if f.clock != nil && !r.OneWay {
+		applyClockOffset(r, f.clock)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(&f.buf, "packet_test_packet%s seq=%di,lost=%t,late=%t,reordered=%t,duplicates=%di,path=%di",
+		f.tags, r.SeqNum, r.Lost, r.Late, r.Reordered, r.Duplicates, r.Path)
+	if !r.Lost {
+		fmt.Fprintf(&f.buf, ",rtt_ms=%.3f,net_ms=%.3f,server_proc_ms=%.3f,jitter_rfc3550_ms=%.3f",
+			r.LatencyMs, r.NetLatencyMs, r.ServerProcMs, r.RFCJitterMs)
+	}
+	if r.OneWay {
+		fmt.Fprintf(&f.buf, ",up_ms=%.3f,down_ms=%.3f", r.UpMs, r.DownMs)
+	}
+	if r.LossDir != "" {
+		fmt.Fprintf(&f.buf, ",loss_dir=%q", r.LossDir)
+	}
+	fmt.Fprintf(&f.buf, " %d\n", r.SentTime)
+}
+
+// Interval adds the point of an interval window
+func (f *influxStream) Interval(w *WindowStats) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fmt.Fprintf(&f.buf, "packet_test_interval%s sent=%di,received=%di,lost=%di,late=%di,loss_percent=%.3f",
+		f.tags, w.Sent, w.Received, w.Sent-min(w.Received, w.Sent), w.Late, w.LossPercent)
+	if w.Received > 0 {
+		fmt.Fprintf(&f.buf, ",rtt_min_ms=%.3f,rtt_avg_ms=%.3f,rtt_max_ms=%.3f,jitter_ms=%.3f,net_ms=%.3f,server_proc_ms=%.3f",
+			w.MinRTTMs, w.AvgRTTMs, w.MaxRTTMs, w.JitterMs, w.NetMs, w.ServerMs)
+	}
+	fmt.Fprintf(&f.buf, " %d\n", w.End.UnixNano())
+}
+
+// Flush writes out the points added so far, and queues them to be posted
+// once a second
+func (f *influxStream) Flush() error {
+	return f.flush(false)
+}
+
+// flush writes out the points added so far. A batch that doesn't fit in
+// the queue is dropped rather than holding up the test.
+func (f *influxStream) flush(final bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var err error
+	if f.cfg.Out != nil && f.buf.Len() > 0 {
+		_, err = f.cfg.Out.Write(f.buf.Bytes())
+	}
+	if f.posts != nil {
+		f.batch.Write(f.buf.Bytes())
+		if f.batch.Len() > 0 && (final || time.Since(f.lastPost) >= influxBatch) {
+			select {
+			case f.posts <- bytes.Clone(f.batch.Bytes()):
+			default:
+				f.dropped++
+			}
+			f.batch.Reset()
+			f.lastPost = time.Now()
+		}
+	}
+	f.buf.Reset()
+	return err
+}
+
+// post sends queued batches to the write URL until Close
+func (f *influxStream) post() {
+	defer close(f.done)
+	client := &http.Client{Timeout: influxTimeout}
+	for batch := range f.posts {
+		err := f.send(client, batch)
+		if err != nil {
+			f.mu.Lock()
+			if f.failed == 0 {
+				fmt.Printf("Warning: InfluxDB write failed: %v\n", err)
+			}
+			f.failed++
+			f.lastErr = err
+			f.mu.Unlock()
+		}
+	}
+}
+
+func (f *influxStream) send(client *http.Client, batch []byte) error {
+	req, err := http.NewRequest(http.MethodPost, f.cfg.URL, bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if f.cfg.Token != "" {
+		req.Header.Set("Authorization", "Token "+f.cfg.Token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// Close sends what's left and waits for the queued batches to be posted.
+// It reports how many batches were lost, if any.
+func (f *influxStream) Close() error {
+	err := f.flush(true)
+	if f.posts == nil {
+		return err
+	}
+	close(f.posts)
+	<-f.done
+	if f.failed > 0 {
+		return fmt.Errorf("%d batches of points weren't written to InfluxDB, the last: %w", f.failed, f.lastErr)
+	}
+	if f.dropped > 0 {
+		return fmt.Errorf("%d batches of points were dropped as InfluxDB fell behind", f.dropped)
+	}
+	return err
+}
+
+// checkInfluxURL checks an InfluxDB write URL: http or https, with the
+// precision left at ns, which the points' timestamps are in
+func checkInfluxURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid InfluxDB URL %q, expected http:// or https://", raw)
+	}
+	if p := u.Query().Get("precision"); p != "" && p != "ns" {
+		return fmt.Errorf("InfluxDB URL %q sets precision %s, but points are timestamped in ns", raw, p)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+// ingestQueue is how many Record calls can wait for the stats lock. The
+// sender and each flow's receiver queue their records instead of blocking
+// on the lock, which readers such as the interval printer, the metrics
+// endpoint and spilling hold for a while; whoever takes the lock next
+// applies the queue in order.
+const ingestQueue = 8192
+
+type ingestKind uint8
+
+const (
+	ingestSent ingestKind = iota
+	ingestSendDone
+	ingestTx
+	ingestReceived
+)
+
+// ingestEvent is one queued Record call
+type ingestEvent struct {
+	kind         ingestKind
+	seq          uint64
+	ns           int64 // send, send done, TX or receive time
+	tickNs       int64 // slot the probe was sent for
+	nowNs        int64 // when the reply was handed over, for the receive overhead
+	serverProcNs int64
+	serverRecvNs int64
+	replies      int
+	path         int
+	reply        uint16
+	rc           recvControl
+}
+
+// ingest queues e. Receivers then apply the queue if nobody holds the
+// lock; the sender only queues, so applying never delays a probe. Events
+// left queued are applied by the next receiver or reader, so a reader
+// always sees every Record call that returned before it. A full queue is
+// applied under the lock rather than dropping e.
+func (s *Stats) ingest(e ingestEvent) {
+	select {
+	case s.queue <- e:
+		if e.kind == ingestReceived && s.mu.TryLock() {
+			s.drain()
+			s.mu.Unlock()
+		}
+	default:
+		s.mu.Lock()
+		s.drain()
+		s.apply(e)
+		s.mu.Unlock()
+	}
+}
+
+// lock takes the stats lock and catches up with the queued events
+func (s *Stats) lock() {
+	s.mu.Lock()
+	s.drain()
+}
+
+// drain applies the events queued so far. Those queued while it runs are
+// left to the next holder, so a busy receiver can't keep it going.
+func (s *Stats) drain() {
+	for range len(s.queue) {
+		s.apply(<-s.queue)
+	}
+}
+
+func (s *Stats) apply(e ingestEvent) {
+	switch e.kind {
+	case ingestSent:
+		s.recordSent(e.seq, e.ns, e.replies, e.path)
+	case ingestSendDone:
+		s.recordSendDone(e.seq, e.tickNs, e.ns)
+	case ingestTx:
+		s.recordTxTime(e.seq, e.ns)
+	case ingestReceived:
+		s.recordReceived(e.seq, e.reply, e.ns, e.nowNs, e.serverProcNs, e.serverRecvNs, e.rc)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Injector perturbs replies before they reach Stats, so the summary, CSV,
+// SLA checks and plots can be tested end to end against known numbers.
+// Whether a reply is dropped depends only on the seed, its sequence number
+// and reply index, so a run is reproducible regardless of timing.
+type Injector struct {
+	LossPercent float64       `json:"loss_percent,omitempty"`
+	Delay       time.Duration `json:"delay_ns,omitempty"`
+	Seed        int64         `json:"seed"`
+}
+
+// String describes the injection for the console and reports
+func (in *Injector) String() string {
+	return fmt.Sprintf("%.2f%% loss, %s delay, seed %d", in.LossPercent, in.Delay, in.Seed)
+}
+
+// Drop reports whether the given reply should be discarded
+func (in *Injector) Drop(seq uint64, reply uint16) bool {
+	if in.LossPercent <= 0 {
+		return false
+	}
+	x := splitmix64(uint64(in.Seed) ^ splitmix64(seq<<16|uint64(reply)))
+	return float64(x>>11)/(1<<53)*100 < in.LossPercent
+}
+
+// splitmix64 is a fast, well-mixed 64-bit hash
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// ParsePercent parses a percentage such as "5%" or "5"
+func ParsePercent(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || v < 0 || v > 100 {
+		return 0, fmt.Errorf("invalid percentage %q", s)
+	}
+	return v, nil
+}
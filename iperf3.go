@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// iperf3Result is the subset of `iperf3 -u -J` output needed for import
+type iperf3Result struct {
+	Start struct {
+		Connected []struct {
+			RemoteHost string `json:"remote_host"`
+			RemotePort int    `json:"remote_port"`
+		} `json:"connected"`
+		Timestamp struct {
+			TimeSecs int64 `json:"timesecs"`
+		} `json:"timestamp"`
+		TestStart struct {
+			Protocol string `json:"protocol"`
+		} `json:"test_start"`
+	} `json:"start"`
+	Intervals []struct {
+		Sum iperf3Sum `json:"sum"`
+	} `json:"intervals"`
+	End struct {
+		Sum iperf3Sum `json:"sum"`
+	} `json:"end"`
+	Error string `json:"error"`
+}
+
+type iperf3Sum struct {
+	Start       float64  `json:"start"`
+	End         float64  `json:"end"`
+	Packets     int      `json:"packets"`
+	LostPackets *int     `json:"lost_packets"` // absent on the sending side's intervals
+	JitterMs    *float64 `json:"jitter_ms"`
+}
+
+// ImportIperf3 converts an iperf3 UDP test's JSON output into this tool's
+// CSV and metadata so it can be plotted, merged and compared like a native
+// run. iperf3 only reports counts per interval, so one record is
+// synthesized per packet, spread evenly over its interval with the
+// interval's losses spread among them. iperf3 doesn't measure RTT, so
+// latency columns are left empty. Returns the CSV path written.
+func ImportIperf3(jsonFile string) (string, error) {
+	data, err := os.ReadFile(jsonFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read iperf3 results: %w", err)
+	}
+	var res iperf3Result
+	if err := json.Unmarshal(data, &res); err != nil {
+		return "", fmt.Errorf("invalid iperf3 JSON: %w", err)
+	}
+	if res.Error != "" {
+		return "", fmt.Errorf("iperf3 run failed: %s", res.Error)
+	}
+	if !strings.EqualFold(res.Start.TestStart.Protocol, "UDP") {
+		return "", fmt.Errorf("only iperf3 UDP tests can be imported, got %q", res.Start.TestStart.Protocol)
+	}
+	if len(res.Intervals) == 0 {
+		return "", fmt.Errorf("iperf3 results have no intervals")
+	}
+
+	start := time.Unix(res.Start.Timestamp.TimeSecs, 0)
+	meta := &RunMetadata{
+		CSVSchema: CSVSchemaVersion,
+		Source:    "iperf3",
+		StartTime: start,
+	}
+	if len(res.Start.Connected) > 0 {
+		c := res.Start.Connected[0]
+		meta.Target = net.JoinHostPort(c.RemoteHost, strconv.Itoa(c.RemotePort))
+	}
+
+	// The sender only learns the loss total at the end, so without
+	// per-interval counts that total is spread over the whole run
+	totalPackets := 0
+	for _, iv := range res.Intervals {
+		totalPackets += iv.Sum.Packets
+	}
+	endLost := 0
+	if res.End.Sum.LostPackets != nil {
+		endLost = *res.End.Sum.LostPackets
+	}
+
+	csvFile := strings.TrimSuffix(jsonFile, ".json") + ".csv"
+	file, err := os.Create(csvFile)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+	writer.Write(csvColumns)
+
+	var seq uint64
+	sum := &RunSummary{}
+	for _, iv := range res.Intervals {
+		packets := iv.Sum.Packets
+		lost := 0
+		if iv.Sum.LostPackets != nil {
+			lost = *iv.Sum.LostPackets
+		} else if totalPackets > 0 {
+			lost = int(float64(endLost) * float64(packets) / float64(totalPackets))
+		}
+		lost = min(lost, packets)
+
+		spacing := (iv.Sum.End - iv.Sum.Start) / float64(max(packets, 1))
+		for i := 0; i < packets; i++ {
+			seq++
+			sent := start.Add(time.Duration((iv.Sum.Start + float64(i)*spacing) * float64(time.Second)))
+			isLost := (i+1)*lost/packets > i*lost/packets
+			recv := "0"
+			if !isLost {
+				recv = strconv.FormatInt(sent.UnixMilli(), 10)
+				sum.Received++
+			}
+			row := make(map[string]string, len(csvColumns))
+			row["seq"] = strconv.FormatUint(seq, 10)
+			row["sent_time"] = strconv.FormatInt(sent.UnixMilli(), 10)
+			row["recv_time"] = recv
+			row["lost"] = strconv.FormatBool(isLost)
+			row["late"] = "false"
+			out := make([]string, len(csvColumns))
+			for j, col := range csvColumns {
+				out[j] = row[col]
+			}
+			writer.Write(out)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return "", err
+	}
+
+	sum.Sent = seq
+	if sum.Sent > 0 {
+		sum.LossPercent = float64(sum.Sent-sum.Received) / float64(sum.Sent) * 100
+	}
+	if res.End.Sum.JitterMs != nil {
+		sum.JitterMs = *res.End.Sum.JitterMs
+	}
+	meta.Summary = sum
+	if err := saveMetadata(csvFile, meta); err != nil {
+		return "", fmt.Errorf("failed to save metadata: %w", err)
+	}
+	return csvFile, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// setDontFragment makes the socket send with the DF bit set (IPv4) or
+// without local fragmentation (IPv6), ignoring the kernel's cached path
+// MTU so sizes above it are still sent and can be seen to fail
+func setDontFragment(conn net.Conn, ipv6 bool) error {
+	uc, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// setTTL sets the TTL (IPv4) or hop limit (IPv6) of packets sent on the
+// socket
+func setTTL(conn net.Conn, ipv6 bool, ttl int) error {
+	uc, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, ttl)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// setTOS sets the IPv4 TOS byte or IPv6 traffic class of packets sent on
+// the socket: the DSCP in the upper six bits, the ECN field in the lower two
+func setTOS(conn net.Conn, ipv6 bool, tos int) error {
+	uc, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// tosControl returns ancillary data that sends one packet to addr with the
+// given TOS byte or traffic class, or nil for the socket's default of 0.
+// IPv4-mapped addresses on a dual-stack socket take the IPv4 option.
+func tosControl(addr *net.UDPAddr, tos int) []byte {
+	if tos == 0 {
+		return nil
+	}
+	oob := make([]byte, syscall.CmsgSpace(4))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	if addr.IP.To4() != nil {
+		h.Level, h.Type = syscall.IPPROTO_IP, syscall.IP_TOS
+	} else {
+		h.Level, h.Type = syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS
+	}
+	h.SetLen(syscall.CmsgLen(4))
+	binary.NativeEndian.PutUint32(oob[syscall.CmsgLen(0):], uint32(tos))
+	return oob
+}
+
+// socketBuffers returns the sizes of a socket's receive and send buffers.
+// Linux doubles the size set to leave room for its bookkeeping and reports
+// the doubled value, so it is halved to compare with what was asked for.
+func socketBuffers(conn net.Conn) (rcv, snd int, ok bool) {
+	uc, isUDP := conn.(*net.UDPConn)
+	if !isUDP {
+		return 0, 0, false
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return 0, 0, false
+	}
+	var rerr, serr error
+	err = raw.Control(func(fd uintptr) {
+		rcv, rerr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		snd, serr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	if err != nil || rerr != nil || serr != nil {
+		return 0, 0, false
+	}
+	return rcv / 2, snd / 2, true
+}
+
+// reusePort lets several sockets bind the same port, the kernel hashing
+// each client's address to one of them. It is a net.ListenConfig Control.
+func reusePort(network, address string, c syscall.RawConn) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
@@ -0,0 +1,35 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Setting DF, the TTL and the TOS byte goes through per-platform socket
+// options, which are only implemented for Linux
+
+func setDontFragment(conn net.Conn, ipv6 bool) error {
+	return errors.New("DF-marked probes are only supported on Linux")
+}
+
+func setTTL(conn net.Conn, ipv6 bool, ttl int) error {
+	return errors.New("setting the TTL is only supported on Linux")
+}
+
+func setTOS(conn net.Conn, ipv6 bool, tos int) error {
+	return errors.New("DSCP and ECN marking are only supported on Linux")
+}
+
+// Without per-packet TOS the server's replies go out not ECN-capable
+func tosControl(addr *net.UDPAddr, tos int) []byte { return nil }
+
+// Sharing a port between sockets is only implemented for Linux
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("several server sockets are only supported on Linux")
+}
+
+// Effective buffer sizes are only read back on Linux
+func socketBuffers(conn net.Conn) (rcv, snd int, ok bool) { return 0, 0, false }
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// irtt compatibility: the same metric set and names as irtt's JSON output
+// (https://github.com/heistp/irtt), so scripts built around irtt can read
+// our runs. Durations are integer nanoseconds as in irtt. One-way send and
+// receive delays need synchronized clocks and are not measured, so those
+// fields are omitted.
+
+// IrttDurationStats mirrors irtt's DurationStats
+type IrttDurationStats struct {
+	Total    int64   `json:"total"`
+	N        int     `json:"n"`
+	Min      int64   `json:"min"`
+	Max      int64   `json:"max"`
+	Mean     int64   `json:"mean"`
+	Median   int64   `json:"median,omitempty"`
+	Stddev   int64   `json:"stddev"`
+	Variance float64 `json:"variance"`
+}
+
+// IrttStats mirrors the subset of irtt's Stats that we can measure
+type IrttStats struct {
+	StartTime            time.Time         `json:"start_time"`
+	SendCall             IrttDurationStats `json:"send_call"`
+	TimerError           IrttDurationStats `json:"timer_error"`
+	RTT                  IrttDurationStats `json:"rtt"`
+	IPDVRoundTrip        IrttDurationStats `json:"ipdv_round_trip"`
+	ServerProcessingTime IrttDurationStats `json:"server_processing_time"`
+	BytesSent            uint64            `json:"bytes_sent"`
+	BytesReceived        uint64            `json:"bytes_received"`
+	Duplicates           int               `json:"duplicates"`
+	LatePackets          int               `json:"late_packets"`
+	Duration             int64             `json:"duration"`
+	PacketsSent          int               `json:"packets_sent"`
+	PacketsReceived      int               `json:"packets_received"`
+	PacketLossPercent    float64           `json:"packet_loss_percent"`
+	DuplicatePercent     float64           `json:"duplicate_percent"`
+	LatePacketsPercent   float64           `json:"late_packets_percent"`
+}
+
+// IrttRoundTrip mirrors one entry of irtt's round_trips array
+type IrttRoundTrip struct {
+	Seq        uint64 `json:"seq"`
+	Lost       string `json:"lost"` // "false" or "true"; direction isn't known
+	Timestamps struct {
+		Client struct {
+			Send    *irttTime `json:"send,omitempty"`
+			Receive *irttTime `json:"receive,omitempty"`
+		} `json:"client"`
+	} `json:"timestamps"`
+	Delay struct {
+		RTT int64 `json:"rtt,omitempty"`
+	} `json:"delay"`
+	IPDV struct {
+		RTT *int64 `json:"rtt,omitempty"`
+	} `json:"ipdv"`
+}
+
+type irttTime struct {
+	Wall int64 `json:"wall"`
+}
+
+// IrttResult is the top level of an irtt-style JSON file
+type IrttResult struct {
+	RunID      string          `json:"run_id,omitempty"` // not in irtt; joins the file to our other outputs
+	Stats      IrttStats       `json:"stats"`
+	RoundTrips []IrttRoundTrip `json:"round_trips"`
+}
+
+// BuildIrttResult converts the run into irtt's model. The sender's
+// schedule error per probe is what irtt calls timer error.
+func BuildIrttResult(records []*PacketRecord, packetSize int, start time.Time, duration time.Duration) *IrttResult {
+	sorted := sortBySeq(records)
+	res := &IrttResult{RoundTrips: make([]IrttRoundTrip, 0, len(sorted))}
+	st := &res.Stats
+	st.StartTime = start
+	st.Duration = duration.Nanoseconds()
+
+	var rtt, ipdv, serverProc, sendCall, timerErr []float64
+	var prev *PacketRecord
+	for _, r := range sorted {
+		rt := IrttRoundTrip{Seq: r.SeqNum, Lost: "true"}
+		rt.Timestamps.Client.Send = &irttTime{Wall: r.SentTime}
+		st.PacketsSent++
+		st.BytesSent += uint64(packetSize)
+		if r.ClientProcMs > 0 {
+			sendCall = append(sendCall, r.ClientProcMs)
+		}
+		timerErr = append(timerErr, r.SchedErrorUs/1000)
+
+		if !r.Lost && r.RecvTime > 0 {
+			rt.Lost = "false"
+			rt.Timestamps.Client.Receive = &irttTime{Wall: r.RecvTime}
+			rt.Delay.RTT = msToNs(r.LatencyMs)
+			st.PacketsReceived++
+			st.BytesReceived += uint64(packetSize)
+			rtt = append(rtt, r.LatencyMs)
+			serverProc = append(serverProc, r.ServerProcMs)
+
+			// IPDV is only defined between consecutive sequence numbers
+			if prev != nil && prev.SeqNum+1 == r.SeqNum && !prev.Lost && prev.RecvTime > 0 {
+				d := r.LatencyMs - prev.LatencyMs
+				ns := msToNs(d)
+				rt.IPDV.RTT = &ns
+				ipdv = append(ipdv, math.Abs(d))
+			}
+		}
+		res.RoundTrips = append(res.RoundTrips, rt)
+		prev = r
+	}
+
+	st.RTT = irttDurations(rtt, true)
+	st.IPDVRoundTrip = irttDurations(ipdv, true)
+	st.ServerProcessingTime = irttDurations(serverProc, false)
+	st.SendCall = irttDurations(sendCall, false)
+	st.TimerError = irttDurations(timerErr, false)
+	if st.PacketsSent > 0 {
+		st.PacketLossPercent = float64(st.PacketsSent-st.PacketsReceived) / float64(st.PacketsSent) * 100
+	}
+	return res
+}
+
+// irttDurations summarizes millisecond values as nanosecond DurationStats
+func irttDurations(valuesMs []float64, withMedian bool) IrttDurationStats {
+	ds := IrttDurationStats{N: len(valuesMs)}
+	if len(valuesMs) == 0 {
+		return ds
+	}
+	sorted := append([]float64(nil), valuesMs...)
+	sort.Float64s(sorted)
+
+	mean := avg(sorted)
+	var variance float64
+	for _, v := range sorted {
+		variance += (v - mean) * (v - mean)
+	}
+	if len(sorted) > 1 {
+		variance /= float64(len(sorted) - 1)
+	}
+
+	var total float64
+	for _, v := range sorted {
+		total += v
+	}
+	ds.Total = msToNs(total)
+	ds.Min = msToNs(sorted[0])
+	ds.Max = msToNs(sorted[len(sorted)-1])
+	ds.Mean = msToNs(mean)
+	if withMedian {
+		ds.Median = msToNs(percentile(sorted, 50))
+	}
+	ds.Stddev = msToNs(math.Sqrt(variance))
+	ds.Variance = variance * 1e12 // ms² to ns²
+	return ds
+}
+
+func msToNs(ms float64) int64 {
+	return int64(math.Round(ms * 1e6))
+}
+
+// SaveIrttJSON writes the result next to the CSV as <name>.irtt.json
+func SaveIrttJSON(csvFile string, res *IrttResult) (string, error) {
+	path := strings.TrimSuffix(csvFile, ".csv") + ".irtt.json"
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, data, 0644)
+}
+
+// PrintIrtt prints the summary in irtt's layout and terminology
+func PrintIrtt(res *IrttResult) {
+	st := res.Stats
+	// Three significant digits, like irtt
+	d := func(ns int64) string {
+		unit := int64(1)
+		for abs := max(ns, -ns); abs/unit >= 1000; unit *= 10 {
+		}
+		return time.Duration(ns).Round(time.Duration(unit)).String()
+	}
+	row := func(name string, ds IrttDurationStats, withMedian bool) {
+		if ds.N == 0 {
+			return
+		}
+		median := ""
+		if withMedian {
+			median = d(ds.Median)
+		}
+		fmt.Printf("%19s %9s %9s %9s %9s %9s\n", name, d(ds.Min), d(ds.Mean), median, d(ds.Max), d(ds.Stddev))
+	}
+
+	fmt.Println("\n--- irtt Metrics ---")
+	fmt.Printf("%19s %9s %9s %9s %9s %9s\n", "", "Min", "Mean", "Median", "Max", "Stddev")
+	fmt.Printf("%19s %9s %9s %9s %9s %9s\n", "", "---", "----", "------", "---", "------")
+	row("RTT", st.RTT, true)
+	row("IPDV (jitter)", st.IPDVRoundTrip, true)
+	fmt.Println()
+	row("send call time", st.SendCall, false)
+	row("timer error", st.TimerError, false)
+	row("server proc. time", st.ServerProcessingTime, false)
+	fmt.Println()
+	fmt.Printf("%24s %s\n", "duration:", d(st.Duration))
+	fmt.Printf("%24s %d/%d (%.2f%% loss)\n", "packets sent/received:", st.PacketsSent, st.PacketsReceived, st.PacketLossPercent)
+	fmt.Printf("%24s %d/%d\n", "bytes sent/received:", st.BytesSent, st.BytesReceived)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// adaptiveAlpha is the smoothing factor of the adaptive playout estimator
+// (Ramjee et al. algorithm 1, as used by classic VoIP stacks)
+const adaptiveAlpha = 0.998002
+
+// JitterBufferResult summarizes a simulated playout of the received packets
+type JitterBufferResult struct {
+	Name     string
+	DelayMs  float64 // buffer delay, averaged over the run for adaptive buffers
+	Played   int
+	Late     int // arrived after their playout deadline
+	Lost     int
+	Gaps     int // runs of consecutive unplayable packets
+	MaxGapMs float64
+	AvgGapMs float64
+}
+
+// SimulateJitterBuffer replays the packet timeline through a fixed buffer of
+// bufferMs and an adaptive buffer, reporting which packets would have been
+// playable. Delay is measured relative to the fastest packet, since that
+// offset is absorbed by playout scheduling anyway.
+func SimulateJitterBuffer(records []*PacketRecord, bufferMs float64) (fixed, adaptive JitterBufferResult) {
+	sorted := sortBySeq(records)
+
+	minLat := minLatency(sorted)
+	interval := packetIntervalMs(sorted)
+
+	fixed = JitterBufferResult{Name: "Fixed", DelayMs: bufferMs}
+	adaptive = JitterBufferResult{Name: "Adaptive"}
+	fixedGap := &gapTracker{interval: interval}
+	adaptiveGap := &gapTracker{interval: interval}
+
+	// Start the adaptive estimator at the fixed buffer size
+	dHat, vHat := 0.0, bufferMs/4
+	var delaySum float64
+	for _, r := range sorted {
+		if r.Lost || r.RecvTime == 0 {
+			fixed.Lost++
+			adaptive.Lost++
+			fixedGap.miss()
+			adaptiveGap.miss()
+			continue
+		}
+		n := r.LatencyMs - minLat
+
+		if n <= bufferMs {
+			fixed.Played++
+			fixedGap.hit()
+		} else {
+			fixed.Late++
+			fixedGap.miss()
+		}
+
+		playout := dHat + 4*vHat
+		delaySum += playout
+		if n <= playout {
+			adaptive.Played++
+			adaptiveGap.hit()
+		} else {
+			adaptive.Late++
+			adaptiveGap.miss()
+		}
+		dHat = adaptiveAlpha*dHat + (1-adaptiveAlpha)*n
+		vHat = adaptiveAlpha*vHat + (1-adaptiveAlpha)*math.Abs(dHat-n)
+	}
+	if received := adaptive.Played + adaptive.Late; received > 0 {
+		adaptive.DelayMs = delaySum / float64(received)
+	}
+
+	fixedGap.finish(&fixed)
+	adaptiveGap.finish(&adaptive)
+	return fixed, adaptive
+}
+
+// gapTracker collects runs of consecutive unplayable packets
+type gapTracker struct {
+	interval float64
+	run      int
+	runs     []int
+}
+
+func (g *gapTracker) miss() { g.run++ }
+
+func (g *gapTracker) hit() {
+	if g.run > 0 {
+		g.runs = append(g.runs, g.run)
+		g.run = 0
+	}
+}
+
+func (g *gapTracker) finish(res *JitterBufferResult) {
+	g.hit()
+	res.Gaps = len(g.runs)
+	total := 0
+	for _, run := range g.runs {
+		total += run
+		res.MaxGapMs = math.Max(res.MaxGapMs, float64(run)*g.interval)
+	}
+	if len(g.runs) > 0 {
+		res.AvgGapMs = float64(total) * g.interval / float64(len(g.runs))
+	}
+}
+
+// packetIntervalMs returns the median send interval of seq-sorted records
+func packetIntervalMs(sorted []*PacketRecord) float64 {
+	if len(sorted) < 2 {
+		return 0
+	}
+	deltas := make([]float64, 0, len(sorted)-1)
+	for i := 1; i < len(sorted); i++ {
+		deltas = append(deltas, float64(sorted[i].SentTime-sorted[i-1].SentTime)/1e6)
+	}
+	sort.Float64s(deltas)
+	return percentile(deltas, 50)
+}
+
+// PrintJitterBuffer prints the playout simulation for both buffer types
+func PrintJitterBuffer(records []*PacketRecord, bufferMs float64) {
+	fixed, adaptive := SimulateJitterBuffer(records, bufferMs)
+
+	fmt.Println("\n--- Jitter Buffer ---")
+	for _, res := range []JitterBufferResult{fixed, adaptive} {
+		total := res.Played + res.Late + res.Lost
+		playable := float64(0)
+		if total > 0 {
+			playable = float64(res.Played) / float64(total) * 100
+		}
+		fmt.Printf("%s (%.1fms): %.2f%% playable, %d late, %d lost, %d gaps (avg %.0fms, max %.0fms)\n",
+			res.Name, res.DelayMs, playable, res.Late, res.Lost, res.Gaps, res.AvgGapMs, res.MaxGapMs)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+)
+
+// The JSONL stream writes each packet record as a JSON line once it can no
+// longer change, while the test runs: an answered probe once it is past
+// the longest loss timeout, as spilled records are, so late duplicates
+// are counted. Losses follow at the end of the run, once the server
+// report says which way they went, or with the rest when spilling, which
+// skips the report. Each record is written once, matching its CSV row.
+// Fields are the CSV's columns, plus the run ID and label so lines from
+// several runs can be told apart in one pipeline.
+
+// jsonlRecord is one line of the stream
+type jsonlRecord struct {
+	RunID        string   `json:"run_id"`
+	Label        string   `json:"label,omitempty"`
+	Seq          uint64   `json:"seq"`
+	SentTime     int64    `json:"sent_time"` // Unix milliseconds
+	RecvTime     int64    `json:"recv_time"` // Unix milliseconds, 0 if lost
+	LatencyMs    float64  `json:"latency_ms"`
+	ServerProcMs float64  `json:"server_proc_ms"`
+	ClientProcMs float64  `json:"client_proc_ms"`
+	SchedErrorUs float64  `json:"sched_error_us"`
+	NetLatencyMs float64  `json:"net_latency_ms"`
+	UpMs         *float64 `json:"up_ms,omitempty"`
+	DownMs       *float64 `json:"down_ms,omitempty"`
+	RecvTTL      int      `json:"recv_ttl"`
+	ECN          int      `json:"ecn"`
+	Path         int      `json:"path"`
+	Lost         bool     `json:"lost"`
+	LossDir      string   `json:"loss_dir,omitempty"`
+	Late         bool     `json:"late"`
+	Reordered    bool     `json:"reordered"`
+	Duplicates   int      `json:"duplicate"`
+	RFCJitterMs  float64  `json:"jitter_rfc3550_ms"`
+}
+
+// jsonlStream writes a run's records to w
+type jsonlStream struct {
+	w     *bufio.Writer
+	enc   *json.Encoder
+	runID string
+	label string
+	clock *ClockSync // splits RTTs into one-way latency, nil if unknown
+}
+
+func newJSONLStream(w io.Writer, runID, label string, clock *ClockSync) *jsonlStream {
+	bw := bufio.NewWriter(w)
+	return &jsonlStream{w: bw, enc: json.NewEncoder(bw), runID: runID, label: label, clock: clock}
+}
+
+func (j *jsonlStream) write(r *PacketRecord) {
+	if j.clock != nil && !r.OneWay {
+		applyClockOffset(r, j.clock)
+	}
+	line := jsonlRecord{
+		RunID:        j.runID,
+		Label:        j.label,
+		Seq:          r.SeqNum,
+		SentTime:     r.SentTime / 1000000,
+		RecvTime:     r.RecvTime / 1000000,
+		LatencyMs:    r.LatencyMs,
+		ServerProcMs: r.ServerProcMs,
+		ClientProcMs: r.ClientProcMs,
+		SchedErrorUs: r.SchedErrorUs,
+		NetLatencyMs: r.NetLatencyMs,
+		RecvTTL:      r.RecvTTL,
+		ECN:          r.ECN,
+		Path:         r.Path,
+		Lost:         r.Lost,
+		LossDir:      r.LossDir,
+		Late:         r.Late,
+		Reordered:    r.Reordered,
+		Duplicates:   r.Duplicates,
+		RFCJitterMs:  r.RFCJitterMs,
+	}
+	if r.OneWay {
+		line.UpMs, line.DownMs = &r.UpMs, &r.DownMs
+	}
+	j.enc.Encode(line)
+}
+
+// Flush writes out the lines buffered so far
+func (j *jsonlStream) Flush() error {
+	return j.w.Flush()
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Latency under load measures bufferbloat. The probe stream runs idle for
+// a baseline, then bulk UDP fills the link while it keeps going, and the
+// RTT under load is compared with idle, as in RPM / responsiveness tests.
+// Upload load goes from its own socket as load packets, which the server
+// only counts; download load is a server stream to another socket, see
+// downlink.go. Background load runs for the whole test instead, without
+// an idle baseline, to measure the probes on a link kept busy.
+
+const (
+	loadPacketSize = 1400        // bulk packet size, under a typical 1500 byte MTU
+	loadSettle     = time.Second // probes sent this soon after the load starts count as neither idle nor loaded
+)
+
+// LoadConfig configures the bulk traffic of a latency-under-load test
+type LoadConfig struct {
+	UpBps      float64       // upload load in bits per second, 0 = none
+	DownBps    float64       // download load, 0 = none
+	Delay      time.Duration // idle baseline before the load starts
+	Background bool          // load from the start, with no idle phase to compare with
+}
+
+// LoadResult summarizes a latency-under-load test
+type LoadResult struct {
+	Start       time.Time `json:"start"`
+	Background  bool      `json:"background,omitempty"`        // no idle phase, the idle stats are empty
+	UpBps       float64   `json:"up_bps,omitempty"`            // target
+	DownBps     float64   `json:"down_bps,omitempty"`          // target
+	UpSent      []float64 `json:"up_sent_bps,omitempty"`       // load sent in each second from Start
+	DownRecv    []float64 `json:"down_received_bps,omitempty"` // load received in each second from Start
+	IdleP50Ms   float64   `json:"idle_p50_ms"`
+	IdleP99Ms   float64   `json:"idle_p99_ms"`
+	LoadedP50Ms float64   `json:"loaded_p50_ms"`
+	LoadedP99Ms float64   `json:"loaded_p99_ms"`
+	RPM         float64   `json:"rpm"` // round trips per minute at the loaded p50
+}
+
+// loadGen runs the bulk traffic
+type loadGen struct {
+	cfg     LoadConfig
+	session Session
+	start   time.Time
+	wg      sync.WaitGroup
+
+	mu       sync.Mutex
+	upSent   []uint64 // bytes per second since start
+	downRecv []uint64
+}
+
+// StartLoad starts the bulk traffic after cfg.Delay; it runs until ctx is
+// done, which should be when the probes stop
+func StartLoad(ctx context.Context, network, addr string, session Session, cfg LoadConfig) (*loadGen, error) {
+	g := &loadGen{cfg: cfg, session: session, start: time.Now().Add(cfg.Delay)}
+	var up, down net.Conn
+	var err error
+	if cfg.UpBps > 0 {
+		if up, err = net.Dial(network, addr); err != nil {
+			return nil, fmt.Errorf("failed to open upload load socket: %w", err)
+		}
+	}
+	if cfg.DownBps > 0 {
+		if down, err = net.Dial(network, addr); err != nil {
+			if up != nil {
+				up.Close()
+			}
+			return nil, fmt.Errorf("failed to open download load socket: %w", err)
+		}
+	}
+
+	wait := func() bool {
+		select {
+		case <-time.After(time.Until(g.start)):
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	if up != nil {
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			defer up.Close()
+			if wait() {
+				g.sendUp(ctx, up)
+			}
+		}()
+	}
+	if down != nil {
+		g.wg.Add(1)
+		go func() {
+			defer g.wg.Done()
+			defer down.Close()
+			if wait() {
+				g.receiveDown(ctx, down)
+			}
+		}()
+	}
+	return g, nil
+}
+
+// count adds bytes to the second they were sent or received in
+func (g *loadGen) count(buckets *[]uint64, bytes int) {
+	i := int(time.Since(g.start) / time.Second)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for len(*buckets) <= i {
+		*buckets = append(*buckets, 0)
+	}
+	(*buckets)[i] += uint64(bytes)
+}
+
+func (g *loadGen) sendUp(ctx context.Context, conn net.Conn) {
+	rate := RateForBitrate(g.cfg.UpBps, loadPacketSize)
+	interval := time.Second / time.Duration(rate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	pace := newPacer(interval, true)
+	var seq uint64
+	for {
+		var tick time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case tick = <-ticker.C:
+		}
+		for range pace.due(tick) {
+			seq++
+			pkt := g.session.stamp(NewPacket(seq, loadPacketSize, monoUnixNano(time.Now())))
+			pkt.Type, pkt.ReplyCount = TypeLoad, 0
+			if _, err := conn.Write(pkt.Encode(loadPacketSize)); err == nil {
+				g.count(&g.upSent, loadPacketSize)
+			}
+		}
+	}
+}
+
+func (g *loadGen) receiveDown(ctx context.Context, conn net.Conn) {
+	rate := RateForBitrate(g.cfg.DownBps, loadPacketSize)
+	// The stream is asked for a day and stopped explicitly; keepalives
+	// stop with the test anyway
+	request := encodeStreamRequest(min(rate, streamMaxRate), streamMaxSeconds, loadPacketSize, g.session)
+	stop := context.AfterFunc(ctx, func() {
+		conn.Write(encodeStreamRequest(0, 0, loadPacketSize, g.session))
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+	go func() {
+		ticker := time.NewTicker(streamKeepalive)
+		defer ticker.Stop()
+		for {
+			conn.Write(request)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+		g.count(&g.downRecv, n)
+	}
+}
+
+// Result waits for the load to stop and compares the probes sent before
+// it started with those sent under it
+func (g *loadGen) Result(records []*PacketRecord) *LoadResult {
+	g.wg.Wait()
+	res := &LoadResult{Start: g.start, Background: g.cfg.Background, UpBps: g.cfg.UpBps, DownBps: g.cfg.DownBps}
+	toBps := func(buckets []uint64) []float64 {
+		bps := make([]float64, len(buckets))
+		for i, b := range buckets {
+			bps[i] = float64(b) * 8
+		}
+		return bps
+	}
+	g.mu.Lock()
+	res.UpSent, res.DownRecv = toBps(g.upSent), toBps(g.downRecv)
+	g.mu.Unlock()
+
+	var idle, loaded []float64
+	startNs, settledNs := monoUnixNano(g.start), monoUnixNano(g.start.Add(loadSettle))
+	for _, r := range records {
+		if r.Lost || r.RecvTime == 0 {
+			continue
+		}
+		switch {
+		case r.SentTime < startNs:
+			idle = append(idle, r.LatencyMs)
+		case r.SentTime >= settledNs:
+			loaded = append(loaded, r.LatencyMs)
+		}
+	}
+	sort.Float64s(idle)
+	sort.Float64s(loaded)
+	res.IdleP50Ms, res.IdleP99Ms = percentile(idle, 50), percentile(idle, 99)
+	res.LoadedP50Ms, res.LoadedP99Ms = percentile(loaded, 50), percentile(loaded, 99)
+	if res.LoadedP50Ms > 0 {
+		res.RPM = 60000 / res.LoadedP50Ms
+	}
+	return res
+}
+
+// avgBps returns the average of the per-second rates, leaving out the
+// last second, which the end of the test usually cuts short
+func avgBps(perSecond []float64) float64 {
+	if len(perSecond) > 1 {
+		perSecond = perSecond[:len(perSecond)-1]
+	}
+	return avg(perSecond)
+}
+
+// PrintLoad prints idle against loaded latency
+func PrintLoad(res *LoadResult) {
+	if res.Background {
+		fmt.Println("\n--- Background load ---")
+	} else {
+		fmt.Println("\n--- Latency under load ---")
+	}
+	if res.UpBps > 0 {
+		fmt.Printf("Load: up %s sent of %s\n", FormatBitrate(avgBps(res.UpSent)), FormatBitrate(res.UpBps))
+	}
+	if res.DownBps > 0 {
+		fmt.Printf("Load: down %s received of %s\n", FormatBitrate(avgBps(res.DownRecv)), FormatBitrate(res.DownBps))
+	}
+	if res.Background {
+		fmt.Printf("Loaded RTT: p50 %.2fms, p99 %.2fms (after the first %s), responsiveness %.0f RPM\n",
+			res.LoadedP50Ms, res.LoadedP99Ms, loadSettle, res.RPM)
+		return
+	}
+	fmt.Printf("Idle RTT:   p50 %.2fms, p99 %.2fms\n", res.IdleP50Ms, res.IdleP99Ms)
+	fmt.Printf("Loaded RTT: p50 %.2fms, p99 %.2fms\n", res.LoadedP50Ms, res.LoadedP99Ms)
+	if res.IdleP50Ms == 0 || res.LoadedP50Ms == 0 {
+		fmt.Println("Not enough probes in both phases to compare")
+		return
+	}
+	fmt.Printf("Bufferbloat: %+.2fms at p50 (%.1fx idle), responsiveness %.0f RPM\n",
+		res.LoadedP50Ms-res.IdleP50Ms, res.LoadedP50Ms/res.IdleP50Ms, res.RPM)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+)
+
+// LossMetrics are the sequence-aware loss metrics of RFC 3357 (loss
+// periods and loss distance), reported next to the raw loss ratio
+type LossMetrics struct {
+	Packets     int     `json:"packets"`
+	Lost        int     `json:"lost"`
+	LossPercent float64 `json:"loss_percent"`
+
+	// A loss period (episode) is a maximal run of consecutive losses
+	Periods         int     `json:"loss_periods"`
+	AvgPeriodLength float64 `json:"avg_period_length"` // packets
+	MaxPeriodLength int     `json:"max_period_length"`
+	AvgPeriodMs     float64 `json:"avg_period_ms"` // first loss to next delivered packet
+	MaxPeriodMs     float64 `json:"max_period_ms"`
+
+	// Loss distance is the sequence distance between successive losses;
+	// distance 1 means back-to-back
+	AvgLossDistance float64 `json:"avg_loss_distance"`
+
+	// Probability that a packet is lost given the previous one was,
+	// compared with LossPercent this shows how bursty the loss is
+	ConditionalLossPercent float64 `json:"conditional_loss_percent"`
+
+	// Loss periods by length, telling random loss from bursts
+	SingleLosses int `json:"single_losses"`
+	Bursts2      int `json:"bursts_2"`
+	Bursts3to5   int `json:"bursts_3_5"`
+	BurstsOver5  int `json:"bursts_over_5"`
+
+	// Gilbert model fitted to the trace, the Gilbert-Elliott model with
+	// every packet lost in the bad state: P is the chance of a loss after
+	// a delivered packet, R of a delivery after a loss. Random loss has
+	// P+R near 1, bursty loss well below; 1/R is the mean burst length.
+	GilbertP float64 `json:"gilbert_p"`
+	GilbertR float64 `json:"gilbert_r"`
+}
+
+// ComputeLossMetrics walks the records in sequence order
+func ComputeLossMetrics(records []*PacketRecord) LossMetrics {
+	sorted := sortBySeq(records)
+	m := LossMetrics{Packets: len(sorted)}
+
+	var periodLengths []int
+	var periodMs []float64
+	var distanceSum float64
+	var distances int
+	var prevLostSeq uint64
+	var afterLoss, lostAfterLoss int
+	var afterDelivery, lostAfterDelivery int
+
+	run := 0
+	var runStart int64
+	for i, r := range sorted {
+		lost := r.Lost
+		if i > 0 && sorted[i-1].Lost {
+			afterLoss++
+			if lost {
+				lostAfterLoss++
+			}
+		} else if i > 0 {
+			afterDelivery++
+			if lost {
+				lostAfterDelivery++
+			}
+		}
+
+		if lost {
+			m.Lost++
+			if prevLostSeq != 0 {
+				distanceSum += float64(r.SeqNum - prevLostSeq)
+				distances++
+			}
+			prevLostSeq = r.SeqNum
+			if run == 0 {
+				runStart = r.SentTime
+			}
+			run++
+			continue
+		}
+		if run > 0 {
+			periodLengths = append(periodLengths, run)
+			periodMs = append(periodMs, float64(r.SentTime-runStart)/1e6)
+			run = 0
+		}
+	}
+	// A period still open at the end lasts until the last probe was sent
+	if run > 0 {
+		periodLengths = append(periodLengths, run)
+		periodMs = append(periodMs, float64(sorted[len(sorted)-1].SentTime-runStart)/1e6)
+	}
+
+	if m.Packets > 0 {
+		m.LossPercent = float64(m.Lost) / float64(m.Packets) * 100
+	}
+	m.Periods = len(periodLengths)
+	if m.Periods > 0 {
+		total := 0
+		for i, n := range periodLengths {
+			total += n
+			switch {
+			case n == 1:
+				m.SingleLosses++
+			case n == 2:
+				m.Bursts2++
+			case n <= 5:
+				m.Bursts3to5++
+			default:
+				m.BurstsOver5++
+			}
+			m.MaxPeriodLength = max(m.MaxPeriodLength, n)
+			m.MaxPeriodMs = max(m.MaxPeriodMs, periodMs[i])
+		}
+		m.AvgPeriodLength = float64(total) / float64(m.Periods)
+		m.AvgPeriodMs = avg(periodMs)
+	}
+	if distances > 0 {
+		m.AvgLossDistance = distanceSum / float64(distances)
+	}
+	if afterLoss > 0 {
+		m.ConditionalLossPercent = float64(lostAfterLoss) / float64(afterLoss) * 100
+		m.GilbertR = 1 - float64(lostAfterLoss)/float64(afterLoss)
+	}
+	if afterDelivery > 0 {
+		m.GilbertP = float64(lostAfterDelivery) / float64(afterDelivery)
+	}
+	return m
+}
+
+// PrintLossMetrics prints the loss period and distance metrics
+func PrintLossMetrics(m LossMetrics) {
+	if m.Lost == 0 {
+		return
+	}
+	fmt.Println("\n--- Loss Periods (RFC 3357) ---")
+	fmt.Printf("Loss: %d of %d (%.2f%%) in %d periods, %.2f%% loss after a loss\n",
+		m.Lost, m.Packets, m.LossPercent, m.Periods, m.ConditionalLossPercent)
+	fmt.Printf("Period length: avg %.1f max %d packets, duration avg %.0fms max %.0fms\n",
+		m.AvgPeriodLength, m.MaxPeriodLength, m.AvgPeriodMs, m.MaxPeriodMs)
+	if m.AvgLossDistance > 0 {
+		fmt.Printf("Loss distance: avg %.1f packets\n", m.AvgLossDistance)
+	}
+	fmt.Printf("Period lengths: %d single, %d of 2, %d of 3-5, %d over 5\n",
+		m.SingleLosses, m.Bursts2, m.Bursts3to5, m.BurstsOver5)
+	if m.GilbertR > 0 {
+		pattern := "close to random"
+		if m.GilbertP+m.GilbertR < 0.8 {
+			pattern = "bursty"
+		}
+		fmt.Printf("Gilbert model: p=%.4f (loss after delivery), r=%.4f (delivery after loss), mean burst %.1f packets, %s\n",
+			m.GilbertP, m.GilbertR, 1/m.GilbertR, pattern)
+	}
+}
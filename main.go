@@ -1,84 +1,293 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
 )
 
+// command is a subcommand of packet-test with its own flags
+type command struct {
+	name, summary string
+	run           func(args []string) error
+}
+
 func main() {
-	// Mode flags
-	serverMode := flag.Bool("server", false, "Run in server mode")
-	clientMode := flag.Bool("client", false, "Run in client mode")
-
-	// Connection flags
-	host := flag.String("host", "localhost", "Server address (client mode)")
-	port := flag.Int("port", 9999, "UDP port")
-
-	// Client flags
-	packetSize := flag.Int("packet-size", 128, "Packet payload size in bytes")
-	rate := flag.Int("rate", 64, "Packets per second")
-	duration := flag.Int("duration", 30, "Test duration in seconds")
-	output := flag.String("output", "", "CSV filename (auto-generated if empty)")
-	burst := flag.Bool("burst", false, "Send packets in bursts (exposes WiFi buffering)")
-	burstSize := flag.Int("burst-size", 10, "Packets per burst (with --burst)")
-	noPlot := flag.Bool("no-plot", false, "Don't generate HTML plot or open browser")
-	lateThreshold := flag.Float64("late-threshold", 100, "Packets above this latency (ms) are counted as late")
-
-	// Plot flag
-	plotFile := flag.String("plot", "", "Generate HTML chart from CSV file")
-
-	flag.Parse()
-
-	// Plot mode
-	if *plotFile != "" {
-		if err := GeneratePlot(*plotFile); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
+	commands := []command{
+		{"server", "Echo probes back to clients", serverCommand},
+		{"client", "Test the path to a server and save the results", clientCommand},
+		{"sweep", "Run the client over a matrix of packet sizes and rates", sweepCommand},
+		{"plot", "Generate HTML reports from saved results", plotCommand},
+		{"compare", "Compare two runs with significance tests", compareCommand},
+	}
+
+	args := os.Args[1:]
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") && !isHelp(args[0]) {
+		args = legacyArgs(args)
+	}
+	if len(args) == 0 {
+		usage(os.Stderr, commands)
+		os.Exit(1)
+	}
+	if isHelp(args[0]) || args[0] == "help" {
+		if len(args) > 1 {
+			args = []string{args[1], "-h"}
+		} else {
+			usage(os.Stdout, commands)
+			return
 		}
-		return
 	}
 
-	// Validate mode selection
-	if *serverMode && *clientMode {
-		fmt.Fprintln(os.Stderr, "Error: cannot use both --server and --client")
+	var err error
+	found := false
+	for _, c := range commands {
+		if c.name == args[0] {
+			err, found = c.run(args[1:]), true
+		}
+	}
+	if !found {
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n\n", args[0])
+		usage(os.Stderr, commands)
 		os.Exit(1)
 	}
 
-	if !*serverMode && !*clientMode {
-		fmt.Fprintln(os.Stderr, "Error: must specify --server or --client mode")
-		flag.PrintDefaults()
+	if errors.Is(err, ErrSLAFailed) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(2)
+	}
+	if errors.Is(err, ErrRegression) {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(3)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+}
+
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// usage lists the commands
+func usage(w io.Writer, commands []command) {
+	fmt.Fprintf(w, "Usage: packet-test <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun \"packet-test <command> -h\" for the flags of a command.\n")
+}
+
+// legacyArgs maps the flags-only command line of earlier versions, such as
+// --client --host example.net, onto a command, so scripts keep working
+func legacyArgs(args []string) []string {
+	for i, arg := range args {
+		if name := strings.TrimLeft(arg, "-"); name == "server" || name == "client" {
+			rest := append(append([]string{}, args[:i]...), args[i+1:]...)
+			return append([]string{name}, rest...)
+		}
+	}
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		switch name {
+		case "selftest", "targets", "schedule":
+			return append([]string{"client"}, args...)
+		case "report", "import-iperf3":
+			return append([]string{"plot"}, args...)
+		case "plot", "compare":
+			// The flag's value becomes the first file, ahead of any given
+			// after the flags
+			rest := append(append([]string{}, args[:i]...), args[i+1:]...)
+			if !hasValue && i+1 < len(args) {
+				value, rest = args[i+1], append(append([]string{}, args[:i]...), args[i+2:]...)
+			}
+			files := 0
+			for files < len(rest) && strings.HasPrefix(rest[files], "-") {
+				if !strings.Contains(rest[files], "=") && strings.TrimLeft(rest[files], "-") != "no-plot" {
+					files++ // the flag's value
+				}
+				files++
+			}
+			files = min(files, len(rest))
+			out := append([]string{name}, rest[:files]...)
+			out = append(out, value)
+			return append(out, rest[files:]...)
+		}
+	}
+	return args
+}
+
+// newFlagSet returns the flag set of a command, whose help shows the
+// command's usage line and description above its flags
+func newFlagSet(name, usage, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: packet-test %s %s\n\n%s\n", name, usage, description)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(fs.Output(), "\nFlags:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
 
-	// Validate packet size
-	if *packetSize < HeaderSize {
-		fmt.Fprintf(os.Stderr, "Error: packet-size must be at least %d bytes\n", HeaderSize)
+// addFamilyFlags adds -4 and -6 to fs
+func addFamilyFlags(fs *flag.FlagSet, usage4, usage6 string) (ipv4, ipv6 *bool) {
+	return fs.Bool("4", false, usage4), fs.Bool("6", false, usage6)
+}
+
+// parseFamily returns the address family of -4 or -6, "" for either
+func parseFamily(ipv4, ipv6 bool) string {
+	switch {
+	case ipv4 && ipv6:
+		fmt.Fprintln(os.Stderr, "Error: cannot use both -4 and -6")
 		os.Exit(1)
+	case ipv4:
+		return "IPv4"
+	case ipv6:
+		return "IPv6"
 	}
+	return ""
+}
 
-	// Run selected mode
-	var err error
-	if *serverMode {
-		err = RunServer(*port)
-	} else {
-		cfg := ClientConfig{
-			Host:          *host,
-			Port:          *port,
-			PacketSize:    *packetSize,
-			Rate:          *rate,
-			Duration:      *duration,
-			OutputFile:    *output,
-			Burst:         *burst,
-			BurstSize:     *burstSize,
-			NoPlot:        *noPlot,
-			LateThreshold: *lateThreshold,
+// plotFlags are the HTML report options of the commands that write reports
+type plotFlags struct {
+	theme, aggregate, chartJS, charts *string
+}
+
+func addPlotFlags(fs *flag.FlagSet) plotFlags {
+	return plotFlags{
+		theme:     fs.String("theme", "dark", "HTML report theme: dark or light (print-friendly)"),
+		aggregate: fs.String("aggregate", "auto", "Plot one point per second instead of per packet: auto (runs over 10 minutes), on or off"),
+		chartJS:   fs.String("chartjs", "", "Inline this Chart.js file (chart.umd.min.js) into HTML reports so they work offline (default: the copy built in, if any, else the CDN)"),
+		charts:    fs.String("charts", "", "Comma-separated charts to include in the HTML report (default all): "+strings.Join(PlotCharts, ",")),
+	}
+}
+
+func (p plotFlags) options() (PlotOptions, error) {
+	opts, err := ParsePlotOptions(*p.theme, *p.charts, *p.aggregate)
+	opts.ChartJS = *p.chartJS
+	return opts, err
+}
+
+// commandContext returns a context that Ctrl+C cancels, and serves pprof
+// for as long as it lasts if pprofAddr is given
+func commandContext(pprofAddr string) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	if pprofAddr != "" {
+		if err := ServePprof(ctx, pprofAddr); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
-		err = RunClient(cfg)
+		fmt.Printf("Serving pprof on %s/debug/pprof/\n\n", pprofAddr)
 	}
+	return ctx, stop
+}
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+// serverCommand runs the echo server until interrupted
+func serverCommand(args []string) error {
+	fs := newFlagSet("server", "[flags]", "Echo probes back to clients, on both IPv4 and IPv6 unless -4 or -6 is given.")
+	port := fs.Int("port", 9999, "UDP port")
+	ipv4, ipv6 := addFamilyFlags(fs, "Listen on IPv4 only instead of both stacks", "Listen on IPv6 only instead of both stacks")
+	serverCSV := fs.String("server-csv", "", "Append a summary row per client (received, missing, gaps, reordering, jitter) to this CSV as each goes idle")
+	sockets := fs.Int("sockets", 1, "Listen on this many sockets sharing the port (SO_REUSEPORT), each served by its own goroutine, to spread packet processing across cores (Linux only)")
+	rateLimit := fs.Int("rate-limit", 0, "Drop packets beyond this many per second from any one source address, background load from clients with --key excepted (0 = off)")
+	truncate := fs.Bool("truncate", false, "Give clients without --key only single header-size replies and no reports or streams, so spoofed sources can't use it for amplification")
+	key := fs.String("key", "", "Shared secret: only clients that prove they have it may ask for more reply bytes than they send or for downlink streams, and with --truncate only they get full replies")
+	metricsPort := fs.Int("metrics-port", 0, "Serve Prometheus metrics on this TCP port at /metrics: per-client counts, rates and jitter (0 = off)")
+	pprofAddr := fs.String("pprof", "", "Serve Go runtime profiles (net/http/pprof) at this address, e.g. :6060, to see whether latency spikes come from the server itself")
+	rcvbuf := fs.Int("rcvbuf", 0, "Socket receive buffer in bytes, e.g. 8388608 for high rates (0 = system default)")
+	sndbuf := fs.Int("sndbuf", 0, "Socket send buffer in bytes (0 = system default)")
+	fs.Parse(args)
+
+	family := parseFamily(*ipv4, *ipv6)
+	if *sockets < 1 {
+		fmt.Fprintln(os.Stderr, "Error: sockets must be at least 1")
 		os.Exit(1)
 	}
+	if *rcvbuf < 0 || *sndbuf < 0 {
+		fmt.Fprintln(os.Stderr, "Error: rcvbuf and sndbuf can't be negative")
+		os.Exit(1)
+	}
+
+	// Ctrl+C stops the server
+	ctx, stop := commandContext(*pprofAddr)
+	defer stop()
+	return RunServer(ctx, ServerConfig{
+		Port:        *port,
+		Family:      family,
+		MetricsPort: *metricsPort,
+		CSVFile:     *serverCSV,
+		RateLimit:   *rateLimit,
+		Key:         *key,
+		Truncate:    *truncate,
+		RecvBuffer:  *rcvbuf,
+		SendBuffer:  *sndbuf,
+		Sockets:     *sockets,
+	})
+}
+
+// plotCommand generates a report from results CSVs, a trend report of a
+// directory, or imports iperf3 results
+func plotCommand(args []string) error {
+	fs := newFlagSet("plot", "[flags] results.csv [more.csv ...]",
+		"Generate an HTML report from a results CSV. Further CSVs are merged into one report, and a\ncomma-separated list (a.csv,b.csv) is overlaid in a comparison report.")
+	trendDir := fs.String("report", "", "Generate trend.html in this directory instead: loss, p99 RTT and jitter of every results CSV under it over time, per target")
+	importIperf3 := fs.String("import-iperf3", "", "Convert iperf3 UDP JSON output (iperf3 -u -J) to CSV and plot it")
+	noPlot := fs.Bool("no-plot", false, "Only convert with --import-iperf3, without generating the report")
+	plot := addPlotFlags(fs)
+	fs.Parse(args)
+
+	opts, err := plot.options()
+	if err != nil {
+		return err
+	}
+	switch {
+	case *importIperf3 != "":
+		csvFile, err := ImportIperf3(*importIperf3)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %s to %s\n", *importIperf3, csvFile)
+		if *noPlot {
+			return nil
+		}
+		return GeneratePlot(csvFile, opts)
+	case *trendDir != "":
+		return GenerateTrendReport(*trendDir, opts)
+	case fs.NArg() == 0:
+		return errors.New("plot needs a results CSV, --report or --import-iperf3")
+	case strings.Contains(fs.Arg(0), ","):
+		return ComparePlots(strings.Split(fs.Arg(0), ","), opts)
+	case fs.NArg() > 1:
+		return MergePlots(fs.Args(), opts)
+	}
+	return GeneratePlot(fs.Arg(0), opts)
+}
+
+// compareCommand compares two runs
+func compareCommand(args []string) error {
+	fs := newFlagSet("compare", "a.csv b.csv", "Compare two runs, with significance tests on latency and loss.")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return errors.New("compare needs exactly two CSVs")
+	}
+	return CompareRuns(fs.Arg(0), fs.Arg(1))
+}
+
+// flagSet reports whether a flag was given on the command line or by a preset
+func flagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
 }
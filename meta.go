@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Run modes other than the default echo test
+const ModeDownlink = "downlink"
+
+// RunMetadata holds per-run context that doesn't fit in the per-packet CSV.
+// It is written next to the CSV as <name>.meta.json and picked up by GeneratePlot.
+type RunMetadata struct {
+	RunID      string         `json:"run_id,omitempty"`
+	Label      string         `json:"label,omitempty"`      // name of the target in a target list
+	Mode       string         `json:"mode,omitempty"`       // "" = echo, ModeDownlink = server push
+	CSVSchema  int            `json:"csv_schema,omitempty"` // 0 in metadata written before versioning
+	Source     string         `json:"source,omitempty"`     // tool that produced the data, "" = packet-test
+	Target     string         `json:"target"`
+	Address    *AddressChoice `json:"address,omitempty"`
+	StartTime  time.Time      `json:"start_time"`
+	TargetInfo *TargetInfo    `json:"target_info,omitempty"`
+	Client     *Environment   `json:"client,omitempty"`
+
+	TracerouteStart *Traceroute `json:"traceroute_start,omitempty"`
+	TracerouteEnd   *Traceroute `json:"traceroute_end,omitempty"`
+	PathChanged     bool        `json:"path_changed,omitempty"`
+
+	StartSync    *StartSync    `json:"start_sync,omitempty"`
+	Clock        *ClockSync    `json:"clock,omitempty"`    // server clock offset behind the one-way latencies
+	Injected     *Injector     `json:"injected,omitempty"` // synthetic perturbation, the results aren't real
+	Events       []RunEvent    `json:"events,omitempty"`
+	TargetRate   int           `json:"target_rate,omitempty"`  // configured probes per second
+	SendPattern  string        `json:"send_pattern,omitempty"` // gaps between probes, "" = fixed interval
+	Replay       string        `json:"replay,omitempty"`       // traffic profile replayed, rate and sizes are its averages
+	PacketSize   int           `json:"packet_size,omitempty"`  // probe UDP payload in bytes
+	ReplySize    int           `json:"reply_size,omitempty"`   // reply UDP payload in bytes
+	DontFragment bool          `json:"dont_fragment,omitempty"`
+	TTL          int           `json:"ttl,omitempty"`        // probe TTL or hop limit, 0 = system default
+	DSCP         string        `json:"dscp,omitempty"`       // probe marking, e.g. "EF (46)", "" = best effort
+	ECN          bool          `json:"ecn,omitempty"`        // probes were sent ECN-capable
+	RecvBuffer   int           `json:"rcvbuf,omitempty"`     // client socket receive buffer in bytes, where known
+	SendBuffer   int           `json:"sndbuf,omitempty"`     // client socket send buffer in bytes, where known
+	TargetBps    float64       `json:"target_bps,omitempty"` // --bandwidth target in bits per second
+	SendRate     []int         `json:"send_rate,omitempty"`  // probes actually sent in each second
+	Summary      *RunSummary   `json:"summary,omitempty"`
+	ICMP         *RunSummary   `json:"icmp,omitempty"` // pings sent alongside the test, series in <name>.icmp.csv
+	Load         *LoadResult   `json:"load,omitempty"` // latency-under-load test
+	LossMetrics  *LossMetrics  `json:"loss_metrics,omitempty"`
+	Voice        *VoiceQuality `json:"voice,omitempty"` // E-model estimate from the summary
+}
+
+// RunSummary holds the headline numbers of a run, so indexes and
+// comparisons don't have to re-read the CSV
+type RunSummary struct {
+	Sent        uint64  `json:"sent"`
+	Received    uint64  `json:"received"`
+	Late        uint64  `json:"late"`
+	LocalDrops  uint64  `json:"local_drops,omitempty"` // replies dropped by the client's receive buffer
+	Corrupt     uint64  `json:"corrupt,omitempty"`     // replies that failed payload verification
+	LossPercent float64 `json:"loss_percent"`
+	AvgRTTMs    float64 `json:"avg_rtt_ms"`
+	P99RTTMs    float64 `json:"p99_rtt_ms"`
+	JitterMs    float64 `json:"jitter_ms"`
+	RFCJitterMs float64 `json:"jitter_rfc3550_ms,omitempty"`
+	UpBps       float64 `json:"up_bps,omitempty"`   // UDP payload bits per second sent
+	DownBps     float64 `json:"down_bps,omitempty"` // and received
+}
+
+// metadataFile returns the metadata path that belongs to a CSV file
+func metadataFile(csvFile string) string {
+	return strings.TrimSuffix(csvFile, ".csv") + ".meta.json"
+}
+
+func saveMetadata(csvFile string, meta *RunMetadata) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(metadataFile(csvFile), data, 0644)
+}
+
+// loadMetadata reads the metadata for a CSV file. A missing file is not an
+// error since older runs and hand-made CSVs have none.
+func loadMetadata(csvFile string) (*RunMetadata, error) {
+	data, err := os.ReadFile(metadataFile(csvFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var meta RunMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("invalid metadata: %w", err)
+	}
+	return &meta, nil
+}
+
+// renderRunInfo renders the metadata block shown at the top of the HTML report
+func renderRunInfo(meta *RunMetadata) string {
+	if meta == nil {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("    <div class=\"run-info\">\n")
+	row := func(label, value string) {
+		fmt.Fprintf(&b, "        <div>%s: <span>%s</span></div>\n", label, html.EscapeString(value))
+	}
+	if meta.Source != "" {
+		row("Source", "imported from "+meta.Source)
+	}
+	if meta.RunID != "" {
+		row("Run ID", meta.RunID)
+	}
+	row("Target", meta.Target)
+	if meta.Mode == ModeDownlink {
+		row("Mode", "downlink stream from the server; latency is one-way delay above the minimum")
+	}
+	if meta.Address != nil && meta.Address.Tried != "" {
+		row("Address", meta.Address.String())
+	}
+	if !meta.StartTime.IsZero() {
+		row("Started", meta.StartTime.Format("2006-01-02 15:04:05 MST"))
+	}
+	if meta.TargetInfo != nil {
+		row("Target info", meta.TargetInfo.String())
+		if meta.TargetInfo.Country != "" {
+			row("Location", "country "+meta.TargetInfo.Country+" only, from the Team Cymru registry data; there is no city or region lookup")
+		}
+	}
+	if meta.Client != nil {
+		row("Client", meta.Client.String())
+		if meta.Client.Device != "" {
+			row("NIC", meta.Client.Device)
+		}
+		if len(meta.Client.Sysctls) > 0 {
+			names := make([]string, 0, len(meta.Client.Sysctls))
+			for name := range meta.Client.Sysctls {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for i, name := range names {
+				names[i] = name + "=" + meta.Client.Sysctls[name]
+			}
+			row("Sysctls", strings.Join(names, ", "))
+		}
+	}
+	if meta.TracerouteStart != nil {
+		row("Path at start", meta.TracerouteStart.String())
+	}
+	if meta.TracerouteEnd != nil {
+		row("Path at end", meta.TracerouteEnd.String())
+	}
+	if meta.PathChanged {
+		b.WriteString("        <div class=\"warning\">Path changed during the run</div>\n")
+	}
+	if meta.Injected != nil {
+		fmt.Fprintf(&b, "        <div class=\"warning\">Synthetic results: injected %s</div>\n", html.EscapeString(meta.Injected.String()))
+	}
+	if meta.StartSync != nil {
+		row("Synchronized start", meta.StartSync.String())
+	}
+	if s := meta.Summary; s != nil && s.UpBps > 0 {
+		bw := fmt.Sprintf("up %s sent, down %s received", FormatBitrate(s.UpBps), FormatBitrate(s.DownBps))
+		if meta.TargetBps > 0 {
+			bw += ", target " + FormatBitrate(meta.TargetBps)
+		}
+		row("Bandwidth", bw)
+	}
+	if meta.Voice != nil {
+		row("VoIP quality", meta.Voice.String())
+	}
+	if meta.Clock != nil {
+		row("Clock", meta.Clock.String())
+	}
+	if meta.Load != nil {
+		row("Latency under load", fmt.Sprintf("p50 %.2fms idle, %.2fms loaded (%.0f RPM)",
+			meta.Load.IdleP50Ms, meta.Load.LoadedP50Ms, meta.Load.RPM))
+	}
+	if meta.ICMP != nil {
+		row("ICMP", fmt.Sprintf("%.2f%% loss, avg %.2fms, p99 %.2fms over %d pings",
+			meta.ICMP.LossPercent, meta.ICMP.AvgRTTMs, meta.ICMP.P99RTTMs, meta.ICMP.Sent))
+	}
+	for _, e := range meta.Events {
+		row("Event", e.String())
+	}
+	b.WriteString("    </div>\n")
+	return b.String()
+}
+
+// renderRunList renders the run-info block of a merged report, one row per run
+func renderRunList(runs []plotRun) string {
+	var b strings.Builder
+	b.WriteString("    <div class=\"run-info\">\n")
+	for i, run := range runs {
+		desc := run.File
+		if run.meta != nil {
+			desc += " - " + run.meta.Target
+			if !run.meta.StartTime.IsZero() {
+				desc += ", started " + run.meta.StartTime.Format("2006-01-02 15:04:05 MST")
+			}
+			if run.meta.RunID != "" {
+				desc += ", run " + run.meta.RunID
+			}
+		}
+		fmt.Fprintf(&b, "        <div>Run %d: <span>%s</span></div>\n", i+1, html.EscapeString(desc))
+	}
+	b.WriteString("    </div>\n")
+	return b.String()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The metrics endpoint serves the live state of a run in the Prometheus
+// text format, so long tests can be scraped and graphed while they run.
+// The format is simple enough to write by hand, which keeps the tool free
+// of dependencies.
+
+// latencyBuckets are the upper bounds of the latency histograms in seconds
+var latencyBuckets = [...]float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// histogram counts observations into latencyBuckets
+type histogram struct {
+	counts [len(latencyBuckets) + 1]uint64 // per bucket, the last one is +Inf
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(v float64) {
+	i := 0
+	for i < len(latencyBuckets) && v > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// metricLabels formats label pairs, e.g. {target="host:9999"}
+func metricLabels(pairs ...string) string {
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", pairs[i], pairs[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// writeMetricHeader writes the HELP and TYPE lines of a metric
+func writeMetricHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeMetric(w io.Writer, name, kind, help, labels string, value float64) {
+	writeMetricHeader(w, name, kind, help)
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func writeHistogram(w io.Writer, name, help, labels string, h histogram) {
+	writeMetricHeader(w, name, "histogram", help)
+	// Bucket labels go after the given ones
+	prefix := strings.TrimSuffix(labels, "}")
+	if prefix != "{" {
+		prefix += ","
+	}
+	var cumulative uint64
+	for i, count := range h.counts {
+		cumulative += count
+		le := "+Inf"
+		if i < len(latencyBuckets) {
+			le = strconv.FormatFloat(latencyBuckets[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(w, "%s_bucket%sle=%q} %d\n", name, prefix, le, cumulative)
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// WriteMetrics writes the run's counters and latency histograms
+func (s *Stats) WriteMetrics(w io.Writer, labels string) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	// Probes still within the loss timeout may yet be answered, so they
+	// aren't lost; a reply arriving later than that lowers the count
+	inFlight := s.unansweredSince(monoUnixNano(time.Now()) - s.lossTimeout().Nanoseconds())
+	lost := s.sent - s.received - min(inFlight, s.sent-s.received)
+
+	writeMetric(w, "packet_test_sent_total", "counter", "Probes sent.", labels, float64(s.sent))
+	writeMetric(w, "packet_test_received_total", "counter", "Probes answered.", labels, float64(s.received))
+	writeMetric(w, "packet_test_lost", "gauge", "Probes unanswered past the loss timeout.", labels, float64(lost))
+	writeMetric(w, "packet_test_late_total", "counter", "Replies over the late threshold.", labels, float64(s.late))
+	writeMetric(w, "packet_test_in_flight", "gauge", "Probes awaiting a reply.", labels, float64(s.outstanding))
+	writeHistogram(w, "packet_test_rtt_seconds", "Round-trip time.", labels, s.rttHist)
+	writeHistogram(w, "packet_test_net_rtt_seconds", "Round-trip time less server processing.", labels, s.netHist)
+}
+
+// observeArrival updates the arrival rate and the interarrival jitter, as
+// in RFC 3550, with a probe's send timestamp and receive time. The clock
+// offset between client and server cancels out of the transit differences.
+func (l *ReceiveLog) observeArrival(sentNs int64, recv time.Time) {
+	transit := monoUnixNano(recv) - sentNs
+	if l.FirstRecv.IsZero() {
+		l.FirstRecv = recv
+	}
+	if !l.LastRecv.IsZero() {
+		d := float64(transit - l.lastTransit)
+		if d < 0 {
+			d = -d
+		}
+		l.JitterNs += (d - l.JitterNs) / 16
+	}
+	l.lastTransit = transit
+	l.LastRecv = recv
+
+	if l.rateStart.IsZero() {
+		l.rateStart = recv
+	}
+	l.rateCount++
+	if elapsed := recv.Sub(l.rateStart); elapsed >= time.Second {
+		l.RatePPS = float64(l.rateCount) / elapsed.Seconds()
+		l.rateStart, l.rateCount = recv, 0
+	}
+}
+
+// writeServerMetrics writes per-client counters for the server, one series
+// per client address and session
+func writeServerMetrics(w io.Writer, clients map[string]*ReceiveLog) {
+	addrs := make([]string, 0, len(clients))
+	for addr := range clients {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	metrics := []struct {
+		name, kind, help string
+		value            func(l *ReceiveLog) float64
+	}{
+		{"packet_test_server_received_total", "counter", "Probes received from the client.",
+			func(l *ReceiveLog) float64 { return float64(l.Received) }},
+		{"packet_test_server_received_bytes_total", "counter", "Probe bytes received from the client.",
+			func(l *ReceiveLog) float64 { return float64(l.Bytes) }},
+		{"packet_test_server_missing", "gauge", "Probes below the client's highest sequence number that didn't arrive.",
+			func(l *ReceiveLog) float64 { return float64(l.MaxSeq - min(l.Received, l.MaxSeq)) }},
+		{"packet_test_server_receive_rate", "gauge", "Probes per second over the last full second, 0 once the client is idle.",
+			func(l *ReceiveLog) float64 {
+				if time.Since(l.LastRecv) > 2*time.Second {
+					return 0
+				}
+				return l.RatePPS
+			}},
+		{"packet_test_server_jitter_seconds", "gauge", "RFC 3550 interarrival jitter of the client's probes.",
+			func(l *ReceiveLog) float64 { return l.JitterNs / 1e9 }},
+		{"packet_test_server_last_seen_seconds", "gauge", "Unix time of the client's last probe.",
+			func(l *ReceiveLog) float64 { return float64(l.LastRecv.UnixNano()) / 1e9 }},
+	}
+	for _, m := range metrics {
+		writeMetricHeader(w, m.name, m.kind, m.help)
+		for _, addr := range addrs {
+			l := clients[addr]
+			if l.Received == 0 {
+				continue // only ever sent control packets
+			}
+			labels := metricLabels("client", addr, "run_id", l.RunID)
+			fmt.Fprintf(w, "%s%s %s\n", m.name, labels, strconv.FormatFloat(m.value(l), 'g', -1, 64))
+		}
+	}
+	writeMetric(w, "packet_test_server_clients", "gauge", "Client addresses and sessions seen since the server started.", "", float64(len(clients)))
+}
+
+// ServeMetrics serves write on /metrics at port until ctx is done
+func ServeMetrics(ctx context.Context, port int, write func(io.Writer)) error {
+	ln, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		write(w)
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("Warning: metrics endpoint stopped: %v\n", err)
+		}
+	}()
+	context.AfterFunc(ctx, func() { srv.Close() })
+	return nil
+}
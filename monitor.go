@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Monitor mode runs the client indefinitely as a series of runs that end
+// on the hour or at midnight, so each hour or day gets its own CSV and
+// report, and keeps a rolling summary of the last day. Runs are separated
+// only by the drain and setup of the next one.
+
+const (
+	monitorRate       = 10               // default probes per second, low enough to leave running
+	monitorMinSegment = 10 * time.Second // a run shorter than this is merged into the next
+	monitorRetry      = 10 * time.Second // wait after a failed run
+	monitorWindow     = 24 * time.Hour   // span of the rolling summary
+)
+
+// MonitorConfig configures continuous monitoring
+type MonitorConfig struct {
+	Client ClientConfig // settings for each run; Duration and OutputFile are set per run
+	Daily  bool         // rotate at midnight instead of every hour
+}
+
+// monitorSegment is one finished run
+type monitorSegment struct {
+	Start, End time.Time
+	File       string
+	Summary    *RunSummary
+}
+
+// nextRotation returns the first hour or day boundary after t, in local time
+func nextRotation(t time.Time, daily bool) time.Time {
+	if daily {
+		return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+}
+
+// RunMonitor runs the client until ctx is cancelled, rotating output at
+// each boundary
+func RunMonitor(ctx context.Context, cfg MonitorConfig) error {
+	prefix := strings.TrimSuffix(cfg.Client.OutputFile, ".csv")
+	if prefix == "" {
+		prefix = "packet-test-monitor"
+	}
+	summaryFile := prefix + "_summary.csv"
+	if cfg.Client.ResultsDir != "" {
+		summaryFile = filepath.Join(cfg.Client.ResultsDir, filepath.Base(summaryFile))
+	}
+	period := "hourly"
+	if cfg.Daily {
+		period = "daily"
+	}
+	fmt.Printf("Monitoring %s at %d pps, rotating %s, summaries in %s\n\n",
+		net.JoinHostPort(cfg.Client.Host, strconv.Itoa(cfg.Client.Port)), cfg.Client.Rate, period, summaryFile)
+
+	var recent []monitorSegment
+	for ctx.Err() == nil {
+		start := time.Now()
+		end := nextRotation(start, cfg.Daily)
+		if end.Sub(start) < monitorMinSegment {
+			end = nextRotation(end, cfg.Daily)
+		}
+		run := cfg.Client
+		run.Duration = int(end.Sub(start).Round(time.Second).Seconds())
+		run.OutputFile = fmt.Sprintf("%s_%s.csv", prefix, start.Format("2006-01-02_15-04-05"))
+		run.NoOpen = true // reports pile up, one per run
+
+		meta, err := RunClient(ctx, run)
+		if err != nil && !errors.Is(err, ErrSLAFailed) {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Printf("Warning: monitoring run failed: %v, retrying in %s\n", err, monitorRetry)
+			select {
+			case <-time.After(monitorRetry):
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		seg := monitorSegment{Start: start, End: time.Now(), File: run.OutputFile, Summary: meta.Summary}
+		if err := appendMonitorSummary(summaryFile, seg); err != nil {
+			fmt.Printf("Warning: failed to update %s: %v\n", summaryFile, err)
+		}
+		recent = append(recent, seg)
+		for len(recent) > 0 && seg.End.Sub(recent[0].End) > monitorWindow {
+			recent = recent[1:]
+		}
+		printMonitorSummary(seg, recent)
+	}
+	return nil
+}
+
+// printMonitorSummary prints the finished run and the rolling totals
+func printMonitorSummary(seg monitorSegment, recent []monitorSegment) {
+	s := seg.Summary
+	fmt.Printf("\n=== Monitor %s-%s: loss %.2f%% (%d of %d), avg RTT %.2fms, p99 %.2fms, jitter %.2fms ===\n",
+		seg.Start.Format("Jan 02 15:04"), seg.End.Format("15:04"),
+		s.LossPercent, s.Sent-s.Received, s.Sent, s.AvgRTTMs, s.P99RTTMs, s.JitterMs)
+
+	var sent, received uint64
+	var rttSum float64
+	worst := recent[0]
+	for _, r := range recent {
+		sent += r.Summary.Sent
+		received += r.Summary.Received
+		rttSum += r.Summary.AvgRTTMs * float64(r.Summary.Received)
+		if r.Summary.LossPercent > worst.Summary.LossPercent {
+			worst = r
+		}
+	}
+	loss, avgRTT := 0.0, 0.0
+	if sent > 0 {
+		loss = float64(sent-received) / float64(sent) * 100
+	}
+	if received > 0 {
+		avgRTT = rttSum / float64(received)
+	}
+	fmt.Printf("=== Last %s: %d runs, loss %.2f%%, avg RTT %.2fms, worst loss %.2f%% at %s ===\n\n",
+		recent[len(recent)-1].End.Sub(recent[0].Start).Round(time.Minute), len(recent),
+		loss, avgRTT, worst.Summary.LossPercent, worst.Start.Format("Jan 02 15:04"))
+}
+
+// appendMonitorSummary adds a run to the summary CSV, creating it with a
+// header if needed
+func appendMonitorSummary(filename string, seg monitorSegment) error {
+	_, statErr := os.Stat(filename)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if os.IsNotExist(statErr) {
+		writer.Write([]string{"start", "end", "file", "sent", "received", "loss_percent", "avg_rtt_ms", "p99_rtt_ms", "jitter_ms"})
+	}
+	s := seg.Summary
+	writer.Write([]string{
+		seg.Start.Format(time.RFC3339),
+		seg.End.Format(time.RFC3339),
+		seg.File,
+		strconv.FormatUint(s.Sent, 10),
+		strconv.FormatUint(s.Received, 10),
+		fmt.Sprintf("%.3f", s.LossPercent),
+		fmt.Sprintf("%.3f", s.AvgRTTMs),
+		fmt.Sprintf("%.3f", s.P99RTTMs),
+		fmt.Sprintf("%.3f", s.JitterMs),
+	})
+	writer.Flush()
+	return writer.Error()
+}
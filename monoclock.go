@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Client timestamps are Unix nanoseconds taken from the monotonic clock,
+// anchored to the wall clock once at startup, so a wall-clock step mid-run
+// (an NTP correction, a manual change) can't make latencies jump or go
+// negative. Steps are still detected and recorded as events, since they
+// shift the timestamps against the server's clock.
+
+// monoBase anchors monotonic timestamps to the wall clock
+var monoBase = time.Now()
+
+// clockStepThreshold is the smallest jump of the wall clock against the
+// monotonic one that counts as a step; slewing moves both together
+const clockStepThreshold = 10 * time.Millisecond
+
+// monoUnixNano returns t in Unix nanoseconds as the monotonic clock has
+// it, for times taken with time.Now in this process
+func monoUnixNano(t time.Time) int64 {
+	return monoBase.UnixNano() + int64(t.Sub(monoBase))
+}
+
+// clockWatch records steps of the wall clock during a run
+type clockWatch struct {
+	mu     sync.Mutex
+	events []RunEvent
+}
+
+// watchClockSteps checks the wall clock against the monotonic one every
+// interval until ctx is done; seq gives the last probe sent at a step
+func watchClockSteps(ctx context.Context, interval time.Duration, seq func() uint64) *clockWatch {
+	w := &clockWatch{}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		start := time.Now()
+		last := start.UnixNano() - monoUnixNano(start)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			now := time.Now()
+			skew := now.UnixNano() - monoUnixNano(now)
+			step := time.Duration(skew - last)
+			last = skew
+			if step.Abs() < clockStepThreshold {
+				continue
+			}
+			s := seq()
+			fmt.Printf("Warning: wall clock stepped %+.1fms at seq %d; latencies use the monotonic clock and are unaffected\n",
+				float64(step)/float64(time.Millisecond), s)
+			w.mu.Lock()
+			w.events = append(w.events, RunEvent{
+				Kind:     EventClockStep,
+				Time:     now,
+				StartSeq: s,
+				EndSeq:   s,
+				Detail:   fmt.Sprintf("wall clock stepped %+.1fms", float64(step)/float64(time.Millisecond)),
+			})
+			w.mu.Unlock()
+		}
+	}()
+	return w
+}
+
+// Events returns the steps seen so far
+func (w *clockWatch) Events() []RunEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]RunEvent(nil), w.events...)
+}
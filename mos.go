@@ -0,0 +1,54 @@
+package main
+
+import "fmt"
+
+// Voice quality is estimated with the simplified E-model (ITU-T G.107) as
+// commonly applied to network measurements: the R-factor starts from the
+// default 93.2, loses a little per millisecond of effective one-way delay
+// up to 160ms and much more beyond, and 2.5 points per percent of loss.
+// Effective delay is half the RTT plus twice the RFC 3550 jitter, which a
+// jitter buffer has to absorb, plus 10ms for the codec.
+
+const (
+	eModelBaseR      = 93.2
+	eModelCodecMs    = 10
+	eModelKneeMs     = 160 // delay impairment grows steeply past this
+	eModelLossFactor = 2.5 // R points per percent loss
+)
+
+// VoiceQuality is an E-model estimate of call quality on the path
+type VoiceQuality struct {
+	RFactor float64 `json:"r_factor"`
+	MOS     float64 `json:"mos"`
+	Rating  string  `json:"rating"` // G.109 category
+}
+
+func (q *VoiceQuality) String() string {
+	return fmt.Sprintf("R-factor %.1f, MOS %.2f (%s)", q.RFactor, q.MOS, q.Rating)
+}
+
+// EstimateVoiceQuality computes the R-factor and MOS from a run's loss,
+// RTT and jitter
+func EstimateVoiceQuality(sum *RunSummary) *VoiceQuality {
+	delay := sum.AvgRTTMs/2 + 2*sum.RFCJitterMs + eModelCodecMs
+	r := eModelBaseR - delay/40
+	if delay >= eModelKneeMs {
+		r = eModelBaseR - (delay-120)/10
+	}
+	r = min(max(r-eModelLossFactor*sum.LossPercent, 0), 100)
+
+	q := &VoiceQuality{RFactor: r, MOS: 1 + 0.035*r + 7e-6*r*(r-60)*(100-r)}
+	switch {
+	case r >= 90:
+		q.Rating = "best"
+	case r >= 80:
+		q.Rating = "high"
+	case r >= 70:
+		q.Rating = "medium"
+	case r >= 60:
+		q.Rating = "low"
+	default:
+		q.Rating = "poor"
+	}
+	return q
+}
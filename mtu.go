@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An MTU sweep finds the largest packet that crosses the path whole.
+// Probes of increasing size go out with DF set, so a router that can't
+// forward one drops it instead of fragmenting it, and the largest size the
+// server still answers is the usable path MTU towards it. Replies are
+// header-sized so the return path doesn't limit the result. Sizes are IP
+// packet sizes, as MTUs are quoted.
+
+const (
+	mtuProbesPerSize = 5
+	mtuProbeInterval = 20 * time.Millisecond
+	mtuReplyWait     = time.Second // after the last probe of a size
+	mtuStopAfter     = 3           // consecutive sizes with nothing through that end the sweep
+	udpHeaderSize    = 8
+)
+
+// MTUConfig configures a path MTU sweep
+type MTUConfig struct {
+	Host       string
+	Port       int
+	Family     string // "IPv4" or "IPv6" to use only that family, "" = either
+	Min        int    // IP packet size in bytes
+	Max        int
+	Step       int
+	OutputFile string
+	Key        string // shared secret the server trusts, see auth.go
+}
+
+// MTUStep is the outcome of one probe size
+type MTUStep struct {
+	Size       int // IP packet size
+	Sent       int
+	Received   int
+	SendErrors int // refused locally, e.g. larger than the interface MTU
+}
+
+// LossPercent returns the share of probes of this size that got no reply
+func (s MTUStep) LossPercent() float64 {
+	return lossPercent(s.Sent-s.Received, s.Sent)
+}
+
+// ParseMTURange parses sizes given as min:max:step in bytes
+func ParseMTURange(s string) (lo, hi, step int, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("invalid MTU range %q, expected min:max:step", s)
+	}
+	vals := make([]int, 3)
+	for i, p := range parts {
+		if vals[i], err = strconv.Atoi(strings.TrimSpace(p)); err != nil || vals[i] <= 0 {
+			return 0, 0, 0, fmt.Errorf("invalid MTU range %q, expected positive sizes in bytes", s)
+		}
+	}
+	if vals[1] < vals[0] {
+		return 0, 0, 0, fmt.Errorf("invalid MTU range %q, max is below min", s)
+	}
+	return vals[0], vals[1], vals[2], nil
+}
+
+// RunMTUSweep sends DF-marked probes from Min to Max bytes and reports the
+// largest size that got through
+func RunMTUSweep(ctx context.Context, cfg MTUConfig) error {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	conn, err := net.Dial(udpNetwork(cfg.Family), addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	ipv6 := conn.RemoteAddr().(*net.UDPAddr).IP.To4() == nil
+	ipHeader := 20
+	if ipv6 {
+		ipHeader = 40
+	}
+	if smallest := HeaderSize + udpHeaderSize + ipHeader; cfg.Min < smallest {
+		return fmt.Errorf("MTU sweep sizes must be at least %d bytes to carry a probe", smallest)
+	}
+	if err := setDontFragment(conn, ipv6); err != nil {
+		return fmt.Errorf("failed to set DF: %w", err)
+	}
+	session := newSession(newRunID(), cfg.Key)
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	fmt.Printf("Sweeping DF-marked probes to %s from %d to %d bytes in steps of %d, %d probes per size\n\n",
+		addr, cfg.Min, cfg.Max, cfg.Step, mtuProbesPerSize)
+	fmt.Printf("%8s %8s %6s %6s %8s\n", "size", "payload", "sent", "recv", "loss")
+
+	var steps []MTUStep
+	best, failed := -1, 0
+	var seq uint64
+	buf := make([]byte, 65535)
+	for size := cfg.Min; size <= cfg.Max && ctx.Err() == nil; size += cfg.Step {
+		step := MTUStep{Size: size}
+		payload := size - ipHeader - udpHeaderSize
+		first := seq + 1
+		for i := 0; i < mtuProbesPerSize && ctx.Err() == nil; i++ {
+			seq++
+			pkt := session.stamp(NewPacket(seq, payload, monoUnixNano(time.Now())))
+			pkt.ReplySize = HeaderSize
+			step.Sent++
+			if _, err := conn.Write(pkt.Encode(payload)); err != nil {
+				step.SendErrors++
+			}
+			select {
+			case <-time.After(mtuProbeInterval):
+			case <-ctx.Done():
+			}
+		}
+
+		got := make([]bool, step.Sent)
+		conn.SetReadDeadline(time.Now().Add(mtuReplyWait))
+		for step.Received < step.Sent-step.SendErrors {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if errors.Is(err, os.ErrDeadlineExceeded) || ctx.Err() != nil {
+					break
+				}
+				continue // ICMP errors for earlier probes surface here
+			}
+			if err := versionError(buf[:n]); err != nil {
+				return err
+			}
+			pkt := DecodePacket(buf[:n])
+			if pkt == nil || pkt.Type != TypeProbe || pkt.Session != session.ID || pkt.SeqNum < first || pkt.SeqNum > seq {
+				continue // late reply to a smaller size
+			}
+			if i := pkt.SeqNum - first; !got[i] {
+				got[i] = true
+				step.Received++
+			}
+		}
+		conn.SetReadDeadline(time.Time{})
+		if ctx.Err() != nil {
+			break
+		}
+
+		steps = append(steps, step)
+		note := ""
+		if step.SendErrors == step.Sent {
+			note = "  refused locally"
+		} else if step.SendErrors > 0 {
+			note = fmt.Sprintf("  %d send errors", step.SendErrors)
+		}
+		fmt.Printf("%8d %8d %6d %6d %7.2f%%%s\n", size, payload, step.Sent, step.Received, step.LossPercent(), note)
+
+		if step.Received > 0 {
+			best, failed = len(steps)-1, 0
+		} else if failed++; failed >= mtuStopAfter {
+			fmt.Printf("Stopping: nothing got through at %d sizes in a row\n", failed)
+			break
+		}
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+
+	switch {
+	case best < 0:
+		fmt.Printf("\nNothing got through, down to %d bytes\n", cfg.Min)
+	case best == len(steps)-1:
+		fmt.Printf("\nEvery size got through up to %d bytes (%d byte UDP payload); the path MTU may be larger\n",
+			steps[best].Size, steps[best].Size-ipHeader-udpHeaderSize)
+	default:
+		fmt.Printf("\nLargest size through: %d bytes (%d byte UDP payload), nothing at %d bytes\n",
+			steps[best].Size, steps[best].Size-ipHeader-udpHeaderSize, steps[best+1].Size)
+	}
+
+	outputFile := cfg.OutputFile
+	if outputFile == "" {
+		outputFile = fmt.Sprintf("packet-test-mtu_%s.csv", time.Now().Format("2006-01-02_15-04-05"))
+	}
+	if err := saveMTUCSV(outputFile, steps, ipHeader); err != nil {
+		return fmt.Errorf("failed to save CSV: %w", err)
+	}
+	fmt.Printf("Results saved to %s\n", outputFile)
+	return nil
+}
+
+func saveMTUCSV(filename string, steps []MTUStep, ipHeader int) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+
+	writer.Write([]string{"size", "udp_payload", "sent", "received", "send_errors", "loss_percent"})
+	for _, s := range steps {
+		writer.Write([]string{
+			strconv.Itoa(s.Size),
+			strconv.Itoa(s.Size - ipHeader - udpHeaderSize),
+			strconv.Itoa(s.Sent),
+			strconv.Itoa(s.Received),
+			strconv.Itoa(s.SendErrors),
+			fmt.Sprintf("%.2f", s.LossPercent()),
+		})
+	}
+	return nil
+}
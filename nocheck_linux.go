@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// disableChecksum makes the socket send IPv4 datagrams with a zero UDP
+// checksum (SO_NO_CHECK). IPv6 requires the checksum, so callers only use
+// it on IPv4 sockets.
+func disableChecksum(conn net.Conn) error {
+	uc, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_NO_CHECK, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
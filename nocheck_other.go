@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// Sending without a UDP checksum needs SO_NO_CHECK, which only Linux has
+func disableChecksum(conn net.Conn) error {
+	return errors.New("zero UDP checksum is only supported on Linux")
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// NotifyConfig selects when the client raises a desktop notification, so
+// long runs don't need watching
+type NotifyConfig struct {
+	OnFinish bool    // when the run ends
+	Bell     bool    // also ring the terminal bell
+	MaxLoss  float64 // window loss percent that counts as a breach, 0 = off
+	MaxRTT   float64 // window average RTT in ms that counts as a breach, 0 = off
+}
+
+// notifier raises notifications for a run. A breach is reported once when
+// it starts and again when the link recovers, not on every window.
+type notifier struct {
+	cfg       NotifyConfig
+	breaching bool
+}
+
+func newNotifier(cfg NotifyConfig) *notifier {
+	return &notifier{cfg: cfg}
+}
+
+// CheckWindow compares an interval window against the breach thresholds
+func (n *notifier) CheckWindow(w *WindowStats) {
+	if w == nil || (n.cfg.MaxLoss <= 0 && n.cfg.MaxRTT <= 0) {
+		return
+	}
+	var breaches []string
+	if n.cfg.MaxLoss > 0 && w.LossPercent > n.cfg.MaxLoss {
+		breaches = append(breaches, fmt.Sprintf("loss %.1f%% (limit %.1f%%)", w.LossPercent, n.cfg.MaxLoss))
+	}
+	if n.cfg.MaxRTT > 0 && w.AvgRTTMs > n.cfg.MaxRTT {
+		breaches = append(breaches, fmt.Sprintf("RTT %.0fms (limit %.0fms)", w.AvgRTTMs, n.cfg.MaxRTT))
+	}
+
+	switch {
+	case len(breaches) > 0 && !n.breaching:
+		n.send("packet-test: threshold breached", fmt.Sprintf("At %ds: %s", w.Seconds, strings.Join(breaches, ", ")))
+	case len(breaches) == 0 && n.breaching:
+		n.send("packet-test: recovered", fmt.Sprintf("Back within thresholds at %ds", w.Seconds))
+	}
+	n.breaching = len(breaches) > 0
+}
+
+// Finished reports the end of the run with its headline numbers
+func (n *notifier) Finished(target string, sum *RunSummary) {
+	if !n.cfg.OnFinish {
+		return
+	}
+	n.send("packet-test finished", fmt.Sprintf("%s: %d sent, %.2f%% lost, avg RTT %.1fms, p99 %.1fms",
+		target, sum.Sent, sum.LossPercent, sum.AvgRTTMs, sum.P99RTTMs))
+}
+
+// send rings the bell if asked and shows a desktop notification through
+// the platform's usual tool. It doesn't wait for the tool, and failures are
+// only reported on the console since the run itself is unaffected.
+func (n *notifier) send(title, message string) {
+	if n.cfg.Bell {
+		fmt.Print("\a")
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", message, title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "linux":
+		cmd = exec.Command("notify-send", title, message)
+	case "windows":
+		quote := func(s string) string { return "'" + strings.ReplaceAll(s, "'", "''") + "'" }
+		script := "Add-Type -AssemblyName System.Windows.Forms; " +
+			"$n = New-Object System.Windows.Forms.NotifyIcon; " +
+			"$n.Icon = [System.Drawing.SystemIcons]::Information; $n.Visible = $true; " +
+			"$n.ShowBalloonTip(10000, " + quote(title) + ", " + quote(message) + ", 'Info'); " +
+			"Start-Sleep -Seconds 10; $n.Dispose()"
+		cmd = exec.Command("powershell", "-NoProfile", "-Command", script)
+	default:
+		fmt.Printf("%s: %s\n", title, message)
+		return
+	}
+
+	if err := cmd.Start(); err != nil {
+		fmt.Printf("Warning: desktop notification failed: %v\n", err)
+		return
+	}
+	go cmd.Wait()
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// maxCatchUpWindow caps how far behind schedule the sender will catch up
+// with back-to-back sends; older slots are counted as missed instead
+const maxCatchUpWindow = 100 * time.Millisecond
+
+// maxRate is the highest rate a pacer can run at, a packet a nanosecond;
+// above it the interval between slots rounds down to zero
+const maxRate = int(time.Second)
+
+// checkRate returns an error unless a pacer can run at rate packets per
+// second
+func checkRate(name string, rate int) error {
+	if rate < 1 || rate > maxRate {
+		return fmt.Errorf("%s must be between 1 and %d packets per second", name, maxRate)
+	}
+	return nil
+}
+
+// Send patterns: the gaps between send slots are the interval exactly,
+// drawn from an exponential distribution with the interval as its mean
+// (Poisson sampling, RFC 2330), or uniform within half an interval either
+// side of it. Probes on a strict grid can alias with periodic behaviour on
+// the path, e.g. WiFi power-save wakeups, and see it always or never.
+const (
+	PatternFixed    = "fixed"
+	PatternPoisson  = "poisson"
+	PatternJittered = "jittered"
+)
+
+// ParsePattern checks a --pattern value
+func ParsePattern(s string) (string, error) {
+	switch s {
+	case PatternFixed, PatternPoisson, PatternJittered:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid pattern %q, expected fixed, poisson or jittered", s)
+}
+
+// A timer can fire well after its time, by tens of microseconds on an
+// idle machine and by milliseconds on a busy VM. The sender wakes early by
+// how late its timer has been firing, between these bounds, and busy-waits
+// the rest of the way to a slot.
+const (
+	minSpin = 50 * time.Microsecond
+	maxSpin = 2 * time.Millisecond
+)
+
+// pacer schedules send slots at absolute times, the nth slot at start + n
+// intervals, so timer latency never accumulates into drift. A slot the
+// sender can't take on time is either sent late, back-to-back with the
+// next, or skipped and counted as missed.
+//
+// With a random pattern each slot follows the last by a random gap.
+// Catching up would send the slots back-to-back and undo the pattern, so a
+// slot the sender isn't ready for is dropped and counted as missed.
+type pacer struct {
+	start    time.Time
+	interval time.Duration
+	catchUp  bool
+	pattern  string
+
+	slots    uint64        // slots accounted for by due
+	caughtUp atomic.Uint64 // slots sent late, back-to-back
+	missed   atomic.Uint64 // slots skipped without sending
+}
+
+func newPacer(interval time.Duration, catchUp bool) *pacer {
+	return &pacer{
+		start:    time.Now(),
+		interval: interval,
+		catchUp:  catchUp,
+	}
+}
+
+// slotSleeper waits for send slots, learning how early to wake
+type slotSleeper struct {
+	timer *time.Timer
+	slack time.Duration // how late the timer has been firing
+}
+
+func newSlotSleeper() *slotSleeper {
+	timer := time.NewTimer(0)
+	timer.Stop()
+	return &slotSleeper{timer: timer, slack: minSpin}
+}
+
+// until waits for t, on the timer for most of the way and spinning for
+// the rest. It returns false if done is closed first.
+func (s *slotSleeper) until(t time.Time, done <-chan struct{}) bool {
+	if d := time.Until(t) - s.slack; d > 0 {
+		wake := time.Now().Add(d)
+		s.timer.Reset(d)
+		select {
+		case <-done:
+			return false
+		case <-s.timer.C:
+		}
+		// Quick to widen after a late wakeup, slow to narrow again
+		late := time.Since(wake)
+		if late > s.slack {
+			s.slack = min(late+late/4, maxSpin)
+		} else {
+			s.slack = max(s.slack-(s.slack-late)/16, minSpin)
+		}
+	}
+	for time.Now().Before(t) {
+		runtime.Gosched()
+	}
+	return true
+}
+
+func (s *slotSleeper) stop() { s.timer.Stop() }
+
+// ticks starts delivering send slots in the given pattern, each as the
+// time it was due. The returned function stops them.
+func (p *pacer) ticks(pattern string) (<-chan time.Time, func()) {
+	p.pattern = pattern
+	done := make(chan struct{})
+	sleep := newSlotSleeper()
+
+	if pattern == PatternFixed || pattern == "" {
+		c := make(chan time.Time)
+		go func() {
+			defer sleep.stop()
+			for n := time.Duration(1); ; n++ {
+				target := p.start.Add(n * p.interval)
+				if late := time.Since(target); late >= p.interval {
+					due := late/p.interval + 1
+					keep := time.Duration(1)
+					if p.catchUp {
+						keep = min(due, max(maxCatchUpWindow/p.interval, 1))
+					}
+					p.missed.Add(uint64(due - keep))
+					n += due - keep
+					target = p.start.Add(n * p.interval)
+				}
+				if !sleep.until(target, done) {
+					return
+				}
+				// The next slot is already due, so this one goes out
+				// back-to-back with it
+				if time.Since(target) >= p.interval {
+					p.caughtUp.Add(1)
+				}
+				select {
+				case <-done:
+					return
+				case c <- target:
+				}
+			}
+		}()
+		return c, func() { close(done) }
+	}
+
+	c := make(chan time.Time, 1)
+	go func() {
+		defer sleep.stop()
+		next := time.Now()
+		for {
+			next = next.Add(p.gap())
+			// Resume from now after a stall rather than firing the
+			// backlog at once
+			if now := time.Now(); now.Sub(next) > maxCatchUpWindow {
+				next = now
+			}
+			if !sleep.until(next, done) {
+				return
+			}
+			select {
+			case <-done:
+				return
+			case c <- next:
+			default:
+				p.missed.Add(1)
+			}
+		}
+	}()
+	return c, func() { close(done) }
+}
+
+// gap draws the time to the next slot of a random pattern
+func (p *pacer) gap() time.Duration {
+	if p.pattern == PatternPoisson {
+		return time.Duration(rand.ExpFloat64() * float64(p.interval))
+	}
+	return time.Duration((0.5 + rand.Float64()) * float64(p.interval))
+}
+
+// due returns how many slots to send for a time.Ticker tick at now, for
+// bulk senders that don't need precise slots. The ticker drops ticks when
+// the sender falls behind, so each tick works out how many slots are
+// actually due and either catches up or records the shortfall.
+func (p *pacer) due(now time.Time) int {
+	expected := uint64(now.Sub(p.start) / p.interval)
+	behind := uint64(1)
+	if expected > p.slots {
+		behind = expected - p.slots
+	}
+	p.slots += behind
+
+	if behind == 1 {
+		return 1
+	}
+
+	if !p.catchUp {
+		p.missed.Add(behind - 1)
+		return 1
+	}
+
+	limit := max(uint64(maxCatchUpWindow/p.interval), 1)
+	if behind > limit {
+		p.missed.Add(behind - limit)
+		behind = limit
+	}
+	p.caughtUp.Add(behind - 1)
+	return int(behind)
+}
+
+// PrintSummary reports achieved vs configured rate for the send window
+// and how far sends were from their slots, from sched (p50, p99 and max
+// in microseconds)
+func (p *pacer) PrintSummary(sent uint64, targetRate int, end time.Time, sched [3]float64) {
+	elapsed := end.Sub(p.start).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	achieved := float64(sent) / elapsed
+	if p.pattern != PatternFixed && p.pattern != "" {
+		fmt.Printf("Send rate: %.1f pps achieved of %d configured, %s gaps (%d slots missed)\n",
+			achieved, targetRate, p.pattern, p.missed.Load())
+	} else {
+		fmt.Printf("Send rate: %.1f pps achieved of %d configured (%d slots caught up, %d missed)\n",
+			achieved, targetRate, p.caughtUp.Load(), p.missed.Load())
+	}
+	fmt.Printf("Schedule error: p50=%.0fus p99=%.0fus max=%.0fus after the slot each probe was due\n", sched[0], sched[1], sched[2])
+	// Random gaps only average out to the rate over a long run. The first
+	// slot is an interval after the start, so only whole intervals were
+	// due, and a slot cut off at the end isn't a shortfall.
+	if p.pattern == PatternFixed || p.pattern == "" {
+		perSlot := float64(targetRate) * p.interval.Seconds()
+		due := math.Floor(elapsed/p.interval.Seconds()) * perSlot
+		if shortfall := 1 - float64(sent)/due; due-float64(sent) > perSlot && shortfall > 0.01 {
+			fmt.Printf("Warning: sent %.1f%% below the configured rate; the client couldn't keep up\n", shortfall*100)
+		}
+	}
+}
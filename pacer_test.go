@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParsePattern(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{PatternFixed, false},
+		{PatternPoisson, false},
+		{PatternJittered, false},
+		{"", true},
+		{"Fixed", true},
+		{"random", true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePattern(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePattern(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+		}
+		if err == nil && got != tt.in {
+			t.Errorf("ParsePattern(%q) = %q", tt.in, got)
+		}
+	}
+}
+
+func TestPacerDue(t *testing.T) {
+	const interval = 10 * time.Millisecond // catches up at most 10 slots
+	tests := []struct {
+		name     string
+		catchUp  bool
+		slots    uint64 // slots already accounted for
+		elapsed  int    // intervals since the start at the tick
+		want     int
+		caughtUp uint64
+		missed   uint64
+	}{
+		{name: "on time", catchUp: true, elapsed: 1, want: 1},
+		{name: "early tick", catchUp: true, slots: 3, elapsed: 3, want: 1},
+		{name: "catch up", catchUp: true, elapsed: 5, want: 5, caughtUp: 4},
+		{name: "catch up capped", catchUp: true, elapsed: 25, want: 10, caughtUp: 9, missed: 15},
+		{name: "no catch up", elapsed: 5, want: 1, missed: 4},
+		{name: "behind after earlier slots", catchUp: true, slots: 10, elapsed: 13, want: 3, caughtUp: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPacer(interval, tt.catchUp)
+			p.slots = tt.slots
+			got := p.due(p.start.Add(time.Duration(tt.elapsed) * interval))
+			if got != tt.want {
+				t.Errorf("due = %d, want %d", got, tt.want)
+			}
+			if p.caughtUp.Load() != tt.caughtUp || p.missed.Load() != tt.missed {
+				t.Errorf("caught up %d, missed %d, want %d and %d", p.caughtUp.Load(), p.missed.Load(), tt.caughtUp, tt.missed)
+			}
+		})
+	}
+}
+
+func TestCheckRate(t *testing.T) {
+	for rate, ok := range map[int]bool{-1: false, 0: false, 1: true, maxRate: true, maxRate + 1: false} {
+		if err := checkRate("rate", rate); (err == nil) != ok {
+			t.Errorf("checkRate(%d) = %v, want ok %v", rate, err, ok)
+		}
+		if ok && time.Second/time.Duration(rate) == 0 {
+			t.Errorf("rate %d passes with a zero interval", rate)
+		}
+	}
+}
@@ -2,52 +2,159 @@ package main
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 )
 
+// Every packet starts with a magic value and the protocol version, so
+// stray traffic on the port isn't mistaken for probes or replies and a
+// client and server of different versions can tell instead of misreading
+// each other. The layout after the version may change between versions;
+// the magic and version never move.
 const (
-	SeqNumSize    = 8
-	TimestampSize = 8
-	ProcTimeSize  = 8
-	HeaderSize    = SeqNumSize + TimestampSize + ProcTimeSize
+	Magic           uint16 = 0x7074 // "pt"
+	ProtocolVersion uint8  = 2
+)
+
+// ErrVersionMismatch is returned when the server speaks another protocol
+// version
+var ErrVersionMismatch = errors.New("protocol version mismatch")
+
+const (
+	MagicSize      = 2
+	VersionSize    = 1
+	SeqNumSize     = 8
+	TimestampSize  = 8
+	ProcTimeSize   = 8
+	ReplySizeSize  = 2
+	ReplyCountSize = 2
+	TypeSize       = 1
+	ServerRecvSize = 8
+	SessionSize    = 4
+	AuthSize       = 8
+	HeaderSize     = MagicSize + VersionSize + SeqNumSize + TimestampSize + ProcTimeSize + ReplySizeSize + ReplyCountSize + TypeSize + ServerRecvSize + SessionSize + AuthSize
+
+	// MaxPacketSize is the largest UDP payload that fits in an IPv4 datagram
+	MaxPacketSize = 65507
+)
+
+// Header field offsets
+const (
+	magicOffset      = 0
+	versionOffset    = magicOffset + MagicSize
+	seqOffset        = versionOffset + VersionSize
+	timestampOffset  = seqOffset + SeqNumSize
+	procTimeOffset   = timestampOffset + TimestampSize
+	replySizeOffset  = procTimeOffset + ProcTimeSize
+	replyCountOffset = replySizeOffset + ReplySizeSize
+	typeOffset       = replyCountOffset + ReplyCountSize
+	serverRecvOffset = typeOffset + TypeSize
+	sessionOffset    = serverRecvOffset + ServerRecvSize
+	authOffset       = sessionOffset + SessionSize
+)
+
+// Packet types
+const (
+	TypeProbe         uint8 = iota // Measurement packet, echoed ReplyCount times
+	TypeReportRequest              // Client asks for the server's receive report
+	TypeReport                     // Server's receive report
+	TypeHello                      // Address probe or run ID announcement before the test, echoed unchanged
+	TypeBarrier                    // Synchronized start handshake, see barrier.go
+	TypeStreamRequest              // Client asks for (or keeps alive) a downlink stream, see downlink.go
+	TypeStream                     // Downlink stream packet from the server
+	TypeClockSync                  // Clock offset exchange, echoed with the server's stamps, see clocksync.go
+	TypeLoad                       // Bulk load alongside the probes, counted but never answered, see load.go
 )
 
 // Packet represents a UDP test packet
 type Packet struct {
-	SeqNum        uint64
-	Timestamp     int64 // Unix nanoseconds (client send time)
-	ServerProcNs  int64 // Server processing duration in nanoseconds
-	Payload       []byte
+	SeqNum       uint64
+	Timestamp    int64  // Unix nanoseconds (client send time)
+	ServerProcNs int64  // Server processing duration in nanoseconds
+	ReplySize    uint16 // Requested reply size in bytes, 0 = same as request
+	ReplyCount   uint16 // Replies requested (client to server) or reply index starting at 1 (server to client)
+	Type         uint8
+	ServerRecvNs int64  // Server wall clock when the request arrived, Unix nanoseconds, 0 = not stamped
+	Session      uint32 // Client session the server tracks the packet under, 0 = by address only
+	Auth         uint64 // Session token from the shared key, 0 = none, see auth.go
+	Payload      []byte
 }
 
 // Encode serializes the packet into bytes
 func (p *Packet) Encode(size int) []byte {
 	buf := make([]byte, size)
-	binary.BigEndian.PutUint64(buf[0:8], p.SeqNum)
-	binary.BigEndian.PutUint64(buf[8:16], uint64(p.Timestamp))
-	binary.BigEndian.PutUint64(buf[16:24], uint64(p.ServerProcNs))
+	binary.BigEndian.PutUint16(buf[magicOffset:], Magic)
+	buf[versionOffset] = ProtocolVersion
+	binary.BigEndian.PutUint64(buf[seqOffset:], p.SeqNum)
+	binary.BigEndian.PutUint64(buf[timestampOffset:], uint64(p.Timestamp))
+	binary.BigEndian.PutUint64(buf[procTimeOffset:], uint64(p.ServerProcNs))
+	binary.BigEndian.PutUint16(buf[replySizeOffset:], p.ReplySize)
+	binary.BigEndian.PutUint16(buf[replyCountOffset:], p.ReplyCount)
+	buf[typeOffset] = p.Type
+	binary.BigEndian.PutUint64(buf[serverRecvOffset:], uint64(p.ServerRecvNs))
+	binary.BigEndian.PutUint32(buf[sessionOffset:], p.Session)
+	binary.BigEndian.PutUint64(buf[authOffset:], p.Auth)
 	// Rest is padding (zeros)
 	return buf
 }
 
-// DecodePacket deserializes bytes into a Packet
+// DecodePacket deserializes bytes into a Packet. It returns nil for
+// anything that isn't a packet of this protocol version.
 func DecodePacket(data []byte) *Packet {
-	if len(data) < HeaderSize {
+	if v, ok := packetVersion(data); !ok || v != ProtocolVersion || len(data) < HeaderSize {
 		return nil
 	}
 	return &Packet{
-		SeqNum:       binary.BigEndian.Uint64(data[0:8]),
-		Timestamp:    int64(binary.BigEndian.Uint64(data[8:16])),
-		ServerProcNs: int64(binary.BigEndian.Uint64(data[16:24])),
+		SeqNum:       binary.BigEndian.Uint64(data[seqOffset:]),
+		Timestamp:    int64(binary.BigEndian.Uint64(data[timestampOffset:])),
+		ServerProcNs: int64(binary.BigEndian.Uint64(data[procTimeOffset:])),
+		ReplySize:    binary.BigEndian.Uint16(data[replySizeOffset:]),
+		ReplyCount:   binary.BigEndian.Uint16(data[replyCountOffset:]),
+		Type:         data[typeOffset],
+		ServerRecvNs: int64(binary.BigEndian.Uint64(data[serverRecvOffset:])),
+		Session:      binary.BigEndian.Uint32(data[sessionOffset:]),
+		Auth:         binary.BigEndian.Uint64(data[authOffset:]),
 		Payload:      data[HeaderSize:],
 	}
 }
 
-// NewPacket creates a new packet with the provided timestamp
+// packetVersion returns the protocol version of a datagram, or false if it
+// doesn't start with the magic
+func packetVersion(data []byte) (uint8, bool) {
+	if len(data) < MagicSize+VersionSize || binary.BigEndian.Uint16(data[magicOffset:]) != Magic {
+		return 0, false
+	}
+	return data[versionOffset], true
+}
+
+// encodeVersionNotice builds the server's answer to a packet of another
+// protocol version: only the magic and its own version, which any version
+// can read
+func encodeVersionNotice() []byte {
+	buf := make([]byte, MagicSize+VersionSize)
+	binary.BigEndian.PutUint16(buf[magicOffset:], Magic)
+	buf[versionOffset] = ProtocolVersion
+	return buf
+}
+
+// versionError returns an error if data is the server's notice that it
+// speaks another protocol version, nil otherwise
+func versionError(data []byte) error {
+	v, ok := packetVersion(data)
+	if !ok || v == ProtocolVersion || len(data) != MagicSize+VersionSize {
+		return nil
+	}
+	return fmt.Errorf("%w: server speaks version %d, this client %d", ErrVersionMismatch, v, ProtocolVersion)
+}
+
+// NewPacket creates a new packet with the provided timestamp that asks for
+// a single same-size reply
 func NewPacket(seqNum uint64, size int, timestamp int64) *Packet {
 	return &Packet{
 		SeqNum:       seqNum,
 		Timestamp:    timestamp,
 		ServerProcNs: 0,
+		ReplyCount:   1,
 		Payload:      make([]byte, size-HeaderSize),
 	}
 }
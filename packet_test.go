@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		pkt  Packet
+		size int
+	}{
+		{"probe", Packet{SeqNum: 1, Timestamp: 1700000000123456789, ReplyCount: 1, Type: TypeProbe}, HeaderSize},
+		{"asymmetric reply", Packet{SeqNum: 42, ReplySize: 1400, ReplyCount: 3, Type: TypeProbe}, 64},
+		{"largest reply", Packet{SeqNum: 1<<64 - 1, ReplySize: MaxPacketSize, ReplyCount: 1<<16 - 1}, HeaderSize},
+		{"server stamps", Packet{SeqNum: 7, ServerProcNs: 12345, ServerRecvNs: -1, ReplyCount: 2}, HeaderSize},
+		{"session", Packet{Type: TypeHello, Session: 0xdeadbeef, Auth: 0x0123456789abcdef}, HeaderSize + 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := tt.pkt.Encode(tt.size)
+			if len(buf) != tt.size {
+				t.Fatalf("encoded %d bytes, want %d", len(buf), tt.size)
+			}
+			got := DecodePacket(buf)
+			if got == nil {
+				t.Fatal("DecodePacket returned nil")
+			}
+			if len(got.Payload) != tt.size-HeaderSize {
+				t.Errorf("payload %d bytes, want %d", len(got.Payload), tt.size-HeaderSize)
+			}
+			got.Payload = nil
+			if !reflect.DeepEqual(*got, tt.pkt) {
+				t.Errorf("decoded %+v, want %+v", *got, tt.pkt)
+			}
+		})
+	}
+}
+
+func TestPacketReplyFieldsOnWire(t *testing.T) {
+	buf := (&Packet{ReplySize: 0x0102, ReplyCount: 0x0304}).Encode(HeaderSize)
+	if got := binary.BigEndian.Uint16(buf[replySizeOffset:]); got != 0x0102 {
+		t.Errorf("reply size on the wire = %#x, want 0x0102", got)
+	}
+	if got := binary.BigEndian.Uint16(buf[replyCountOffset:]); got != 0x0304 {
+		t.Errorf("reply count on the wire = %#x, want 0x0304", got)
+	}
+	if replyCountOffset != replySizeOffset+ReplySizeSize || typeOffset != replyCountOffset+ReplyCountSize {
+		t.Error("reply fields overlap their neighbours")
+	}
+}
+
+func TestDecodePacketRejects(t *testing.T) {
+	valid := (&Packet{SeqNum: 1}).Encode(HeaderSize)
+	otherVersion := append([]byte(nil), valid...)
+	otherVersion[versionOffset] = ProtocolVersion + 1
+	badMagic := append([]byte(nil), valid...)
+	badMagic[magicOffset] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"short", valid[:HeaderSize-1]},
+		{"other version", otherVersion},
+		{"bad magic", badMagic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DecodePacket(tt.data); got != nil {
+				t.Errorf("DecodePacket = %+v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestVersionError(t *testing.T) {
+	notice := encodeVersionNotice()
+	other := append([]byte(nil), notice...)
+	other[versionOffset] = ProtocolVersion + 1
+
+	if err := versionError(notice); err != nil {
+		t.Errorf("own version: %v", err)
+	}
+	if err := versionError(other); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("other version: got %v, want ErrVersionMismatch", err)
+	}
+	if err := versionError((&Packet{}).Encode(HeaderSize)); err != nil {
+		t.Errorf("full packet: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// PathResult summarizes the probes sent from one source port
+type PathResult struct {
+	Port   string
+	Sent   int
+	Lost   int
+	AvgRTT float64
+	P50RTT float64
+	P99RTT float64
+}
+
+// LossPercent returns the share of the path's probes that were lost
+func (p PathResult) LossPercent() float64 {
+	if p.Sent == 0 {
+		return 0
+	}
+	return float64(p.Lost) / float64(p.Sent) * 100
+}
+
+// SummarizePaths groups records by the source port they were sent from.
+// Load balancers hash the five-tuple, so each port can take a different
+// path through ECMP or LAG members.
+func SummarizePaths(records []*PacketRecord, ports []string) []PathResult {
+	results := make([]PathResult, len(ports))
+	latencies := make([][]float64, len(ports))
+	for i, port := range ports {
+		results[i].Port = port
+	}
+	for _, r := range records {
+		if r.Path < 0 || r.Path >= len(results) {
+			continue
+		}
+		res := &results[r.Path]
+		res.Sent++
+		if r.Lost || r.RecvTime == 0 {
+			res.Lost++
+			continue
+		}
+		latencies[r.Path] = append(latencies[r.Path], r.LatencyMs)
+	}
+	for i := range results {
+		results[i].AvgRTT = avg(latencies[i])
+		results[i].P50RTT, _, results[i].P99RTT = percentiles(latencies[i], 50, 90, 99)
+	}
+	return results
+}
+
+// PrintPaths prints per-port stats under title and how much the paths differ
+func PrintPaths(title string, records []*PacketRecord, ports []string) {
+	results := SummarizePaths(records, ports)
+
+	fmt.Printf("\n--- %s ---\n", title)
+	var p50s, losses []float64
+	for _, res := range results {
+		if res.Sent == 0 {
+			continue
+		}
+		fmt.Printf("Port %s: %d sent, %.2f%% loss, RTT avg=%.1fms p50=%.1fms p99=%.1fms\n",
+			res.Port, res.Sent, res.LossPercent(), res.AvgRTT, res.P50RTT, res.P99RTT)
+		p50s = append(p50s, res.P50RTT)
+		losses = append(losses, res.LossPercent())
+	}
+	if len(p50s) < 2 {
+		return
+	}
+
+	sort.Float64s(p50s)
+	sort.Float64s(losses)
+	mean := avg(p50s)
+	var variance float64
+	for _, v := range p50s {
+		variance += (v - mean) * (v - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(p50s)))
+	fmt.Printf("Spread: p50 RTT %.1f-%.1fms (stddev %.1fms), loss %.2f-%.2f%%\n",
+		p50s[0], p50s[len(p50s)-1], stddev, losses[0], losses[len(losses)-1])
+}
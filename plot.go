@@ -2,28 +2,46 @@ package main
 
 import (
 	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"math"
 	"os"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
-const htmlTemplate = `<!DOCTYPE html>
-<html>
-<head>
-    <title>Packet Loss Test Results</title>
-    <script src="https://cdn.jsdelivr.net/npm/chart.js"></script>
-    <style>
+// reportStyle is the stylesheet shared by the HTML reports
+const reportStyle = `    <style>
+        :root {
+            --bg: #1a1a2e;
+            --panel: #16213e;
+            --text: #eee;
+            --muted: #888;
+            --info: #aaa;
+            --accent: #00d9ff;
+        }
+        body.theme-light {
+            --bg: #ffffff;
+            --panel: #f3f5f9;
+            --text: #222;
+            --muted: #666;
+            --info: #555;
+            --accent: #0077aa;
+        }
         body {
             font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
             margin: 0;
             padding: 20px;
-            background: #1a1a2e;
-            color: #eee;
+            background: var(--bg);
+            color: var(--text);
         }
-        h1 { color: #00d9ff; }
+        h1 { color: var(--accent); }
         .chart-container {
-            background: #16213e;
+            background: var(--panel);
             border-radius: 8px;
             padding: 20px;
             margin-bottom: 20px;
@@ -35,24 +53,51 @@ const htmlTemplate = `<!DOCTYPE html>
             margin-bottom: 20px;
         }
         .stat-box {
-            background: #16213e;
+            background: var(--panel);
             padding: 15px;
             border-radius: 8px;
             text-align: center;
         }
         .stat-value {
             font-size: 2em;
-            color: #00d9ff;
+            color: var(--accent);
             font-weight: bold;
         }
         .stat-label {
-            color: #888;
+            color: var(--muted);
             font-size: 0.9em;
         }
+        .run-info {
+            background: var(--panel);
+            border-radius: 8px;
+            padding: 10px 15px;
+            margin-bottom: 20px;
+            color: var(--info);
+        }
+        .run-info div { margin: 4px 0; }
+        .run-info span { color: var(--text); }
+        .run-info .warning { color: #ff6b6b; font-weight: bold; }
+        .hidden { display: none; }
+
+        /* Print on white whatever the theme; dark charts are inverted since
+           their colors are baked into the canvas */
+        @media print {
+            body { --bg: #fff; --panel: #fff; --text: #000; --muted: #444; --info: #333; --accent: #000; padding: 0; }
+            .chart-container, .stat-box, .run-info { border: 1px solid #ccc; break-inside: avoid; }
+            body:not(.theme-light) canvas { filter: invert(1) hue-rotate(180deg); }
+        }
     </style>
-</head>
+`
+
+const htmlTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Packet Loss Test Results</title>
+    {{CHARTJS}}
+` + reportStyle + `</head>
 <body>
     <h1>UDP Packet Loss Test Results</h1>
+{{RUN_INFO}}
 
     <div class="stats">
         <div class="stat-box">
@@ -64,16 +109,16 @@ const htmlTemplate = `<!DOCTYPE html>
             <div class="stat-label">Packet Loss</div>
         </div>
         <div class="stat-box">
-            <div class="stat-value">{{AVG_LATENCY}}ms</div>
+            <div class="stat-value">{{AVG_LATENCY}}</div>
             <div class="stat-label">Avg Latency</div>
         </div>
         <div class="stat-box">
-            <div class="stat-value">{{MAX_LATENCY}}ms</div>
+            <div class="stat-value">{{MAX_LATENCY}}</div>
             <div class="stat-label">Max Latency</div>
         </div>
         <div class="stat-box">
             <div class="stat-value">{{AVG_NET_LATENCY}}</div>
-            <div class="stat-label">Avg Net+Client</div>
+            <div class="stat-label">Avg {{NET_LABEL}}</div>
         </div>
         <div class="stat-box">
             <div class="stat-value">{{AVG_SERVER_PROC}}</div>
@@ -81,68 +126,164 @@ const htmlTemplate = `<!DOCTYPE html>
         </div>
     </div>
 
-    <div class="chart-container">
+    <div class="chart-container" data-chart="envelope">
+        <canvas id="envelopeChart"></canvas>
+    </div>
+
+    <div class="chart-container" data-chart="latency">
         <canvas id="latencyChart"></canvas>
     </div>
 
-    <div class="chart-container">
+    <div class="chart-container" data-chart="cdf">
+        <canvas id="cdfChart"></canvas>
+    </div>
+
+    <div class="chart-container" data-chart="histogram">
+        <canvas id="histogramChart"></canvas>
+    </div>
+
+    <div class="chart-container" data-chart="net">
         <canvas id="netLatencyChart"></canvas>
     </div>
 
-    <div class="chart-container">
+    <div class="chart-container" data-chart="server">
         <canvas id="serverProcChart"></canvas>
     </div>
 
-    <div class="chart-container">
+    <div class="chart-container" data-chart="budget">
+        <canvas id="budgetChart"></canvas>
+    </div>
+
+    <div class="chart-container" data-chart="sendrate">
+        <canvas id="sendRateChart"></canvas>
+    </div>
+
+    <div class="chart-container" data-chart="throughput">
         <canvas id="throughputChart"></canvas>
     </div>
 
-    <div class="chart-container">
+    <div class="chart-container" data-chart="loss">
         <canvas id="lossChart"></canvas>
     </div>
 
+    <div class="chart-container" data-chart="direction">
+        <canvas id="directionChart"></canvas>
+    </div>
+
+    <div class="chart-container" data-chart="oneway">
+        <canvas id="oneWayChart"></canvas>
+    </div>
+
+    <div class="chart-container" data-chart="icmp">
+        <canvas id="icmpChart"></canvas>
+    </div>
+
+    <div class="chart-container" data-chart="load">
+        <canvas id="loadChart"></canvas>
+    </div>
+
     <script>
         const data = {{DATA_JSON}};
+        const events = {{EVENTS_JSON}};
+        const runs = {{RUNS_JSON}};
+        // Long runs come pre-aggregated to one point per second instead of
+        // per-packet data, which leaves only the envelope chart
+        const seconds = {{SECONDS_JSON}};
+        const sendRate = {{SEND_RATE_JSON}};
+        const icmp = {{ICMP_JSON}};
+        const load = {{LOAD_JSON}};
+        const dist = {{DIST_JSON}};
+
+        // Theme and chart set default to what the report was generated
+        // with and can be overridden with ?theme=light&charts=latency,loss
+        const params = new URLSearchParams(location.search);
+        const themes = {
+            dark: { text: '#eee', muted: '#888', grid: '#333' },
+            light: { text: '#222', muted: '#666', grid: '#ddd' }
+        };
+        const themeName = themes[params.get('theme')] ? params.get('theme') : '{{THEME}}';
+        const theme = themes[themeName];
+        document.body.classList.add('theme-' + themeName);
+
+        let chartSet = (params.get('charts') || '{{CHARTS}}').split(',').filter(c => c);
+        if (seconds) {
+            chartSet = ['envelope', 'cdf', 'histogram', 'sendrate'];
+        } else {
+            document.getElementById('envelopeChart').parentElement.classList.add('hidden');
+        }
+        if (chartSet.length > 0) {
+            document.querySelectorAll('[data-chart]').forEach(el => {
+                if (!chartSet.includes(el.dataset.chart)) el.classList.add('hidden');
+            });
+        }
 
         // Sort data by sequence number for proper display
         data.sort((a, b) => a.seq - b.seq);
 
+        // Roaming events are shaded across the packets they swallowed
+        const maxLatency = Math.max(0, ...data.filter(d => !d.lost).map(d => d.latency));
+        const roams = events.filter(e => e.kind === 'roam');
+        const inEvent = d => roams.some(e => d.seq >= e.start_seq && d.seq <= e.end_seq);
+        const latencyDatasets = [{
+            label: 'Latency (ms)',
+            data: data.map(d => d.lost ? null : d.latency),
+            backgroundColor: data.map(d => {
+                if (d.lost) return '#ff6b6b';
+                if (d.latency > 50) return '#feca57';
+                return '#00d9ff';
+            }),
+            borderWidth: 0
+        }];
+        if (runs.length > 1) {
+            latencyDatasets.push({
+                label: 'Run start',
+                data: data.map((d, i) => i > 0 && d.run !== data[i - 1].run ? maxLatency : null),
+                backgroundColor: '#ffffff',
+                borderWidth: 0,
+                grouped: false
+            });
+        }
+        if (roams.length > 0) {
+            latencyDatasets.push({
+                label: 'Roaming',
+                data: data.map(d => inEvent(d) ? maxLatency : null),
+                backgroundColor: 'rgba(162, 155, 254, 0.4)',
+                borderWidth: 0,
+                grouped: false
+            });
+        }
+
         // Latency bar chart
         new Chart(document.getElementById('latencyChart'), {
             type: 'bar',
             data: {
                 labels: data.map(d => d.seq),
-                datasets: [{
-                    label: 'Latency (ms)',
-                    data: data.map(d => d.lost ? null : d.latency),
-                    backgroundColor: data.map(d => {
-                        if (d.lost) return '#ff6b6b';
-                        if (d.latency > 50) return '#feca57';
-                        return '#00d9ff';
-                    }),
-                    borderWidth: 0
-                }]
+                datasets: latencyDatasets
             },
             options: {
                 responsive: true,
                 plugins: {
-                    title: { display: true, text: 'Latency Per Packet', color: '#eee' }
+                    title: { display: true, text: 'Latency Per Packet', color: theme.text }
                 },
                 scales: {
                     x: {
-                        title: { display: true, text: 'Packet Sequence', color: '#888' },
-                        ticks: { color: '#888', maxTicksLimit: 20 },
-                        grid: { color: '#333' }
+                        title: { display: true, text: 'Packet Sequence', color: theme.muted },
+                        ticks: { color: theme.muted, maxTicksLimit: 20 },
+                        grid: { color: theme.grid }
                     },
                     y: {
-                        title: { display: true, text: 'Latency (ms)', color: '#888' },
-                        ticks: { color: '#888' },
-                        grid: { color: '#333' }
+                        title: { display: true, text: 'Latency (ms)', color: theme.muted },
+                        ticks: { color: theme.muted },
+                        grid: { color: theme.grid }
                     }
                 }
             }
         });
 
+        // Older CSVs have no client column, so their net value still includes client time
+        const hasClient = data.some(d => d.client !== null);
+        const netLabel = hasClient ? 'Network' : 'Net+Client';
+
         const hasNet = data.some(d => d.net !== null);
         if (hasNet) {
             new Chart(document.getElementById('netLatencyChart'), {
@@ -150,7 +291,7 @@ const htmlTemplate = `<!DOCTYPE html>
                 data: {
                     labels: data.map(d => d.seq),
                     datasets: [{
-                        label: 'Net+Client (ms)',
+                        label: netLabel + ' (ms)',
                         data: data.map(d => d.lost ? null : d.net),
                         borderColor: '#4ecdc4',
                         backgroundColor: 'rgba(78, 205, 196, 0.2)',
@@ -162,18 +303,18 @@ const htmlTemplate = `<!DOCTYPE html>
                 options: {
                     responsive: true,
                     plugins: {
-                        title: { display: true, text: 'Net+Client Latency Per Packet', color: '#eee' }
+                        title: { display: true, text: netLabel + ' Latency Per Packet', color: theme.text }
                     },
                     scales: {
                         x: {
-                            title: { display: true, text: 'Packet Sequence', color: '#888' },
-                            ticks: { color: '#888', maxTicksLimit: 20 },
-                            grid: { color: '#333' }
+                            title: { display: true, text: 'Packet Sequence', color: theme.muted },
+                            ticks: { color: theme.muted, maxTicksLimit: 20 },
+                            grid: { color: theme.grid }
                         },
                         y: {
-                            title: { display: true, text: 'Latency (ms)', color: '#888' },
-                            ticks: { color: '#888' },
-                            grid: { color: '#333' }
+                            title: { display: true, text: 'Latency (ms)', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid }
                         }
                     }
                 }
@@ -201,18 +342,18 @@ const htmlTemplate = `<!DOCTYPE html>
                 options: {
                     responsive: true,
                     plugins: {
-                        title: { display: true, text: 'Server Processing Time Per Packet', color: '#eee' }
+                        title: { display: true, text: 'Server Processing Time Per Packet', color: theme.text }
                     },
                     scales: {
                         x: {
-                            title: { display: true, text: 'Packet Sequence', color: '#888' },
-                            ticks: { color: '#888', maxTicksLimit: 20 },
-                            grid: { color: '#333' }
+                            title: { display: true, text: 'Packet Sequence', color: theme.muted },
+                            ticks: { color: theme.muted, maxTicksLimit: 20 },
+                            grid: { color: theme.grid }
                         },
                         y: {
-                            title: { display: true, text: 'Time (ms)', color: '#888' },
-                            ticks: { color: '#888' },
-                            grid: { color: '#333' }
+                            title: { display: true, text: 'Time (ms)', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid }
                         }
                     }
                 }
@@ -221,21 +362,96 @@ const htmlTemplate = `<!DOCTYPE html>
             document.getElementById('serverProcChart').parentElement.style.display = 'none';
         }
 
-        // Calculate throughput over time (packets per 500ms window)
+        // Latency budget: stack each packet's RTT components so it's obvious
+        // which one dominates spikes
+        if (hasNet && hasServer) {
+            const budgetSeries = [
+                { label: netLabel + ' (ms)', key: 'net', color: '#4ecdc4' },
+                { label: 'Server Proc (ms)', key: 'server', color: '#feca57' }
+            ];
+            if (hasClient) {
+                budgetSeries.push({ label: 'Client Proc (ms)', key: 'client', color: '#a29bfe' });
+            }
+            new Chart(document.getElementById('budgetChart'), {
+                type: 'bar',
+                data: {
+                    labels: data.map(d => d.seq),
+                    datasets: budgetSeries.map(s => ({
+                        label: s.label,
+                        data: data.map(d => d.lost ? null : d[s.key]),
+                        backgroundColor: s.color,
+                        borderWidth: 0
+                    }))
+                },
+                options: {
+                    responsive: true,
+                    plugins: {
+                        title: { display: true, text: 'Latency Budget Per Packet', color: theme.text },
+                        legend: { labels: { color: theme.text } }
+                    },
+                    scales: {
+                        x: {
+                            stacked: true,
+                            title: { display: true, text: 'Packet Sequence', color: theme.muted },
+                            ticks: { color: theme.muted, maxTicksLimit: 20 },
+                            grid: { color: theme.grid }
+                        },
+                        y: {
+                            stacked: true,
+                            title: { display: true, text: 'Latency (ms)', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid }
+                        }
+                    }
+                }
+            });
+        } else {
+            document.getElementById('budgetChart').parentElement.style.display = 'none';
+        }
+
+        // Calculate throughput over time (packets per 500ms window). Each run
+        // is bucketed separately so gaps between merged runs are skipped.
         const receivedPackets = data.filter(d => !d.lost && d.recvTime > 0);
         if (receivedPackets.length > 0) {
-            const minTime = Math.min(...receivedPackets.map(d => d.recvTime));
-            const maxTime = Math.max(...receivedPackets.map(d => d.recvTime));
             const windowMs = 500; // 500ms windows
+            const baseTime = receivedPackets.reduce((m, d) => Math.min(m, d.recvTime), Infinity);
             const throughputData = [];
 
-            for (let t = minTime; t < maxTime; t += windowMs) {
-                const windowEnd = t + windowMs;
-                const packetsInWindow = receivedPackets.filter(d => d.recvTime >= t && d.recvTime < windowEnd).length;
-                const pps = (packetsInWindow / windowMs) * 1000; // packets per second
-                throughputData.push({
-                    time: ((t - minTime) / 1000).toFixed(1),
-                    pps: pps
+            for (const run of new Set(receivedPackets.map(d => d.run))) {
+                const runPackets = receivedPackets.filter(d => d.run === run);
+                const minTime = runPackets.reduce((m, d) => Math.min(m, d.recvTime), Infinity);
+                const maxTime = runPackets.reduce((m, d) => Math.max(m, d.recvTime), -Infinity);
+                const counts = new Map();
+                for (const d of runPackets) {
+                    const k = Math.floor((d.recvTime - minTime) / windowMs);
+                    counts.set(k, (counts.get(k) || 0) + 1);
+                }
+                const replySize = runs[run] ? runs[run].reply_size : 0;
+                for (let k = 0; minTime + k * windowMs < maxTime; k++) {
+                    const pps = ((counts.get(k) || 0) / windowMs) * 1000; // packets per second
+                    throughputData.push({
+                        time: ((minTime + k * windowMs - baseTime) / 1000).toFixed(1),
+                        pps: pps,
+                        mbps: replySize ? pps * replySize * 8 / 1e6 : null
+                    });
+                }
+            }
+            const throughputDatasets = [{
+                label: 'Packets/sec',
+                data: throughputData.map(d => d.pps),
+                backgroundColor: throughputData.map(d => {
+                    if (d.pps < 30) return '#ff6b6b';
+                    if (d.pps < 50) return '#feca57';
+                    return '#4ecdc4';
+                }),
+                borderWidth: 0
+            }];
+            // Runs that recorded their reply size also get the bitrate
+            if (throughputData.some(d => d.mbps !== null)) {
+                throughputDatasets.push({
+                    type: 'line', label: 'Mbps', yAxisID: 'mbps',
+                    data: throughputData.map(d => d.mbps),
+                    borderColor: '#a29bfe', borderWidth: 1.5, pointRadius: 0
                 });
             }
 
@@ -244,32 +460,31 @@ const htmlTemplate = `<!DOCTYPE html>
                 type: 'bar',
                 data: {
                     labels: throughputData.map(d => d.time + 's'),
-                    datasets: [{
-                        label: 'Packets/sec',
-                        data: throughputData.map(d => d.pps),
-                        backgroundColor: throughputData.map(d => {
-                            if (d.pps < 30) return '#ff6b6b';
-                            if (d.pps < 50) return '#feca57';
-                            return '#4ecdc4';
-                        }),
-                        borderWidth: 0
-                    }]
+                    datasets: throughputDatasets
                 },
                 options: {
                     responsive: true,
                     plugins: {
-                        title: { display: true, text: 'Throughput Over Time (packets received per second)', color: '#eee' }
+                        title: { display: true, text: 'Throughput Over Time (packets received per second)', color: theme.text }
                     },
                     scales: {
                         x: {
-                            title: { display: true, text: 'Time', color: '#888' },
-                            ticks: { color: '#888' },
-                            grid: { color: '#333' }
+                            title: { display: true, text: 'Time', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid }
                         },
                         y: {
-                            title: { display: true, text: 'Packets/sec', color: '#888' },
-                            ticks: { color: '#888' },
-                            grid: { color: '#333' },
+                            title: { display: true, text: 'Packets/sec', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid },
+                            min: 0
+                        },
+                        mbps: {
+                            display: throughputDatasets.length > 1,
+                            position: 'right',
+                            title: { display: true, text: 'Mbps', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { drawOnChartArea: false },
                             min: 0
                         }
                     }
@@ -303,46 +518,467 @@ const htmlTemplate = `<!DOCTYPE html>
             options: {
                 responsive: true,
                 plugins: {
-                    title: { display: true, text: 'Packet Loss Over Time', color: '#eee' }
+                    title: { display: true, text: 'Packet Loss Over Time', color: theme.text }
                 },
                 scales: {
                     x: {
-                        title: { display: true, text: 'Packet Sequence', color: '#888' },
-                        ticks: { color: '#888' },
-                        grid: { color: '#333' }
+                        title: { display: true, text: 'Packet Sequence', color: theme.muted },
+                        ticks: { color: theme.muted },
+                        grid: { color: theme.grid }
                     },
                     y: {
-                        title: { display: true, text: 'Loss %', color: '#888' },
-                        ticks: { color: '#888' },
-                        grid: { color: '#333' },
+                        title: { display: true, text: 'Loss %', color: theme.muted },
+                        ticks: { color: theme.muted },
+                        grid: { color: theme.grid },
                         min: 0
                     }
                 }
             }
         });
+
+        // Loss split by direction, where the server's receive report told
+        // us whether the probe or the reply went missing
+        const hasDirection = data.some(d => d.dir);
+        if (hasDirection) {
+            const dirData = [];
+            for (let i = 0; i < data.length; i += windowSize) {
+                const win = data.slice(i, i + windowSize);
+                const pct = dir => (win.filter(d => d.lost && d.dir === dir).length / win.length) * 100;
+                dirData.push({ seq: i + windowSize/2, up: pct('up'), down: pct('down'), unknown: pct(null) });
+            }
+            const dirSeries = [
+                { label: 'Upstream (probe lost)', key: 'up', color: '#ff6b6b' },
+                { label: 'Downstream (reply lost)', key: 'down', color: '#feca57' }
+            ];
+            if (dirData.some(d => d.unknown > 0)) {
+                dirSeries.push({ label: 'Unknown', key: 'unknown', color: '#888' });
+            }
+            new Chart(document.getElementById('directionChart'), {
+                type: 'bar',
+                data: {
+                    labels: dirData.map(d => Math.floor(d.seq)),
+                    datasets: dirSeries.map(s => ({
+                        label: s.label,
+                        data: dirData.map(d => d[s.key]),
+                        backgroundColor: s.color,
+                        borderWidth: 0
+                    }))
+                },
+                options: {
+                    responsive: true,
+                    plugins: {
+                        title: { display: true, text: 'Loss Direction Over Time', color: theme.text },
+                        legend: { labels: { color: theme.text } }
+                    },
+                    scales: {
+                        x: {
+                            stacked: true,
+                            title: { display: true, text: 'Packet Sequence', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid }
+                        },
+                        y: {
+                            stacked: true,
+                            title: { display: true, text: 'Loss %', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid },
+                            min: 0
+                        }
+                    }
+                }
+            });
+        } else {
+            document.getElementById('directionChart').parentElement.style.display = 'none';
+        }
+
+        // One-way latency, from the server clock offset estimated around the run
+        const hasOneWay = data.some(d => d.up !== null);
+        if (hasOneWay) {
+            new Chart(document.getElementById('oneWayChart'), {
+                type: 'line',
+                data: {
+                    labels: data.map(d => d.seq),
+                    datasets: [
+                        {
+                            label: 'Upstream (ms)', data: data.map(d => d.up),
+                            borderColor: '#00d9ff', pointRadius: 0, spanGaps: true, borderWidth: 1.5
+                        },
+                        {
+                            label: 'Downstream (ms)', data: data.map(d => d.down),
+                            borderColor: '#feca57', pointRadius: 0, spanGaps: true, borderWidth: 1.5
+                        }
+                    ]
+                },
+                options: {
+                    responsive: true,
+                    plugins: {
+                        title: { display: true, text: 'One-Way Latency Per Packet', color: theme.text },
+                        legend: { labels: { color: theme.text } }
+                    },
+                    scales: {
+                        x: {
+                            title: { display: true, text: 'Packet Sequence', color: theme.muted },
+                            ticks: { color: theme.muted, maxTicksLimit: 20 },
+                            grid: { color: theme.grid }
+                        },
+                        y: {
+                            title: { display: true, text: 'Latency (ms)', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid }
+                        }
+                    }
+                }
+            });
+        } else {
+            document.getElementById('oneWayChart').parentElement.style.display = 'none';
+        }
+
+        // UDP and ICMP latency on a shared time axis, from --icmp-compare
+        if (icmp.length > 0) {
+            const udp = data.filter(d => !d.lost && d.recvTime > 0);
+            const t0 = udp.reduce((t, d) => Math.min(t, d.recvTime - d.latency), icmp[0].t);
+            new Chart(document.getElementById('icmpChart'), {
+                type: 'line',
+                data: {
+                    datasets: [
+                        {
+                            label: 'UDP (ms)', data: udp.map(d => ({ x: (d.recvTime - d.latency - t0) / 1000, y: d.latency })),
+                            borderColor: '#00d9ff', pointRadius: 0, borderWidth: 1
+                        },
+                        {
+                            label: 'ICMP (ms)', data: icmp.map(p => ({ x: (p.t - t0) / 1000, y: p.latency })),
+                            borderColor: '#ff9ff3', backgroundColor: '#ff9ff3', pointRadius: 2, borderWidth: 1.5
+                        }
+                    ]
+                },
+                options: {
+                    responsive: true,
+                    plugins: {
+                        title: { display: true, text: 'UDP vs ICMP Latency', color: theme.text },
+                        legend: { labels: { color: theme.text } }
+                    },
+                    scales: {
+                        x: {
+                            type: 'linear',
+                            title: { display: true, text: 'Seconds', color: theme.muted },
+                            ticks: { color: theme.muted, maxTicksLimit: 20 },
+                            grid: { color: theme.grid }
+                        },
+                        y: {
+                            title: { display: true, text: 'Latency (ms)', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid },
+                            min: 0
+                        }
+                    }
+                }
+            });
+        } else {
+            document.getElementById('icmpChart').parentElement.style.display = 'none';
+        }
+
+        // Latency against the bulk load of a latency-under-load test
+        if (load) {
+            const udp = data.filter(d => !d.lost && d.recvTime > 0);
+            const loadStart = Date.parse(load.start);
+            const t0 = udp.reduce((t, d) => Math.min(t, d.recvTime - d.latency), loadStart);
+            const loadPoints = bps => (bps || []).map((b, i) => ({ x: (loadStart + i * 1000 - t0) / 1000, y: b / 1e6 }));
+            const loadDatasets = [{
+                label: 'RTT (ms)', data: udp.map(d => ({ x: (d.recvTime - d.latency - t0) / 1000, y: d.latency })),
+                borderColor: '#00d9ff', pointRadius: 0, borderWidth: 1
+            }];
+            if (load.up_bps) {
+                loadDatasets.push({
+                    label: 'Upload load (Mbps)', data: loadPoints(load.up_sent_bps), yAxisID: 'mbps',
+                    borderColor: '#feca57', pointRadius: 0, borderWidth: 1.5, stepped: true
+                });
+            }
+            if (load.down_bps) {
+                loadDatasets.push({
+                    label: 'Download load (Mbps)', data: loadPoints(load.down_received_bps), yAxisID: 'mbps',
+                    borderColor: '#ff9ff3', pointRadius: 0, borderWidth: 1.5, stepped: true
+                });
+            }
+            new Chart(document.getElementById('loadChart'), {
+                type: 'line',
+                data: { datasets: loadDatasets },
+                options: {
+                    responsive: true,
+                    plugins: {
+                        title: { display: true, text: 'Latency Under Load', color: theme.text },
+                        legend: { labels: { color: theme.text } }
+                    },
+                    scales: {
+                        x: {
+                            type: 'linear',
+                            title: { display: true, text: 'Seconds', color: theme.muted },
+                            ticks: { color: theme.muted, maxTicksLimit: 20 },
+                            grid: { color: theme.grid }
+                        },
+                        y: {
+                            title: { display: true, text: 'Latency (ms)', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid },
+                            min: 0
+                        },
+                        mbps: {
+                            position: 'right',
+                            title: { display: true, text: 'Load (Mbps)', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { drawOnChartArea: false },
+                            min: 0
+                        }
+                    }
+                }
+            });
+        } else {
+            document.getElementById('loadChart').parentElement.style.display = 'none';
+        }
+
+        // RTT distribution: percentiles read straight off the CDF, and the
+        // shape (modes, long tail) off the histogram
+        if (dist) {
+            new Chart(document.getElementById('cdfChart'), {
+                type: 'line',
+                data: {
+                    datasets: [{
+                        label: 'Packets at or below (%)',
+                        data: dist.cdf.map(p => ({ x: p[0], y: p[1] })),
+                        borderColor: '#00d9ff', pointRadius: 0, borderWidth: 1.5, stepped: true
+                    }]
+                },
+                options: {
+                    responsive: true,
+                    plugins: {
+                        title: { display: true, text: 'Latency CDF', color: theme.text },
+                        legend: { display: false }
+                    },
+                    scales: {
+                        x: {
+                            type: 'linear',
+                            title: { display: true, text: 'Latency (ms)', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid }
+                        },
+                        y: {
+                            title: { display: true, text: 'Packets at or below (%)', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid },
+                            min: 0, max: 100
+                        }
+                    }
+                }
+            });
+
+            const binLabels = dist.counts.map((_, i) => (dist.start_ms + i * dist.bin_ms).toFixed(2));
+            const binCounts = dist.counts.slice();
+            if (dist.overflow > 0) {
+                binLabels.push('>' + (dist.start_ms + dist.counts.length * dist.bin_ms).toFixed(2));
+                binCounts.push(dist.overflow);
+            }
+            new Chart(document.getElementById('histogramChart'), {
+                type: 'bar',
+                data: {
+                    labels: binLabels,
+                    datasets: [{
+                        label: 'Packets',
+                        data: binCounts,
+                        backgroundColor: binCounts.map((_, i) => i < dist.counts.length ? '#00d9ff' : '#feca57'),
+                        borderWidth: 0, barPercentage: 1, categoryPercentage: 1
+                    }]
+                },
+                options: {
+                    responsive: true,
+                    plugins: {
+                        title: { display: true, text: 'Latency Histogram', color: theme.text },
+                        legend: { display: false }
+                    },
+                    scales: {
+                        x: {
+                            title: { display: true, text: 'Latency (ms, bin start)', color: theme.muted },
+                            ticks: { color: theme.muted, maxTicksLimit: 20 },
+                            grid: { color: theme.grid }
+                        },
+                        y: {
+                            title: { display: true, text: 'Packets', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid },
+                            min: 0
+                        }
+                    }
+                }
+            });
+        } else {
+            document.getElementById('cdfChart').parentElement.style.display = 'none';
+            document.getElementById('histogramChart').parentElement.style.display = 'none';
+        }
+
+        // Achieved send rate against the configured rate, so pacing
+        // shortfalls (sender CPU, socket blocking) are visible
+        if (sendRate.length > 0) {
+            new Chart(document.getElementById('sendRateChart'), {
+                data: {
+                    labels: sendRate.map(s => new Date(s.t * 1000).toLocaleTimeString()),
+                    datasets: [
+                        {
+                            type: 'bar', label: 'Achieved (pps)', data: sendRate.map(s => s.rate),
+                            backgroundColor: sendRate.map(s => s.rate < s.target * 0.95 ? '#ff6b6b' : '#4ecdc4'),
+                            borderWidth: 0
+                        },
+                        {
+                            type: 'line', label: 'Target (pps)', data: sendRate.map(s => s.target),
+                            borderColor: '#feca57', borderWidth: 2, pointRadius: 0, stepped: true
+                        }
+                    ]
+                },
+                options: {
+                    responsive: true,
+                    plugins: {
+                        title: { display: true, text: 'Send Rate vs Target', color: theme.text },
+                        legend: { labels: { color: theme.text } }
+                    },
+                    scales: {
+                        x: {
+                            title: { display: true, text: 'Time', color: theme.muted },
+                            ticks: { color: theme.muted, maxTicksLimit: 20 },
+                            grid: { color: theme.grid }
+                        },
+                        y: {
+                            title: { display: true, text: 'Packets/sec', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid },
+                            min: 0
+                        }
+                    }
+                }
+            });
+        } else {
+            document.getElementById('sendRateChart').parentElement.style.display = 'none';
+        }
+
+        if (seconds) {
+            const label = s => new Date(s.t * 1000).toLocaleTimeString();
+            new Chart(document.getElementById('envelopeChart'), {
+                data: {
+                    labels: seconds.map(label),
+                    datasets: [
+                        {
+                            type: 'line', label: 'Max (ms)', data: seconds.map(s => s.max),
+                            borderWidth: 0, pointRadius: 0, spanGaps: false,
+                            backgroundColor: 'rgba(0, 217, 255, 0.15)', fill: '+1'
+                        },
+                        {
+                            type: 'line', label: 'Min (ms)', data: seconds.map(s => s.min),
+                            borderWidth: 0, pointRadius: 0, spanGaps: false
+                        },
+                        {
+                            type: 'line', label: 'Avg (ms)', data: seconds.map(s => s.avg),
+                            borderColor: '#00d9ff', borderWidth: 1.5, pointRadius: 0, spanGaps: false
+                        },
+                        {
+                            type: 'line', label: 'p99 (ms)', data: seconds.map(s => s.p99),
+                            borderColor: '#feca57', borderWidth: 1, pointRadius: 0, spanGaps: false
+                        },
+                        {
+                            type: 'bar', label: 'Loss %', yAxisID: 'loss',
+                            data: seconds.map(s => s.sent ? s.lost / s.sent * 100 : null),
+                            backgroundColor: '#ff6b6b', borderWidth: 0
+                        }
+                    ]
+                },
+                options: {
+                    responsive: true,
+                    plugins: {
+                        title: { display: true, text: 'Latency Envelope and Loss Per Second', color: theme.text },
+                        legend: { labels: { color: theme.text } }
+                    },
+                    scales: {
+                        x: {
+                            title: { display: true, text: 'Time', color: theme.muted },
+                            ticks: { color: theme.muted, maxTicksLimit: 20 },
+                            grid: { color: theme.grid }
+                        },
+                        y: {
+                            title: { display: true, text: 'Latency (ms)', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { color: theme.grid },
+                            min: 0
+                        },
+                        loss: {
+                            position: 'right',
+                            title: { display: true, text: 'Loss %', color: theme.muted },
+                            ticks: { color: theme.muted },
+                            grid: { drawOnChartArea: false },
+                            min: 0
+                        }
+                    }
+                }
+            });
+        }
     </script>
 </body>
 </html>`
 
-// GeneratePlot reads a CSV file and generates an HTML chart
-func GeneratePlot(csvFile string) error {
-	// Read CSV
-	file, err := os.Open(csvFile)
-	if err != nil {
-		return fmt.Errorf("failed to open CSV: %w", err)
-	}
-	defer file.Close()
+// PlotCharts lists the chart names accepted by --charts and ?charts=
+var PlotCharts = []string{"envelope", "latency", "cdf", "histogram", "net", "server", "budget", "sendrate", "throughput", "loss", "direction", "oneway", "icmp", "load"}
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return fmt.Errorf("failed to read CSV: %w", err)
-	}
+// aggregateAfter is how long a run must span before the report switches
+// to per-second aggregates in auto mode
+const aggregateAfter = 10 * time.Minute
 
-	if len(records) < 2 {
-		return fmt.Errorf("CSV file is empty or has no data rows")
+// PlotOptions controls how the HTML report looks
+type PlotOptions struct {
+	Theme     string   // "dark" or "light"
+	Charts    []string // charts to show, empty = all
+	Aggregate string   // "auto", "on" or "off": plot one point per second instead of per packet
+	ChartJS   string   // Chart.js file to inline, "" = the embedded copy or the CDN
+}
+
+// ParsePlotOptions validates the --theme, --charts and --aggregate flag values
+func ParsePlotOptions(theme, charts, aggregate string) (PlotOptions, error) {
+	opts := PlotOptions{Theme: theme, Aggregate: aggregate}
+	if theme != "dark" && theme != "light" {
+		return opts, fmt.Errorf("invalid theme %q, expected dark or light", theme)
+	}
+	if aggregate != "auto" && aggregate != "on" && aggregate != "off" {
+		return opts, fmt.Errorf("invalid aggregate mode %q, expected auto, on or off", aggregate)
+	}
+	if charts == "" {
+		return opts, nil
 	}
+	for _, c := range strings.Split(charts, ",") {
+		c = strings.TrimSpace(c)
+		if !slices.Contains(PlotCharts, c) {
+			return opts, fmt.Errorf("unknown chart %q, expected one of %s", c, strings.Join(PlotCharts, ", "))
+		}
+		opts.Charts = append(opts.Charts, c)
+	}
+	return opts, nil
+}
 
+// GeneratePlot reads a CSV file and generates an HTML chart next to it
+func GeneratePlot(csvFile string, opts PlotOptions) error {
+	return generatePlot([]string{csvFile}, strings.TrimSuffix(csvFile, ".csv")+".html", opts)
+}
+
+// MergePlots generates one continuous report from consecutive runs, e.g.
+// hourly scheduled tests. Sequence numbers are offset so each run follows
+// the previous one, and run boundaries are marked in the charts. The report
+// is named after the first file with a _merged suffix.
+func MergePlots(csvFiles []string, opts PlotOptions) error {
+	return generatePlot(csvFiles, strings.TrimSuffix(csvFiles[0], ".csv")+"_merged.html", opts)
+}
+
+// plotRun identifies one CSV file within a report
+type plotRun struct {
+	File      string    `json:"file"`
+	StartSeq  uint64    `json:"start_seq"`
+	Started   time.Time `json:"started,omitzero"`
+	ReplySize int       `json:"reply_size,omitempty"` // bytes, 0 if unknown
+	meta      *RunMetadata
+}
+
+func generatePlot(csvFiles []string, outputFile string, opts PlotOptions) error {
 	// Parse data and calculate stats
 	var dataJSON strings.Builder
 	dataJSON.WriteString("[")
@@ -350,91 +986,174 @@ func GeneratePlot(csvFile string) error {
 	var totalPackets, lostPackets int
 	var totalLatency, maxLatency float64
 	var totalNet, totalServer float64
-	var receivedCount int
+	var receivedCount, measuredCount int
+	var hasNet, hasServer, hasClient bool
 
-	header := records[0]
-	colIndex := make(map[string]int, len(header))
-	for i, col := range header {
-		colIndex[strings.TrimSpace(col)] = i
-	}
-
-	seqIdx, ok := colIndex["seq"]
-	if !ok {
-		return fmt.Errorf("CSV missing required column: seq")
-	}
-	recvIdx, ok := colIndex["recv_time"]
-	if !ok {
-		return fmt.Errorf("CSV missing required column: recv_time")
-	}
-	latIdx, ok := colIndex["latency_ms"]
-	if !ok {
-		return fmt.Errorf("CSV missing required column: latency_ms")
-	}
-	lostIdx, ok := colIndex["lost"]
-	if !ok {
-		return fmt.Errorf("CSV missing required column: lost")
-	}
-	netIdx, hasNet := colIndex["net_latency_ms"]
-	serverIdx, hasServer := colIndex["server_proc_ms"]
+	var runs []plotRun
+	var events []RunEvent
+	var seqOffset uint64
+	seconds := make(map[int64]*secondBucket)
+	sendRate := []sendRatePoint{}
+	icmp := []icmpPoint{}
+	var load *LoadResult
+	var firstSentMs, lastSentMs int64
+	var latencies []float64
+	for runIdx, csvFile := range csvFiles {
+		records, err := readCSV(csvFile)
+		if err != nil {
+			return err
+		}
 
-	for i, record := range records[1:] { // Skip header
-		if seqIdx >= len(record) || recvIdx >= len(record) || latIdx >= len(record) || lostIdx >= len(record) {
-			continue
+		meta, err := loadMetadata(csvFile)
+		if err != nil {
+			return fmt.Errorf("failed to read metadata: %w", err)
+		}
+		version := 0
+		if meta != nil {
+			version = meta.CSVSchema
 		}
+		layout, err := parseCSVHeader(records[0], version)
+		if err != nil {
+			return fmt.Errorf("%s: %w", csvFile, err)
+		}
+		fileHasNet := layout.Has("net_latency_ms")
+		fileHasServer := layout.Has("server_proc_ms")
+		hasNet = hasNet || fileHasNet
+		hasServer = hasServer || fileHasServer
+		hasClient = hasClient || !layout.NetIncludesClient()
+
+		maxSeq := seqOffset
+		for _, record := range records[1:] { // Skip header
+			if !layout.Complete(record) {
+				continue
+			}
 
-		seq := record[seqIdx]
-		recvTime := record[recvIdx]
-		latency, _ := strconv.ParseFloat(record[latIdx], 64)
-		lost := record[lostIdx] == "true"
-
-		netJSON := "null"
-		serverJSON := "null"
-		if hasNet && netIdx < len(record) {
-			if netVal, err := strconv.ParseFloat(record[netIdx], 64); err == nil {
-				netJSON = fmt.Sprintf("%.2f", netVal)
-				if !lost {
-					totalNet += netVal
+			seq, err := strconv.ParseUint(layout.Get(record, "seq"), 10, 64)
+			if err != nil {
+				continue
+			}
+			seq += seqOffset
+			maxSeq = max(maxSeq, seq)
+			recvTime := layout.Get(record, "recv_time")
+			lost := layout.Get(record, "lost") == "true"
+			// Imported data may have no RTT at all
+			latency, err := strconv.ParseFloat(layout.Get(record, "latency_ms"), 64)
+			latencyJSON := "null"
+			measured := err == nil && !lost
+			if measured {
+				latencyJSON = fmt.Sprintf("%.2f", latency)
+			}
+
+			if sentMs, err := strconv.ParseInt(layout.Get(record, "sent_time"), 10, 64); err == nil && sentMs > 0 {
+				if firstSentMs == 0 || sentMs < firstSentMs {
+					firstSentMs = sentMs
 				}
+				lastSentMs = max(lastSentMs, sentMs)
+				bucket := seconds[sentMs/1000]
+				if bucket == nil {
+					bucket = &secondBucket{T: sentMs / 1000}
+					seconds[sentMs/1000] = bucket
+				}
+				bucket.add(lost, latency, measured)
 			}
-		}
-		if hasServer && serverIdx < len(record) {
-			if serverVal, err := strconv.ParseFloat(record[serverIdx], 64); err == nil {
-				serverJSON = fmt.Sprintf("%.2f", serverVal)
-				if !lost {
-					totalServer += serverVal
+
+			netJSON := "null"
+			serverJSON := "null"
+			clientJSON := "null"
+			if fileHasNet {
+				if netVal, err := strconv.ParseFloat(layout.Get(record, "net_latency_ms"), 64); err == nil {
+					netJSON = fmt.Sprintf("%.2f", netVal)
+					if !lost {
+						totalNet += netVal
+					}
+				}
+			}
+			if fileHasServer {
+				if serverVal, err := strconv.ParseFloat(layout.Get(record, "server_proc_ms"), 64); err == nil {
+					serverJSON = fmt.Sprintf("%.2f", serverVal)
+					if !lost {
+						totalServer += serverVal
+					}
 				}
 			}
-		}
 
-		totalPackets++
-		if lost {
-			lostPackets++
-		} else {
-			receivedCount++
-			totalLatency += latency
-			if latency > maxLatency {
-				maxLatency = latency
+			if !layout.NetIncludesClient() {
+				if clientVal, err := strconv.ParseFloat(layout.Get(record, "client_proc_ms"), 64); err == nil {
+					clientJSON = fmt.Sprintf("%.3f", clientVal)
+				}
+			}
+
+			upJSON, downJSON := "null", "null"
+			if up, err := strconv.ParseFloat(layout.Get(record, "up_ms"), 64); err == nil && !lost {
+				upJSON = fmt.Sprintf("%.2f", up)
+			}
+			if down, err := strconv.ParseFloat(layout.Get(record, "down_ms"), 64); err == nil && !lost {
+				downJSON = fmt.Sprintf("%.2f", down)
+			}
+
+			dirJSON := "null"
+			if dir := layout.Get(record, "loss_dir"); lost && dir != "" {
+				dirJSON = strconv.Quote(dir)
+			}
+
+			if totalPackets > 0 {
+				dataJSON.WriteString(",")
 			}
+			totalPackets++
+			if lost {
+				lostPackets++
+			} else {
+				receivedCount++
+			}
+			if measured {
+				measuredCount++
+				latencies = append(latencies, latency)
+				totalLatency += latency
+				if latency > maxLatency {
+					maxLatency = latency
+				}
+			}
+
+			dataJSON.WriteString(fmt.Sprintf(`{"seq":%d,"run":%d,"recvTime":%s,"latency":%s,"net":%s,"server":%s,"client":%s,"up":%s,"down":%s,"lost":%t,"dir":%s}`,
+				seq, runIdx, recvTime, latencyJSON, netJSON, serverJSON, clientJSON, upJSON, downJSON, lost, dirJSON))
 		}
 
-		if i > 0 {
-			dataJSON.WriteString(",")
+		run := plotRun{File: filepath.Base(csvFile), StartSeq: seqOffset + 1, meta: meta}
+		if meta != nil {
+			run.Started = meta.StartTime
+			run.ReplySize = meta.ReplySize
+			if load == nil {
+				load = meta.Load
+			}
+			for i, rate := range meta.SendRate {
+				sendRate = append(sendRate, sendRatePoint{T: meta.StartTime.Unix() + int64(i), Rate: rate, Target: meta.TargetRate})
+			}
+			for _, e := range meta.Events {
+				e.StartSeq += seqOffset
+				e.EndSeq += seqOffset
+				events = append(events, e)
+			}
 		}
-		dataJSON.WriteString(fmt.Sprintf(`{"seq":%s,"recvTime":%s,"latency":%.2f,"net":%s,"server":%s,"lost":%t}`,
-			seq, recvTime, latency, netJSON, serverJSON, lost))
+		runs = append(runs, run)
+		seqOffset = maxSeq
+		icmp = append(icmp, loadICMPPoints(csvFile)...)
 	}
 	dataJSON.WriteString("]")
 
 	// Calculate summary stats
 	lossPercent := float64(0)
-	avgLatency := float64(0)
+	avgLatency := "N/A"
+	maxLatencyText := "N/A"
 	avgNet := "N/A"
 	avgServer := "N/A"
 	if totalPackets > 0 {
 		lossPercent = float64(lostPackets) / float64(totalPackets) * 100
 	}
+	if measuredCount > 0 {
+		avgLatency = fmt.Sprintf("%.1fms", totalLatency/float64(measuredCount))
+		maxLatencyText = fmt.Sprintf("%.1fms", maxLatency)
+	}
 	if receivedCount > 0 {
-		avgLatency = totalLatency / float64(receivedCount)
 		if hasNet {
 			avgNet = fmt.Sprintf("%.1fms", totalNet/float64(receivedCount))
 		}
@@ -443,18 +1162,84 @@ func GeneratePlot(csvFile string) error {
 		}
 	}
 
+	eventsJSON, err := json.Marshal(append([]RunEvent{}, events...))
+	if err != nil {
+		return fmt.Errorf("failed to encode events: %w", err)
+	}
+	sendRateJSON, err := json.Marshal(sendRate)
+	if err != nil {
+		return fmt.Errorf("failed to encode send rate: %w", err)
+	}
+	icmpJSON, err := json.Marshal(icmp)
+	if err != nil {
+		return fmt.Errorf("failed to encode ICMP series: %w", err)
+	}
+	loadJSON, err := json.Marshal(load)
+	if err != nil {
+		return fmt.Errorf("failed to encode load test: %w", err)
+	}
+	runsJSON, err := json.Marshal(runs)
+	if err != nil {
+		return fmt.Errorf("failed to encode runs: %w", err)
+	}
+	distJSON, err := json.Marshal(newLatencyDistribution(latencies))
+	if err != nil {
+		return fmt.Errorf("failed to encode latency distribution: %w", err)
+	}
+
+	// Long runs are plotted per second to keep the page light
+	span := time.Duration(lastSentMs-firstSentMs) * time.Millisecond
+	secondsJSON := "null"
+	if opts.Aggregate == "on" || (opts.Aggregate != "off" && span > aggregateAfter) {
+		data, err := json.Marshal(aggregateSeconds(seconds))
+		if err != nil {
+			return fmt.Errorf("failed to encode per-second data: %w", err)
+		}
+		secondsJSON = string(data)
+		dataJSON.Reset()
+		dataJSON.WriteString("[]")
+	}
+
+	runInfo := renderRunInfo(runs[0].meta)
+	if len(runs) > 1 {
+		runInfo = renderRunList(runs)
+	}
+
 	// Generate HTML
 	html := htmlTemplate
+	html = strings.Replace(html, "{{RUN_INFO}}", runInfo, 1)
 	html = strings.Replace(html, "{{TOTAL_PACKETS}}", strconv.Itoa(totalPackets), 1)
 	html = strings.Replace(html, "{{LOSS_PERCENT}}", fmt.Sprintf("%.2f", lossPercent), 1)
-	html = strings.Replace(html, "{{AVG_LATENCY}}", fmt.Sprintf("%.1f", avgLatency), 1)
-	html = strings.Replace(html, "{{MAX_LATENCY}}", fmt.Sprintf("%.1f", maxLatency), 1)
+	html = strings.Replace(html, "{{AVG_LATENCY}}", avgLatency, 1)
+	html = strings.Replace(html, "{{MAX_LATENCY}}", maxLatencyText, 1)
+	netLabel := "Net+Client"
+	if hasClient {
+		netLabel = "Network"
+	}
+	html = strings.Replace(html, "{{NET_LABEL}}", netLabel, 1)
 	html = strings.Replace(html, "{{AVG_NET_LATENCY}}", avgNet, 1)
 	html = strings.Replace(html, "{{AVG_SERVER_PROC}}", avgServer, 1)
 	html = strings.Replace(html, "{{DATA_JSON}}", dataJSON.String(), 1)
+	html = strings.Replace(html, "{{EVENTS_JSON}}", string(eventsJSON), 1)
+	html = strings.Replace(html, "{{RUNS_JSON}}", string(runsJSON), 1)
+	html = strings.Replace(html, "{{SECONDS_JSON}}", secondsJSON, 1)
+	html = strings.Replace(html, "{{SEND_RATE_JSON}}", string(sendRateJSON), 1)
+	html = strings.Replace(html, "{{ICMP_JSON}}", string(icmpJSON), 1)
+	html = strings.Replace(html, "{{LOAD_JSON}}", string(loadJSON), 1)
+	html = strings.Replace(html, "{{DIST_JSON}}", string(distJSON), 1)
+	theme := opts.Theme
+	if theme == "" {
+		theme = "dark"
+	}
+	html = strings.Replace(html, "{{THEME}}", theme, 1)
+	html = strings.Replace(html, "{{CHARTS}}", strings.Join(opts.Charts, ","), 1)
+	chartJS, err := chartJSTag(opts.ChartJS)
+	if err != nil {
+		return err
+	}
+	html = strings.Replace(html, "{{CHARTJS}}", chartJS, 1)
 
 	// Write output file
-	outputFile := strings.TrimSuffix(csvFile, ".csv") + ".html"
 	err = os.WriteFile(outputFile, []byte(html), 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write HTML: %w", err)
@@ -463,3 +1248,158 @@ func GeneratePlot(csvFile string) error {
 	fmt.Printf("Generated %s\n", outputFile)
 	return nil
 }
+
+// sendRatePoint is the achieved and configured send rate for one second
+type sendRatePoint struct {
+	T      int64 `json:"t"` // Unix seconds
+	Rate   int   `json:"rate"`
+	Target int   `json:"target"`
+}
+
+// icmpPoint is one ping from a run's ICMP series
+type icmpPoint struct {
+	T       int64    `json:"t"` // Unix milliseconds
+	Latency *float64 `json:"latency"`
+}
+
+// loadICMPPoints reads the ICMP series saved with a run, if there is one
+func loadICMPPoints(csvFile string) []icmpPoint {
+	rows, err := readCSV(icmpFile(csvFile))
+	if err != nil {
+		return nil
+	}
+	layout, err := parseCSVHeader(rows[0], 1)
+	if err != nil {
+		return nil
+	}
+	var points []icmpPoint
+	for _, row := range rows[1:] {
+		if !layout.Complete(row) {
+			continue
+		}
+		t, err := strconv.ParseInt(layout.Get(row, "sent_time"), 10, 64)
+		if err != nil {
+			continue
+		}
+		p := icmpPoint{T: t}
+		if lat, err := strconv.ParseFloat(layout.Get(row, "latency_ms"), 64); err == nil && layout.Get(row, "lost") != "true" {
+			p.Latency = &lat
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+// Latency distribution charts: the CDF is drawn through at most cdfMaxPoints
+// points, and the histogram spans the lowest RTT to the p99.9 in
+// histogramBins bins so one freak reply doesn't flatten it
+const (
+	cdfMaxPoints  = 200
+	histogramBins = 50
+)
+
+// latencyDistribution is the RTT CDF and histogram of a report
+type latencyDistribution struct {
+	CDF      [][2]float64 `json:"cdf"`
+	StartMs  float64      `json:"start_ms"` // lower edge of the first bin
+	BinMs    float64      `json:"bin_ms"`
+	Counts   []int        `json:"counts"`
+	Overflow int          `json:"overflow"` // packets above the last bin
+}
+
+// newLatencyDistribution returns the distribution of the RTTs, nil if
+// there are none. values is sorted in place.
+func newLatencyDistribution(values []float64) *latencyDistribution {
+	if len(values) == 0 {
+		return nil
+	}
+	sort.Float64s(values)
+	d := &latencyDistribution{CDF: cdfPoints(values, cdfMaxPoints), StartMs: values[0]}
+	span := percentile(values, 99.9) - d.StartMs
+	if span <= 0 {
+		span = values[len(values)-1] - d.StartMs
+	}
+	d.BinMs = max(span/histogramBins, 0.01) // the CSV's resolution
+	d.Counts = make([]int, int(math.Ceil(span/d.BinMs))+1)
+	for _, v := range values {
+		bin := int((v - d.StartMs) / d.BinMs)
+		if bin >= len(d.Counts) {
+			d.Overflow++
+		} else {
+			d.Counts[bin]++
+		}
+	}
+	return d
+}
+
+// cdfPoints returns up to n points of the cumulative distribution of
+// sorted values, as value and percent of values at or below it, spread
+// evenly over the ranks and ending at the maximum
+func cdfPoints(sorted []float64, n int) [][2]float64 {
+	n = min(len(sorted), n)
+	points := make([][2]float64, 0, n)
+	for i := range n {
+		k := (i + 1) * len(sorted) / n
+		points = append(points, [2]float64{sorted[k-1], float64(k) / float64(len(sorted)) * 100})
+	}
+	return points
+}
+
+// secondBucket aggregates the packets sent within one wall-clock second
+type secondBucket struct {
+	T         int64    `json:"t"` // Unix seconds
+	Sent      int      `json:"sent"`
+	Lost      int      `json:"lost"`
+	Min       *float64 `json:"min"`
+	Avg       *float64 `json:"avg"`
+	P99       *float64 `json:"p99"`
+	Max       *float64 `json:"max"`
+	latencies []float64
+}
+
+func (b *secondBucket) add(lost bool, latency float64, measured bool) {
+	b.Sent++
+	if lost {
+		b.Lost++
+	}
+	if measured {
+		b.latencies = append(b.latencies, latency)
+	}
+}
+
+// aggregateSeconds finalizes the buckets in time order. Seconds with no
+// measured latency keep null stats so the chart shows a gap.
+func aggregateSeconds(buckets map[int64]*secondBucket) []*secondBucket {
+	out := make([]*secondBucket, 0, len(buckets))
+	for _, b := range buckets {
+		if len(b.latencies) > 0 {
+			sort.Float64s(b.latencies)
+			minLat, avgLat, maxLat, _ := calcStats(b.latencies)
+			p99 := percentile(b.latencies, 99)
+			b.Min, b.Avg, b.P99, b.Max = &minLat, &avgLat, &p99, &maxLat
+		}
+		out = append(out, b)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].T < out[j].T })
+	return out
+}
+
+// readCSV reads a results CSV, requiring a header and at least one data row
+func readCSV(csvFile string) ([][]string, error) {
+	file, err := os.Open(csvFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %w", err)
+	}
+
+	if len(records) < 2 {
+		return nil, fmt.Errorf("CSV file %s is empty or has no data rows", csvFile)
+	}
+	return records, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Preset is a bundle of client flags modelling one kind of traffic, plus
+// the limits that traffic needs to work well
+type Preset struct {
+	Description string
+	Flags       [][2]string // flag name and value, in display order
+	SLA         SLA
+}
+
+// Presets are chosen with --preset. Flags given explicitly on the command
+// line win over the preset's.
+var Presets = map[string]Preset{
+	"voip": {
+		Description: "voice call: G.711 with 20ms frames, 60ms jitter buffer",
+		Flags: [][2]string{
+			{"rate", "50"},
+			{"packet-size", "172"},
+			{"late-threshold", "150"},
+			{"jitter-buffer", "60"},
+		},
+		SLA: SLA{MaxLoss: 1, MaxP99: 150, MaxJitter: 30},
+	},
+	"gaming": {
+		Description: "online game: 64 Hz tick, small state updates",
+		Flags: [][2]string{
+			{"game-tick", "64"},
+			{"packet-size", "100"},
+			{"late-threshold", "80"},
+		},
+		SLA: SLA{MaxLoss: 0.5, MaxP99: 80, MaxJitter: 15},
+	},
+	"streaming": {
+		Description: "video stream: ~5 Mbps bursty downstream, light upstream acks",
+		Flags: [][2]string{
+			{"rate", "50"},
+			{"packet-size", "64"},
+			{"burst", "true"},
+			{"burst-size", "5"},
+			{"down-rate", "450"},
+			{"down-size", "1400"},
+			{"late-threshold", "500"},
+		},
+		SLA: SLA{MaxLoss: 2, MaxP99: 500, MaxJitter: 100},
+	},
+	"iot": {
+		Description: "IoT telemetry: one small report per second over a longer run",
+		Flags: [][2]string{
+			{"rate", "1"},
+			{"packet-size", "64"},
+			{"duration", "300"},
+			{"late-threshold", "1000"},
+		},
+		SLA: SLA{MaxLoss: 5, MaxP99: 1000},
+	},
+}
+
+// PresetNames lists the presets for help text
+func PresetNames() string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+// ApplyPreset sets the preset's flags in fs that weren't given on the
+// command line and prints the expanded configuration. Call it after
+// fs.Parse.
+func ApplyPreset(fs *flag.FlagSet, name string) (*Preset, error) {
+	p, ok := Presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q (want %s)", name, PresetNames())
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	fmt.Printf("Preset %s: %s\n", name, p.Description)
+	for _, kv := range p.Flags {
+		if explicit[kv[0]] {
+			fmt.Printf("  --%s %s (overridden)\n", kv[0], fs.Lookup(kv[0]).Value)
+			continue
+		}
+		if err := fs.Set(kv[0], kv[1]); err != nil {
+			return nil, fmt.Errorf("preset %s: --%s: %w", name, kv[0], err)
+		}
+		fmt.Printf("  --%s %s\n", kv[0], kv[1])
+	}
+	fmt.Printf("  SLA: loss <= %.1f%%, p99 RTT <= %.0fms", p.SLA.MaxLoss, p.SLA.MaxP99)
+	if p.SLA.MaxJitter > 0 {
+		fmt.Printf(", jitter <= %.0fms", p.SLA.MaxJitter)
+	}
+	fmt.Printf("\n\n")
+	return &p, nil
+}
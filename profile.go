@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	rpprof "runtime/pprof"
+	"strings"
+	"time"
+)
+
+// ServePprof serves the Go runtime profiles at addr, e.g. :6060, under
+// /debug/pprof/ until ctx is done
+func ServePprof(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for pprof: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fmt.Printf("Warning: pprof endpoint stopped: %v\n", err)
+		}
+	}()
+	context.AfterFunc(ctx, func() { srv.Close() })
+	return nil
+}
+
+// cpuProfile holds the CPU profile of a run until its CSV name is known
+type cpuProfile struct {
+	buf bytes.Buffer
+}
+
+// startCPUProfile starts profiling the process until Save or Stop
+func startCPUProfile() (*cpuProfile, error) {
+	p := &cpuProfile{}
+	if err := rpprof.StartCPUProfile(&p.buf); err != nil {
+		return nil, fmt.Errorf("failed to start CPU profile: %w", err)
+	}
+	return p, nil
+}
+
+// Stop stops profiling without saving, for runs that fail
+func (p *cpuProfile) Stop() {
+	rpprof.StopCPUProfile()
+}
+
+// Save stops profiling and writes the profile next to csvFile as
+// <name>.cpu.pprof, returning its path
+func (p *cpuProfile) Save(csvFile string) (string, error) {
+	p.Stop()
+	path := strings.TrimSuffix(csvFile, ".csv") + ".cpu.pprof"
+	return path, os.WriteFile(path, p.buf.Bytes(), 0644)
+}
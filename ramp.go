@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ramp mode is a capacity finder: a single client raises its send rate
+// step by step and the highest rate that still stays under the loss limit
+// is reported. Steps are run like stress steps with one virtual client.
+
+const rampStopAfter = 2 // consecutive steps over the loss limit that end the ramp
+
+// RampConfig configures a rate sweep
+type RampConfig struct {
+	Host        string
+	Port        int
+	Family      string // "IPv4" or "IPv6" to use only that family, "" = either
+	PacketSize  int
+	Start       int // pps
+	End         int
+	Step        int
+	StepSeconds int
+	MaxLoss     float64 // percent
+	OutputFile  string
+}
+
+// ParseRamp parses a ramp given as start:end:step in packets per second
+func ParseRamp(s string) (start, end, step int, err error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, 0, 0, fmt.Errorf("invalid ramp %q, expected start:end:step", s)
+	}
+	vals := make([]int, 3)
+	for i, p := range parts {
+		if vals[i], err = strconv.Atoi(strings.TrimSpace(p)); err != nil || vals[i] <= 0 {
+			return 0, 0, 0, fmt.Errorf("invalid ramp %q, expected positive packets per second", s)
+		}
+	}
+	if vals[1] < vals[0] {
+		return 0, 0, 0, fmt.Errorf("invalid ramp %q, end is below start", s)
+	}
+	return vals[0], vals[1], vals[2], nil
+}
+
+// RunRamp sweeps the send rate from Start to End and reports the highest
+// sustainable rate
+func RunRamp(ctx context.Context, cfg RampConfig) error {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	fmt.Printf("Ramping %s from %d to %d pps in steps of %d, %ds per step, %d byte packets\n\n",
+		addr, cfg.Start, cfg.End, cfg.Step, cfg.StepSeconds, cfg.PacketSize)
+	fmt.Printf("%8s %12s %12s %8s %10s %10s\n", "rate", "offered pps", "recv pps", "loss", "p50 RTT", "p99 RTT")
+
+	var steps []StressStep
+	var rates []int
+	best, failed := -1, 0
+	for rate := cfg.Start; rate <= cfg.End && ctx.Err() == nil; rate += cfg.Step {
+		step, err := runStressStep(ctx, StressConfig{
+			Family:      cfg.Family,
+			PacketSize:  cfg.PacketSize,
+			Rate:        rate,
+			StepSeconds: cfg.StepSeconds,
+		}, addr, 1)
+		if err != nil {
+			return err
+		}
+		steps = append(steps, step)
+		rates = append(rates, rate)
+		fmt.Printf("%8d %12.0f %12.0f %7.2f%% %8.2fms %8.2fms\n",
+			rate, step.OfferedPPS, step.ReceivedPPS, step.LossPercent, step.P50Ms, step.P99Ms)
+
+		if step.LossPercent <= cfg.MaxLoss {
+			best, failed = len(steps)-1, 0
+		} else if failed++; failed >= rampStopAfter {
+			fmt.Printf("Stopping: %d steps in a row over %.2f%% loss\n", failed, cfg.MaxLoss)
+			break
+		}
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+
+	if best >= 0 {
+		s := steps[best]
+		fmt.Printf("\nHighest sustainable rate: %d pps (%s) with %.2f%% loss, p50 RTT %.2fms, p99 %.2fms\n",
+			rates[best], FormatBitrate(s.OfferedPPS*float64(cfg.PacketSize*8)), s.LossPercent, s.P50Ms, s.P99Ms)
+	} else {
+		fmt.Printf("\nNo rate stayed under %.2f%% loss, down to %d pps\n", cfg.MaxLoss, cfg.Start)
+	}
+
+	outputFile := cfg.OutputFile
+	if outputFile == "" {
+		outputFile = fmt.Sprintf("packet-test-ramp_%s.csv", time.Now().Format("2006-01-02_15-04-05"))
+	}
+	if err := saveRampCSV(outputFile, rates, steps); err != nil {
+		return fmt.Errorf("failed to save CSV: %w", err)
+	}
+	fmt.Printf("Results saved to %s\n", outputFile)
+	return nil
+}
+
+func saveRampCSV(filename string, rates []int, steps []StressStep) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+
+	writer.Write([]string{"rate", "offered_pps", "received_pps", "loss_percent", "rtt_p50_ms", "rtt_p99_ms"})
+	for i, s := range steps {
+		writer.Write([]string{
+			strconv.Itoa(rates[i]),
+			fmt.Sprintf("%.1f", s.OfferedPPS),
+			fmt.Sprintf("%.1f", s.ReceivedPPS),
+			fmt.Sprintf("%.2f", s.LossPercent),
+			fmt.Sprintf("%.3f", s.P50Ms),
+			fmt.Sprintf("%.3f", s.P99Ms),
+		})
+	}
+	return nil
+}
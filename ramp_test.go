@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestParseRamp(t *testing.T) {
+	tests := []struct {
+		in               string
+		start, end, step int
+		wantErr          bool
+	}{
+		{in: "10:100:10", start: 10, end: 100, step: 10},
+		{in: " 50 : 50 : 5 ", start: 50, end: 50, step: 5},
+		{in: "10:100", wantErr: true},
+		{in: "10:100:10:1", wantErr: true},
+		{in: "a:100:10", wantErr: true},
+		{in: "0:100:10", wantErr: true},
+		{in: "10:100:0", wantErr: true},
+		{in: "10:-5:1", wantErr: true},
+		{in: "100:10:10", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		start, end, step, err := ParseRamp(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRamp(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if start != tt.start || end != tt.end || step != tt.step {
+			t.Errorf("ParseRamp(%q) = %d, %d, %d, want %d, %d, %d", tt.in, start, end, step, tt.start, tt.end, tt.step)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// rateLimitPrune is how often the server forgets sources that went quiet
+const rateLimitPrune = time.Minute
+
+// rateLimiter drops packets beyond a rate from any one source address,
+// with a token bucket per address that allows a burst of one second's
+// worth. Addresses rather than address and port are limited, since a
+// spoofer picks its ports freely.
+type rateLimiter struct {
+	mu      sync.Mutex // the server's sockets share one limiter
+	rate    float64    // packets per second
+	buckets map[netip.Addr]*tokenBucket
+	pruned  time.Time
+	Dropped uint64
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	noted  bool // limiting was logged
+}
+
+func newRateLimiter(pps int) *rateLimiter {
+	return &rateLimiter{rate: float64(pps), buckets: make(map[netip.Addr]*tokenBucket), pruned: time.Now()}
+}
+
+// allow reports whether a packet from addr arriving at now is within the
+// rate, logging the first packet dropped from each address
+func (r *rateLimiter) allow(addr netip.Addr, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.pruned) >= rateLimitPrune {
+		r.prune(now)
+	}
+	b := r.buckets[addr]
+	if b == nil {
+		b = &tokenBucket{tokens: r.rate, last: now}
+		r.buckets[addr] = b
+	}
+	b.tokens = min(r.rate, b.tokens+now.Sub(b.last).Seconds()*r.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	r.Dropped++
+	if !b.noted {
+		b.noted = true
+		fmt.Printf("Rate limiting %s to %g packets per second\n", addr, r.rate)
+	}
+	return false
+}
+
+// prune forgets addresses idle long enough for their bucket to be full
+func (r *rateLimiter) prune(now time.Time) {
+	for addr, b := range r.buckets {
+		if now.Sub(b.last) >= rateLimitPrune {
+			delete(r.buckets, addr)
+		}
+	}
+	r.pruned = now
+}
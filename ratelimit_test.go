@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	a := netip.MustParseAddr("192.0.2.1")
+	b := netip.MustParseAddr("2001:db8::1")
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	r := newRateLimiter(2)
+	steps := []struct {
+		addr netip.Addr
+		ms   int
+		want bool
+	}{
+		{a, 0, true}, // a burst of one second's worth
+		{a, 0, true},
+		{a, 0, false},
+		{b, 0, true}, // each address has its own bucket
+		{a, 250, false},
+		{a, 500, true}, // refilled at 2 per second
+		{a, 500, false},
+		{a, 5000, true}, // the bucket holds no more than the burst
+		{a, 5000, true},
+		{a, 5000, false},
+	}
+	for i, s := range steps {
+		if got := r.allow(s.addr, at(s.ms)); got != s.want {
+			t.Errorf("step %d: allow(%s) at %dms = %v, want %v", i, s.addr, s.ms, got, s.want)
+		}
+	}
+	if r.Dropped != 4 {
+		t.Errorf("dropped %d, want 4", r.Dropped)
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	r := newRateLimiter(10)
+	start := time.Now()
+	r.allow(netip.MustParseAddr("192.0.2.1"), start)
+	r.allow(netip.MustParseAddr("192.0.2.2"), start.Add(rateLimitPrune/2))
+	r.prune(start.Add(rateLimitPrune))
+	if len(r.buckets) != 1 {
+		t.Errorf("%d buckets after pruning, want 1", len(r.buckets))
+	}
+}
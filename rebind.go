@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	rebindAfterFailures = 3           // consecutive send errors before redialing
+	rebindCheckInterval = time.Second // how often the route's source address is rechecked
+)
+
+// rebindConn is a connected UDP socket that can be swapped for a fresh one
+// when the local address changes mid-run (DHCP renewal, VPN reconnect,
+// interface flap). A swap closes the old socket, so a pending Read fails
+// once and the next Read picks up the new one.
+//
+// The server keys clients by address, so it sees a rebound client as a new
+// one; count-only reports only cover probes sent after the last rebind.
+type rebindConn struct {
+	network, addr string
+	opts          socketOptions
+
+	mu        sync.Mutex
+	conn      net.Conn
+	failures  int
+	lastCheck time.Time
+	tx        *txTracker // nil without transmit timestamps
+
+	batch *batchConn // the receiving goroutine's, for the current socket
+}
+
+// maxTxPending is how many probes may await a transmit timestamp before
+// the kernel is taken not to deliver them and tracking stops
+const maxTxPending = 1000
+
+// txStamp is the time a packet left the stack. The kernel numbers packets
+// by socket in the order sent; Seq is the probe's sequence number.
+type txStamp struct {
+	ID  uint32
+	Seq uint64
+	Ns  int64
+}
+
+// txTracker matches the kernel's transmit timestamps to probes. Writes
+// are serialized so the kernel's numbering follows the order counted.
+type txTracker struct {
+	mu   sync.Mutex
+	conn net.Conn // the socket the numbering counts on
+	next uint32
+	seqs map[uint32]uint64
+	oob  []byte
+}
+
+// socketOptions are the IP options every probe socket is set up with
+type socketOptions struct {
+	ZeroChecksum bool // send with a zero UDP checksum (IPv4 only)
+	DontFragment bool // set DF, so probes too big for the path are dropped rather than fragmented
+	TTL          int  // TTL or hop limit of probes, 0 = system default
+	DSCP         int  // DiffServ code point of probes, 0 = best effort
+	ECN          bool // send probes ECN-capable, as ECT(0)
+	RecvBuffer   int  // socket receive buffer in bytes, 0 = system default
+	SendBuffer   int  // socket send buffer in bytes, 0 = system default
+}
+
+func dialRebindable(network, addr string, opts socketOptions) (*rebindConn, error) {
+	c := &rebindConn{network: network, addr: addr, opts: opts, lastCheck: time.Now()}
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	if enableTxTimestamps(conn) {
+		c.tx = &txTracker{conn: conn, seqs: make(map[uint32]uint64), oob: make([]byte, 256)}
+	}
+	return c, nil
+}
+
+// dial opens a socket to the target with the options every socket gets
+func (c *rebindConn) dial() (net.Conn, error) {
+	conn, err := net.Dial(c.network, c.addr)
+	if err != nil {
+		return nil, err
+	}
+	enableRecvControl(conn)
+	if err := setSocketBuffers(conn, c.opts.RecvBuffer, c.opts.SendBuffer); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to size socket buffers: %w", err)
+	}
+	if c.tx != nil {
+		enableTxTimestamps(conn)
+	}
+	ipv6 := conn.RemoteAddr().(*net.UDPAddr).IP.To4() == nil
+	if c.opts.ZeroChecksum {
+		if err := disableChecksum(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to disable UDP checksum: %w", err)
+		}
+	}
+	if c.opts.DontFragment {
+		if err := setDontFragment(conn, ipv6); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to set DF: %w", err)
+		}
+	}
+	if c.opts.TTL > 0 {
+		if err := setTTL(conn, ipv6, c.opts.TTL); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to set TTL: %w", err)
+		}
+	}
+	tos := c.opts.DSCP << 2
+	if c.opts.ECN {
+		tos |= ecnECT0
+	}
+	if tos > 0 {
+		if err := setTOS(conn, ipv6, tos); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to set DSCP or ECN: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (c *rebindConn) current() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *rebindConn) Read(b []byte) (int, error)         { return c.current().Read(b) }
+func (c *rebindConn) Close() error                       { return c.current().Close() }
+func (c *rebindConn) LocalAddr() net.Addr                { return c.current().LocalAddr() }
+func (c *rebindConn) RemoteAddr() net.Addr               { return c.current().RemoteAddr() }
+func (c *rebindConn) SetDeadline(t time.Time) error      { return c.current().SetDeadline(t) }
+func (c *rebindConn) SetReadDeadline(t time.Time) error  { return c.current().SetReadDeadline(t) }
+func (c *rebindConn) SetWriteDeadline(t time.Time) error { return c.current().SetWriteDeadline(t) }
+
+// Write sends on the current socket, noting the kernel's number for
+// probes when transmit timestamps are on
+func (c *rebindConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	conn, tx := c.conn, c.tx
+	c.mu.Unlock()
+	if tx == nil {
+		return conn.Write(b)
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.conn != conn {
+		// Rebound: the new socket numbers from 0
+		tx.conn, tx.next = conn, 0
+		clear(tx.seqs)
+	}
+	n, err := conn.Write(b)
+	if err != nil {
+		return n, err
+	}
+	if len(b) >= HeaderSize && b[typeOffset] == TypeProbe {
+		tx.seqs[tx.next] = binary.BigEndian.Uint64(b[seqOffset:])
+	}
+	tx.next++
+	return n, err
+}
+
+// TxTimestamps returns the transmit timestamps the kernel has reported
+// since the last call, on the monotonic clock of client timestamps
+func (c *rebindConn) TxTimestamps() []txStamp {
+	c.mu.Lock()
+	tx := c.tx
+	c.mu.Unlock()
+	if tx == nil {
+		return nil
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	stamps := readTxTimestamps(tx.conn, tx.oob)
+	now := time.Now()
+	matched := stamps[:0]
+	for _, st := range stamps {
+		seq, ok := tx.seqs[st.ID]
+		if !ok {
+			continue
+		}
+		delete(tx.seqs, st.ID)
+		st.Seq = seq
+		st.Ns = monoUnixNano(now) - (now.UnixNano() - st.Ns)
+		matched = append(matched, st)
+	}
+	if len(tx.seqs) > maxTxPending {
+		c.mu.Lock()
+		c.tx = nil
+		c.mu.Unlock()
+	}
+	return matched
+}
+
+// ReadBatch reads one or more packets along with their ancillary data and
+// source addresses. Only the receiving goroutine may call it.
+func (c *rebindConn) ReadBatch(msgs []message) (int, error) {
+	conn := c.current().(*net.UDPConn)
+	if c.batch == nil || c.batch.uc != conn {
+		batch, err := newBatchConn(conn)
+		if err != nil {
+			return 0, err
+		}
+		c.batch = batch
+	}
+	return c.batch.ReadBatch(msgs)
+}
+
+// Check is called after every send with its result. It rebinds after
+// repeated send failures, or when a periodic check finds the route to the
+// target now leaves from a different local address, and returns the old
+// and new local addresses when it did.
+func (c *rebindConn) Check(sendErr error) (from, to string, rebound bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A refused send means the server isn't listening, which a new
+	// socket won't fix
+	if sendErr != nil && !errors.Is(sendErr, syscall.ECONNREFUSED) {
+		c.failures++
+	} else {
+		c.failures = 0
+	}
+	failing := c.failures >= rebindAfterFailures
+	if !failing && time.Since(c.lastCheck) < rebindCheckInterval {
+		return "", "", false
+	}
+	c.lastCheck = time.Now()
+
+	// Dialing UDP sends nothing; it only asks the kernel for a route and
+	// source address
+	fresh, err := c.dial()
+	if err != nil {
+		return "", "", false
+	}
+	oldIP := c.conn.LocalAddr().(*net.UDPAddr).IP
+	newIP := fresh.LocalAddr().(*net.UDPAddr).IP
+	if !failing && oldIP.Equal(newIP) {
+		fresh.Close()
+		return "", "", false
+	}
+
+	from, to = c.conn.LocalAddr().String(), fresh.LocalAddr().String()
+	c.conn.Close()
+	c.conn = fresh
+	c.failures = 0
+	return from, to, true
+}
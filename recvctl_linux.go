@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// enableRecvControl asks the kernel to attach ancillary data to each
+// received packet: the TTL (IPv4) or hop limit (IPv6), the TOS byte or
+// traffic class for its ECN field, the kernel's receive timestamp
+// (SO_TIMESTAMPNS), and the socket's running count of packets dropped
+// because its receive buffer was full (SO_RXQ_OVFL). On a single-family
+// socket the other family's options fail; the errors are ignored.
+func enableRecvControl(conn net.Conn) {
+	uc, ok := conn.(*net.UDPConn)
+	if !ok {
+		return
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return
+	}
+	raw.Control(func(fd uintptr) {
+		syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTTL, 1)
+		syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVHOPLIMIT, 1)
+		syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_RECVTOS, 1)
+		syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVTCLASS, 1)
+		syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RXQ_OVFL, 1)
+		syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+	})
+}
+
+// parseRecvControl extracts the received TTL or hop limit, ECN field,
+// kernel timestamp and the socket drop counter from ancillary data.
+// Missing values are left zero.
+func parseRecvControl(oob []byte) recvControl {
+	var rc recvControl
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return rc
+	}
+	for _, m := range msgs {
+		// IPv4 passes the TOS as a single byte, everything else as an int
+		if m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_TOS && len(m.Data) > 0 {
+			rc.ECN = int(m.Data[0] & 3)
+			continue
+		}
+		if m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SCM_TIMESTAMPNS && len(m.Data) >= int(unsafe.Sizeof(syscall.Timespec{})) {
+			rc.KernelNs = (*syscall.Timespec)(unsafe.Pointer(&m.Data[0])).Nano()
+			continue
+		}
+		if len(m.Data) < 4 {
+			continue
+		}
+		value := binary.NativeEndian.Uint32(m.Data)
+		switch {
+		case m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_TTL,
+			m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_HOPLIMIT:
+			rc.TTL = int(value)
+		case m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_TCLASS:
+			rc.ECN = int(value & 3)
+		case m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SO_RXQ_OVFL:
+			rc.Drops = value
+			rc.HasDrops = true
+		}
+	}
+	return rc
+}
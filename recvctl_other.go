@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// Received TTL, ECN, kernel timestamps and socket drop counts need
+// per-platform control message parsing, which is only implemented for
+// Linux. Elsewhere replies are recorded with TTL 0, no ECN and a userspace
+// timestamp, and local drops aren't reported.
+func enableRecvControl(conn net.Conn) {}
+
+func parseRecvControl(oob []byte) recvControl { return recvControl{} }
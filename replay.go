@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A traffic profile replays the timing and sizes of real traffic, e.g. a
+// game's, as probes instead of a constant rate. It comes from a schedule
+// file of "offset size" lines, the offset in seconds and the size the UDP
+// payload in bytes, or from a pcap of the traffic, whose UDP packets are
+// all taken; capture with a filter for just the flow to copy, e.g.
+// "udp and dst port 3074". The profile is looped until the run ends.
+
+// profilePacket is one packet of a profile
+type profilePacket struct {
+	Offset time.Duration // from the first packet
+	Size   int           // UDP payload bytes
+}
+
+// Profile is a traffic pattern to replay
+type Profile struct {
+	Source  string
+	Packets []profilePacket
+	Period  time.Duration // length of one loop: the last offset plus an average gap
+	Raised  int           // packets smaller than the probe header, sent at HeaderSize
+	Clipped int           // packets bigger than MaxPacketSize, sent at that size
+}
+
+// pcap file magic numbers, microsecond and nanosecond timestamps
+const (
+	pcapMagicMicros = 0xa1b2c3d4
+	pcapMagicNanos  = 0xa1b23c4d
+	pcapngMagic     = 0x0a0d0d0a
+)
+
+// LoadProfile reads a schedule file or pcap
+func LoadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var packets []profilePacket
+	if len(data) >= 4 {
+		switch binary.BigEndian.Uint32(data) {
+		case pcapMagicMicros, pcapMagicNanos, bits.ReverseBytes32(pcapMagicMicros), bits.ReverseBytes32(pcapMagicNanos):
+			packets, err = parsePcap(data)
+		case pcapngMagic:
+			return nil, fmt.Errorf("%s is pcapng, which isn't supported; convert it with editcap -F pcap", path)
+		default:
+			packets, err = parseSchedule(data)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(packets) < 2 {
+		return nil, fmt.Errorf("%s: a profile needs at least 2 packets, found %d", path, len(packets))
+	}
+
+	sort.SliceStable(packets, func(i, j int) bool { return packets[i].Offset < packets[j].Offset })
+	p := &Profile{Source: filepath.Base(path), Packets: packets}
+	first := packets[0].Offset
+	for i := range p.Packets {
+		pkt := &p.Packets[i]
+		pkt.Offset -= first
+		if pkt.Size < HeaderSize {
+			pkt.Size = HeaderSize
+			p.Raised++
+		} else if pkt.Size > MaxPacketSize {
+			pkt.Size = MaxPacketSize
+			p.Clipped++
+		}
+	}
+	last := p.Packets[len(p.Packets)-1].Offset
+	if last <= 0 {
+		return nil, fmt.Errorf("%s: all packets have the same timestamp", path)
+	}
+	p.Period = last + last/time.Duration(len(p.Packets)-1)
+	return p, nil
+}
+
+// parseSchedule reads "offset size" lines, separated by whitespace or a
+// comma. Blank lines and # comments are skipped, as is a header line.
+func parseSchedule(data []byte) ([]profilePacket, error) {
+	var packets []profilePacket
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' })
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected an offset and a size", line)
+		}
+		offset, offErr := strconv.ParseFloat(fields[0], 64)
+		size, sizeErr := strconv.Atoi(fields[1])
+		if offErr != nil || sizeErr != nil {
+			if len(packets) == 0 && offErr != nil {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: expected an offset in seconds and a size in bytes", line)
+		}
+		if offset < 0 || math.IsInf(offset, 0) || math.IsNaN(offset) || size < 0 {
+			return nil, fmt.Errorf("line %d: offset and size can't be negative", line)
+		}
+		packets = append(packets, profilePacket{Offset: time.Duration(offset * float64(time.Second)), Size: size})
+	}
+	return packets, scanner.Err()
+}
+
+// parsePcap takes the UDP payload size and timestamp of each UDP packet in
+// a classic pcap file
+func parsePcap(data []byte) ([]profilePacket, error) {
+	if len(data) < 24 {
+		return nil, errors.New("truncated pcap header")
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	magic := order.Uint32(data)
+	if magic != pcapMagicMicros && magic != pcapMagicNanos {
+		order = binary.LittleEndian
+		magic = order.Uint32(data)
+	}
+	fraction := time.Microsecond
+	if magic == pcapMagicNanos {
+		fraction = time.Nanosecond
+	}
+	linkType := order.Uint32(data[20:]) & 0xffff
+
+	var packets []profilePacket
+	for rest := data[24:]; len(rest) >= 16; {
+		ts := time.Duration(order.Uint32(rest))*time.Second + time.Duration(order.Uint32(rest[4:]))*fraction
+		captured := int(order.Uint32(rest[8:]))
+		if captured > len(rest)-16 {
+			break // truncated capture
+		}
+		frame := rest[16 : 16+captured]
+		rest = rest[16+captured:]
+
+		ip, err := pcapNetwork(frame, linkType)
+		if err != nil {
+			return nil, err
+		}
+		if size, ok := udpPayloadSize(ip); ok {
+			packets = append(packets, profilePacket{Offset: ts, Size: size})
+		}
+	}
+	return packets, nil
+}
+
+// pcapNetwork strips the link-layer header off a frame, returning nil for
+// frames that don't carry IP
+func pcapNetwork(frame []byte, linkType uint32) ([]byte, error) {
+	var etherType uint16
+	switch linkType {
+	case 0: // BSD loopback, a host-order address family
+		if len(frame) < 4 {
+			return nil, nil
+		}
+		return frame[4:], nil
+	case 1: // Ethernet, possibly VLAN tagged
+		if len(frame) < 14 {
+			return nil, nil
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[12:]), frame[14:]
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(frame) >= 4 {
+			etherType, frame = binary.BigEndian.Uint16(frame[2:]), frame[4:]
+		}
+	case 12, 14, 101: // raw IP
+		return frame, nil
+	case 113: // Linux cooked capture
+		if len(frame) < 16 {
+			return nil, nil
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[14:]), frame[16:]
+	case 276: // Linux cooked capture v2
+		if len(frame) < 20 {
+			return nil, nil
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame), frame[20:]
+	default:
+		return nil, fmt.Errorf("unsupported pcap link type %d", linkType)
+	}
+	if etherType != 0x0800 && etherType != 0x86dd {
+		return nil, nil
+	}
+	return frame, nil
+}
+
+// udpPayloadSize returns the UDP payload length of an IP packet, from the
+// UDP header so snapped captures still count in full
+func udpPayloadSize(ip []byte) (int, bool) {
+	if len(ip) < 1 {
+		return 0, false
+	}
+	var udp []byte
+	switch ip[0] >> 4 {
+	case 4:
+		ihl := int(ip[0]&0x0f) * 4
+		// Only the first fragment has the UDP header
+		if len(ip) < 20 || ip[9] != 17 || binary.BigEndian.Uint16(ip[6:])&0x1fff != 0 || len(ip) < ihl {
+			return 0, false
+		}
+		udp = ip[ihl:]
+	case 6:
+		if len(ip) < 40 || ip[6] != 17 {
+			return 0, false
+		}
+		udp = ip[40:]
+	default:
+		return 0, false
+	}
+	if len(udp) < 8 {
+		return 0, false
+	}
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+	if length < 8 {
+		return 0, false
+	}
+	return length - 8, true
+}
+
+// Rate returns the profile's average packets per second
+func (p *Profile) Rate() int {
+	return max(1, int(math.Round(float64(len(p.Packets))/p.Period.Seconds())))
+}
+
+// AvgSize returns the profile's average packet size
+func (p *Profile) AvgSize() int {
+	var total int
+	for _, pkt := range p.Packets {
+		total += pkt.Size
+	}
+	return total / len(p.Packets)
+}
+
+func (p *Profile) String() string {
+	lo, hi := p.Packets[0].Size, p.Packets[0].Size
+	for _, pkt := range p.Packets {
+		lo, hi = min(lo, pkt.Size), max(hi, pkt.Size)
+	}
+	return fmt.Sprintf("%s: %d packets over %s, %d pps average, %d-%d bytes (avg %d)",
+		p.Source, len(p.Packets), p.Period.Round(time.Millisecond), p.Rate(), lo, hi, p.AvgSize())
+}
+
+// replaySlot is a probe due from a profile
+type replaySlot struct {
+	Time time.Time
+	Size int
+}
+
+// replay delivers the profile's packets at their offsets, looping, until
+// the returned function is called. A slot the sender takes late is still
+// sent, so sizes, order and bursts are kept; the schedule just slips.
+func (p *Profile) replay() (<-chan replaySlot, func()) {
+	c := make(chan replaySlot)
+	done := make(chan struct{})
+	go func() {
+		start := time.Now()
+		sleep := newSlotSleeper()
+		defer sleep.stop()
+		for loop := time.Duration(0); ; loop++ {
+			for _, pkt := range p.Packets {
+				due := start.Add(loop*p.Period + pkt.Offset)
+				if !sleep.until(due, done) {
+					return
+				}
+				select {
+				case <-done:
+					return
+				case c <- replaySlot{Time: due, Size: pkt.Size}:
+				}
+			}
+		}
+	}()
+	return c, func() { close(done) }
+}
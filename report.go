@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Receive reports let the client learn which probes actually reached the
+// server. The client sends a TypeReportRequest packet whose SeqNum is the
+// first sequence number it wants covered, and the server answers with a
+// TypeReport packet carrying its totals and a bitmap chunk starting there.
+
+const (
+	reportChunkSeqs = 8 * 8192 // sequence numbers covered per report packet
+	maxTrackedSeq   = 1 << 26  // bitmap cap so garbage sequence numbers can't exhaust memory
+	maxSeqLead      = 1 << 16  // how far past 4x the probes received the bitmap may grow at once
+	reportTimeout   = 500 * time.Millisecond
+	reportRetries   = 3
+)
+
+// Report payload layout (after the packet header)
+const (
+	reportReceivedOffset = 0
+	reportBytesOffset    = 8
+	reportMaxSeqOffset   = 16
+	reportBitmapOffset   = 24
+)
+
+// ReceiveLog tracks which probes the server received from one client
+type ReceiveLog struct {
+	Received   uint64 // distinct probes
+	Bytes      uint64
+	MaxSeq     uint64
+	Reordered  uint64   // probes that arrived after a later one
+	Duplicates uint64   // probes received again, not counted in Received
+	seen       []uint64 // bitmap indexed by sequence number
+
+	RunID     string    // announced by the client, "" if it didn't
+	SyncStart time.Time // agreed start if the client joined a barrier
+
+	// Arrival timing for the server's metrics and summaries
+	FirstRecv   time.Time
+	LastRecv    time.Time
+	JitterNs    float64 // RFC 3550 interarrival jitter
+	RatePPS     float64 // probes per second over the last full second
+	lastTransit int64
+	rateStart   time.Time
+	lastSeen    time.Time // last packet of any type, for eviction
+	rateCount   uint64
+	summarized  uint64 // Received when the client was last summarized
+	token       uint64 // auth token the client's packets must carry, see auth.go
+	untrusted   bool   // replies were truncated or refused for lack of a token
+	capped      bool   // replies were capped to the probe size for lack of a token
+	noStream    bool   // a stream was refused for lack of a token
+}
+
+// Record marks a probe as received
+func (l *ReceiveLog) Record(seq uint64, size int) {
+	l.Bytes += uint64(size)
+	if l.Has(seq) {
+		l.Duplicates++
+		return
+	}
+	l.Received++
+	if seq < l.MaxSeq {
+		l.Reordered++
+	}
+	l.MaxSeq = max(l.MaxSeq, seq)
+	l.mark(seq)
+}
+
+// mark sets seq in the bitmap. The bitmap only grows in step with the
+// probes received, so one datagram with a garbage sequence number can't
+// make it allocate megabytes; probes past that aren't tracked.
+func (l *ReceiveLog) mark(seq uint64) {
+	if seq >= maxTrackedSeq || seq > 4*l.Received+maxSeqLead {
+		return
+	}
+	word := seq / 64
+	for uint64(len(l.seen)) <= word {
+		l.seen = append(l.seen, 0)
+	}
+	l.seen[word] |= 1 << (seq % 64)
+}
+
+// Has reports whether a probe was received
+func (l *ReceiveLog) Has(seq uint64) bool {
+	word := seq / 64
+	if word >= uint64(len(l.seen)) {
+		return false
+	}
+	return l.seen[word]&(1<<(seq%64)) != 0
+}
+
+// encodeReport builds the report packet covering sequence numbers from start
+func (l *ReceiveLog) encodeReport(start uint64, session uint32) []byte {
+	var bitmapLen uint64
+	if l.MaxSeq >= start {
+		bitmapLen = (min(l.MaxSeq-start+1, reportChunkSeqs) + 7) / 8
+	}
+
+	pkt := &Packet{SeqNum: start, Type: TypeReport, Session: session}
+	buf := pkt.Encode(HeaderSize + reportBitmapOffset + int(bitmapLen))
+	payload := buf[HeaderSize:]
+	binary.BigEndian.PutUint64(payload[reportReceivedOffset:], l.Received)
+	binary.BigEndian.PutUint64(payload[reportBytesOffset:], l.Bytes)
+	binary.BigEndian.PutUint64(payload[reportMaxSeqOffset:], l.MaxSeq)
+
+	bitmap := payload[reportBitmapOffset:]
+	for i := uint64(0); i < bitmapLen*8; i++ {
+		if l.Has(start + i) {
+			bitmap[i/8] |= 1 << (i % 8)
+		}
+	}
+	return buf
+}
+
+// decodeReport merges a report packet's totals and bitmap chunk into l
+func (l *ReceiveLog) decodeReport(pkt *Packet) error {
+	if len(pkt.Payload) < reportBitmapOffset {
+		return fmt.Errorf("short report (%d bytes)", len(pkt.Payload))
+	}
+	l.Received = binary.BigEndian.Uint64(pkt.Payload[reportReceivedOffset:])
+	l.Bytes = binary.BigEndian.Uint64(pkt.Payload[reportBytesOffset:])
+	l.MaxSeq = binary.BigEndian.Uint64(pkt.Payload[reportMaxSeqOffset:])
+
+	bitmap := pkt.Payload[reportBitmapOffset:]
+	for i := uint64(0); i < uint64(len(bitmap))*8; i++ {
+		if bitmap[i/8]&(1<<(i%8)) != 0 {
+			l.mark(pkt.SeqNum + i)
+		}
+	}
+	return nil
+}
+
+// FetchReport retrieves the server's receive log for this connection and
+// session, covering sequence numbers up to lastSeq. The receiver goroutine
+// must be stopped first since replies are read directly from conn.
+func FetchReport(ctx context.Context, conn net.Conn, session Session, lastSeq uint64) (*ReceiveLog, error) {
+	log := &ReceiveLog{}
+	buf := make([]byte, 65535)
+	defer conn.SetReadDeadline(time.Time{})
+
+	// Unblock the pending Read once the context is done
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	for start := uint64(1); start == 1 || start <= lastSeq; start += reportChunkSeqs {
+		req := session.stamp(&Packet{SeqNum: start, Type: TypeReportRequest})
+		received := false
+
+		for attempt := 0; attempt < reportRetries && !received; attempt++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if _, err := conn.Write(req.Encode(HeaderSize)); err != nil {
+				return nil, fmt.Errorf("failed to request report: %w", err)
+			}
+
+			conn.SetReadDeadline(time.Now().Add(reportTimeout))
+			for {
+				n, err := conn.Read(buf)
+				if err != nil {
+					break // timed out, retry
+				}
+				if err := versionError(buf[:n]); err != nil {
+					return nil, err
+				}
+				// Skip late echoes still in flight
+				pkt := DecodePacket(buf[:n])
+				if pkt == nil || pkt.Type != TypeReport || pkt.SeqNum != start || pkt.Session != session.ID {
+					continue
+				}
+				if err := log.decodeReport(pkt); err != nil {
+					return nil, err
+				}
+				received = true
+				break
+			}
+		}
+
+		if !received {
+			return nil, fmt.Errorf("no report from server after %d attempts", reportRetries)
+		}
+	}
+
+	return log, nil
+}
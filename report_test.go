@@ -0,0 +1,91 @@
+package main
+
+import "testing"
+
+func TestReceiveLogRecord(t *testing.T) {
+	var l ReceiveLog
+	for _, seq := range []uint64{1, 2, 4, 3, 3, 6} {
+		l.Record(seq, 100)
+	}
+	if l.Received != 5 || l.Duplicates != 1 || l.Reordered != 1 || l.MaxSeq != 6 || l.Bytes != 600 {
+		t.Errorf("received %d, duplicates %d, reordered %d, max seq %d, bytes %d, want 5, 1, 1, 6, 600",
+			l.Received, l.Duplicates, l.Reordered, l.MaxSeq, l.Bytes)
+	}
+	for seq, want := range map[uint64]bool{0: false, 1: true, 3: true, 5: false, 6: true, 1000: false} {
+		if l.Has(seq) != want {
+			t.Errorf("Has(%d) = %v, want %v", seq, !want, want)
+		}
+	}
+	if got := l.missing(); got != 1 {
+		t.Errorf("missing = %d, want 1", got)
+	}
+	if gaps, longest := l.seqGaps(); gaps != 1 || longest != 1 {
+		t.Errorf("gaps = %d, longest %d, want 1 and 1", gaps, longest)
+	}
+}
+
+func TestReceiveLogMarkBounded(t *testing.T) {
+	tests := []struct {
+		name    string
+		seq     uint64
+		tracked bool
+	}{
+		{"near the start", 1000, true},
+		{"within the lead", maxSeqLead, true},
+		{"far ahead", 1 << 25, false},
+		{"past the cap", maxTrackedSeq, false},
+		{"garbage", 1<<64 - 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var l ReceiveLog
+			l.Record(tt.seq, HeaderSize)
+			if l.Has(tt.seq) != tt.tracked {
+				t.Errorf("Has(%d) = %v, want %v", tt.seq, !tt.tracked, tt.tracked)
+			}
+			// One datagram must not allocate more than the lead allows
+			if words := len(l.seen); words > (4+maxSeqLead)/64+1 {
+				t.Errorf("bitmap grew to %d words", words)
+			}
+			if gaps, _ := l.seqGaps(); !tt.tracked && gaps != 0 {
+				t.Errorf("untracked gaps counted: %d", gaps)
+			}
+		})
+	}
+}
+
+func TestReceiveLogReportRoundTrip(t *testing.T) {
+	var server ReceiveLog
+	for seq := uint64(1); seq <= reportChunkSeqs+100; seq++ {
+		if seq%7 != 0 {
+			server.Record(seq, 64)
+		}
+	}
+
+	var client ReceiveLog
+	for start := uint64(0); start <= server.MaxSeq; start += reportChunkSeqs {
+		pkt := DecodePacket(server.encodeReport(start, 0xabcd))
+		if pkt == nil || pkt.Type != TypeReport || pkt.Session != 0xabcd {
+			t.Fatalf("report from %d doesn't decode as this session's report", start)
+		}
+		if err := client.decodeReport(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.Received != server.Received || client.Bytes != server.Bytes || client.MaxSeq != server.MaxSeq {
+		t.Errorf("totals %d/%d/%d, want %d/%d/%d", client.Received, client.Bytes, client.MaxSeq,
+			server.Received, server.Bytes, server.MaxSeq)
+	}
+	for seq := uint64(0); seq <= server.MaxSeq+1; seq++ {
+		if client.Has(seq) != server.Has(seq) {
+			t.Fatalf("Has(%d) = %v after the report, server has %v", seq, client.Has(seq), server.Has(seq))
+		}
+	}
+}
+
+func TestDecodeReportShort(t *testing.T) {
+	var l ReceiveLog
+	if err := l.decodeReport(&Packet{Payload: make([]byte, reportBitmapOffset-1)}); err == nil {
+		t.Error("short report accepted")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const indexTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Packet Test Runs</title>
+    <style>
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
+            margin: 0;
+            padding: 20px;
+            background: #1a1a2e;
+            color: #eee;
+        }
+        h1 { color: #00d9ff; }
+        table {
+            border-collapse: collapse;
+            width: 100%;
+            background: #16213e;
+            border-radius: 8px;
+        }
+        th, td { padding: 8px 12px; text-align: left; }
+        th { color: #888; font-weight: normal; border-bottom: 1px solid #333; }
+        td.num { text-align: right; }
+        tr:hover { background: #1f2b4d; }
+        a { color: #00d9ff; }
+        .bad { color: #ff6b6b; }
+    </style>
+</head>
+<body>
+    <h1>Packet Test Runs</h1>
+    <table>
+        <tr><th>Started</th><th>Target</th><th>Sent</th><th>Loss</th><th>Avg RTT</th><th>p99 RTT</th><th>Events</th><th>Files</th></tr>
+{{ROWS}}    </table>
+</body>
+</html>`
+
+// newRunDir creates the subdirectory for one run under the results
+// directory, named by start time and host so runs sort chronologically
+func newRunDir(resultsDir, timestamp, host string) (string, error) {
+	name := timestamp + "_" + strings.Map(func(r rune) rune {
+		if r == ':' || r == '/' || r == '\\' || r == '%' {
+			return '-'
+		}
+		return r
+	}, host)
+	dir := filepath.Join(resultsDir, name)
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// UpdateIndex rewrites index.html in the results directory, listing every
+// run found in its subdirectories, newest first
+func UpdateIndex(resultsDir string) error {
+	metaFiles, err := filepath.Glob(filepath.Join(resultsDir, "*", "*.meta.json"))
+	if err != nil {
+		return err
+	}
+
+	type run struct {
+		csvFile string
+		meta    *RunMetadata
+	}
+	var runs []run
+	for _, f := range metaFiles {
+		csvFile := strings.TrimSuffix(f, ".meta.json") + ".csv"
+		meta, err := loadMetadata(csvFile)
+		if err != nil || meta == nil {
+			fmt.Printf("Warning: skipping %s in index: %v\n", f, err)
+			continue
+		}
+		runs = append(runs, run{csvFile, meta})
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].meta.StartTime.After(runs[j].meta.StartTime) })
+
+	var rows strings.Builder
+	for _, r := range runs {
+		rel, err := filepath.Rel(resultsDir, r.csvFile)
+		if err != nil {
+			rel = r.csvFile
+		}
+		rel = filepath.ToSlash(rel)
+		report := strings.TrimSuffix(rel, ".csv") + ".html"
+
+		sent, loss, avgRTT, p99RTT := "", "", "", ""
+		lossClass := ""
+		if s := r.meta.Summary; s != nil {
+			sent = fmt.Sprintf("%d", s.Sent)
+			loss = fmt.Sprintf("%.2f%%", s.LossPercent)
+			avgRTT = fmt.Sprintf("%.1fms", s.AvgRTTMs)
+			p99RTT = fmt.Sprintf("%.1fms", s.P99RTTMs)
+			if s.LossPercent > 1 {
+				lossClass = " bad"
+			}
+		}
+
+		links := fmt.Sprintf(`<a href="%s">csv</a>`, html.EscapeString(rel))
+		if _, err := os.Stat(filepath.Join(resultsDir, filepath.FromSlash(report))); err == nil {
+			links = fmt.Sprintf(`<a href="%s">report</a> `, html.EscapeString(report)) + links
+		}
+
+		fmt.Fprintf(&rows, "        <tr><td>%s</td><td>%s</td><td class=\"num\">%s</td><td class=\"num%s\">%s</td><td class=\"num\">%s</td><td class=\"num\">%s</td><td class=\"num\">%d</td><td>%s</td></tr>\n",
+			r.meta.StartTime.Format("2006-01-02 15:04:05"), html.EscapeString(r.meta.Target),
+			sent, lossClass, loss, avgRTT, p99RTT, len(r.meta.Events), links)
+	}
+
+	page := strings.Replace(indexTemplate, "{{ROWS}}", rows.String(), 1)
+	return os.WriteFile(filepath.Join(resultsDir, "index.html"), []byte(page), 0644)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// runIDSize is the length of a formatted run ID
+const runIDSize = 36
+
+// newRunID returns a random (version 4) UUID identifying one run. It ties
+// together the client's files, the server's log and any uploaded records.
+func newRunID() string {
+	var u [16]byte
+	rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// sessionID returns the session a run's packets carry: the first 32 bits
+// of its run ID, so the server's log lines up with the client's. The
+// server keeps separate state per address and session, so two runs from
+// the same address (a restart that reuses the source port, or clients
+// behind one NAT mapping) can't mix their sequence numbers.
+func sessionID(runID string) uint32 {
+	v, _ := strconv.ParseUint(runID[:8], 16, 32)
+	return max(uint32(v), 1) // 0 means no session
+}
+
+// sessionKey returns the key the server tracks a client under
+func sessionKey(addr string, session uint32) string {
+	if session == 0 {
+		return addr
+	}
+	return fmt.Sprintf("%s/%08x", addr, session)
+}
+
+// announceRun sends the server a hello carrying the run ID so its log can
+// name the run. It isn't retried: the ID is for joining logs, and the
+// test doesn't depend on it arriving.
+func announceRun(conn net.Conn, session Session, runID string) error {
+	hello := session.stamp(&Packet{Type: TypeHello}).Encode(HeaderSize + runIDSize)
+	copy(hello[HeaderSize:], runID)
+	_, err := conn.Write(hello)
+	return err
+}
+
+// helloRunID returns the run ID carried by a hello, or "" if it has none
+func helloRunID(hello []byte) string {
+	if len(hello) != HeaderSize+runIDSize {
+		return ""
+	}
+	id := hello[HeaderSize:]
+	for _, c := range id {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || c == '-') {
+			return ""
+		}
+	}
+	return string(id)
+}
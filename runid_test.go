@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestHelloRunID(t *testing.T) {
+	hello := func(id string, size int) []byte {
+		buf := (&Packet{Type: TypeHello}).Encode(size)
+		copy(buf[HeaderSize:], id)
+		return buf
+	}
+	const id = "0f8fad5b-d9cb-469f-a165-70867728950e"
+	tests := []struct {
+		name  string
+		hello []byte
+		want  string
+	}{
+		{"run ID", hello(id, HeaderSize+runIDSize), id},
+		{"bare hello", hello("", HeaderSize), ""},
+		{"too long", hello(id, HeaderSize+runIDSize+1), ""},
+		{"upper case", hello("0F8FAD5B-D9CB-469F-A165-70867728950E", HeaderSize+runIDSize), ""},
+		{"not hex", hello("0f8fad5b-d9cb-469f-a165-70867728950z", HeaderSize+runIDSize), ""},
+		{"control bytes", hello("", HeaderSize+runIDSize), ""},
+	}
+	for _, tt := range tests {
+		if got := helloRunID(tt.hello); got != tt.want {
+			t.Errorf("%s: helloRunID = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewRunID(t *testing.T) {
+	a, b := newRunID(), newRunID()
+	if a == b {
+		t.Error("two runs got the same ID")
+	}
+	if got := helloRunID(append((&Packet{Type: TypeHello}).Encode(HeaderSize), a...)); got != a {
+		t.Errorf("run ID %q doesn't survive a hello", a)
+	}
+	if sessionID(a) != sessionID(a) {
+		t.Error("session ID isn't stable for a run")
+	}
+}
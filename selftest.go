@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// Self-test mode runs a server and a client in this process over
+// loopback. Nothing is between them but the host's own stack, so the
+// latency and jitter it measures are the tool's floor on this machine:
+// timers, scheduling, two trips through the stack and the echo. A real
+// path's numbers within that floor can't be told from the tool's own.
+
+const selfTestDuration = 10 // default seconds, unless --duration or --count is given
+
+// RunSelfTest measures the loopback floor with cfg's rate, sizes and
+// options, and prints it after the client's usual summary
+func RunSelfTest(ctx context.Context, cfg ClientConfig) error {
+	port, err := freeUDPPort()
+	if err != nil {
+		return fmt.Errorf("failed to find a free loopback port: %w", err)
+	}
+
+	serverCtx, stopServer := context.WithCancel(context.Background())
+	defer stopServer()
+	ready := make(chan struct{})
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- RunServer(serverCtx, ServerConfig{Port: port, Family: "IPv4", Quiet: true, Ready: ready})
+	}()
+	select {
+	case <-ready:
+	case err := <-serverErr:
+		return fmt.Errorf("failed to start the loopback server: %w", err)
+	}
+
+	fmt.Printf("Self-test: server and client in this process over loopback, port %d\n\n", port)
+	cfg.Host, cfg.Port, cfg.Family = "127.0.0.1", port, "IPv4"
+	cfg.NoLookup, cfg.Traceroute, cfg.ICMPCompare = true, false, false
+	meta, err := RunClient(ctx, cfg)
+	stopServer()
+	<-serverErr
+	if err != nil {
+		return err
+	}
+
+	sum := meta.Summary
+	fmt.Println("\n--- Self-test ---")
+	if sum == nil || sum.Received == 0 {
+		fmt.Println("No replies over loopback; the floor couldn't be measured")
+		return nil
+	}
+	fmt.Printf("Measurement floor at %d pps, %d byte packets: RTT avg %.3fms p99 %.3fms, jitter %.3fms (RFC 3550 %.3fms), loss %.2f%%\n",
+		meta.TargetRate, cfg.PacketSize, sum.AvgRTTMs, sum.P99RTTMs, sum.JitterMs, sum.RFCJitterMs, sum.LossPercent)
+	fmt.Println("Latency and jitter this small on a real path are the tool and this host, not the network")
+	return nil
+}
+
+// freeUDPPort returns a loopback UDP port nothing is bound to right now
+func freeUDPPort() (int, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
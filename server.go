@@ -1,52 +1,447 @@
 package main
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"net"
+	"sync"
 	"time"
 )
 
-// RunServer starts the UDP echo server
-func RunServer(port int) error {
-	addr := fmt.Sprintf(":%d", port)
-	conn, err := net.ListenPacket("udp", addr)
+// maxUnkeyedReplies is the most replies a probe from a client the server
+// can't authenticate gets, however many it asks for
+const maxUnkeyedReplies = 4
+
+// server is the state the echo loops share. With several sockets each has
+// its own loop, so anything shared is changed under mu.
+type server struct {
+	cfg     ServerConfig
+	limiter *rateLimiter // nil without a rate limit, locks itself
+	streams streamer     // locks itself
+
+	mu      sync.Mutex
+	clients map[string]*ReceiveLog
+	barrier barrier
+
+	// Datagrams that aren't packets of this protocol version are dropped;
+	// each address is flagged once
+	strays      map[string]bool
+	mismatched  map[string]bool
+	strayCount  uint64
+	loadSources map[string]uint64 // auth token of each session sending load
+	loadBytes   uint64
+}
+
+// RunServer starts the UDP echo server and serves until ctx is cancelled.
+// It listens on both IPv4 and IPv6 where the system allows, or only on
+// cfg.Family if that is "IPv4" or "IPv6". Each client is summarized once
+// it goes idle and again for any activity left when the server stops.
+//
+// With cfg.Sockets above 1 it opens that many sockets on the port with
+// SO_REUSEPORT, each served by its own goroutine; the kernel spreads
+// clients across them by address, so every client stays on one socket.
+func RunServer(ctx context.Context, cfg ServerConfig) error {
+	addr := fmt.Sprintf(":%d", cfg.Port)
+	conns, err := listenServer(udpNetwork(cfg.Family), addr, max(cfg.Sockets, 1))
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
-	defer conn.Close()
+	batches := make([]*batchConn, len(conns))
+	for i, uc := range conns {
+		defer uc.Close()
+		enableRecvControl(uc)
+		if err := setSocketBuffers(uc, cfg.RecvBuffer, cfg.SendBuffer); err != nil {
+			return fmt.Errorf("failed to size socket buffers: %w", err)
+		}
+		if batches[i], err = newBatchConn(uc); err != nil {
+			return fmt.Errorf("failed to set up socket: %w", err)
+		}
+	}
 
-	fmt.Printf("UDP server listening on port %d\n", port)
-	fmt.Println("Press Ctrl+C to stop")
+	// Closing the sockets is the only way to unblock a pending read
+	stop := context.AfterFunc(ctx, func() {
+		for _, uc := range conns {
+			uc.Close()
+		}
+	})
+	defer stop()
 
-	buf := make([]byte, 65535)
-	clients := make(map[string]bool)
+	s := &server{
+		cfg:         cfg,
+		clients:     make(map[string]*ReceiveLog),
+		strays:      make(map[string]bool),
+		mismatched:  make(map[string]bool),
+		loadSources: make(map[string]uint64),
+	}
+	if cfg.RateLimit > 0 {
+		s.limiter = newRateLimiter(cfg.RateLimit)
+	}
+	if cfg.Ready != nil {
+		close(cfg.Ready)
+	}
+	if !cfg.Quiet {
+		if cfg.Family != "" {
+			fmt.Printf("UDP server listening on port %d (%s only)\n", cfg.Port, cfg.Family)
+		} else {
+			fmt.Printf("UDP server listening on port %d\n", cfg.Port)
+		}
+		if len(conns) > 1 {
+			fmt.Printf("Serving from %d sockets sharing the port (SO_REUSEPORT), a goroutine each\n", len(conns))
+		}
+		if cfg.RecvBuffer > 0 || cfg.SendBuffer > 0 {
+			printSocketBuffers(conns[0], cfg.RecvBuffer, cfg.SendBuffer)
+		}
+		if cfg.CSVFile != "" {
+			fmt.Printf("Saving client summaries to %s\n", cfg.CSVFile)
+		}
+		if cfg.Truncate {
+			fmt.Printf("Clients without the key get %d byte replies and no reports or streams\n", HeaderSize)
+		}
+		if cfg.Key == "" {
+			fmt.Println("Downlink streams are off: they need --key")
+		}
+		if cfg.RateLimit > 0 {
+			fmt.Printf("Limiting each source address to %d packets per second\n", cfg.RateLimit)
+		}
+		fmt.Println("Press Ctrl+C to stop")
+	}
 
-	for {
-		n, clientAddr, err := conn.ReadFrom(buf)
+	// The metrics endpoint and the summaries read clients under mu too
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if !cfg.Quiet {
+					summarizeIdle(s.clients, &s.mu, now.Add(-serverIdleTimeout), cfg.CSVFile)
+				}
+				s.prune(now)
+			}
+		}
+	}()
+	if cfg.MetricsPort > 0 {
+		err := ServeMetrics(ctx, cfg.MetricsPort, func(w io.Writer) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			writeServerMetrics(w, s.clients)
+		})
 		if err != nil {
-			fmt.Printf("Read error: %v\n", err)
-			continue
+			return err
 		}
-		recvTime := time.Now()
+		fmt.Printf("Serving Prometheus metrics on :%d/metrics\n", cfg.MetricsPort)
+	}
 
-		// Log new clients
-		addrStr := clientAddr.String()
-		if !clients[addrStr] {
-			clients[addrStr] = true
-			fmt.Printf("New client connected: %s\n", addrStr)
+	var wg sync.WaitGroup
+	for i, uc := range conns {
+		wg.Go(func() { s.serve(ctx, uc, batches[i]) })
+	}
+	wg.Wait()
+
+	if !cfg.Quiet {
+		summarizeIdle(s.clients, &s.mu, time.Time{}, cfg.CSVFile)
+	}
+	if s.limiter != nil && s.limiter.Dropped > 0 {
+		fmt.Printf("Rate limiting dropped %d packets\n", s.limiter.Dropped)
+	}
+	if s.strayCount > 0 {
+		fmt.Printf("Ignored %d datagrams without the packet-test header\n", s.strayCount)
+	}
+	if s.loadBytes > 0 {
+		fmt.Printf("Received %d bytes of background load\n", s.loadBytes)
+	}
+	return nil
+}
+
+// listenServer opens the server's sockets, sharing the port when there
+// are several
+func listenServer(network, addr string, n int) ([]*net.UDPConn, error) {
+	if n == 1 {
+		conn, err := net.ListenPacket(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return []*net.UDPConn{conn.(*net.UDPConn)}, nil
+	}
+	lc := net.ListenConfig{Control: reusePort}
+	conns := make([]*net.UDPConn, 0, n)
+	for range n {
+		conn, err := lc.ListenPacket(context.Background(), network, addr)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
 		}
+		conns = append(conns, conn.(*net.UDPConn))
+	}
+	return conns, nil
+}
 
-		// Stamp server processing time into the response (if packet is large enough)
-		if n >= HeaderSize {
-			procNs := time.Since(recvTime).Nanoseconds()
-			binary.BigEndian.PutUint64(buf[16:24], uint64(procNs))
+// prune forgets clients silent for serverEvictAfter once their summary
+// is logged, since any source can open a session with a new ID
+func (s *server) prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, l := range s.clients {
+		if now.Sub(l.lastSeen) >= serverEvictAfter && (l.Received == l.summarized || s.cfg.Quiet) {
+			delete(s.clients, key)
 		}
+	}
+}
 
-		// Echo the packet back immediately
-		_, err = conn.WriteTo(buf[:n], clientAddr)
+// serve echoes the probes arriving on one socket until ctx is done
+func (s *server) serve(ctx context.Context, conn *net.UDPConn, batch *batchConn) {
+	cfg := s.cfg
+	in := newMessages(65535, 256)
+	// Probe replies queue up while a batch of reads is handled and go out
+	// together, each stamped with its processing time as it is sent
+	out := newMessages(MaxPacketSize, 0)
+	outRecv := make([]time.Time, len(out))
+	queued := 0
+	flush := func() {
+		for sent := 0; sent < queued; {
+			for i := sent; i < queued; i++ {
+				procNs := time.Since(outRecv[i]).Nanoseconds()
+				binary.BigEndian.PutUint64(out[i].Buf[procTimeOffset:], uint64(procNs))
+			}
+			n, err := batch.WriteBatch(out[sent:queued])
+			sent += n
+			if err != nil {
+				fmt.Printf("Write error to %s: %v\n", out[sent].Addr, err)
+				sent++
+			}
+		}
+		queued = 0
+	}
+
+	for {
+		count, err := batch.ReadBatch(in)
 		if err != nil {
-			fmt.Printf("Write error to %s: %v\n", addrStr, err)
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Printf("Read error: %v\n", err)
+			continue
+		}
+		now := time.Now()
+		for i := range in[:count] {
+			m := &in[i]
+			buf, n, clientAddr := m.Buf, m.N, m.Addr
+			rc := parseRecvControl(m.OOB[:m.OOBN])
+			// Datagrams of a batch arrived at different times; the
+			// kernel's timestamp tells them apart
+			recvTime := now
+			if age := now.UnixNano() - rc.KernelNs; rc.KernelNs > 0 && age >= 0 && age < int64(time.Second) {
+				recvTime = now.Add(-time.Duration(age))
+			}
+			addrStr := clientAddr.String()
+
+			// Background load is never answered, so it can't be reflected at
+			// anyone; it is only counted. Load from a client with the key
+			// skips the rate limit, which would otherwise starve the probes
+			// from the same address, and anyone else's counts against it.
+			if version, ok := packetVersion(buf[:n]); ok && version == ProtocolVersion && n >= HeaderSize && buf[typeOffset] == TypeLoad {
+				session := binary.BigEndian.Uint32(buf[sessionOffset:])
+				key := sessionKey(addrStr, session)
+				s.mu.Lock()
+				token, known := s.loadSources[key]
+				s.mu.Unlock()
+				if !known {
+					token = authToken(cfg.Key, session)
+				}
+				keyed := cfg.Key != "" && binary.BigEndian.Uint64(buf[authOffset:]) == token
+				if !keyed && s.limiter != nil && !s.limiter.allow(clientAddr.AddrPort().Addr().Unmap(), recvTime) {
+					continue
+				}
+				s.mu.Lock()
+				s.loadBytes += uint64(n)
+				if !known {
+					s.loadSources[key] = token
+					fmt.Printf("Client %s is sending background load\n", key)
+				}
+				s.mu.Unlock()
+				continue
+			}
+			if s.limiter != nil && !s.limiter.allow(clientAddr.AddrPort().Addr().Unmap(), recvTime) {
+				continue
+			}
+
+			switch version, ok := packetVersion(buf[:n]); {
+			case ok && version != ProtocolVersion:
+				// Tell the client rather than leave it waiting for echoes
+				s.mu.Lock()
+				if !s.mismatched[addrStr] {
+					s.mismatched[addrStr] = true
+					fmt.Printf("Client %s speaks protocol version %d, this server %d; its packets are ignored\n", addrStr, version, ProtocolVersion)
+				}
+				s.mu.Unlock()
+				if _, err := conn.WriteTo(encodeVersionNotice(), clientAddr); err != nil {
+					fmt.Printf("Write error to %s: %v\n", addrStr, err)
+				}
+				continue
+			case !ok || n < HeaderSize:
+				s.mu.Lock()
+				s.strayCount++
+				if !s.strays[addrStr] {
+					s.strays[addrStr] = true
+					fmt.Printf("Ignoring datagrams from %s without the packet-test header\n", addrStr)
+				}
+				s.mu.Unlock()
+				continue
+			}
+
+			// Log new clients, each session from an address separately
+			session := binary.BigEndian.Uint32(buf[sessionOffset:])
+			key := sessionKey(addrStr, session)
+			s.mu.Lock()
+			client, ok := s.clients[key]
+			if !ok {
+				client = &ReceiveLog{token: authToken(cfg.Key, session)}
+				s.clients[key] = client
+				if !cfg.Quiet {
+					fmt.Printf("New client connected: %s\n", key)
+				}
+			}
+			client.lastSeen = recvTime
+			s.mu.Unlock()
+
+			// Without the key a client could be a spoofed source, so with
+			// --truncate it gets nothing bigger than it sent
+			keyed := cfg.Key != "" && binary.BigEndian.Uint64(buf[authOffset:]) == client.token
+			trusted := !cfg.Truncate || keyed
+			limited := func() bool {
+				if trusted {
+					return false
+				}
+				s.mu.Lock()
+				defer s.mu.Unlock()
+				if !client.untrusted {
+					client.untrusted = true
+					fmt.Printf("Client %s has no valid key: replies cut to %d bytes, reports and streams refused\n", key, HeaderSize)
+				}
+				return true
+			}
+
+			seq := binary.BigEndian.Uint64(buf[seqOffset:])
+			switch buf[typeOffset] {
+			case TypeProbe:
+				s.mu.Lock()
+				if client.Received == 0 && !client.SyncStart.IsZero() {
+					fmt.Printf("Client %s first probe %+.2fms from synchronized start\n",
+						key, float64(recvTime.Sub(client.SyncStart).Nanoseconds())/1e6)
+				}
+				client.Record(seq, n)
+				client.observeArrival(int64(binary.BigEndian.Uint64(buf[timestampOffset:])), recvTime)
+				s.mu.Unlock()
+			case TypeReportRequest:
+				if limited() {
+					continue
+				}
+				s.mu.Lock()
+				report := client.encodeReport(seq, session)
+				s.mu.Unlock()
+				if _, err := conn.WriteTo(report, clientAddr); err != nil {
+					fmt.Printf("Write error to %s: %v\n", addrStr, err)
+				}
+				continue
+			case TypeHello:
+				s.mu.Lock()
+				if runID := helloRunID(buf[:n]); runID != "" && runID != client.RunID {
+					client.RunID = runID
+					if !cfg.Quiet {
+						fmt.Printf("Client %s is run %s\n", key, runID)
+					}
+				}
+				s.mu.Unlock()
+				if _, err := conn.WriteTo(buf[:n], clientAddr); err != nil {
+					fmt.Printf("Write error to %s: %v\n", addrStr, err)
+				}
+				continue
+			case TypeStreamRequest:
+				// Only a client that proves it has the key gets a stream;
+				// anyone could send the request from a spoofed address
+				if !keyed {
+					s.mu.Lock()
+					if !client.noStream {
+						client.noStream = true
+						fmt.Printf("Client %s has no valid key: stream refused\n", key)
+					}
+					s.mu.Unlock()
+					continue
+				}
+				s.streams.handle(ctx, conn, DecodePacket(buf[:n]), clientAddr)
+				continue
+			case TypeBarrier:
+				s.mu.Lock()
+				s.barrier.handle(conn, DecodePacket(buf[:n]), clientAddr, s.clients)
+				s.mu.Unlock()
+				continue
+			case TypeClockSync:
+				binary.BigEndian.PutUint64(buf[serverRecvOffset:], uint64(recvTime.UnixNano()))
+				binary.BigEndian.PutUint64(buf[procTimeOffset:], uint64(time.Since(recvTime).Nanoseconds()))
+				if _, err := conn.WriteTo(buf[:n], clientAddr); err != nil {
+					fmt.Printf("Write error to %s: %v\n", addrStr, err)
+				}
+				continue
+			default:
+				continue
+			}
+
+			// The client picks the size and number of replies so each
+			// direction can run at its own rate
+			replySize := int(binary.BigEndian.Uint16(buf[replySizeOffset:]))
+			if replySize == 0 {
+				replySize = n
+			}
+			replySize = max(HeaderSize, min(replySize, MaxPacketSize))
+			if replySize > n {
+				clear(buf[n:replySize])
+			}
+			replyCount := binary.BigEndian.Uint16(buf[replyCountOffset:])
+			if limited() {
+				replySize, replyCount = HeaderSize, min(replyCount, 1)
+			} else if !keyed {
+				// A source that can't prove it has the key gets no more
+				// bytes back than it sent, so it can't be spoofed to aim a
+				// flood at someone else
+				size := min(replySize, n)
+				count := min(replyCount, maxUnkeyedReplies, uint16(n/size))
+				if size != replySize || count != replyCount {
+					s.mu.Lock()
+					if !client.capped {
+						client.capped = true
+						fmt.Printf("Client %s has no valid key: replies capped to the bytes of its probes\n", key)
+					}
+					s.mu.Unlock()
+				}
+				replySize, replyCount = size, count
+			}
+			binary.BigEndian.PutUint64(buf[serverRecvOffset:], uint64(recvTime.UnixNano()))
+			// Replies carry the probe's ECN field back, so a congestion
+			// mark on the way out reaches the client too
+			tos := tosControl(clientAddr, rc.ECN)
+
+			for r := uint16(1); r <= replyCount; r++ {
+				// Stamp the reply index into the response; the
+				// processing time is stamped when it is sent, so its
+				// receive time plus processing time is when the server
+				// sent it
+				if queued == len(out) {
+					flush()
+				}
+				binary.BigEndian.PutUint16(buf[replyCountOffset:], r)
+				o := &out[queued]
+				o.N = copy(o.Buf, buf[:replySize])
+				o.OOB, o.Addr = tos, clientAddr
+				outRecv[queued] = recvTime
+				queued++
+			}
 		}
+		flush()
 	}
 }
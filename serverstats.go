@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The server summarizes what it received from each client once the client
+// goes quiet: how many probes, which sequence numbers never arrived, and
+// how evenly they came. A client that resumes is summarized again later
+// with its running totals.
+
+// serverIdleTimeout is how long a client must be silent to be summarized
+const serverIdleTimeout = 5 * time.Second
+
+// serverEvictAfter is how long a client must be silent, and summarized,
+// before the server forgets it. It leaves time for the client's closing
+// report requests; a client that comes back after it starts afresh.
+const serverEvictAfter = time.Minute
+
+// ServerConfig holds server configuration
+type ServerConfig struct {
+	Port        int
+	Family      string // "IPv4" or "IPv6" to listen on only that family, "" = both
+	MetricsPort int    // serve Prometheus metrics on this port, 0 = off
+	CSVFile     string // append a row per client summary here, "" = off
+	RateLimit   int    // packets per second accepted from one source address, 0 = unlimited
+	Key         string // shared secret of trusted clients, see auth.go
+	Truncate    bool   // give clients without the key only header-size single replies
+	RecvBuffer  int    // socket receive buffer in bytes, 0 = system default
+	SendBuffer  int    // socket send buffer in bytes, 0 = system default
+	Sockets     int    // sockets sharing the port with SO_REUSEPORT, each with its own goroutine, 0 or 1 = one
+
+	// For a server run in-process by the self-test: Quiet leaves out the
+	// banner, client logs and summaries, and Ready is closed once the
+	// sockets are listening
+	Quiet bool
+	Ready chan struct{}
+}
+
+// seqGaps returns the number of runs of missing sequence numbers between
+// 1 and MaxSeq and the length of the longest, as far as the bitmap reaches
+func (l *ReceiveLog) seqGaps() (gaps, longest uint64) {
+	var run uint64
+	for seq := uint64(1); seq <= l.MaxSeq && seq < uint64(len(l.seen))*64; seq++ {
+		// Skip whole words at a time where nothing is missing
+		if seq%64 == 0 && run == 0 && seq/64 < uint64(len(l.seen)) && l.seen[seq/64] == ^uint64(0) {
+			seq += 63
+			continue
+		}
+		if l.Has(seq) {
+			if run > 0 {
+				gaps++
+				longest = max(longest, run)
+			}
+			run = 0
+		} else {
+			run++
+		}
+	}
+	if run > 0 {
+		gaps++
+		longest = max(longest, run)
+	}
+	return gaps, longest
+}
+
+// missing returns the sequence numbers up to MaxSeq that never arrived
+func (l *ReceiveLog) missing() uint64 {
+	tracked := min(l.MaxSeq, maxTrackedSeq-1)
+	var seen uint64
+	for _, w := range l.seen {
+		seen += uint64(bits.OnesCount64(w))
+	}
+	if l.Has(0) {
+		seen--
+	}
+	return tracked - min(seen, tracked)
+}
+
+// clientSummary is one line of the server's per-client summaries
+type clientSummary struct {
+	Addr        string
+	RunID       string
+	First, Last time.Time
+	Received    uint64
+	Bytes       uint64
+	MaxSeq      uint64
+	Missing     uint64
+	Gaps        uint64
+	LongestGap  uint64
+	Reordered   uint64
+	Duplicates  uint64
+	JitterMs    float64
+	AvgRatePPS  float64
+	LossPercent float64
+}
+
+func summarizeClient(addr string, l *ReceiveLog) clientSummary {
+	s := clientSummary{
+		Addr:       addr,
+		RunID:      l.RunID,
+		First:      l.FirstRecv,
+		Last:       l.LastRecv,
+		Received:   l.Received,
+		Bytes:      l.Bytes,
+		MaxSeq:     l.MaxSeq,
+		Missing:    l.missing(),
+		Reordered:  l.Reordered,
+		Duplicates: l.Duplicates,
+		JitterMs:   l.JitterNs / 1e6,
+	}
+	s.Gaps, s.LongestGap = l.seqGaps()
+	if span := s.Last.Sub(s.First).Seconds(); span > 0 {
+		s.AvgRatePPS = float64(s.Received-1) / span
+	}
+	if s.MaxSeq > 0 {
+		s.LossPercent = float64(s.Missing) / float64(min(s.MaxSeq, maxTrackedSeq-1)) * 100
+	}
+	return s
+}
+
+func (s clientSummary) print() {
+	run := ""
+	if s.RunID != "" {
+		run = " (run " + s.RunID + ")"
+	}
+	fmt.Printf("\n--- Client %s%s ---\n", s.Addr, run)
+	fmt.Printf("Received %d probes (%d bytes) over %s, %.1f pps average\n",
+		s.Received, s.Bytes, s.Last.Sub(s.First).Round(time.Millisecond), s.AvgRatePPS)
+	fmt.Printf("Missing %d of %d (%.2f%%) in %d gaps, longest %d\n",
+		s.Missing, s.MaxSeq, s.LossPercent, s.Gaps, s.LongestGap)
+	fmt.Printf("Reordered %d, duplicates %d, interarrival jitter %.2fms (RFC 3550)\n\n",
+		s.Reordered, s.Duplicates, s.JitterMs)
+}
+
+// summarizeIdle prints and saves a summary of each client that has been
+// silent since before cutoff and received probes since its last summary.
+// A zero cutoff summarizes every such client, for shutdown.
+func summarizeIdle(clients map[string]*ReceiveLog, mu *sync.Mutex, cutoff time.Time, csvFile string) {
+	mu.Lock()
+	var due []clientSummary
+	for addr, l := range clients {
+		if l.Received == l.summarized || (!cutoff.IsZero() && l.LastRecv.After(cutoff)) {
+			continue
+		}
+		l.summarized = l.Received
+		due = append(due, summarizeClient(addr, l))
+	}
+	mu.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].First.Before(due[j].First) })
+	for _, s := range due {
+		s.print()
+		if csvFile != "" {
+			if err := appendClientSummary(csvFile, s); err != nil {
+				fmt.Printf("Warning: failed to update %s: %v\n", csvFile, err)
+			}
+		}
+	}
+}
+
+// appendClientSummary adds a client summary to the server CSV, creating it
+// with a header if needed
+func appendClientSummary(filename string, s clientSummary) error {
+	_, statErr := os.Stat(filename)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if os.IsNotExist(statErr) {
+		writer.Write([]string{"client", "run_id", "first_recv", "last_recv", "received", "bytes", "max_seq",
+			"missing", "loss_percent", "gaps", "longest_gap", "reordered", "duplicates", "jitter_ms", "avg_rate_pps"})
+	}
+	writer.Write([]string{
+		s.Addr,
+		s.RunID,
+		s.First.Format(time.RFC3339Nano),
+		s.Last.Format(time.RFC3339Nano),
+		strconv.FormatUint(s.Received, 10),
+		strconv.FormatUint(s.Bytes, 10),
+		strconv.FormatUint(s.MaxSeq, 10),
+		strconv.FormatUint(s.Missing, 10),
+		fmt.Sprintf("%.3f", s.LossPercent),
+		strconv.FormatUint(s.Gaps, 10),
+		strconv.FormatUint(s.LongestGap, 10),
+		strconv.FormatUint(s.Reordered, 10),
+		strconv.FormatUint(s.Duplicates, 10),
+		fmt.Sprintf("%.3f", s.JitterMs),
+		fmt.Sprintf("%.1f", s.AvgRatePPS),
+	})
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSLAFailed is returned by a run that exceeded one of its SLA limits;
+// the client exits with status 2 for it
+var ErrSLAFailed = errors.New("SLA failed")
+
+// SLA is a set of pass/fail limits on the run's headline numbers. Zero
+// limits are not checked.
+type SLA struct {
+	MaxLoss   float64 `json:"max_loss_percent,omitempty"`
+	MaxP99    float64 `json:"max_p99_ms,omitempty"`
+	MaxJitter float64 `json:"max_jitter_ms,omitempty"`
+}
+
+// CheckSLA prints each limit against the run's summary and returns the
+// names of the checks that failed
+func CheckSLA(sla SLA, sum *RunSummary) []string {
+	checks := []struct {
+		name         string
+		value, limit float64
+		unit         string
+	}{
+		{"Loss", sum.LossPercent, sla.MaxLoss, "%"},
+		{"p99 RTT", sum.P99RTTMs, sla.MaxP99, "ms"},
+		{"Jitter", sum.JitterMs, sla.MaxJitter, "ms"},
+	}
+
+	fmt.Println("\n--- SLA ---")
+	var failed []string
+	for _, c := range checks {
+		if c.limit <= 0 {
+			continue
+		}
+		result := "PASS"
+		if c.value > c.limit {
+			result = "FAIL"
+			failed = append(failed, c.name)
+		}
+		fmt.Printf("%s %-8s %.2f%s (limit %.2f%s)\n", result, c.name+":", c.value, c.unit, c.limit, c.unit)
+	}
+	return failed
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// Socket buffers hold the packets that arrive while the reader is busy and
+// those written faster than the interface takes them. At high rates the
+// system defaults overflow on the host itself, which then looks like loss
+// on the network.
+
+// setSocketBuffers sizes a socket's receive and send buffers; 0 leaves
+// one at the system default
+func setSocketBuffers(conn net.Conn, rcv, snd int) error {
+	uc, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	if rcv > 0 {
+		if err := uc.SetReadBuffer(rcv); err != nil {
+			return fmt.Errorf("receive buffer: %w", err)
+		}
+	}
+	if snd > 0 {
+		if err := uc.SetWriteBuffer(snd); err != nil {
+			return fmt.Errorf("send buffer: %w", err)
+		}
+	}
+	return nil
+}
+
+// printSocketBuffers reports a socket's effective buffer sizes, warning
+// where the system granted less than asked for
+func printSocketBuffers(conn net.Conn, rcv, snd int) {
+	gotRcv, gotSnd, ok := socketBuffers(conn)
+	if !ok {
+		fmt.Printf("Socket buffers: asked for %s receive, %s send\n", bufferSize(rcv), bufferSize(snd))
+		return
+	}
+	fmt.Printf("Socket buffers: %s receive, %s send\n", bufferSize(gotRcv), bufferSize(gotSnd))
+	if rcv > gotRcv {
+		fmt.Printf("Warning: asked for a %s receive buffer, the system allows %s; raise net.core.rmem_max\n",
+			bufferSize(rcv), bufferSize(gotRcv))
+	}
+	if snd > gotSnd {
+		fmt.Printf("Warning: asked for a %s send buffer, the system allows %s; raise net.core.wmem_max\n",
+			bufferSize(snd), bufferSize(gotSnd))
+	}
+}
+
+func bufferSize(n int) string {
+	switch {
+	case n == 0:
+		return "default"
+	case n%(1<<20) == 0:
+		return fmt.Sprintf("%dMiB", n>>20)
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMiB", float64(n)/(1<<20))
+	case n%(1<<10) == 0:
+		return fmt.Sprintf("%dKiB", n>>10)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Spilling streams each packet record to the CSV once it can no longer
+// change, instead of holding every record until the end of the run, so a
+// day-long run at a high rate doesn't need a map entry per probe. A record
+// is final once it is older than the longest loss timeout; a reply after
+// that is ignored, as the probe already counts as lost. Rows go to a
+// temporary file next to the results, renamed into place at the end since
+// the final name isn't known until then.
+
+// spillFile is the CSV records are spilled to
+type spillFile struct {
+	file   *os.File
+	writer *csv.Writer
+	clock  *ClockSync // splits RTTs into one-way latency, nil if unknown
+}
+
+// newSpillFile creates a temporary CSV in dir and writes the header
+func newSpillFile(dir string, clock *ClockSync) (*spillFile, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp(dir, ".packet-test-*.csv")
+	if err != nil {
+		return nil, err
+	}
+	f := &spillFile{file: file, writer: csv.NewWriter(file), clock: clock}
+	f.writer.Write(csvColumns)
+	return f, nil
+}
+
+// spillDir returns the directory the spill file goes in: where the
+// results will be saved, so the final rename stays on one filesystem
+func spillDir(cfg ClientConfig) string {
+	if cfg.ResultsDir != "" {
+		return cfg.ResultsDir
+	}
+	return filepath.Dir(cfg.OutputFile)
+}
+
+func (f *spillFile) write(r *PacketRecord) {
+	if f.clock != nil && !r.OneWay {
+		applyClockOffset(r, f.clock)
+	}
+	f.writer.Write(csvRow(r))
+}
+
+// Close finishes the file and moves it to filename
+func (f *spillFile) Close(filename string) error {
+	f.writer.Flush()
+	err := f.writer.Error()
+	if chmodErr := f.file.Chmod(0644); err == nil { // CreateTemp makes it private
+		err = chmodErr
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.file.Name())
+		return err
+	}
+	if err := os.Rename(f.file.Name(), filename); err != nil {
+		return fmt.Errorf("failed to move %s into place: %w", f.file.Name(), err)
+	}
+	return nil
+}
+
+// Abort removes the file of a run that didn't finish; it does nothing
+// once the file has been closed
+func (f *spillFile) Abort() {
+	if f.file.Close() == nil {
+		os.Remove(f.file.Name())
+	}
+}
@@ -8,6 +8,15 @@ import (
 	"time"
 )
 
+// Loss timeout bounds. Replies are waited for lossTimeoutRTTs times the
+// slowest RTT seen, so satellite and intercontinental paths aren't cut off
+// by a timeout tuned for a LAN.
+const (
+	lossTimeoutRTTs = 3
+	minLossTimeout  = 500 * time.Millisecond
+	maxLossTimeout  = 10 * time.Second
+)
+
 // PacketRecord stores data for a single packet
 type PacketRecord struct {
 	SeqNum       uint64
@@ -15,43 +24,139 @@ type PacketRecord struct {
 	RecvTime     int64 // Unix nanoseconds, 0 if lost
 	LatencyMs    float64
 	ServerProcMs float64
-	NetLatencyMs float64
+	ClientProcMs float64 // send path overhead between timestamping and handing the packet to the kernel
+	SchedErrorUs float64 // how late the probe was sent after its slot, the sender's delay rather than the network's
+	NetLatencyMs float64 // RTT minus server and client processing
+	ServerRecv   int64   // server clock when the probe arrived, Unix nanoseconds, 0 if unknown
+	UpMs         float64 // one-way client to server latency, valid if OneWay
+	DownMs       float64 // one-way server to client latency, valid if OneWay
+	OneWay       bool    // UpMs and DownMs were estimated from the server's clock
+	RecvTTL      int     // TTL or hop limit of the reply, 0 if unknown
+	ECN          int     // ECN field of the reply, ecnCE if a router marked it
+	Path         int     // index of the source port the probe was sent from
 	Lost         bool
+	LossDir      string // LossUp or LossDown when the server report says which, "" if unknown
 	Late         bool
+	Reordered    bool    // the first reply arrived after a reply to a later probe on the same path
+	Duplicates   int     // replies received more than once
+	RFCJitterMs  float64 // RFC 3550 interarrival jitter of the RTT as of this reply
+	replies      uint64  // bitmask of the reply indices received, the first 64 only
+	stamped      int64   // the timestamp the probe carries, once SentTime is its TX timestamp
 }
 
+// Loss directions
+const (
+	LossUp   = "up"   // the probe never reached the server
+	LossDown = "down" // the server got the probe but the reply was lost
+)
+
 // Stats tracks packet statistics
 type Stats struct {
 	mu      sync.Mutex
+	queue   chan ingestEvent // Record calls waiting to be applied; see ingest
 	records map[uint64]*PacketRecord
 
 	sent     uint64
 	received uint64
 	late     uint64
 
+	// Asymmetric mode: probes may ask for zero or several replies
+	unechoed        uint64
+	repliesExpected uint64
+	repliesReceived uint64
+	lastSentNs      int64
+	lastSeq         uint64
+	sendPerSecond   []int // probes sent in each second since start, including unechoed ones
+
+	// Probes awaiting their first reply; on long paths thousands can be
+	// in flight at once
+	outstanding     uint64
+	peakOutstanding uint64
+
+	// Client overhead in microseconds: sendOverhead is timestamp to Write
+	// return, recvOverhead is the kernel's receive timestamp (or Read
+	// return where there is none) to recorded, tickLag is how late the
+	// sender ran after the slot it was sending for, txDelay is timestamp
+	// to the kernel's transmit timestamp where there is one
+	sendOverhead  digest
+	txDelay       digest
+	recvOverhead  digest
+	tickLag       digest
+	kernelStamped uint64 // first replies with a kernel receive timestamp
+
+	// Replies dropped by the client's own socket because its receive
+	// buffer was full; they show up as lost but never left the host
+	localDrops uint64
+
+	// Lost probes attributed by the server's receive report
+	lostUp   uint64
+	lostDown uint64
+
+	// Replies whose payload failed verification, and replies strict
+	// validation rejected by reason
+	corrupt  uint64
+	rejected [rejectKinds]uint64
+
+	// Reply TTL; a change mid-run means the return path was rerouted
+	firstTTL   int
+	lastTTL    int
+	ttlChanges []RunEvent
+
+	// ECN field of first replies by codepoint, when probes are sent
+	// ECN-capable
+	ecn        bool
+	ecnReplies [4]uint64
+
 	lateThreshold float64 // milliseconds
 
-	latencies    []float64
-	netLatencies []float64
-	serverProc   []float64
-	minLat       float64
-	maxLat       float64
-	sumLat       float64
-	minNet       float64
-	maxNet       float64
-	sumNet       float64
-	minServer    float64
-	maxServer    float64
-	sumServer    float64
+	// Fixed loss timeout, 0 = adapt to the RTT. With it each probe is
+	// settled in the interval stats once its timeout passes, answered in
+	// time or lost; timeoutSeq is the next probe to settle.
+	timeout      time.Duration
+	timeoutSeq   uint64
+	afterTimeout uint64 // replies that arrived after the timeout
+
+	// Reordering is judged per path, against the highest sequence number
+	// answered on it so far
+	maxRecvSeq map[int]uint64
+	reordered  uint64
+	duplicates uint64
+
+	// RFC 3550 interarrival jitter: the RTT differences between replies
+	// in arrival order, smoothed with gain 1/16
+	rfcJitter   float64
+	lastTransit int64 // RTT of the previous reply in nanoseconds, 0 = none yet
+
+	// Distributions of the first replies, in bounded memory
+	rtt     digest
+	net     digest
+	server  digest
+	rttHist histogram // for the metrics endpoint, in seconds
+	netHist histogram
 
 	windowStartNs    int64
+	windowFirstSeq   uint64
 	windowSent       uint64
 	windowReceived   uint64
 	windowLate       uint64
+	windowCE         uint64
 	windowLatencies  []float64
 	windowNetLatency []float64
 	windowServerProc []float64
 
+	// Records older than the longest loss timeout are written out and
+	// dropped when spilling; spillSeq is the next one to write
+	spill    *spillFile
+	spillSeq uint64
+
+	// Records are streamed once they can no longer change, as they are
+	// spilled; streamSeq is the next one to stream. Losses wait in
+	// heldLosses for the server report, unless spilling, which skips it.
+	streams    []recordStream
+	streamSeq  uint64
+	heldLosses []*PacketRecord
+
+	quiet         bool // compute interval stats without printing them
 	lastPrintTime time.Time
 	startTime     time.Time
 }
@@ -60,52 +165,130 @@ type Stats struct {
 func NewStats(lateThreshold float64) *Stats {
 	now := time.Now()
 	return &Stats{
-		records:       make(map[uint64]*PacketRecord),
-		lateThreshold: lateThreshold,
-		minLat:        math.MaxFloat64,
-		minNet:        math.MaxFloat64,
-		minServer:     math.MaxFloat64,
-		maxLat:        0,
-		lastPrintTime: now,
-		startTime:     now,
-		windowStartNs: now.UnixNano(),
+		queue:          make(chan ingestEvent, ingestQueue),
+		records:        make(map[uint64]*PacketRecord),
+		maxRecvSeq:     make(map[int]uint64),
+		lateThreshold:  lateThreshold,
+		lastPrintTime:  now,
+		startTime:      now,
+		windowStartNs:  monoUnixNano(now),
+		windowFirstSeq: 1,
+		timeoutSeq:     1,
+		spillSeq:       1,
+		streamSeq:      1,
 	}
 }
 
-// RecordSent records a sent packet
-func (s *Stats) RecordSent(seqNum uint64, sentTime int64) {
-	s.mu.Lock()
+// SetTimeout sets a fixed loss timeout: a probe not answered within it
+// counts as lost in the interval stats and live metrics
+func (s *Stats) SetTimeout(timeout time.Duration) {
+	s.lock()
 	defer s.mu.Unlock()
+	s.timeout = timeout
+}
+
+// RecordSent records a sent packet that asked for the given number of
+// replies, and the source port index it went out on. Probes without
+// replies only count towards upstream throughput.
+func (s *Stats) RecordSent(seqNum uint64, sentTime int64, replies int, path int) {
+	s.ingest(ingestEvent{kind: ingestSent, seq: seqNum, ns: sentTime, replies: replies, path: path})
+}
 
+func (s *Stats) recordSent(seqNum uint64, sentTime int64, replies int, path int) {
+	s.lastSentNs = sentTime
+	s.lastSeq = seqNum
+	if sec := int((sentTime - monoUnixNano(s.startTime)) / int64(time.Second)); sec >= 0 {
+		for len(s.sendPerSecond) <= sec {
+			s.sendPerSecond = append(s.sendPerSecond, 0)
+		}
+		s.sendPerSecond[sec]++
+	}
+	if replies == 0 {
+		s.unechoed++
+		return
+	}
+
+	s.repliesExpected += uint64(replies)
+	s.outstanding++
+	s.peakOutstanding = max(s.peakOutstanding, s.outstanding)
 	s.sent++
 	s.windowSent++
 	s.records[seqNum] = &PacketRecord{
 		SeqNum:   seqNum,
 		SentTime: sentTime,
+		Path:     path,
 		Lost:     true, // Assume lost until we receive response
 	}
 }
 
-// RecordReceived records a received packet response
-func (s *Stats) RecordReceived(seqNum uint64, recvTime int64, serverProcNs int64) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// RecordReceived records a received packet response, given the index of
+// the reply among those the probe asked for, along with the server's
+// clock when the probe arrived, 0 if unknown, and the reply's TTL and ECN
+// field
+func (s *Stats) RecordReceived(seqNum uint64, reply uint16, recvTime int64, serverProcNs, serverRecvNs int64, rc recvControl) {
+	s.ingest(ingestEvent{kind: ingestReceived, seq: seqNum, reply: reply, ns: recvTime, nowNs: monoUnixNano(time.Now()),
+		serverProcNs: serverProcNs, serverRecvNs: serverRecvNs, rc: rc})
+}
 
+// recordReceived applies a reply; nowNs is when it was handed over, which
+// may be a while before it is applied
+func (s *Stats) recordReceived(seqNum uint64, reply uint16, recvTime, nowNs int64, serverProcNs, serverRecvNs int64, rc recvControl) {
 	record, exists := s.records[seqNum]
 	if !exists {
 		return
 	}
+	// A reply index seen before is a duplicate, e.g. a link-layer
+	// retransmission whose acknowledgement got lost
+	if reply >= 1 && reply <= 64 {
+		bit := uint64(1) << (reply - 1)
+		if record.replies&bit != 0 {
+			record.Duplicates++
+			s.duplicates++
+			return
+		}
+		record.replies |= bit
+	}
+	s.repliesReceived++
 
 	if record.Lost { // Only count first response
+		if seqNum < s.maxRecvSeq[record.Path] {
+			record.Reordered = true
+			s.reordered++
+		}
+		s.maxRecvSeq[record.Path] = max(s.maxRecvSeq[record.Path], seqNum)
+		transit := recvTime - record.SentTime
+		if s.lastTransit != 0 {
+			s.rfcJitter += (math.Abs(float64(transit-s.lastTransit)/1e6) - s.rfcJitter) / 16
+		}
+		s.lastTransit = transit
+		record.RFCJitterMs = s.rfcJitter
+		s.recvOverhead.Add(float64(nowNs-recvTime) / float64(time.Microsecond))
+		if rc.KernelNs > 0 {
+			s.kernelStamped++
+		}
+
 		record.RecvTime = recvTime
 		record.LatencyMs = float64(recvTime-record.SentTime) / float64(time.Millisecond)
 		record.ServerProcMs = float64(serverProcNs) / float64(time.Millisecond)
-		netLatency := record.LatencyMs - record.ServerProcMs
+		record.ServerRecv = serverRecvNs
+		netLatency := record.LatencyMs - record.ServerProcMs - record.ClientProcMs
 		if netLatency < 0 {
 			netLatency = 0
 		}
 		record.NetLatencyMs = netLatency
 		record.Lost = false
+		s.outstanding--
+		record.RecvTTL = rc.TTL
+		s.recordTTL(record)
+		record.ECN = rc.ECN
+		s.ecnReplies[rc.ECN]++
+		if rc.ECN == ecnCE {
+			s.windowCE++
+		}
+
+		if s.timeout > 0 && recvTime-record.SentTime > s.timeout.Nanoseconds() {
+			s.afterTimeout++
+		}
 
 		// Check if packet is late
 		if record.LatencyMs > s.lateThreshold {
@@ -114,31 +297,11 @@ func (s *Stats) RecordReceived(seqNum uint64, recvTime int64, serverProcNs int64
 		}
 
 		s.received++
-		s.latencies = append(s.latencies, record.LatencyMs)
-		s.sumLat += record.LatencyMs
-		s.netLatencies = append(s.netLatencies, record.NetLatencyMs)
-		s.sumNet += record.NetLatencyMs
-		s.serverProc = append(s.serverProc, record.ServerProcMs)
-		s.sumServer += record.ServerProcMs
-
-		if record.LatencyMs < s.minLat {
-			s.minLat = record.LatencyMs
-		}
-		if record.LatencyMs > s.maxLat {
-			s.maxLat = record.LatencyMs
-		}
-		if record.NetLatencyMs < s.minNet {
-			s.minNet = record.NetLatencyMs
-		}
-		if record.NetLatencyMs > s.maxNet {
-			s.maxNet = record.NetLatencyMs
-		}
-		if record.ServerProcMs < s.minServer {
-			s.minServer = record.ServerProcMs
-		}
-		if record.ServerProcMs > s.maxServer {
-			s.maxServer = record.ServerProcMs
-		}
+		s.rtt.Add(record.LatencyMs)
+		s.net.Add(record.NetLatencyMs)
+		s.server.Add(record.ServerProcMs)
+		s.rttHist.observe(record.LatencyMs / 1000)
+		s.netHist.observe(record.NetLatencyMs / 1000)
 
 		if record.SentTime >= s.windowStartNs {
 			s.windowReceived++
@@ -152,26 +315,247 @@ func (s *Stats) RecordReceived(seqNum uint64, recvTime int64, serverProcNs int64
 	}
 }
 
-// PrintInterval prints interval stats if 5 seconds have passed
-func (s *Stats) PrintInterval() {
-	if time.Since(s.lastPrintTime) < 5*time.Second {
+// RecordLocalDrops adds replies the kernel dropped at the client's
+// receive socket
+func (s *Stats) RecordLocalDrops(n uint64) {
+	s.lock()
+	defer s.mu.Unlock()
+	s.localDrops += n
+}
+
+// RecordCorrupt counts a reply whose payload failed verification
+func (s *Stats) RecordCorrupt() {
+	s.lock()
+	defer s.mu.Unlock()
+	s.corrupt++
+}
+
+// RecordRejected counts a reply strict validation turned away
+func (s *Stats) RecordRejected(reason int) {
+	s.lock()
+	defer s.mu.Unlock()
+	s.rejected[reason]++
+}
+
+// Rejected returns the strict validation rejections by reason
+func (s *Stats) Rejected() [rejectKinds]uint64 {
+	s.lock()
+	defer s.mu.Unlock()
+	return s.rejected
+}
+
+// SentTime returns the send time a probe carries
+func (s *Stats) SentTime(seq uint64) (int64, bool) {
+	s.lock()
+	defer s.mu.Unlock()
+	r, ok := s.records[seq]
+	if !ok {
+		return 0, false
+	}
+	if r.stamped != 0 {
+		return r.stamped, true
+	}
+	return r.SentTime, true
+}
+
+// Corrupt returns the number of replies that failed payload verification
+func (s *Stats) Corrupt() uint64 {
+	s.lock()
+	defer s.mu.Unlock()
+	return s.corrupt
+}
+
+// recordTTL notes when the reply TTL differs from the previous reply's
+func (s *Stats) recordTTL(record *PacketRecord) {
+	if record.RecvTTL == 0 {
 		return
 	}
+	if s.firstTTL == 0 {
+		s.firstTTL = record.RecvTTL
+	} else if record.RecvTTL != s.lastTTL {
+		s.ttlChanges = append(s.ttlChanges, RunEvent{
+			Kind:     EventTTL,
+			Time:     time.Unix(0, record.RecvTime),
+			StartSeq: record.SeqNum,
+			EndSeq:   record.SeqNum,
+			Detail:   fmt.Sprintf("reply TTL %d -> %d", s.lastTTL, record.RecvTTL),
+		})
+	}
+	s.lastTTL = record.RecvTTL
+}
+
+// TTLChanges returns the points where the reply TTL changed
+func (s *Stats) TTLChanges() []RunEvent {
+	s.lock()
+	defer s.mu.Unlock()
+	return append([]RunEvent(nil), s.ttlChanges...)
+}
+
+// ApplyClockOffset splits the RTT less server processing of every answered
+// probe the server stamped into upstream and downstream latency, using the
+// server clock offset at each end. Upstream runs from the client's send
+// timestamp, so it includes the client's send path.
+func (s *Stats) ApplyClockOffset(clock *ClockSync) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.records {
+		applyClockOffset(r, clock)
+	}
+}
+
+func applyClockOffset(r *PacketRecord, clock *ClockSync) {
+	if r.Lost || r.ServerRecv == 0 {
+		return
+	}
+	serverSend := r.ServerRecv + int64(r.ServerProcMs*float64(time.Millisecond))
+	upNs := r.ServerRecv - clock.OffsetAt(r.SentTime) - r.SentTime
+	downNs := r.RecvTime - (serverSend - clock.OffsetAt(r.RecvTime))
+	r.UpMs = float64(upNs) / float64(time.Millisecond)
+	r.DownMs = float64(downNs) / float64(time.Millisecond)
+	r.OneWay = true
+}
+
+// RecordSendDone records client send overhead once the packet has been
+// handed to the kernel. tickNs is when the sender was scheduled to run.
+func (s *Stats) RecordSendDone(seqNum uint64, tickNs, doneNs int64) {
+	s.ingest(ingestEvent{kind: ingestSendDone, seq: seqNum, tickNs: tickNs, ns: doneNs})
+}
+
+func (s *Stats) recordSendDone(seqNum uint64, tickNs, doneNs int64) {
+	record, exists := s.records[seqNum]
+	if !exists {
+		return
+	}
+
+	record.ClientProcMs = float64(doneNs-record.SentTime) / float64(time.Millisecond)
+	s.sendOverhead.Add(float64(doneNs-record.SentTime) / float64(time.Microsecond))
+	record.SchedErrorUs = float64(max(record.SentTime-tickNs, 0)) / float64(time.Microsecond)
+	s.tickLag.Add(record.SchedErrorUs)
+
+	// On very fast paths the reply can be recorded before Write returns
+	if !record.Lost {
+		record.NetLatencyMs = max(record.NetLatencyMs-record.ClientProcMs, 0)
+	}
+}
+
+// RecordTxTime moves a probe's send time to when the kernel transmitted
+// it, so its latency leaves out the time spent getting into the stack.
+// Probes already answered keep the time taken before the write.
+func (s *Stats) RecordTxTime(seqNum uint64, txNs int64) {
+	s.ingest(ingestEvent{kind: ingestTx, seq: seqNum, ns: txNs})
+}
+
+func (s *Stats) recordTxTime(seqNum uint64, txNs int64) {
+	record, exists := s.records[seqNum]
+	if !exists || !record.Lost {
+		return
+	}
+	delay := txNs - record.SentTime
+	if delay < 0 || delay >= int64(time.Second) {
+		return
+	}
+	s.txDelay.Add(float64(delay) / float64(time.Microsecond))
+	record.stamped = record.SentTime
+	record.SentTime = txNs
+	record.ClientProcMs = 0
+}
+
+// ApplyServerReport marks the probes the server received. In count-only
+// mode no replies come back, so "received" means the probe reached the server.
+func (s *Stats) ApplyServerReport(log *ReceiveLog) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	for seq, record := range s.records {
+		if !record.Lost {
+			continue
+		}
+		if log.Has(seq) {
+			record.Lost = false
+			s.outstanding--
+			s.received++
+		} else {
+			record.LossDir = LossUp
+			s.lostUp++
+		}
+	}
+}
+
+// AttributeLoss marks each lost probe as lost on the way up or down from
+// the server's receive report for the path it was sent on. Paths without
+// a report leave their losses unattributed.
+func (s *Stats) AttributeLoss(reports []*ReceiveLog) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	for _, record := range s.records {
+		if !record.Lost || record.Path >= len(reports) || reports[record.Path] == nil {
+			continue
+		}
+		if reports[record.Path].Has(record.SeqNum) {
+			record.LossDir = LossDown
+			s.lostDown++
+		} else {
+			record.LossDir = LossUp
+			s.lostUp++
+		}
+	}
+}
+
+// WindowStats summarizes one interval window of the live output
+type WindowStats struct {
+	End         time.Time
+	Seconds     int // since the start of the run
+	Sent        uint64
+	Received    uint64
+	Late        uint64
+	LossPercent float64
+	MinRTTMs    float64
+	AvgRTTMs    float64
+	MaxRTTMs    float64
+	JitterMs    float64
+	NetMs       float64 // average RTT less processing
+	ServerMs    float64 // average server processing time
+}
+
+// PrintInterval prints interval stats if 5 seconds have passed and returns
+// them, or nil if it's not time yet
+func (s *Stats) PrintInterval() *WindowStats {
+	if time.Since(s.lastPrintTime) < 5*time.Second {
+		return nil
+	}
 	now := time.Now()
-	s.mu.Lock()
+	s.lock()
+
+	// Replies to the newest probes may still be on their way, so they are
+	// left out of the window instead of being counted as lost
+	inFlightSince := monoUnixNano(now) - s.lossTimeout().Nanoseconds()
+	var inFlight uint64
+	for seq := s.windowFirstSeq; seq <= s.lastSeq; seq++ {
+		if r, ok := s.records[seq]; ok && r.Lost && r.SentTime > inFlightSince {
+			inFlight++
+		}
+	}
 
 	elapsed := time.Since(s.startTime)
-	windowSent := s.windowSent
+	windowSent := s.windowSent - inFlight
 	windowReceived := s.windowReceived
+	if s.timeout > 0 {
+		windowSent, windowReceived = s.settleTimedOut(now)
+	}
 	windowLate := s.windowLate
+	windowCE := s.windowCE
 	windowLatencies := append([]float64(nil), s.windowLatencies...)
 	windowNet := append([]float64(nil), s.windowNetLatency...)
 	windowServer := append([]float64(nil), s.windowServerProc...)
 
-	s.windowStartNs = now.UnixNano()
+	s.windowStartNs = monoUnixNano(now)
+	s.windowFirstSeq = s.lastSeq + 1
 	s.windowSent = 0
 	s.windowReceived = 0
 	s.windowLate = 0
+	s.windowCE = 0
 	s.windowLatencies = s.windowLatencies[:0]
 	s.windowNetLatency = s.windowNetLatency[:0]
 	s.windowServerProc = s.windowServerProc[:0]
@@ -188,18 +572,58 @@ func (s *Stats) PrintInterval() {
 	avgNet := avg(windowNet)
 	avgServer := avg(windowServer)
 
+	ce := ""
+	if s.ecn {
+		ce = fmt.Sprintf("  CE: %d", windowCE)
+	}
 	spike := ""
 	if jitter > 10 {
 		spike = "  << spike"
 	}
 
-	fmt.Printf("[%ds] Win Loss: %.1f%%  Late: %d  RTT: %.0f/%.0f/%.0fms  Jitter: %.0fms  Net: %.0fms  Srv: %.0fms%s\n",
-		secs, loss, windowLate, minLat, avgLat, maxLat, jitter, avgNet, avgServer, spike)
+	if !s.quiet {
+		fmt.Printf("[%ds] Win Loss: %.1f%%  Late: %d  RTT: %.0f/%.0f/%.0fms  Jitter: %.0fms  Net: %.0fms  Srv: %.0fms%s%s\n",
+			secs, loss, windowLate, minLat, avgLat, maxLat, jitter, avgNet, avgServer, ce, spike)
+	}
+	return &WindowStats{
+		End:         now,
+		Seconds:     secs,
+		Sent:        windowSent,
+		Received:    windowReceived,
+		Late:        windowLate,
+		LossPercent: loss,
+		MinRTTMs:    minLat,
+		AvgRTTMs:    avgLat,
+		MaxRTTMs:    maxLat,
+		JitterMs:    jitter,
+		NetMs:       avgNet,
+		ServerMs:    avgServer,
+	}
+}
+
+// settleTimedOut settles every probe whose timeout has passed by now and
+// returns how many there were and how many were answered in time
+func (s *Stats) settleTimedOut(now time.Time) (settled, answered uint64) {
+	deadline := monoUnixNano(now) - s.timeout.Nanoseconds()
+	for ; s.timeoutSeq <= s.lastSeq; s.timeoutSeq++ {
+		r, ok := s.records[s.timeoutSeq]
+		if !ok {
+			continue
+		}
+		if r.SentTime > deadline {
+			break
+		}
+		settled++
+		if !r.Lost && r.RecvTime-r.SentTime <= s.timeout.Nanoseconds() {
+			answered++
+		}
+	}
+	return settled, answered
 }
 
 // PrintSummary prints the final summary
 func (s *Stats) PrintSummary() {
-	s.mu.Lock()
+	s.lock()
 	defer s.mu.Unlock()
 
 	fmt.Println("\n--- Summary ---")
@@ -215,43 +639,391 @@ func (s *Stats) PrintSummary() {
 	fmt.Printf("Packets: %d sent, %d received, %d lost (%.2f%%), %d late (%.2f%%)\n",
 		s.sent, s.received, lost, lossPercent, s.late, latePercent)
 	fmt.Printf("Late threshold: %.0fms\n", s.lateThreshold)
-
-	if len(s.latencies) > 0 {
-		avgLat := s.sumLat / float64(len(s.latencies))
-
-		var jitterSum float64
-		for _, lat := range s.latencies {
-			jitterSum += math.Abs(lat - avgLat)
+	if s.timeout > 0 {
+		fmt.Printf("Timeout: %s, %d replies arrived after it (lost in the interval stats, received here)\n",
+			s.timeout, s.afterTimeout)
+	}
+	if s.lostUp+s.lostDown > 0 {
+		fmt.Printf("Loss direction: %d upstream (never reached the server), %d downstream (reply lost)",
+			s.lostUp, s.lostDown)
+		if unknown := lost - min(s.lostUp+s.lostDown, lost); unknown > 0 {
+			fmt.Printf(", %d unknown", unknown)
+		}
+		fmt.Println()
+	}
+	if s.ecn {
+		s.printECN()
+	}
+	if s.reordered > 0 || s.duplicates > 0 {
+		fmt.Printf("Reordered: %d (%.2f%% of received), duplicates: %d (%.2f%%)\n",
+			s.reordered, percentOf(s.reordered, s.received), s.duplicates, percentOf(s.duplicates, s.received))
+	}
+	if s.localDrops > 0 {
+		local := min(s.localDrops, lost)
+		netLoss := float64(0)
+		if s.sent > 0 {
+			netLoss = float64(lost-local) / float64(s.sent) * 100
 		}
-		jitter := jitterSum / float64(len(s.latencies))
+		fmt.Printf("Local receive overflow: %d replies dropped by the client's socket buffer (raise --rcvbuf), network loss %.2f%% excluding them\n",
+			s.localDrops, netLoss)
+	}
 
-		p50, p90, p99 := percentiles(s.latencies, 50, 90, 99)
-		fmt.Printf("RTT: min=%.0fms avg=%.0fms max=%.0fms p50=%.0fms p90=%.0fms p99=%.0fms\n",
-			s.minLat, avgLat, s.maxLat, p50, p90, p99)
-		fmt.Printf("Jitter: %.0fms average\n", jitter)
+	if s.rtt.Count() > 0 {
+		fmt.Printf("RTT: min=%.0fms avg=%.0fms max=%.0fms p50=%.0fms p90=%.0fms p99=%.0fms p99.9=%.0fms\n",
+			s.rtt.Min(), s.rtt.Avg(), s.rtt.Max(),
+			s.rtt.Percentile(50), s.rtt.Percentile(90), s.rtt.Percentile(99), s.rtt.Percentile(99.9))
+		fmt.Printf("Jitter: %.0fms average deviation, %.1fms RFC 3550\n", s.rtt.MeanDeviation(), s.rfcJitter)
 	} else {
-		fmt.Println("RTT: no data (all packets lost)")
+		if s.received > 0 {
+			fmt.Println("RTT: no data (no replies measured)")
+		} else {
+			fmt.Println("RTT: no data (all packets lost)")
+		}
 	}
 
-	if len(s.netLatencies) > 0 {
-		avgNet := s.sumNet / float64(len(s.netLatencies))
-		p50, p90, p99 := percentiles(s.netLatencies, 50, 90, 99)
-		fmt.Printf("Net+Client: min=%.0fms avg=%.0fms max=%.0fms p50=%.0fms p90=%.0fms p99=%.0fms\n",
-			s.minNet, avgNet, s.maxNet, p50, p90, p99)
+	if s.net.Count() > 0 {
+		fmt.Printf("Net: min=%.0fms avg=%.0fms max=%.0fms p50=%.0fms p90=%.0fms p99=%.0fms p99.9=%.0fms\n",
+			s.net.Min(), s.net.Avg(), s.net.Max(),
+			s.net.Percentile(50), s.net.Percentile(90), s.net.Percentile(99), s.net.Percentile(99.9))
 	}
 
-	if len(s.serverProc) > 0 {
-		avgServer := s.sumServer / float64(len(s.serverProc))
+	if s.server.Count() > 0 {
 		fmt.Printf("Server proc: min=%.0fms avg=%.0fms max=%.0fms\n",
-			s.minServer, avgServer, s.maxServer)
+			s.server.Min(), s.server.Avg(), s.server.Max())
 	} else {
 		fmt.Println("Server proc: no data")
 	}
+
+	if s.firstTTL != 0 {
+		if len(s.ttlChanges) == 0 {
+			fmt.Printf("Reply TTL: %d, unchanged\n", s.firstTTL)
+		} else {
+			fmt.Printf("Reply TTL: %d at start, %d at end, changed %d times (return path rerouted)\n",
+				s.firstTTL, s.lastTTL, len(s.ttlChanges))
+		}
+	}
+
+	if s.sendOverhead.Count() > 0 {
+		sendP50, sendP99 := s.sendOverhead.Percentile(50), s.sendOverhead.Percentile(99)
+		fmt.Printf("Client overhead: send p50=%.0fus p99=%.0fus", sendP50, sendP99)
+		if s.txDelay.Count() > 0 {
+			fmt.Printf(", stack p50=%.0fus p99=%.0fus (TX timestamps)", s.txDelay.Percentile(50), s.txDelay.Percentile(99))
+		}
+		if s.recvOverhead.Count() > 0 {
+			recvP50, recvP99 := s.recvOverhead.Percentile(50), s.recvOverhead.Percentile(99)
+			fmt.Printf(", recv p50=%.0fus p99=%.0fus", recvP50, recvP99)
+			if s.kernelStamped > 0 {
+				fmt.Print(" from kernel timestamps")
+			}
+		}
+		fmt.Println()
+	}
+}
+
+// Headline returns the summary numbers recorded in the run metadata
+func (s *Stats) Headline() *RunSummary {
+	s.lock()
+	defer s.mu.Unlock()
+
+	sum := &RunSummary{Sent: s.sent, Received: s.received, Late: s.late, LocalDrops: s.localDrops, Corrupt: s.corrupt}
+	if s.sent > 0 {
+		sum.LossPercent = float64(s.sent-s.received) / float64(s.sent) * 100
+	}
+	sum.AvgRTTMs, sum.JitterMs, sum.P99RTTMs = s.rtt.Avg(), s.rtt.MeanDeviation(), s.rtt.Percentile(99)
+	sum.RFCJitterMs = s.rfcJitter
+	return sum
 }
 
-// GetRecords returns all packet records for CSV export
+// SendRate returns the probes sent in each full second of the run
+func (s *Stats) SendRate() []int {
+	s.lock()
+	defer s.mu.Unlock()
+
+	// The last second is usually cut short by the end of the test
+	full := int((s.lastSentNs - monoUnixNano(s.startTime)) / int64(time.Second))
+	return append([]int(nil), s.sendPerSecond[:min(full, len(s.sendPerSecond))]...)
+}
+
+// ScheduleError returns the p50, p99 and max of how late probes were sent
+// after their slots, in microseconds
+func (s *Stats) ScheduleError() [3]float64 {
+	s.lock()
+	defer s.mu.Unlock()
+	if s.tickLag.Count() == 0 {
+		return [3]float64{}
+	}
+	return [3]float64{s.tickLag.Percentile(50), s.tickLag.Percentile(99), s.tickLag.Max()}
+}
+
+// DrainTimeout returns how long to wait for the last replies once sending
+// stops: a few times the p99 RTT, never below minLossTimeout so short
+// paths keep the old behaviour, and never above limit. The p99 rather than
+// the maximum keeps one freak reply from stretching every run's tail.
+func (s *Stats) DrainTimeout(limit time.Duration) (timeout time.Duration, p99Ms float64) {
+	s.lock()
+	defer s.mu.Unlock()
+	p99Ms = s.rtt.Percentile(99)
+	timeout = time.Duration(p99Ms * lossTimeoutRTTs * float64(time.Millisecond))
+	return min(max(timeout, minLossTimeout), limit), p99Ms
+}
+
+// lossTimeout returns how long a reply can take before the probe is
+// counted as lost in the live output: the fixed timeout if one is set,
+// otherwise a few times the slowest RTT seen
+func (s *Stats) lossTimeout() time.Duration {
+	if s.timeout > 0 {
+		return s.timeout
+	}
+	timeout := time.Duration(s.rtt.Max() * lossTimeoutRTTs * float64(time.Millisecond))
+	return min(max(timeout, minLossTimeout), maxLossTimeout)
+}
+
+// UnansweredSince returns the number of probes sent at or after sinceNs
+// that haven't been answered
+func (s *Stats) UnansweredSince(sinceNs int64) uint64 {
+	s.lock()
+	defer s.mu.Unlock()
+	return s.unansweredSince(sinceNs)
+}
+
+func (s *Stats) unansweredSince(sinceNs int64) uint64 {
+	var n uint64
+	for seq := s.lastSeq; seq >= s.spillSeq; seq-- {
+		r, ok := s.records[seq]
+		if !ok {
+			continue
+		}
+		if r.SentTime < sinceNs {
+			break
+		}
+		if r.Lost {
+			n++
+		}
+	}
+	return n
+}
+
+// Lost returns the number of probes without a reply
+func (s *Stats) Lost() uint64 {
+	s.lock()
+	defer s.mu.Unlock()
+	return s.sent - s.received
+}
+
+// Outstanding returns the number of probes still waiting for a reply
+func (s *Stats) Outstanding() uint64 {
+	s.lock()
+	defer s.mu.Unlock()
+	return s.outstanding
+}
+
+// PrintBDP prints the bandwidth-delay product of the run, given the
+// configured rate and probe size, next to the peak number of probes that
+// were actually in flight
+func (s *Stats) PrintBDP(rate, packetSize int) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	if s.rtt.Count() == 0 {
+		return
+	}
+	avgLat := s.rtt.Avg()
+	bdpPackets := float64(rate) * avgLat / 1000
+	fmt.Printf("BDP: %.0f packets (%.1f KB) in flight at %d pps and %.0fms avg RTT, peak %d outstanding\n",
+		bdpPackets, bdpPackets*float64(packetSize)/1024, rate, avgLat, s.peakOutstanding)
+}
+
+// PrintDirections prints per-direction counts, volume, throughput and
+// loss for asymmetric runs, given the probe and reply sizes in bytes
+func (s *Stats) PrintDirections(upSize, downSize int) {
+	s.lock()
+	defer s.mu.Unlock()
+
+	upBytes, downBytes := (s.sent+s.unechoed)*uint64(upSize), s.repliesReceived*uint64(downSize)
+	fmt.Printf("Volume: %d bytes up in %d byte probes, %d bytes down in %d byte replies (%.2fx the upstream)\n",
+		upBytes, upSize, downBytes, downSize, float64(downBytes)/float64(max(upBytes, 1)))
+
+	downLoss := float64(0)
+	if s.repliesExpected > 0 {
+		downLoss = float64(s.repliesExpected-min(s.repliesReceived, s.repliesExpected)) / float64(s.repliesExpected) * 100
+	}
+	fmt.Printf("Replies: %d of %d received (%.2f%% missing), %d probes sent without reply\n",
+		s.repliesReceived, s.repliesExpected, downLoss, s.unechoed)
+
+	if upBps, downBps := s.throughput(upSize, downSize); upBps > 0 {
+		fmt.Printf("Throughput: up %.1f kbps sent, down %.1f kbps received\n", upBps/1000, downBps/1000)
+	}
+
+	// With the server's report, loss splits by direction and so by
+	// packet size: a path that drops large packets shows on one side only
+	if s.lostUp+s.lostDown > 0 {
+		reached := s.sent - min(s.lostUp, s.sent)
+		fmt.Printf("Loss by direction: %.2f%% of %d byte probes upstream, %.2f%% of %d byte replies downstream (to probes that arrived)\n",
+			lossPercent(int(s.lostUp), int(s.sent)), upSize, lossPercent(int(s.lostDown), int(reached)), downSize)
+	}
+}
+
+// Throughput returns the UDP payload bits per second sent and received
+// over the sending period, given the probe and reply sizes
+func (s *Stats) Throughput(upSize, downSize int) (upBps, downBps float64) {
+	s.lock()
+	defer s.mu.Unlock()
+	return s.throughput(upSize, downSize)
+}
+
+func (s *Stats) throughput(upSize, downSize int) (upBps, downBps float64) {
+	elapsed := float64(s.lastSentNs-monoUnixNano(s.startTime)) / float64(time.Second)
+	if elapsed <= 0 {
+		return 0, 0
+	}
+	upBps = float64((s.sent+s.unechoed)*uint64(upSize)) * 8 / elapsed
+	downBps = float64(s.repliesReceived*uint64(downSize)) * 8 / elapsed
+	return upBps, downBps
+}
+
+// LastSeq returns the sequence number of the last probe sent
+func (s *Stats) LastSeq() uint64 {
+	s.lock()
+	defer s.mu.Unlock()
+	return s.lastSeq
+}
+
+// SetQuiet stops PrintInterval from printing, for when the terminal UI
+// shows the run instead
+func (s *Stats) SetQuiet(quiet bool) {
+	s.lock()
+	defer s.mu.Unlock()
+	s.quiet = quiet
+}
+
+// SetECN tells the stats probes are sent ECN-capable, so CE marks on the
+// replies are reported
+func (s *Stats) SetECN(ecn bool) {
+	s.lock()
+	defer s.mu.Unlock()
+	s.ecn = ecn
+}
+
+// printECN prints how many replies came back congestion-marked. Replies
+// that lost their ECT mark mean a hop clears the ECN field, or the server
+// doesn't reflect it, so marks on that leg can't be seen.
+func (s *Stats) printECN() {
+	capable := s.ecnReplies[ecnECT0] + s.ecnReplies[ecnECT1] + s.ecnReplies[ecnCE]
+	if s.received > 0 && capable == 0 {
+		fmt.Println("ECN: no reply was ECN-capable; the path clears the ECN field or the server doesn't reflect it")
+		return
+	}
+	fmt.Printf("ECN: %d of %d replies CE-marked (%.2f%%)", s.ecnReplies[ecnCE], s.received, percentOf(s.ecnReplies[ecnCE], s.received))
+	if cleared := s.ecnReplies[ecnNotECT]; cleared > 0 {
+		fmt.Printf(", %d arrived not ECN-capable", cleared)
+	}
+	fmt.Println()
+}
+
+// SetSpill makes the stats write records to f once they are final instead
+// of keeping them; see Spill
+func (s *Stats) SetSpill(f *spillFile) {
+	s.lock()
+	defer s.mu.Unlock()
+	s.spill = f
+}
+
+// recordStream is a destination for each record once it is resolved
+type recordStream interface {
+	write(r *PacketRecord)
+	Flush() error
+}
+
+// AddStream makes the stats write each record to rs once it is resolved;
+// see Spill
+func (s *Stats) AddStream(rs recordStream) {
+	s.lock()
+	defer s.mu.Unlock()
+	s.streams = append(s.streams, rs)
+}
+
+// streamRecord writes r to every stream
+func (s *Stats) streamRecord(r *PacketRecord) {
+	for _, rs := range s.streams {
+		rs.write(r)
+	}
+}
+
+// Spill writes out and drops the records that can no longer change by now:
+// those older than the longest loss timeout and, with a fixed timeout,
+// already settled in the interval stats. Those records are streamed too.
+func (s *Stats) Spill(now time.Time) {
+	s.lock()
+	defer s.mu.Unlock()
+	if s.spill == nil && len(s.streams) == 0 {
+		return
+	}
+	cutoff := monoUnixNano(now) - max(s.lossTimeout(), maxLossTimeout).Nanoseconds()
+	if len(s.streams) > 0 {
+		for ; s.streamSeq <= s.lastSeq; s.streamSeq++ {
+			r, ok := s.records[s.streamSeq]
+			if !ok {
+				continue
+			}
+			if r.SentTime > cutoff || (s.timeout > 0 && s.streamSeq >= s.timeoutSeq) {
+				break
+			}
+			if r.Lost && s.spill == nil {
+				s.heldLosses = append(s.heldLosses, r)
+				continue
+			}
+			s.streamRecord(r)
+		}
+		for _, rs := range s.streams {
+			rs.Flush()
+		}
+	}
+	for ; s.spill != nil && s.spillSeq <= s.lastSeq; s.spillSeq++ {
+		r, ok := s.records[s.spillSeq]
+		if !ok {
+			continue
+		}
+		if r.SentTime > cutoff || (s.timeout > 0 && s.spillSeq >= s.timeoutSeq) {
+			break
+		}
+		s.spill.write(r)
+		// A probe spilled as lost no longer waits for its reply
+		if r.Lost {
+			s.outstanding--
+		}
+		delete(s.records, s.spillSeq)
+	}
+}
+
+// FlushSpill writes out the records still held, and streams those not
+// streamed yet, at the end of the run once loss directions are known
+func (s *Stats) FlushSpill() {
+	s.lock()
+	defer s.mu.Unlock()
+	for _, r := range s.heldLosses {
+		s.streamRecord(r)
+	}
+	s.heldLosses = nil
+	for ; len(s.streams) > 0 && s.streamSeq <= s.lastSeq; s.streamSeq++ {
+		if r, ok := s.records[s.streamSeq]; ok {
+			s.streamRecord(r)
+		}
+	}
+	for ; s.spill != nil && s.spillSeq <= s.lastSeq; s.spillSeq++ {
+		if r, ok := s.records[s.spillSeq]; ok {
+			s.spill.write(r)
+			if r.Lost {
+				s.outstanding--
+			}
+			delete(s.records, s.spillSeq)
+		}
+	}
+}
+
+// GetRecords returns the packet records still held, all of them unless
+// spilling, for CSV export and analysis
 func (s *Stats) GetRecords() []*PacketRecord {
-	s.mu.Lock()
+	s.lock()
 	defer s.mu.Unlock()
 
 	records := make([]*PacketRecord, 0, len(s.records))
@@ -289,6 +1061,14 @@ func calcStats(values []float64) (min, avg, max, jitter float64) {
 	return
 }
 
+// percentOf returns n as a percentage of total, 0 if total is 0
+func percentOf(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
+
 func avg(values []float64) float64 {
 	if len(values) == 0 {
 		return 0
@@ -330,3 +1110,25 @@ func percentile(sorted []float64, p float64) float64 {
 	weight := pos - float64(lower)
 	return sorted[lower]*(1-weight) + sorted[upper]*weight
 }
+
+// sortBySeq returns a copy of records ordered by sequence number
+func sortBySeq(records []*PacketRecord) []*PacketRecord {
+	sorted := make([]*PacketRecord, len(records))
+	copy(sorted, records)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].SeqNum < sorted[j].SeqNum })
+	return sorted
+}
+
+// minLatency returns the lowest RTT among answered records, or 0 if none were
+func minLatency(records []*PacketRecord) float64 {
+	minLat := math.MaxFloat64
+	for _, r := range records {
+		if !r.Lost && r.RecvTime > 0 && r.LatencyMs < minLat {
+			minLat = r.LatencyMs
+		}
+	}
+	if minLat == math.MaxFloat64 {
+		return 0
+	}
+	return minLat
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The StatsD output sends each interval window to a StatsD agent, such as
+// the Datadog agent's DogStatsD, over UDP: the window's sent, received,
+// lost and late probes as counters, its loss as a gauge and its RTT, jitter
+// and latency split as timings. Metrics carry DogStatsD tags for the
+// target, the run's ID as test_id, the label of a target list, and any
+// given with --statsd-tags.
+
+// StatsdConfig says where interval metrics go
+type StatsdConfig struct {
+	Addr   string   // host:port of the agent
+	Prefix string   // metric name prefix, e.g. packet_test
+	Tags   []string // extra tags, as key:value or value
+}
+
+// statsdClient sends a run's interval windows to the agent
+type statsdClient struct {
+	conn   net.Conn
+	prefix string
+	tags   string
+	warned bool
+}
+
+func newStatsdClient(cfg StatsdConfig, target, runID, label string) (*statsdClient, error) {
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	tags := []string{"target:" + statsdTag(target), "test_id:" + statsdTag(runID)}
+	if label != "" {
+		tags = append(tags, "label:"+statsdTag(label))
+	}
+	tags = append(tags, cfg.Tags...)
+	return &statsdClient{conn: conn, prefix: cfg.Prefix, tags: strings.Join(tags, ",")}, nil
+}
+
+// statsdTag replaces the characters DogStatsD uses as separators
+func statsdTag(s string) string {
+	return strings.NewReplacer(",", "_", "|", "_", "#", "_").Replace(s)
+}
+
+// ParseStatsdTags parses a comma-separated tag list like "env:prod,team:net"
+func ParseStatsdTags(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var tags []string
+	for tag := range strings.SplitSeq(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || strings.ContainsAny(tag, "|#") {
+			return nil, fmt.Errorf("invalid StatsD tags %q, expected a comma-separated list of key:value", s)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// Interval sends the metrics of an interval window, one datagram each.
+// A failed send is warned about once and otherwise ignored, as with any
+// StatsD client.
+func (c *statsdClient) Interval(w *WindowStats) {
+	c.send("sent", strconv.FormatUint(w.Sent, 10), "c")
+	c.send("received", strconv.FormatUint(w.Received, 10), "c")
+	c.send("lost", strconv.FormatUint(w.Sent-min(w.Received, w.Sent), 10), "c")
+	c.send("late", strconv.FormatUint(w.Late, 10), "c")
+	c.send("loss_percent", fmt.Sprintf("%.3f", w.LossPercent), "g")
+	if w.Received == 0 {
+		return
+	}
+	c.send("rtt.min", fmt.Sprintf("%.3f", w.MinRTTMs), "ms")
+	c.send("rtt.avg", fmt.Sprintf("%.3f", w.AvgRTTMs), "ms")
+	c.send("rtt.max", fmt.Sprintf("%.3f", w.MaxRTTMs), "ms")
+	c.send("jitter", fmt.Sprintf("%.3f", w.JitterMs), "ms")
+	c.send("net", fmt.Sprintf("%.3f", w.NetMs), "ms")
+	c.send("server_proc", fmt.Sprintf("%.3f", w.ServerMs), "ms")
+}
+
+func (c *statsdClient) send(name, value, kind string) {
+	_, err := fmt.Fprintf(c.conn, "%s.%s:%s|%s|#%s", c.prefix, name, value, kind, c.tags)
+	if err != nil && !c.warned {
+		fmt.Printf("Warning: StatsD send to %s failed: %v\n", c.conn.RemoteAddr(), err)
+		c.warned = true
+	}
+}
+
+func (c *statsdClient) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Stress mode measures the reflector rather than the network: it ramps up
+// virtual clients, each on its own socket (so its own source port and its
+// own entry on the server), and watches for the step where the server
+// starts adding latency or dropping.
+
+const (
+	stressDrain       = time.Second // wait for late replies after each step
+	stressKneeLoss    = 1.0         // loss percent that marks the knee
+	stressKneeLatency = 2.0         // p50 RTT growth over the first step that marks the knee
+	stressKneeSlackMs = 1.0         // ignore growth below this, loopback RTTs are tiny
+)
+
+// StressConfig configures a server capacity test
+type StressConfig struct {
+	Host        string
+	Port        int
+	Family      string // "IPv4" or "IPv6" to use only that family, "" = either
+	PacketSize  int
+	Rate        int // per virtual client
+	MaxClients  int
+	StepSeconds int
+	OutputFile  string
+}
+
+// StressStep is one rung of the ramp
+type StressStep struct {
+	Clients     int
+	OfferedPPS  float64
+	ReceivedPPS float64
+	LossPercent float64
+	P50Ms       float64
+	P99Ms       float64
+	ServerP99Ms float64
+}
+
+// stressCollector gathers replies from all virtual clients of a step
+type stressCollector struct {
+	mu       sync.Mutex
+	sent     uint64
+	received uint64
+	rtts     []float64
+	server   []float64
+}
+
+// RunStress ramps virtual clients from 1 to MaxClients, doubling each
+// step, and reports the server's scaling curve
+func RunStress(ctx context.Context, cfg StressConfig) error {
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(cfg.Port))
+	fmt.Printf("Stress testing %s: up to %d virtual clients at %d pps each, %ds per step\n\n",
+		addr, cfg.MaxClients, cfg.Rate, cfg.StepSeconds)
+	fmt.Printf("%8s %12s %12s %8s %10s %10s %12s\n", "clients", "offered pps", "recv pps", "loss", "p50 RTT", "p99 RTT", "server p99")
+
+	var steps []StressStep
+	for clients := 1; ctx.Err() == nil; clients *= 2 {
+		clients = min(clients, cfg.MaxClients)
+		step, err := runStressStep(ctx, cfg, addr, clients)
+		if err != nil {
+			return err
+		}
+		steps = append(steps, step)
+		fmt.Printf("%8d %12.0f %12.0f %7.2f%% %8.2fms %8.2fms %10.2fms\n",
+			step.Clients, step.OfferedPPS, step.ReceivedPPS, step.LossPercent, step.P50Ms, step.P99Ms, step.ServerP99Ms)
+		if clients == cfg.MaxClients {
+			break
+		}
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+
+	if knee := stressKnee(steps); knee != nil {
+		fmt.Printf("\nServer saturates at %d clients (%.0f pps offered): loss %.2f%%, p50 RTT %.2fms vs %.2fms at 1 client\n",
+			knee.Clients, knee.OfferedPPS, knee.LossPercent, knee.P50Ms, steps[0].P50Ms)
+	} else {
+		fmt.Printf("\nNo saturation up to %d clients (%.0f pps offered)\n",
+			steps[len(steps)-1].Clients, steps[len(steps)-1].OfferedPPS)
+	}
+
+	outputFile := cfg.OutputFile
+	if outputFile == "" {
+		outputFile = fmt.Sprintf("packet-test-stress_%s.csv", time.Now().Format("2006-01-02_15-04-05"))
+	}
+	if err := saveStressCSV(outputFile, steps); err != nil {
+		return fmt.Errorf("failed to save CSV: %w", err)
+	}
+	fmt.Printf("Results saved to %s\n", outputFile)
+	return nil
+}
+
+// runStressStep runs the given number of virtual clients for one step
+func runStressStep(ctx context.Context, cfg StressConfig, addr string, clients int) (StressStep, error) {
+	conns := make([]net.Conn, 0, clients)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for range clients {
+		c, err := net.Dial(udpNetwork(cfg.Family), addr)
+		if err != nil {
+			return StressStep{}, fmt.Errorf("failed to connect to %s: %w", addr, err)
+		}
+		conns = append(conns, c)
+	}
+
+	col := &stressCollector{}
+	stepCtx, stop := context.WithTimeout(ctx, time.Duration(cfg.StepSeconds)*time.Second)
+	defer stop()
+	start := time.Now()
+
+	var sendWg, recvWg sync.WaitGroup
+	for i, c := range conns {
+		recvWg.Add(1)
+		go func() {
+			defer recvWg.Done()
+			col.receive(c)
+		}()
+		sendWg.Add(1)
+		go func() {
+			defer sendWg.Done()
+			// Spread the clients' send times over one interval
+			interval := time.Second / time.Duration(cfg.Rate)
+			select {
+			case <-time.After(interval * time.Duration(i) / time.Duration(clients)):
+			case <-stepCtx.Done():
+				return
+			}
+			col.send(stepCtx, c, cfg.PacketSize, interval)
+		}()
+	}
+	sendWg.Wait()
+	elapsed := time.Since(start).Seconds()
+
+	// Replies still in flight are let in, then the sockets are closed to
+	// stop the receivers
+	select {
+	case <-time.After(stressDrain):
+	case <-ctx.Done():
+	}
+	for _, c := range conns {
+		c.Close()
+	}
+	recvWg.Wait()
+	conns = nil
+
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	step := StressStep{
+		Clients:     clients,
+		OfferedPPS:  float64(col.sent) / elapsed,
+		ReceivedPPS: float64(col.received) / elapsed,
+	}
+	if col.sent > 0 {
+		step.LossPercent = float64(col.sent-min(col.received, col.sent)) / float64(col.sent) * 100
+	}
+	sort.Float64s(col.rtts)
+	sort.Float64s(col.server)
+	step.P50Ms = percentile(col.rtts, 50)
+	step.P99Ms = percentile(col.rtts, 99)
+	step.ServerP99Ms = percentile(col.server, 99)
+	return step, nil
+}
+
+// send paces probes at interval, catching up on slots the ticker dropped
+// so high rates are actually offered
+func (col *stressCollector) send(ctx context.Context, conn net.Conn, size int, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	pace := newPacer(interval, true)
+	var seq uint64
+	for {
+		var tick time.Time
+		select {
+		case <-ctx.Done():
+			return
+		case tick = <-ticker.C:
+		}
+		for range pace.due(tick) {
+			seq++
+			data := NewPacket(seq, size, time.Now().UnixNano()).Encode(size)
+			if _, err := conn.Write(data); err != nil {
+				continue
+			}
+			col.mu.Lock()
+			col.sent++
+			col.mu.Unlock()
+		}
+	}
+}
+
+// receive takes RTTs from the send time echoed in each reply, so no
+// per-packet state is kept
+func (col *stressCollector) receive(conn net.Conn) {
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		now := time.Now().UnixNano()
+		pkt := DecodePacket(buf[:n])
+		if pkt == nil || pkt.Type != TypeProbe {
+			continue
+		}
+		col.mu.Lock()
+		col.received++
+		col.rtts = append(col.rtts, float64(now-pkt.Timestamp)/1e6)
+		col.server = append(col.server, float64(pkt.ServerProcNs)/1e6)
+		col.mu.Unlock()
+	}
+}
+
+// stressKnee returns the first step where the server fell behind, or nil
+func stressKnee(steps []StressStep) *StressStep {
+	base := steps[0].P50Ms
+	for i := range steps {
+		s := &steps[i]
+		if s.LossPercent > stressKneeLoss ||
+			s.P50Ms > base*stressKneeLatency && s.P50Ms-base > stressKneeSlackMs {
+			return s
+		}
+	}
+	return nil
+}
+
+func saveStressCSV(filename string, steps []StressStep) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	defer writer.Flush()
+
+	writer.Write([]string{"clients", "offered_pps", "received_pps", "loss_percent", "rtt_p50_ms", "rtt_p99_ms", "server_p99_ms"})
+	for _, s := range steps {
+		writer.Write([]string{
+			strconv.Itoa(s.Clients),
+			fmt.Sprintf("%.1f", s.OfferedPPS),
+			fmt.Sprintf("%.1f", s.ReceivedPPS),
+			fmt.Sprintf("%.2f", s.LossPercent),
+			fmt.Sprintf("%.3f", s.P50Ms),
+			fmt.Sprintf("%.3f", s.P99Ms),
+			fmt.Sprintf("%.3f", s.ServerP99Ms),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"net"
+)
+
+// Strict reply validation. A connected socket only accepts datagrams from
+// the server's address, but anything sent from there (or spoofed to look
+// like it) that is long enough still decodes as a reply. In strict mode a
+// reply must come from the server's address, be a probe echo of the
+// expected size, carry the exact send timestamp recorded for its sequence
+// number (which a stray or replayed datagram won't) and have an intact
+// payload pattern.
+
+// Rejection reasons
+const (
+	rejectForeign    = iota // not a probe echo, or no such probe outstanding
+	rejectSpoofed           // source address isn't the server
+	rejectMismatched        // known sequence number but wrong timestamp or size
+	rejectKinds
+)
+
+// replyCheck configures how receivePackets vets replies
+type replyCheck struct {
+	PatternSize int    // probe size when payloads carry the check pattern, 0 = unchecked
+	Strict      bool   // validate source, size and timestamp too
+	ReplySize   int    // expected reply length in strict mode
+	Session     uint32 // replies from other sessions are stale echoes of an earlier run
+}
+
+// validate returns the rejection reason for a reply, or -1 if it's
+// acceptable. Payload corruption is checked separately.
+func (c replyCheck) validate(stats *Stats, pkt *Packet, n int, from, server *net.UDPAddr) int {
+	if from != nil && server != nil && (!from.IP.Equal(server.IP) || from.Port != server.Port) {
+		return rejectSpoofed
+	}
+	if pkt == nil || pkt.Type != TypeProbe || pkt.Session != c.Session {
+		return rejectForeign
+	}
+	sent, ok := stats.SentTime(pkt.SeqNum)
+	if !ok {
+		return rejectForeign
+	}
+	if sent != pkt.Timestamp || n != c.ReplySize {
+		return rejectMismatched
+	}
+	return -1
+}
+
+// PrintRejected prints the replies strict validation turned away
+func PrintRejected(rejected [rejectKinds]uint64, corrupt uint64) {
+	fmt.Println("\n--- Reply validation ---")
+	fmt.Printf("Rejected: %d foreign, %d spoofed, %d mismatched, %d corrupt\n",
+		rejected[rejectForeign], rejected[rejectSpoofed], rejected[rejectMismatched], corrupt)
+	if rejected[rejectForeign]+rejected[rejectSpoofed]+rejected[rejectMismatched] > 0 {
+		fmt.Println("Stray datagrams reached the test socket; without --strict they would have counted as replies")
+	}
+}
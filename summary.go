@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// LatencyStats is a latency distribution in milliseconds
+type LatencyStats struct {
+	Min  float64 `json:"min_ms"`
+	Avg  float64 `json:"avg_ms"`
+	Max  float64 `json:"max_ms"`
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P99  float64 `json:"p99_ms"`
+	P999 float64 `json:"p999_ms"`
+}
+
+// newLatencyStats returns the distribution in d, nil if it's empty
+func newLatencyStats(d *digest) *LatencyStats {
+	if d.Count() == 0 {
+		return nil
+	}
+	return &LatencyStats{
+		Min:  d.Min(),
+		Avg:  d.Avg(),
+		Max:  d.Max(),
+		P50:  d.Percentile(50),
+		P90:  d.Percentile(90),
+		P99:  d.Percentile(99),
+		P999: d.Percentile(99.9),
+	}
+}
+
+// JSONSummary is the final summary for scripts, written with --summary-json
+type JSONSummary struct {
+	RunID           string        `json:"run_id"`
+	Label           string        `json:"label,omitempty"` // target list label
+	Target          string        `json:"target"`
+	StartTime       time.Time     `json:"start_time"`
+	DurationSec     float64       `json:"duration_s"` // sending time
+	CSVFile         string        `json:"csv_file"`
+	Sent            uint64        `json:"sent"`
+	Received        uint64        `json:"received"`
+	Lost            uint64        `json:"lost"`
+	LossPercent     float64       `json:"loss_percent"`
+	Late            uint64        `json:"late"`
+	LatePercent     float64       `json:"late_percent"`
+	LateThresholdMs float64       `json:"late_threshold_ms"`
+	Reordered       uint64        `json:"reordered"`
+	Duplicates      uint64        `json:"duplicates"`
+	LostUp          uint64        `json:"lost_up,omitempty"`   // lost probes the server never received
+	LostDown        uint64        `json:"lost_down,omitempty"` // lost probes whose reply was lost
+	CEMarked        uint64        `json:"ce_marked,omitempty"` // replies with a congestion mark, with --ecn
+	LocalDrops      uint64        `json:"local_drops,omitempty"`
+	Corrupt         uint64        `json:"corrupt,omitempty"`
+	RTT             *LatencyStats `json:"rtt,omitempty"`
+	JitterMs        float64       `json:"jitter_ms"`                // mean absolute deviation of the RTT
+	RFCJitterMs     float64       `json:"jitter_rfc3550_ms"`        // RFC 3550 interarrival jitter of the RTT
+	Net             *LatencyStats `json:"net,omitempty"`            // RTT less the server's processing time
+	ServerProc      *LatencyStats `json:"server_proc,omitempty"`    // time the server held each probe
+	SchedError      *LatencyStats `json:"sched_error_us,omitempty"` // how late probes were sent after their slots, in microseconds
+	PacketSize      int           `json:"packet_size,omitempty"`
+	ReplySize       int           `json:"reply_size,omitempty"`
+	UpBps           float64       `json:"up_bps,omitempty"`
+	DownBps         float64       `json:"down_bps,omitempty"`
+	Voice           *VoiceQuality `json:"voice,omitempty"`      // E-model R-factor and MOS
+	SLAPass         *bool         `json:"sla_pass,omitempty"`   // nil if no SLA was checked
+	SLAFailed       []string      `json:"sla_failed,omitempty"` // names of the failed checks
+}
+
+// JSONSummary returns the numbers of PrintSummary in machine-readable form
+func (s *Stats) JSONSummary() *JSONSummary {
+	s.lock()
+	defer s.mu.Unlock()
+
+	sum := &JSONSummary{
+		Sent:            s.sent,
+		Received:        s.received,
+		Lost:            s.sent - s.received,
+		Late:            s.late,
+		LateThresholdMs: s.lateThreshold,
+		Reordered:       s.reordered,
+		Duplicates:      s.duplicates,
+		LostUp:          s.lostUp,
+		LostDown:        s.lostDown,
+		LocalDrops:      s.localDrops,
+		Corrupt:         s.corrupt,
+		RTT:             newLatencyStats(&s.rtt),
+		Net:             newLatencyStats(&s.net),
+		ServerProc:      newLatencyStats(&s.server),
+		SchedError:      newLatencyStats(&s.tickLag),
+	}
+	if s.sent > 0 {
+		sum.LossPercent = float64(sum.Lost) / float64(s.sent) * 100
+		sum.LatePercent = float64(s.late) / float64(s.sent) * 100
+	}
+	sum.CEMarked = s.ecnReplies[ecnCE]
+	sum.JitterMs = s.rtt.MeanDeviation()
+	sum.RFCJitterMs = s.rfcJitter
+	return sum
+}
+
+// saveJSONSummary writes the summary to path, or to stdout if path is "-"
+func saveJSONSummary(path string, sum *JSONSummary) error {
+	data, err := json.MarshalIndent(sum, "", "  ")
+	if err != nil {
+		return err
+	}
+	if path == "-" {
+		fmt.Println(string(data))
+		return nil
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sweep mode runs the client once per packet size and rate of a matrix,
+// in the order given. Every cell keeps its own CSV; the combined CSV has
+// a row per cell, and the HTML report lays the cells out as a heatmap
+// with sizes down and rates across, so the corner where the path gives
+// out stands out.
+
+const sweepTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Packet Test Sweep</title>
+` + reportStyle + `    <style>
+        table.heatmap { border-collapse: separate; border-spacing: 4px; }
+        table.heatmap th { color: var(--muted); font-weight: normal; padding: 6px 10px; }
+        table.heatmap td { min-width: 90px; padding: 14px 10px; border-radius: 6px; text-align: center; color: #111; }
+        table.heatmap td.empty { background: var(--bg); color: var(--muted); }
+        table.heatmap td small { display: block; opacity: 0.7; }
+        .metrics button { background: var(--bg); color: var(--text); border: 1px solid var(--muted); border-radius: 4px; padding: 4px 10px; margin-right: 6px; cursor: pointer; }
+        .metrics button.active { border-color: var(--accent); color: var(--accent); }
+    </style>
+</head>
+<body>
+    <h1>UDP Packet Loss Sweep</h1>
+    <div class="run-info"><div>{{INFO}}</div></div>
+
+    <div class="chart-container">
+        <div class="metrics" id="metrics"></div>
+        <p id="scale" class="stat-label"></p>
+        <table class="heatmap" id="heatmap"></table>
+    </div>
+
+    <script>
+        const sweep = {{SWEEP_JSON}};
+        const params = new URLSearchParams(location.search);
+        const themeName = ['dark', 'light'].includes(params.get('theme')) ? params.get('theme') : '{{THEME}}';
+        document.body.classList.add('theme-' + themeName);
+
+        const metrics = [
+            { key: 'loss', label: 'Loss', unit: '%' },
+            { key: 'avg', label: 'Avg RTT', unit: 'ms' },
+            { key: 'p99', label: 'p99 RTT', unit: 'ms' },
+            { key: 'jitter', label: 'Jitter', unit: 'ms' }
+        ];
+        const cell = (size, rate) => sweep.cells.find(c => c.size === size && c.rate === rate);
+
+        // Green at the best cell to red at the worst, on a linear scale
+        function shade(v, lo, hi) {
+            const f = hi > lo ? (v - lo) / (hi - lo) : 0;
+            return 'hsl(' + Math.round(120 * (1 - f)) + ', 70%, 55%)';
+        }
+
+        function draw(metric) {
+            const values = sweep.cells.filter(c => !c.error).map(c => c[metric.key]);
+            const lo = Math.min(...values), hi = Math.max(...values);
+            document.getElementById('scale').textContent = values.length
+                ? metric.label + ' from ' + lo.toFixed(2) + metric.unit + ' (green) to ' + hi.toFixed(2) + metric.unit + ' (red)'
+                : 'No cell completed';
+
+            let rows = '<tr><th>size \\ rate</th>' + sweep.rates.map(r => '<th>' + r + ' pps</th>').join('') + '</tr>';
+            for (const size of sweep.sizes) {
+                rows += '<tr><th>' + size + ' B</th>';
+                for (const rate of sweep.rates) {
+                    const c = cell(size, rate);
+                    if (!c) {
+                        rows += '<td class="empty">not run</td>';
+                    } else if (c.error) {
+                        rows += '<td class="empty" title="' + c.error.replace(/"/g, '&quot;') + '">failed</td>';
+                    } else {
+                        rows += '<td style="background:' + shade(c[metric.key], lo, hi) + '" title="' + c.file.replace(/"/g, '&quot;') + '">'
+                            + c[metric.key].toFixed(2) + metric.unit
+                            + '<small>' + c.received + ' of ' + c.sent + '</small></td>';
+                    }
+                }
+                rows += '</tr>';
+            }
+            document.getElementById('heatmap').innerHTML = rows;
+            document.querySelectorAll('#metrics button').forEach(b => b.classList.toggle('active', b.dataset.key === metric.key));
+        }
+
+        const buttons = document.getElementById('metrics');
+        for (const m of metrics) {
+            const b = document.createElement('button');
+            b.textContent = m.label;
+            b.dataset.key = m.key;
+            b.onclick = () => draw(m);
+            buttons.appendChild(b);
+        }
+        draw(metrics[0]);
+    </script>
+</body>
+</html>
+`
+
+// SweepConfig configures a size by rate sweep
+type SweepConfig struct {
+	Client ClientConfig // settings for each run; PacketSize, Rate and OutputFile are set per cell
+	Sizes  []int        // payload bytes
+	Rates  []int        // pps
+}
+
+// sweepCell is the outcome of one size and rate
+type sweepCell struct {
+	Size     int     `json:"size"`
+	Rate     int     `json:"rate"`
+	File     string  `json:"file"`
+	Sent     uint64  `json:"sent"`
+	Received uint64  `json:"received"`
+	Loss     float64 `json:"loss"`
+	Avg      float64 `json:"avg"`
+	P99      float64 `json:"p99"`
+	Jitter   float64 `json:"jitter"`
+	Error    string  `json:"error,omitempty"` // why the run failed, if it did
+}
+
+// ParseSweepList parses a comma-separated list of positive numbers like
+// "64,512,1400" for the sweep flag name
+func ParseSweepList(name, s string) ([]int, error) {
+	var vals []int
+	for part := range strings.SplitSeq(s, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid %s %q, expected a comma-separated list of positive numbers", name, s)
+		}
+		vals = append(vals, v)
+	}
+	return vals, nil
+}
+
+// RunSweep runs the client for each size and rate in turn and saves the
+// combined CSV and heatmap report. A failed cell is recorded and skipped.
+func RunSweep(ctx context.Context, cfg SweepConfig) error {
+	prefix := strings.TrimSuffix(cfg.Client.OutputFile, ".csv")
+	if prefix == "" {
+		prefix = "packet-test-sweep_" + time.Now().Format("2006-01-02_15-04-05")
+	}
+	summaryCSV := prefix + "_sweep.csv"
+	if cfg.Client.ResultsDir != "" {
+		summaryCSV = filepath.Join(cfg.Client.ResultsDir, filepath.Base(summaryCSV))
+	}
+	total := len(cfg.Sizes) * len(cfg.Rates)
+	fmt.Printf("Sweeping %s: %d sizes x %d rates, %d runs of %ds\n\n",
+		net.JoinHostPort(cfg.Client.Host, strconv.Itoa(cfg.Client.Port)), len(cfg.Sizes), len(cfg.Rates), total, cfg.Client.Duration)
+
+	var cells []sweepCell
+	var slaFailed []string
+sweep:
+	for _, size := range cfg.Sizes {
+		for _, rate := range cfg.Rates {
+			if ctx.Err() != nil {
+				break sweep
+			}
+			name := fmt.Sprintf("%dB_%dpps", size, rate)
+			run := cfg.Client
+			run.PacketSize, run.Rate = size, rate
+			run.Label = name
+			run.OutputFile = prefix + "_" + name + ".csv"
+			run.SummaryJSON = ""
+			run.NoPlot = true // the heatmap covers it
+
+			fmt.Printf("=== %d byte packets at %d pps (%d of %d) ===\n\n", size, rate, len(cells)+1, total)
+			c := sweepCell{Size: size, Rate: rate}
+			meta, err := RunClient(ctx, run)
+			switch {
+			case errors.Is(err, ErrSLAFailed):
+				slaFailed = append(slaFailed, name)
+			case err != nil:
+				fmt.Printf("Warning: %s failed: %v\n", name, err)
+				c.Error = err.Error()
+			}
+			if meta != nil && c.Error == "" {
+				s := meta.Summary
+				c.File = run.OutputFile
+				c.Sent, c.Received, c.Loss = s.Sent, s.Received, s.LossPercent
+				c.Avg, c.P99, c.Jitter = s.AvgRTTMs, s.P99RTTMs, s.JitterMs
+			}
+			cells = append(cells, c)
+			fmt.Println()
+		}
+	}
+	if len(cells) == 0 {
+		return nil
+	}
+
+	printSweep(cfg, cells)
+	if err := saveSweepCSV(summaryCSV, cells); err != nil {
+		return fmt.Errorf("failed to save sweep summary: %w", err)
+	}
+	fmt.Printf("Sweep summary saved to %s\n", summaryCSV)
+	if !cfg.Client.NoPlot {
+		htmlFile := strings.TrimSuffix(summaryCSV, ".csv") + ".html"
+		if err := generateSweepReport(htmlFile, cfg, cells); err != nil {
+			return fmt.Errorf("failed to generate sweep report: %w", err)
+		}
+		fmt.Printf("Heatmap saved to %s\n", htmlFile)
+		if !cfg.Client.NoOpen {
+			openBrowser(htmlFile)
+		}
+	}
+	if len(slaFailed) > 0 {
+		return fmt.Errorf("%w for %s", ErrSLAFailed, strings.Join(slaFailed, ", "))
+	}
+	return nil
+}
+
+// printSweep prints the loss of each cell as a size by rate table
+func printSweep(cfg SweepConfig, cells []sweepCell) {
+	fmt.Println("--- Sweep loss ---")
+	fmt.Printf("%8s", "size")
+	for _, rate := range cfg.Rates {
+		fmt.Printf(" %10s", strconv.Itoa(rate)+" pps")
+	}
+	fmt.Println()
+	for _, size := range cfg.Sizes {
+		fmt.Printf("%8s", strconv.Itoa(size)+" B")
+		for _, rate := range cfg.Rates {
+			v := "-"
+			for _, c := range cells {
+				if c.Size == size && c.Rate == rate {
+					v = "failed"
+					if c.Error == "" {
+						v = fmt.Sprintf("%.2f%%", c.Loss)
+					}
+				}
+			}
+			fmt.Printf(" %10s", v)
+		}
+		fmt.Println()
+	}
+}
+
+// saveSweepCSV writes a row per cell, in the order they ran
+func saveSweepCSV(filename string, cells []sweepCell) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"packet_size", "rate", "file", "sent", "received",
+		"loss_percent", "avg_rtt_ms", "p99_rtt_ms", "jitter_ms", "error"})
+	for _, c := range cells {
+		row := []string{strconv.Itoa(c.Size), strconv.Itoa(c.Rate), c.File}
+		if c.Error == "" {
+			row = append(row,
+				strconv.FormatUint(c.Sent, 10),
+				strconv.FormatUint(c.Received, 10),
+				fmt.Sprintf("%.3f", c.Loss),
+				fmt.Sprintf("%.3f", c.Avg),
+				fmt.Sprintf("%.3f", c.P99),
+				fmt.Sprintf("%.3f", c.Jitter),
+				"")
+		} else {
+			row = append(row, "", "", "", "", "", "", c.Error)
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// generateSweepReport writes the heatmap of the sweep's cells
+func generateSweepReport(htmlFile string, cfg SweepConfig, cells []sweepCell) error {
+	data, err := json.Marshal(struct {
+		Sizes []int       `json:"sizes"`
+		Rates []int       `json:"rates"`
+		Cells []sweepCell `json:"cells"`
+	}{cfg.Sizes, cfg.Rates, cells})
+	if err != nil {
+		return err
+	}
+	theme := cfg.Client.Plot.Theme
+	if theme == "" {
+		theme = "dark"
+	}
+	info := fmt.Sprintf("%s, %d sizes x %d rates, %ds per run",
+		net.JoinHostPort(cfg.Client.Host, strconv.Itoa(cfg.Client.Port)), len(cfg.Sizes), len(cfg.Rates), cfg.Client.Duration)
+
+	page := sweepTemplate
+	page = strings.Replace(page, "{{INFO}}", html.EscapeString(info), 1)
+	page = strings.Replace(page, "{{SWEEP_JSON}}", string(data), 1)
+	page = strings.Replace(page, "{{THEME}}", theme, 1)
+	return os.WriteFile(htmlFile, []byte(page), 0644)
+}
@@ -0,0 +1,7 @@
+package main
+
+// The syscall package predates sendmmsg and SO_REUSEPORT on 386
+const (
+	sysSendmmsg = 345
+	soReusePort = 0xf
+)
@@ -0,0 +1,7 @@
+package main
+
+// The syscall package predates sendmmsg and SO_REUSEPORT on amd64
+const (
+	sysSendmmsg = 307
+	soReusePort = 0xf
+)
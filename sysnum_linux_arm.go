@@ -0,0 +1,9 @@
+package main
+
+import "syscall"
+
+// The syscall package predates SO_REUSEPORT on arm
+const (
+	sysSendmmsg = syscall.SYS_SENDMMSG
+	soReusePort = 0xf
+)
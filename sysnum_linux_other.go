@@ -0,0 +1,10 @@
+//go:build linux && !amd64 && !386 && !arm
+
+package main
+
+import "syscall"
+
+const (
+	sysSendmmsg = syscall.SYS_SENDMMSG
+	soReusePort = syscall.SO_REUSEPORT
+)
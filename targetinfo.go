@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const lookupTimeout = 3 * time.Second
+
+// TargetInfo describes who and where the target address is
+type TargetInfo struct {
+	IP         string   `json:"ip"`
+	ReverseDNS []string `json:"reverse_dns,omitempty"`
+	ASN        string   `json:"asn,omitempty"`
+	ASName     string   `json:"as_name,omitempty"`
+	Prefix     string   `json:"prefix,omitempty"`
+	Country    string   `json:"country,omitempty"` // of the prefix's registration, the only location known
+}
+
+// String formats the target info for console output and reports,
+// e.g. "203.0.113.7 (host.example.net) AS64500 EXAMPLE-AS, DE [DE]"
+func (t *TargetInfo) String() string {
+	var b strings.Builder
+	b.WriteString(t.IP)
+	if len(t.ReverseDNS) > 0 {
+		fmt.Fprintf(&b, " (%s)", t.ReverseDNS[0])
+	}
+	if t.ASN != "" {
+		fmt.Fprintf(&b, " AS%s", t.ASN)
+		if t.ASName != "" {
+			fmt.Fprintf(&b, " %s", t.ASName)
+		}
+	}
+	if t.Country != "" {
+		fmt.Fprintf(&b, " [%s]", t.Country)
+	}
+	return b.String()
+}
+
+// LookupTargetInfo resolves reverse DNS, origin AS, and country for an IP.
+// AS data comes from the Team Cymru IP-to-ASN DNS service, so no extra
+// dependencies or API keys are needed. That service knows the country the
+// prefix is registered in but not the city or region, which would need a
+// geolocation database. Failed or cancelled lookups leave fields empty.
+func LookupTargetInfo(ctx context.Context, ip net.IP) *TargetInfo {
+	info := &TargetInfo{IP: ip.String()}
+
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	if names, err := net.DefaultResolver.LookupAddr(ctx, ip.String()); err == nil {
+		for _, name := range names {
+			info.ReverseDNS = append(info.ReverseDNS, strings.TrimSuffix(name, "."))
+		}
+	}
+
+	// Private and loopback addresses have no public origin AS
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return info
+	}
+
+	// Origin record: "64500 | 203.0.113.0/24 | DE | ripencc | 2010-01-01"
+	fields := cymruTXT(ctx, cymruOriginName(ip))
+	if len(fields) >= 3 {
+		// Multi-origin prefixes list several ASNs separated by spaces
+		info.ASN = strings.Fields(fields[0])[0]
+		info.Prefix = fields[1]
+		info.Country = fields[2]
+	}
+
+	// AS record: "64500 | DE | ripencc | 2000-01-01 | EXAMPLE-AS, DE"
+	if info.ASN != "" {
+		fields = cymruTXT(ctx, "AS"+info.ASN+".asn.cymru.com")
+		if len(fields) >= 5 {
+			info.ASName = fields[4]
+		}
+	}
+
+	return info
+}
+
+// cymruOriginName builds the reversed-address query name for an IP
+func cymruOriginName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.origin.asn.cymru.com", v4[3], v4[2], v4[1], v4[0])
+	}
+
+	const hexDigits = "0123456789abcdef"
+	v6 := ip.To16()
+	var b strings.Builder
+	for i := len(v6) - 1; i >= 0; i-- {
+		b.WriteByte(hexDigits[v6[i]&0x0f])
+		b.WriteByte('.')
+		b.WriteByte(hexDigits[v6[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("origin6.asn.cymru.com")
+	return b.String()
+}
+
+// cymruTXT returns the pipe-separated fields of the first TXT record for name
+func cymruTXT(ctx context.Context, name string) []string {
+	records, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if err != nil || len(records) == 0 {
+		return nil
+	}
+	fields := strings.Split(records[0], "|")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	if len(fields) == 0 || fields[0] == "" {
+		return nil
+	}
+	return fields
+}
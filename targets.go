@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Target list mode tests each target of a file in turn, each with its own
+// host, port, rate and packet size, and keys the results by the target's
+// label: every run's CSV is named after it, and a summary CSV and JSON
+// hold a row and an entry per label.
+
+// Target is one entry of a target list. Zero fields take the value of the
+// command line flags.
+type Target struct {
+	Label      string
+	Host       string
+	Port       int
+	Rate       int
+	PacketSize int
+}
+
+// TargetsConfig configures a target list run
+type TargetsConfig struct {
+	Client  ClientConfig // settings for each run; the target's fields and OutputFile are set per run
+	Targets []Target
+}
+
+// TargetResult is the outcome of one target's run in the summary JSON
+type TargetResult struct {
+	Host       string      `json:"host"`
+	Port       int         `json:"port"`
+	Rate       int         `json:"rate"`
+	PacketSize int         `json:"packet_size"`
+	RunID      string      `json:"run_id,omitempty"`
+	CSVFile    string      `json:"csv_file,omitempty"`
+	Error      string      `json:"error,omitempty"` // why the run failed, if it did
+	Summary    *RunSummary `json:"summary,omitempty"`
+}
+
+// LoadTargets reads a target list. It is YAML, limited to a list of flat
+// mappings with the keys label, host, port, rate and size, optionally
+// under a top-level targets key:
+//
+//	targets:
+//	  - label: fra
+//	    host: fra.example.net
+//	    rate: 50
+//	  - label: nyc
+//	    host: 203.0.113.7
+//	    port: 9000
+//	    size: 1200
+//
+// A target without a label is labelled with its host.
+func LoadTargets(path string) ([]Target, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	targets, err := parseTargets(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("%s: no targets", path)
+	}
+	return targets, nil
+}
+
+func parseTargets(data []byte) ([]Target, error) {
+	var targets []Target
+	var cur *Target
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, " #"); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") || (text == "targets:" && len(targets) == 0) {
+			continue
+		}
+		if text == "-" || strings.HasPrefix(text, "- ") {
+			targets = append(targets, Target{})
+			cur = &targets[len(targets)-1]
+			if text = strings.TrimSpace(strings.TrimPrefix(text, "-")); text == "" {
+				continue
+			}
+		}
+		if cur == nil {
+			return nil, fmt.Errorf("line %d: expected a list of targets, each starting with -", line)
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key: value", line)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		switch key {
+		case "label":
+			cur.Label = value
+		case "host":
+			cur.Host = value
+		case "port", "rate", "size":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("line %d: %s must be a positive number", line, key)
+			}
+			switch key {
+			case "port":
+				cur.Port = n
+			case "rate":
+				cur.Rate = n
+			default:
+				cur.PacketSize = n
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown key %q, expected label, host, port, rate or size", line, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for i := range targets {
+		t := &targets[i]
+		if t.Host == "" {
+			return nil, fmt.Errorf("target %d has no host", i+1)
+		}
+		if t.Label == "" {
+			t.Label = t.Host
+		}
+		if strings.ContainsAny(t.Label, `/\:*?"<>| `) {
+			return nil, fmt.Errorf("target label %q can't be used in file names, give it a label", t.Label)
+		}
+		if seen[t.Label] {
+			return nil, fmt.Errorf("target label %q is used twice", t.Label)
+		}
+		seen[t.Label] = true
+		if t.Port > 65535 {
+			return nil, fmt.Errorf("target %s: port must be at most 65535", t.Label)
+		}
+		if t.PacketSize > 0 && (t.PacketSize < HeaderSize || t.PacketSize > MaxPacketSize) {
+			return nil, fmt.Errorf("target %s: size must be %d to %d bytes", t.Label, HeaderSize, MaxPacketSize)
+		}
+	}
+	return targets, nil
+}
+
+// RunTargets runs the client against each target in turn and saves the
+// summaries keyed by label. A failed target is recorded and skipped.
+func RunTargets(ctx context.Context, cfg TargetsConfig) error {
+	prefix := strings.TrimSuffix(cfg.Client.OutputFile, ".csv")
+	if prefix == "" {
+		prefix = "packet-test_" + time.Now().Format("2006-01-02_15-04-05")
+	}
+	summaryCSV := prefix + "_targets.csv"
+	summaryJSON := prefix + "_targets.json"
+	if cfg.Client.ResultsDir != "" {
+		summaryCSV = filepath.Join(cfg.Client.ResultsDir, filepath.Base(summaryCSV))
+		summaryJSON = filepath.Join(cfg.Client.ResultsDir, filepath.Base(summaryJSON))
+	}
+	if cfg.Client.SummaryJSON != "" {
+		summaryJSON = cfg.Client.SummaryJSON
+	}
+	fmt.Printf("Testing %d targets in turn, summaries in %s\n\n", len(cfg.Targets), summaryCSV)
+
+	results := make(map[string]*TargetResult)
+	var done []Target
+	var slaFailed []string
+	for i, t := range cfg.Targets {
+		if ctx.Err() != nil {
+			break
+		}
+		run := cfg.Client
+		run.Label, run.Host = t.Label, t.Host
+		if t.Port > 0 {
+			run.Port = t.Port
+		}
+		if t.Rate > 0 {
+			run.Rate = t.Rate
+		}
+		if t.PacketSize > 0 {
+			run.PacketSize = t.PacketSize
+		}
+		run.OutputFile = prefix + "_" + t.Label + ".csv"
+		run.SummaryJSON = ""
+		run.NoOpen = true // reports pile up, one per target
+
+		fmt.Printf("=== Target %s (%d of %d): %s ===\n\n", t.Label, i+1, len(cfg.Targets), net.JoinHostPort(run.Host, strconv.Itoa(run.Port)))
+		res := &TargetResult{Host: run.Host, Port: run.Port, Rate: run.Rate, PacketSize: run.PacketSize}
+		meta, err := RunClient(ctx, run)
+		switch {
+		case errors.Is(err, ErrSLAFailed):
+			slaFailed = append(slaFailed, t.Label)
+		case err != nil:
+			fmt.Printf("Warning: target %s failed: %v\n", t.Label, err)
+			res.Error = err.Error()
+		}
+		if meta != nil && res.Error == "" {
+			res.RunID, res.CSVFile, res.Summary = meta.RunID, run.OutputFile, meta.Summary
+		}
+		results[t.Label] = res
+		done = append(done, t)
+		fmt.Println()
+	}
+
+	printTargets(done, results)
+	if err := saveTargetsCSV(summaryCSV, done, results); err != nil {
+		return fmt.Errorf("failed to save target summary: %w", err)
+	}
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	if summaryJSON == "-" {
+		fmt.Println(string(data))
+	} else if err := os.WriteFile(summaryJSON, data, 0644); err != nil {
+		return fmt.Errorf("failed to save target summary: %w", err)
+	}
+	fmt.Printf("Target summaries saved to %s", summaryCSV)
+	if summaryJSON != "-" {
+		fmt.Printf(" and %s", summaryJSON)
+	}
+	fmt.Println()
+	if len(slaFailed) > 0 {
+		return fmt.Errorf("%w for %s", ErrSLAFailed, strings.Join(slaFailed, ", "))
+	}
+	return nil
+}
+
+// printTargets prints a line per target
+func printTargets(targets []Target, results map[string]*TargetResult) {
+	fmt.Println("--- Targets ---")
+	for _, t := range targets {
+		res := results[t.Label]
+		if res.Summary == nil {
+			fmt.Printf("%-20s failed: %s\n", t.Label, res.Error)
+			continue
+		}
+		s := res.Summary
+		fmt.Printf("%-20s loss %.2f%% (%d of %d), avg RTT %.2fms, p99 %.2fms, jitter %.2fms\n",
+			t.Label, s.LossPercent, s.Sent-s.Received, s.Sent, s.AvgRTTMs, s.P99RTTMs, s.JitterMs)
+	}
+}
+
+// saveTargetsCSV writes a row per target, in the order of the list
+func saveTargetsCSV(filename string, targets []Target, results map[string]*TargetResult) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"label", "host", "port", "rate", "packet_size", "file", "sent", "received",
+		"loss_percent", "avg_rtt_ms", "p99_rtt_ms", "jitter_ms", "error"})
+	for _, t := range targets {
+		res := results[t.Label]
+		row := []string{t.Label, res.Host, strconv.Itoa(res.Port), strconv.Itoa(res.Rate), strconv.Itoa(res.PacketSize), res.CSVFile}
+		if s := res.Summary; s != nil {
+			row = append(row,
+				strconv.FormatUint(s.Sent, 10),
+				strconv.FormatUint(s.Received, 10),
+				fmt.Sprintf("%.3f", s.LossPercent),
+				fmt.Sprintf("%.3f", s.AvgRTTMs),
+				fmt.Sprintf("%.3f", s.P99RTTMs),
+				fmt.Sprintf("%.3f", s.JitterMs),
+				"")
+		} else {
+			row = append(row, "", "", "", "", "", "", res.Error)
+		}
+		writer.Write(row)
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return file.Close()
+}
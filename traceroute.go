@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const tracerouteMaxHops = 30
+
+// TraceHop is a single traceroute hop. Addr is empty when the hop didn't answer.
+type TraceHop struct {
+	TTL  int     `json:"ttl"`
+	Addr string  `json:"addr,omitempty"`
+	RTT  float64 `json:"rtt_ms,omitempty"`
+}
+
+// Traceroute is a path snapshot taken with the system traceroute tool
+type Traceroute struct {
+	Time  time.Time  `json:"time"`
+	Hops  []TraceHop `json:"hops"`
+	Error string     `json:"error,omitempty"`
+}
+
+// RunTraceroute traces the path to host using the platform's traceroute
+// (UDP probes on Unix, ICMP via tracert on Windows). Addresses are not
+// resolved so the snapshot stays fast and comparable. Cancelling ctx
+// kills the trace.
+func RunTraceroute(ctx context.Context, host string) *Traceroute {
+	tr := &Traceroute{Time: time.Now()}
+
+	var cmd *exec.Cmd
+	maxHops := strconv.Itoa(tracerouteMaxHops)
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.CommandContext(ctx, "tracert", "-d", "-w", "1000", "-h", maxHops, host)
+	default:
+		cmd = exec.CommandContext(ctx, "traceroute", "-n", "-q", "1", "-w", "1", "-m", maxHops, host)
+	}
+
+	out, err := cmd.Output()
+	if err != nil && len(out) == 0 {
+		tr.Error = err.Error()
+		return tr
+	}
+
+	tr.Hops = parseTraceroute(out)
+	return tr
+}
+
+// parseTraceroute extracts hops from traceroute/tracert output. Lines that
+// don't start with a hop number (headers, trailers) are skipped.
+func parseTraceroute(out []byte) []TraceHop {
+	var hops []TraceHop
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		ttl, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+
+		hop := TraceHop{TTL: ttl}
+		for i, f := range fields[1:] {
+			f = strings.Trim(f, "[]()")
+			if net.ParseIP(f) != nil && hop.Addr == "" {
+				hop.Addr = f
+				continue
+			}
+			// RTT follows as "12.3 ms" (traceroute) or "12 ms" / "<1 ms" (tracert)
+			if hop.RTT == 0 && i+2 < len(fields) && fields[i+2] == "ms" {
+				if rtt, err := strconv.ParseFloat(strings.TrimPrefix(f, "<"), 64); err == nil {
+					hop.RTT = rtt
+				}
+			}
+		}
+		hops = append(hops, hop)
+	}
+	return hops
+}
+
+// PathChanged reports whether the responding hops differ between two
+// snapshots. Hops that didn't answer in either snapshot are ignored since
+// rate-limited routers drop probes at random.
+func PathChanged(a, b *Traceroute) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	n := len(a.Hops)
+	if len(b.Hops) > n {
+		n = len(b.Hops)
+	}
+	for i := 0; i < n; i++ {
+		var addrA, addrB string
+		if i < len(a.Hops) {
+			addrA = a.Hops[i].Addr
+		}
+		if i < len(b.Hops) {
+			addrB = b.Hops[i].Addr
+		}
+		if addrA != "" && addrB != "" && addrA != addrB {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the snapshot as a compact hop list, e.g. "1 192.0.2.1, 2 *, 3 198.51.100.1"
+func (t *Traceroute) String() string {
+	if t.Error != "" {
+		return "failed: " + t.Error
+	}
+	parts := make([]string, len(t.Hops))
+	for i, hop := range t.Hops {
+		addr := hop.Addr
+		if addr == "" {
+			addr = "*"
+		}
+		parts[i] = fmt.Sprintf("%d %s", hop.TTL, addr)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A trend report follows a link across many runs: every results CSV under
+// a directory becomes one point per chart, at the time the run started, so
+// loss, p99 RTT and jitter can be read over days or months. Runs are
+// grouped into one series per target, or per label from a target list.
+
+const trendTemplate = `<!DOCTYPE html>
+<html>
+<head>
+    <title>Packet Test Trends</title>
+    {{CHARTJS}}
+` + reportStyle + `    <style>
+        table.runs { width: 100%; border-collapse: collapse; }
+        table.runs th, table.runs td { padding: 6px 10px; text-align: right; border-bottom: 1px solid var(--bg); }
+        table.runs th:nth-child(-n+2), table.runs td:nth-child(-n+2) { text-align: left; }
+        table.runs th { color: var(--accent); font-weight: normal; }
+        table.runs a { color: var(--accent); }
+    </style>
+</head>
+<body>
+    <h1>UDP Packet Loss Test Trends</h1>
+    <div class="run-info"><div>{{INFO}}</div></div>
+
+    <div class="chart-container">
+        <canvas id="lossChart"></canvas>
+    </div>
+
+    <div class="chart-container">
+        <canvas id="p99Chart"></canvas>
+    </div>
+
+    <div class="chart-container">
+        <canvas id="jitterChart"></canvas>
+    </div>
+
+    <div class="chart-container">
+        <table class="runs">
+            <tr><th>Started</th><th>Target</th><th>Sent</th><th>Loss</th><th>Avg RTT</th><th>p99 RTT</th><th>Jitter</th><th>Files</th></tr>
+{{ROWS}}
+        </table>
+    </div>
+
+    <script>
+        const series = {{SERIES_JSON}};
+        const themes = {
+            dark: { text: '#eee', muted: '#888', grid: '#333' },
+            light: { text: '#222', muted: '#666', grid: '#ddd' }
+        };
+        const params = new URLSearchParams(location.search);
+        const themeName = themes[params.get('theme')] ? params.get('theme') : '{{THEME}}';
+        const theme = themes[themeName];
+        document.body.classList.add('theme-' + themeName);
+
+        const colors = ['#00d9ff', '#feca57', '#ff6b6b', '#1dd1a1', '#a29bfe', '#ff9ff3', '#54a0ff', '#c8d6e5'];
+        const color = i => colors[i % colors.length];
+
+        const timeAxis = {
+            type: 'linear',
+            title: { display: true, text: 'Run started', color: theme.muted },
+            ticks: { color: theme.muted, callback: v => new Date(v).toLocaleString([], { month: 'short', day: 'numeric', hour: '2-digit', minute: '2-digit' }) },
+            grid: { color: theme.grid }
+        };
+        const valueAxis = (title, unit) => ({
+            type: 'linear',
+            min: 0,
+            title: { display: true, text: title + ' (' + unit + ')', color: theme.muted },
+            ticks: { color: theme.muted },
+            grid: { color: theme.grid }
+        });
+        const chart = (id, title, field, unit) => new Chart(document.getElementById(id), {
+            type: 'line',
+            data: {
+                datasets: series.map((s, i) => ({
+                    label: s.label,
+                    data: s.runs.map(r => ({ x: r.t, y: r[field], file: r.file })),
+                    borderColor: color(i),
+                    backgroundColor: color(i),
+                    borderWidth: 1,
+                    pointRadius: 2
+                }))
+            },
+            options: {
+                responsive: true,
+                parsing: false,
+                plugins: {
+                    title: { display: true, text: title, color: theme.text },
+                    legend: { labels: { color: theme.text } },
+                    tooltip: { callbacks: { title: items => items[0].raw.file } }
+                },
+                scales: { x: timeAxis, y: valueAxis(title, unit) }
+            }
+        });
+
+        chart('lossChart', 'Loss', 'loss', '%');
+        chart('p99Chart', 'p99 RTT', 'p99', 'ms');
+        chart('jitterChart', 'Jitter', 'jitter', 'ms');
+    </script>
+</body>
+</html>
+`
+
+// trendRun is one run's point in the trend charts
+type trendRun struct {
+	T      int64   `json:"t"` // start, Unix milliseconds
+	File   string  `json:"file"`
+	Loss   float64 `json:"loss"`
+	P99    float64 `json:"p99"`
+	Jitter float64 `json:"jitter"`
+	start  time.Time
+	target string
+	sum    RunSummary
+}
+
+// trendSeries is the runs against one target
+type trendSeries struct {
+	Label string      `json:"label"`
+	Runs  []*trendRun `json:"runs"`
+}
+
+// GenerateTrendReport writes trend.html into dir, with a point per results
+// CSV found in it or its subdirectories
+func GenerateTrendReport(dir string, opts PlotOptions) error {
+	var runs []*trendRun
+	skipped := 0
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".csv") || strings.HasSuffix(path, ".icmp.csv") {
+			return nil
+		}
+		run, err := loadTrendRun(path)
+		if err != nil {
+			skipped++
+			return nil
+		}
+		if rel, err := filepath.Rel(dir, path); err == nil {
+			run.File = filepath.ToSlash(rel)
+		}
+		runs = append(runs, run)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		return fmt.Errorf("no results CSVs found in %s", dir)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].start.Before(runs[j].start) })
+
+	var series []*trendSeries
+	byTarget := make(map[string]*trendSeries)
+	for _, r := range runs {
+		s, ok := byTarget[r.target]
+		if !ok {
+			s = &trendSeries{Label: r.target}
+			byTarget[r.target] = s
+			series = append(series, s)
+		}
+		s.Runs = append(s.Runs, r)
+	}
+
+	seriesJSON, err := json.Marshal(series)
+	if err != nil {
+		return err
+	}
+	chartJS, err := chartJSTag(opts.ChartJS)
+	if err != nil {
+		return err
+	}
+	theme := opts.Theme
+	if theme == "" {
+		theme = "dark"
+	}
+	info := fmt.Sprintf("%d runs against %d targets, %s to %s", len(runs), len(series),
+		runs[0].start.Format("2006-01-02 15:04"), runs[len(runs)-1].start.Format("2006-01-02 15:04"))
+
+	page := trendTemplate
+	page = strings.Replace(page, "{{INFO}}", html.EscapeString(info), 1)
+	page = strings.Replace(page, "{{ROWS}}", strings.TrimSuffix(renderTrendRows(dir, runs), "\n"), 1)
+	page = strings.Replace(page, "{{SERIES_JSON}}", string(seriesJSON), 1)
+	page = strings.Replace(page, "{{THEME}}", theme, 1)
+	page = strings.Replace(page, "{{CHARTJS}}", chartJS, 1)
+
+	outputFile := filepath.Join(dir, "trend.html")
+	if err := os.WriteFile(outputFile, []byte(page), 0644); err != nil {
+		return fmt.Errorf("failed to write HTML: %w", err)
+	}
+	fmt.Printf("Generated %s from %s\n", outputFile, info)
+	if skipped > 0 {
+		fmt.Printf("Skipped %d CSVs that aren't packet-test results\n", skipped)
+	}
+	return nil
+}
+
+// loadTrendRun reads a run's headline numbers from its metadata, or from
+// the CSV itself for runs without a summary
+func loadTrendRun(csvFile string) (*trendRun, error) {
+	meta, err := loadMetadata(csvFile)
+	if err != nil {
+		return nil, err
+	}
+	run := &trendRun{File: csvFile}
+	if meta != nil {
+		run.start, run.target = meta.StartTime, meta.Target
+		if meta.Label != "" {
+			run.target = meta.Label
+		}
+	}
+	if meta != nil && meta.Summary != nil {
+		run.sum = *meta.Summary
+	} else {
+		records, err := LoadRecords(csvFile)
+		if err != nil || len(records) == 0 {
+			return nil, fmt.Errorf("failed to load %s: %w", csvFile, err)
+		}
+		lats, lost := answeredLatencies(records)
+		run.sum = RunSummary{Sent: uint64(len(records)), Received: uint64(len(records) - lost),
+			LossPercent: lossPercent(lost, len(records))}
+		if len(lats) > 0 {
+			_, run.sum.AvgRTTMs, _, run.sum.JitterMs = calcStats(lats)
+			run.sum.P99RTTMs = percentile(lats, 99)
+		}
+		if run.start.IsZero() {
+			first := records[0].SentTime
+			for _, r := range records {
+				first = min(first, r.SentTime)
+			}
+			run.start = time.Unix(0, first)
+		}
+	}
+	if run.target == "" {
+		run.target = "unknown target"
+	}
+	run.T = run.start.UnixMilli()
+	run.Loss, run.P99, run.Jitter = run.sum.LossPercent, run.sum.P99RTTMs, run.sum.JitterMs
+	return run, nil
+}
+
+// renderTrendRows renders a table row per run, newest first
+func renderTrendRows(dir string, runs []*trendRun) string {
+	var b strings.Builder
+	for i := len(runs) - 1; i >= 0; i-- {
+		r := runs[i]
+		links := fmt.Sprintf(`<a href="%s">csv</a>`, html.EscapeString(r.File))
+		report := strings.TrimSuffix(r.File, ".csv") + ".html"
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(report))); err == nil {
+			links = fmt.Sprintf(`<a href="%s">report</a> `, html.EscapeString(report)) + links
+		}
+		fmt.Fprintf(&b, "            <tr><td>%s</td><td>%s</td><td>%d</td><td>%.2f%%</td><td>%.2fms</td><td>%.2fms</td><td>%.2fms</td><td>%s</td></tr>\n",
+			r.start.Format("2006-01-02 15:04:05"), html.EscapeString(r.target), r.sum.Sent,
+			r.sum.LossPercent, r.sum.AvgRTTMs, r.sum.P99RTTMs, r.sum.JitterMs, links)
+	}
+	return b.String()
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// The terminal UI redraws a live view of the run every second in place of
+// the interval lines: a sparkline of the RTT per second, a loss gauge and
+// the percentiles so far. It uses plain ANSI escapes so it works over SSH
+// without a browser or any dependency.
+
+const (
+	tuiHistory   = 60   // seconds of RTT in the sparkline
+	tuiGaugeSize = 40   // characters in the loss gauge
+	tuiGaugeFull = 10.0 // loss percent that fills the gauge
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// LiveStats is a snapshot of a run in progress
+type LiveStats struct {
+	Sent, Received, Late uint64
+	Lost                 uint64 // unanswered past the loss timeout
+	InFlight             uint64
+	RTTCount             uint64
+	RTTSum               float64 // ms, for averages between snapshots
+	RTT                  LatencyStats
+	RFCJitterMs          float64
+}
+
+// Live returns a snapshot of the run so far
+func (s *Stats) Live() LiveStats {
+	s.lock()
+	defer s.mu.Unlock()
+
+	inFlight := s.unansweredSince(monoUnixNano(time.Now()) - s.lossTimeout().Nanoseconds())
+	live := LiveStats{
+		Sent:        s.sent,
+		Received:    s.received,
+		Late:        s.late,
+		Lost:        s.sent - s.received - min(inFlight, s.sent-s.received),
+		InFlight:    inFlight,
+		RTTCount:    s.rtt.Count(),
+		RTTSum:      s.rtt.Avg() * float64(s.rtt.Count()),
+		RFCJitterMs: s.rfcJitter,
+	}
+	if l := newLatencyStats(&s.rtt); l != nil {
+		live.RTT = *l
+	}
+	return live
+}
+
+// tui draws the live view
+type tui struct {
+	out     io.Writer
+	title   string
+	start   time.Time
+	next    time.Time
+	last    LiveStats
+	rtt     []float64 // average RTT per second, -1 if nothing was answered
+	secLoss float64   // loss percent of the last second
+}
+
+func newTUI(title string) *tui {
+	now := time.Now()
+	return &tui{out: os.Stdout, title: title, start: now, next: now.Add(time.Second)}
+}
+
+// Update redraws the view once a second has passed since the last draw
+func (t *tui) Update(stats *Stats, now time.Time) {
+	if now.Before(t.next) {
+		return
+	}
+	t.next = t.next.Add(time.Second)
+	live := stats.Live()
+
+	avg := -1.0
+	if n := live.RTTCount - t.last.RTTCount; n > 0 {
+		avg = (live.RTTSum - t.last.RTTSum) / float64(n)
+	}
+	t.rtt = append(t.rtt, avg)
+	if len(t.rtt) > tuiHistory {
+		t.rtt = t.rtt[1:]
+	}
+	// Loss among the probes settled since the last draw
+	t.secLoss = 0
+	settled, settledBefore := live.Sent-live.InFlight, t.last.Sent-t.last.InFlight
+	if settled > settledBefore && live.Lost >= t.last.Lost {
+		t.secLoss = float64(live.Lost-t.last.Lost) / float64(settled-settledBefore) * 100
+	}
+	t.last = live
+	t.draw(now)
+}
+
+func (t *tui) draw(now time.Time) {
+	var b strings.Builder
+	b.WriteString("\033[H\033[2J") // home and clear
+	live := t.last
+	fmt.Fprintf(&b, "%s   %s elapsed\n\n", t.title, now.Sub(t.start).Round(time.Second))
+
+	lo, hi := -1.0, 0.0
+	for _, v := range t.rtt {
+		if v >= 0 && (lo < 0 || v < lo) {
+			lo = v
+		}
+		hi = max(hi, v)
+	}
+	var spark strings.Builder
+	for _, v := range t.rtt {
+		switch {
+		case v < 0:
+			spark.WriteRune(' ')
+		case hi <= lo:
+			spark.WriteRune(sparkBlocks[0])
+		default:
+			i := int((v - lo) / (hi - lo) * float64(len(sparkBlocks)-1))
+			spark.WriteRune(sparkBlocks[i])
+		}
+	}
+	if lo < 0 {
+		fmt.Fprintf(&b, "RTT/s    %s  no replies\n", spark.String())
+	} else {
+		fmt.Fprintf(&b, "RTT/s    %s  %.2f-%.2fms, now %s\n", spark.String(), lo, hi, tuiMs(t.rtt[len(t.rtt)-1]))
+	}
+
+	loss := 0.0
+	if settled := live.Sent - live.InFlight; settled > 0 {
+		loss = float64(live.Lost) / float64(settled) * 100
+	}
+	filled := int(min(loss/tuiGaugeFull, 1) * tuiGaugeSize)
+	if loss > 0 && filled == 0 {
+		filled = 1 // any loss shows
+	}
+	fmt.Fprintf(&b, "Loss     [%s%s] %.2f%% (%d of %d), %.1f%% last second\n\n",
+		strings.Repeat("█", filled), strings.Repeat("░", tuiGaugeSize-filled),
+		loss, live.Lost, live.Sent-live.InFlight, t.secLoss)
+
+	fmt.Fprintf(&b, "%-8s %9s %9s %9s %9s %9s %9s %9s\n", "", "min", "avg", "p50", "p90", "p99", "p99.9", "max")
+	if live.RTTCount > 0 {
+		r := live.RTT
+		fmt.Fprintf(&b, "%-8s %9.2f %9.2f %9.2f %9.2f %9.2f %9.2f %9.2f\n", "RTT ms", r.Min, r.Avg, r.P50, r.P90, r.P99, r.P999, r.Max)
+	} else {
+		fmt.Fprintf(&b, "%-8s %9s\n", "RTT ms", "-")
+	}
+	fmt.Fprintf(&b, "\nSent %d  Received %d  Late %d  In flight %d  Jitter %.2fms (RFC 3550)\n",
+		live.Sent, live.Received, live.Late, live.InFlight, live.RFCJitterMs)
+	io.WriteString(t.out, b.String())
+}
+
+func tuiMs(v float64) string {
+	if v < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2fms", v)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// SO_TIMESTAMPING flags, from linux/net_tstamp.h
+const (
+	sofTimestampingTxSoftware = 1 << 1
+	sofTimestampingSoftware   = 1 << 4
+	sofTimestampingOptID      = 1 << 7
+	sofTimestampingOptTSOnly  = 1 << 11
+
+	soEEOriginTimestamping = 4 // sock_extended_err origin of a timestamp
+)
+
+// enableTxTimestamps asks the kernel to report when each packet sent on
+// the socket leaves the stack, on its error queue and numbered from 0 in
+// the order sent, without a copy of the packet
+func enableTxTimestamps(conn net.Conn) bool {
+	uc, ok := conn.(*net.UDPConn)
+	if !ok {
+		return false
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	flags := sofTimestampingTxSoftware | sofTimestampingSoftware | sofTimestampingOptID | sofTimestampingOptTSOnly
+	var serr error
+	err = raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMPING, flags)
+	})
+	return err == nil && serr == nil
+}
+
+// readTxTimestamps drains the socket's error queue without blocking and
+// returns the transmit timestamps in it, wall clock Unix nanoseconds by
+// packet number
+func readTxTimestamps(conn net.Conn, oob []byte) []txStamp {
+	uc, ok := conn.(*net.UDPConn)
+	if !ok {
+		return nil
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var stamps []txStamp
+	var buf [64]byte
+	raw.Control(func(fd uintptr) {
+		for {
+			_, oobn, _, _, err := syscall.Recvmsg(int(fd), buf[:], oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+			if err != nil {
+				return
+			}
+			msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+			if err != nil {
+				continue
+			}
+			var st txStamp
+			var haveID bool
+			for _, m := range msgs {
+				switch {
+				case m.Header.Level == syscall.SOL_SOCKET && m.Header.Type == syscall.SO_TIMESTAMPING &&
+					len(m.Data) >= int(unsafe.Sizeof(syscall.Timespec{})):
+					st.Ns = (*syscall.Timespec)(unsafe.Pointer(&m.Data[0])).Nano()
+				case (m.Header.Level == syscall.SOL_IP && m.Header.Type == syscall.IP_RECVERR ||
+					m.Header.Level == syscall.SOL_IPV6 && m.Header.Type == syscall.IPV6_RECVERR) && len(m.Data) >= 16:
+					// struct sock_extended_err: the origin at 4, the
+					// packet number at 12
+					if m.Data[4] == soEEOriginTimestamping {
+						st.ID = binary.NativeEndian.Uint32(m.Data[12:])
+						haveID = true
+					}
+				}
+			}
+			if haveID && st.Ns > 0 {
+				stamps = append(stamps, st)
+			}
+		}
+	})
+	return stamps
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package main
+
+import "net"
+
+// Transmit timestamps come from the Linux error queue; elsewhere probes
+// keep the send time taken before the write
+func enableTxTimestamps(conn net.Conn) bool { return false }
+
+func readTxTimestamps(conn net.Conn, oob []byte) []txStamp { return nil }